@@ -6,18 +6,46 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	"github.com/gofiber/fiber/v2/middleware/requestid"
 	"notes-app/api/v1"
+	"notes-app/config"
 	"notes-app/service"
+	"notes-app/utils"
 	"time"
 )
 
 type Services struct {
-	UserService service.IUserService
-	AuthService service.IAuthService
+	UserService          service.IUserService
+	AuthService          service.IAuthService
+	SessionService       service.ISessionService
+	AccessTokenService   service.IAccessTokenService
+	TOTPService          service.ITOTPService
+	LoginThrottleService service.ILoginThrottleService
+	OIDCService          service.IOIDCService
+	PrivacyService       service.IPrivacyService
+	OrganizationService  service.IOrganizationService
+	GroupService         service.IGroupService
+	ShareLinkService     service.IShareLinkService
+	AccessRequestService service.IAccessRequestService
+	NoteService          service.INoteService
+	MailQueue            *utils.WorkerPool
+}
+
+// FiberConfig returns the configuration of the fiber.App, which only reads the IP address of the client from the proxy
+// header in requests from the trusted proxies.
+func FiberConfig() fiber.Config {
+	cfg := config.Get()
+
+	return fiber.Config{
+		ErrorHandler:            ErrorHandler,
+		ProxyHeader:             cfg.ProxyHeader,
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          cfg.TrustedProxies,
+		EnableIPValidation:      true,
+	}
 }
 
 // GenApp initializes and returns a new fiber.App instance to serve the APIs for the application.
 func GenApp(services Services) *fiber.App {
-	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	app := fiber.New(FiberConfig())
 
 	// Recover middleware recovers from panics anywhere in the app
 	app.Use(recover.New())
@@ -36,12 +64,28 @@ func GenApp(services Services) *fiber.App {
 		return c.SendString("Hello, World!")
 	})
 
+	// Publish the public keys used to sign tokens
+	app.Get("/.well-known/jwks.json", jwksHandler(services.AuthService))
+
 	api := app.Group("/api")
 
 	// Register v1 APIs
 	v1.RegisterRoutes(api.Group("/v1"), v1.Services{
-		UserService: services.UserService,
-		AuthService: services.AuthService,})
+		UserService:          services.UserService,
+		AuthService:          services.AuthService,
+		SessionService:       services.SessionService,
+		AccessTokenService:   services.AccessTokenService,
+		TOTPService:          services.TOTPService,
+		LoginThrottleService: services.LoginThrottleService,
+		OIDCService:          services.OIDCService,
+		PrivacyService:       services.PrivacyService,
+		OrganizationService:  services.OrganizationService,
+		GroupService:         services.GroupService,
+		ShareLinkService:     services.ShareLinkService,
+		AccessRequestService: services.AccessRequestService,
+		NoteService:          services.NoteService,
+		MailQueue:            services.MailQueue,
+	})
 
 	return app
 }
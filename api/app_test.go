@@ -0,0 +1,72 @@
+package api_test
+
+import (
+	"io"
+	"net/http"
+	"notes-app/api"
+	"notes-app/config"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	cfg := config.Get()
+	originalHeader, originalProxies := cfg.ProxyHeader, cfg.TrustedProxies
+	defer func() { cfg.ProxyHeader, cfg.TrustedProxies = originalHeader, originalProxies }()
+
+	// Requests sent with app.Test come from 0.0.0.0
+	testCases := map[string]struct {
+		proxyHeader    string
+		trustedProxies []string
+		header         string
+		ip             string
+	}{
+		"no proxy": {
+			header: "203.0.113.7",
+			ip:     "0.0.0.0",
+		},
+		"trusted proxy": {
+			proxyHeader:    "X-Real-IP",
+			trustedProxies: []string{"0.0.0.0/8"},
+			header:         "203.0.113.7",
+			ip:             "203.0.113.7",
+		},
+		"untrusted proxy": {
+			proxyHeader:    "X-Real-IP",
+			trustedProxies: []string{"10.0.0.0/8"},
+			header:         "203.0.113.7",
+			ip:             "0.0.0.0",
+		},
+		"invalid header": {
+			proxyHeader:    "X-Real-IP",
+			trustedProxies: []string{"0.0.0.0/8"},
+			header:         "not an ip",
+			ip:             "0.0.0.0",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg.ProxyHeader, cfg.TrustedProxies = tc.proxyHeader, tc.trustedProxies
+
+			app := fiber.New(api.FiberConfig())
+			app.Get("/ip", func(c *fiber.Ctx) error {
+				return c.SendString(c.IP())
+			})
+
+			request, err := http.NewRequest(http.MethodGet, "/ip", nil)
+			assert.NoError(t, err)
+			request.Header.Set("X-Real-IP", tc.header)
+
+			response, err := app.Test(request)
+			assert.NoError(t, err)
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.ip, string(body))
+		})
+	}
+}
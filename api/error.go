@@ -1,28 +1,40 @@
 package api
 
 import (
-	"errors"
-	"github.com/gofiber/fiber/v2"
+	"log/slog"
+	"notes-app/apperror"
 	"notes-app/utils"
+
+	"github.com/gofiber/fiber/v2"
 )
 
-// ErrorHandler formats any errors as a proper JSON response
+// ErrorHandler formats any errors as a proper JSON response.
+//
+// Errors are sent as utils.ErrorResponse by default, or as RFC 7807 problem details if the client prefers
+// application/problem+json in the Accept header.
 var ErrorHandler = func(c *fiber.Ctx, err error) error {
-	// Status code defaults to 500
-	code := fiber.StatusInternalServerError
+	// Convert the error into an application error, so that every response has a code
+	appErr := apperror.From(err)
 
-	// Retrieve the custom status code if it's a *fiber.Error
-	var e *fiber.Error
-	if errors.As(err, &e) {
-		code = e.Code
+	// Log internal errors, since their cause is never sent to the client
+	requestID, _ := c.Locals("requestid").(string)
+	if appErr.Code == apperror.CodeInternal {
+		slog.Error("Internal server error", slog.Any("error", err), slog.String("requestID", requestID))
 	}
 
-	// Set Content-Type: text/plain; charset=utf-8
-	c.Set(fiber.HeaderContentType, fiber.MIMETextPlainCharsetUTF8)
+	// Send problem details if the client asked for them
+	if c.Accepts(fiber.MIMEApplicationJSON, MIMEApplicationProblemJSON) == MIMEApplicationProblemJSON {
+		return c.Status(appErr.Status()).JSON(newProblem(c, appErr, requestID), MIMEApplicationProblemJSON)
+	}
 
 	// Return status code with error message
-	return c.Status(code).JSON(utils.ApiResponse{
-		Success: false,
-		Message: err.Error(),
+	return c.Status(appErr.Status()).JSON(utils.ErrorResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: false,
+			Message: appErr.Message,
+		},
+		Code:      appErr.Code,
+		Details:   appErr.Details,
+		RequestID: requestID,
 	})
 }
@@ -0,0 +1,167 @@
+package api_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"notes-app/api"
+	"notes-app/apperror"
+	"notes-app/utils"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorHandler(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(requestid.New(requestid.Config{Generator: func() string { return "test-request-id" }}))
+	app.Get("/validation", func(c *fiber.Ctx) error {
+		return apperror.ErrValidationFailed.WithDetails(apperror.FieldError{Field: "email", Message: "is required"})
+	})
+	app.Get("/internal", func(c *fiber.Ctx) error {
+		return errors.New("connection refused")
+	})
+
+	testCases := map[string]struct {
+		path   string
+		status int
+		body   utils.ErrorResponse
+	}{
+		"application error": {
+			path:   "/validation",
+			status: http.StatusUnprocessableEntity,
+			body: utils.ErrorResponse{
+				ApiResponse: utils.ApiResponse{Success: false, Message: "Validation failed"},
+				Code:        apperror.CodeValidationFailed,
+				Details:     []apperror.FieldError{{Field: "email", Message: "is required"}},
+				RequestID:   "test-request-id",
+			},
+		},
+		"internal error": {
+			path:   "/internal",
+			status: http.StatusInternalServerError,
+			body: utils.ErrorResponse{
+				ApiResponse: utils.ApiResponse{Success: false, Message: "Internal server error"},
+				Code:        apperror.CodeInternal,
+				RequestID:   "test-request-id",
+			},
+		},
+		"unknown route": {
+			path:   "/nosuchroute",
+			status: http.StatusNotFound,
+			body: utils.ErrorResponse{
+				ApiResponse: utils.ApiResponse{Success: false, Message: "Cannot GET /nosuchroute"},
+				Code:        apperror.CodeNotFound,
+				RequestID:   "test-request-id",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodGet, tc.path, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			response, err := app.Test(request)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response is JSON with the expected status code
+			assert.Equal(t, tc.status, response.StatusCode)
+			assert.Equal(t, fiber.MIMEApplicationJSON, response.Header.Get(fiber.HeaderContentType))
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			var responseBody utils.ErrorResponse
+			if err = json.Unmarshal(body, &responseBody); err != nil {
+				t.Error(err)
+				return
+			}
+
+			assert.Equal(t, tc.body, responseBody)
+		})
+	}
+}
+
+func TestErrorHandlerProblemDetails(t *testing.T) {
+	app := fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	app.Use(requestid.New(requestid.Config{Generator: func() string { return "test-request-id" }}))
+	app.Post("/users", func(c *fiber.Ctx) error {
+		return apperror.ErrValidationFailed.WithDetails(apperror.FieldError{Field: "email", Message: "is required"})
+	})
+
+	testCases := map[string]struct {
+		accept      string
+		contentType string
+	}{
+		"no accept header":       {accept: "", contentType: fiber.MIMEApplicationJSON},
+		"json":                   {accept: fiber.MIMEApplicationJSON, contentType: fiber.MIMEApplicationJSON},
+		"any":                    {accept: "*/*", contentType: fiber.MIMEApplicationJSON},
+		"problem json":           {accept: api.MIMEApplicationProblemJSON, contentType: api.MIMEApplicationProblemJSON},
+		"problem json preferred": {accept: "application/json;q=0.5, application/problem+json", contentType: api.MIMEApplicationProblemJSON},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			request, err := http.NewRequest(http.MethodPost, "/users?draft=true", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if tc.accept != "" {
+				request.Header.Add(fiber.HeaderAccept, tc.accept)
+			}
+
+			response, err := app.Test(request)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			assert.Equal(t, http.StatusUnprocessableEntity, response.StatusCode)
+			assert.Equal(t, tc.contentType, response.Header.Get(fiber.HeaderContentType))
+
+			// Only check the body for problem details, the other format is covered by TestErrorHandler
+			if tc.contentType != api.MIMEApplicationProblemJSON {
+				return
+			}
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			var problem api.Problem
+			if err = json.Unmarshal(body, &problem); err != nil {
+				t.Error(err)
+				return
+			}
+
+			assert.Equal(t, api.Problem{
+				Type:      "about:blank",
+				Title:     "Unprocessable Entity",
+				Status:    http.StatusUnprocessableEntity,
+				Detail:    "Validation failed",
+				Instance:  "/users?draft=true",
+				Code:      apperror.CodeValidationFailed,
+				RequestID: "test-request-id",
+				Errors:    []apperror.FieldError{{Field: "email", Message: "is required"}},
+			}, problem)
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"net/http"
+	"notes-app/apperror"
+	"notes-app/config"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MIMEApplicationProblemJSON is the media type for RFC 7807 problem details.
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// Problem is a struct that defines the schema for RFC 7807 problem details.
+//
+// It is sent instead of utils.ErrorResponse when the client prefers application/problem+json in the Accept header.
+type Problem struct {
+	// Type is a URI reference that identifies the problem type.
+	Type string `json:"type"`
+
+	// Title is a short, human-readable summary of the problem type.
+	Title string `json:"title"`
+
+	// Status is the HTTP status code generated by the server for this occurrence of the problem.
+	Status int `json:"status"`
+
+	// Detail is a human-readable explanation specific to this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+
+	// Instance is a URI reference that identifies the specific occurrence of the problem.
+	Instance string `json:"instance,omitempty"`
+
+	/*
+		Extension members
+	*/
+
+	// Code is the stable, machine-readable identifier for the error.
+	Code apperror.Code `json:"code"`
+
+	// RequestID is the unique ID of the request, to help with correlating errors with logs.
+	RequestID string `json:"request_id,omitempty"`
+
+	// Errors contains field-level errors, if any.
+	Errors []apperror.FieldError `json:"errors,omitempty"`
+}
+
+// newProblem creates the problem details for the given application error.
+func newProblem(c *fiber.Ctx, err *apperror.Error, requestID string) Problem {
+	return Problem{
+		Type:      problemType(err.Code),
+		Title:     http.StatusText(err.Status()),
+		Status:    err.Status(),
+		Detail:    err.Message,
+		Instance:  c.OriginalURL(),
+		Code:      err.Code,
+		RequestID: requestID,
+		Errors:    err.Details,
+	}
+}
+
+// problemType returns the type URI for the given error code.
+//
+// If no base URI is configured, "about:blank" is used, in which case the title is the HTTP status phrase as
+// recommended by RFC 7807.
+func problemType(code apperror.Code) string {
+	baseURI := config.Get().ProblemTypeBaseURI
+	if baseURI == "" {
+		return "about:blank"
+	}
+
+	// Convert codes like USER_EXISTS to user-exists
+	slug := strings.ReplaceAll(strings.ToLower(string(code)), "_", "-")
+	return strings.TrimSuffix(baseURI, "/") + "/" + slug
+}
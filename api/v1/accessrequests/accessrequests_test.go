@@ -0,0 +1,302 @@
+package accessrequests_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"notes-app/api"
+	"notes-app/api/v1/accessrequests"
+	"notes-app/apperror"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+// The current user has a pending request pendingID, and has already decided on decidedID.
+const (
+	pendingID = 1
+	decidedID = 2
+)
+
+type mockAccessRequestService struct {
+	service.IAccessRequestService
+}
+
+func (svc mockAccessRequestService) Create(requesterID, noteID uint, access, message string, opts *service.DBOpts) (models.AccessRequest, error) {
+	switch noteID {
+	case 7:
+		return models.AccessRequest{ID: 3, NoteID: noteID, RequesterID: requesterID, Access: access, Message: message, Status: models.AccessRequestPending}, nil
+	case 8:
+		// The current user owns note 8
+		return models.AccessRequest{}, apperror.ErrValidationFailed.WithDetails(apperror.FieldError{Field: "note_id", Message: "is already yours"})
+	}
+	return models.AccessRequest{}, apperror.ErrNoteNotFound
+}
+
+func (svc mockAccessRequestService) ListReceived(userID uint, status string, opts *service.DBOpts) ([]service.ReceivedAccessRequest, error) {
+	requests := []service.ReceivedAccessRequest{
+		{
+			AccessRequest: models.AccessRequest{ID: pendingID, NoteID: 7, RequesterID: 2, Access: service.AccessEdit, Status: models.AccessRequestPending},
+			RequesterName: "Jane Doe",
+		},
+		{
+			AccessRequest: models.AccessRequest{ID: decidedID, NoteID: 7, RequesterID: 3, Access: service.AccessRead, Status: models.AccessRequestDenied},
+			RequesterName: "Richard Roe",
+		},
+	}
+
+	filtered := make([]service.ReceivedAccessRequest, 0)
+	for _, request := range requests {
+		if status == "" || request.Status == status {
+			filtered = append(filtered, request)
+		}
+	}
+	return filtered, nil
+}
+
+func (svc mockAccessRequestService) ListSent(userID uint, opts *service.DBOpts) ([]models.AccessRequest, error) {
+	return []models.AccessRequest{{ID: 3, NoteID: 9, RequesterID: userID, OwnerID: 4, Access: service.AccessRead, Status: models.AccessRequestPending}}, nil
+}
+
+// decide decides on a request, same as AccessRequestService does.
+func (svc mockAccessRequestService) decide(id uint, status, access string) (models.AccessRequest, error) {
+	switch id {
+	case pendingID:
+		now := time.Now()
+		return models.AccessRequest{ID: id, Status: status, GrantedAccess: access, DecidedAt: &now}, nil
+	case decidedID:
+		return models.AccessRequest{}, apperror.ErrAccessRequestDecided
+	}
+	return models.AccessRequest{}, apperror.ErrAccessRequestNotFound
+}
+
+func (svc mockAccessRequestService) Approve(userID, id uint, access string, opts *service.DBOpts) (models.AccessRequest, error) {
+	return svc.decide(id, models.AccessRequestApproved, access)
+}
+
+func (svc mockAccessRequestService) Deny(userID, id uint, opts *service.DBOpts) (models.AccessRequest, error) {
+	return svc.decide(id, models.AccessRequestDenied, service.AccessNone)
+}
+
+func (svc mockAccessRequestService) ListEvents(userID, id uint, opts *service.DBOpts) ([]models.AccessRequestEvent, error) {
+	if id != decidedID {
+		return nil, apperror.ErrAccessRequestNotFound
+	}
+	return []models.AccessRequestEvent{
+		{ID: 1, AccessRequestID: id, NoteID: 7, ActorID: 3, Action: models.AccessRequestEventRequested, Access: service.AccessRead},
+		{ID: 2, AccessRequestID: id, NoteID: 7, ActorID: userID, Action: models.AccessRequestEventDenied},
+	}, nil
+}
+
+type mockAuthService struct {
+	service.IAuthService
+}
+
+func (svc mockAuthService) GenMiddleware(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that sets a user ID and session ID in the context
+		c.Locals("userID", "1")
+		c.Locals("sessionID", "session-1")
+		return c.Next()
+	}
+}
+
+type mockUserService struct {
+	service.IUserService
+}
+
+func (svc mockUserService) GenVerifiedEmailMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that only rejects users who say that they haven't verified their email
+		if c.Get(fiber.HeaderAuthorization) == "Bearer unverified" {
+			return apperror.ErrEmailNotVerified
+		}
+		return c.Next()
+	}
+}
+
+type accessRequestsTestSuite struct {
+	suite.Suite
+	app *fiber.App
+}
+
+func (suite *accessRequestsTestSuite) SetupSuite() {
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	suite.app = fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	accessrequests.RegisterRoutes(suite.app, accessrequests.Controller{
+		AccessRequestService: mockAccessRequestService{},
+		AuthService:          mockAuthService{},
+		UserService:          mockUserService{},
+	})
+}
+
+// send sends a request with the given JSON body, and decodes the response into out.
+//
+// Returns the status code of the response.
+func (suite *accessRequestsTestSuite) send(method, path, body string, out any) int {
+	request, err := http.NewRequest(method, path, strings.NewReader(body))
+	suite.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := suite.app.Test(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	suite.Require().NoError(json.NewDecoder(response.Body).Decode(out))
+	return response.StatusCode
+}
+
+func (suite *accessRequestsTestSuite) TestCreate() {
+	var responseBody accessrequests.AccessRequestResponse
+	status := suite.send(http.MethodPost, "/", `{"note_id": 7, "access": "edit", "message": " Can I help? "}`, &responseBody)
+
+	// Assert that the pending request is returned, without revealing the owner
+	suite.Equal(http.StatusCreated, status)
+	suite.Equal(models.AccessRequestPending, responseBody.AccessRequest.Status)
+	suite.Equal("Can I help?", responseBody.AccessRequest.Message)
+	suite.Zero(responseBody.AccessRequest.OwnerID)
+
+	testCases := map[string]struct {
+		body   string
+		status int
+		code   apperror.Code
+	}{
+		"unknown note":   {body: `{"note_id": 9, "access": "read"}`, status: http.StatusNotFound, code: apperror.CodeNoteNotFound},
+		"own note":       {body: `{"note_id": 8, "access": "read"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"missing note":   {body: `{"access": "read"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"unknown access": {body: `{"note_id": 7, "access": "admin"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var errorBody utils.ErrorResponse
+			status := suite.send(http.MethodPost, "/", tc.body, &errorBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, errorBody.Code)
+		})
+	}
+}
+
+func (suite *accessRequestsTestSuite) TestListReceived() {
+	var responseBody accessrequests.ListReceivedResponse
+	status := suite.send(http.MethodGet, "/", "", &responseBody)
+
+	// Assert that all the requests are listed along with who sent them
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(responseBody.AccessRequests, 2)
+	suite.Equal("Jane Doe", responseBody.AccessRequests[0].RequesterName)
+
+	status = suite.send(http.MethodGet, "/?status=pending", "", &responseBody)
+
+	// Assert that the requests can be filtered by status
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(responseBody.AccessRequests, 1)
+	suite.Equal(uint(pendingID), responseBody.AccessRequests[0].ID)
+
+	var errorBody utils.ErrorResponse
+	status = suite.send(http.MethodGet, "/?status=maybe", "", &errorBody)
+	suite.Equal(http.StatusUnprocessableEntity, status)
+	suite.Equal(apperror.CodeValidationFailed, errorBody.Code)
+}
+
+func (suite *accessRequestsTestSuite) TestListSent() {
+	var responseBody accessrequests.ListSentResponse
+	status := suite.send(http.MethodGet, "/sent", "", &responseBody)
+
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(responseBody.AccessRequests, 1)
+	suite.Equal(uint(9), responseBody.AccessRequests[0].NoteID)
+}
+
+func (suite *accessRequestsTestSuite) TestDecide() {
+	var responseBody accessrequests.AccessRequestResponse
+	status := suite.send(http.MethodPost, "/1/approve", `{"access": "read"}`, &responseBody)
+
+	// Assert that the decision is returned along with the granted access
+	suite.Equal(http.StatusOK, status)
+	suite.Equal(models.AccessRequestApproved, responseBody.AccessRequest.Status)
+	suite.Equal(service.AccessRead, responseBody.AccessRequest.GrantedAccess)
+	suite.NotNil(responseBody.AccessRequest.DecidedAt)
+
+	testCases := map[string]struct {
+		path   string
+		body   string
+		status int
+		code   apperror.Code
+	}{
+		"deny":                   {path: "/1/deny", status: http.StatusOK},
+		"approve unknown":        {path: "/9/approve", body: `{"access": "edit"}`, status: http.StatusNotFound, code: apperror.CodeAccessRequestNotFound},
+		"approve invalid id":     {path: "/abc/approve", body: `{"access": "edit"}`, status: http.StatusNotFound, code: apperror.CodeAccessRequestNotFound},
+		"approve decided":        {path: "/2/approve", body: `{"access": "edit"}`, status: http.StatusConflict, code: apperror.CodeAccessRequestDecided},
+		"approve unknown access": {path: "/1/approve", body: `{"access": "admin"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"approve without access": {path: "/1/approve", body: `{}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"deny decided":           {path: "/2/deny", status: http.StatusConflict, code: apperror.CodeAccessRequestDecided},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var errorBody utils.ErrorResponse
+			status := suite.send(http.MethodPost, tc.path, tc.body, &errorBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, errorBody.Code)
+			suite.Equal(tc.status < http.StatusBadRequest, errorBody.Success)
+		})
+	}
+}
+
+func (suite *accessRequestsTestSuite) TestListEvents() {
+	var responseBody accessrequests.ListEventsResponse
+	status := suite.send(http.MethodGet, "/2/events", "", &responseBody)
+
+	// Assert that the audit trail is listed in order
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(responseBody.Events, 2)
+	suite.Equal(models.AccessRequestEventRequested, responseBody.Events[0].Action)
+	suite.Equal(models.AccessRequestEventDenied, responseBody.Events[1].Action)
+
+	var errorBody utils.ErrorResponse
+	status = suite.send(http.MethodGet, "/9/events", "", &errorBody)
+	suite.Equal(http.StatusNotFound, status)
+	suite.Equal(apperror.CodeAccessRequestNotFound, errorBody.Code)
+}
+
+func (suite *accessRequestsTestSuite) TestUnverifiedEmail() {
+	testCases := map[string]struct {
+		method string
+		path   string
+		body   string
+	}{
+		"create":  {method: http.MethodPost, path: "/", body: `{"note_id": 7, "access": "edit"}`},
+		"approve": {method: http.MethodPost, path: "/1/approve", body: `{"access": "read"}`},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			request, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			suite.Require().NoError(err)
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("Authorization", "Bearer unverified")
+
+			response, err := suite.app.Test(request)
+			suite.Require().NoError(err)
+			defer response.Body.Close()
+
+			// Assert that users who haven't verified their email can't share with anyone
+			var responseBody utils.ErrorResponse
+			suite.Require().NoError(json.NewDecoder(response.Body).Decode(&responseBody))
+			suite.Equal(http.StatusForbidden, response.StatusCode)
+			suite.Equal(apperror.CodeEmailNotVerified, responseBody.Code)
+		})
+	}
+}
+
+func TestAccessRequestsRoutes(t *testing.T) {
+	suite.Run(t, new(accessRequestsTestSuite))
+}
@@ -0,0 +1,183 @@
+package accessrequests
+
+import (
+	"notes-app/apperror"
+	"notes-app/service"
+	"notes-app/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Controller defines the handlers for the v1/access-requests API.
+type Controller struct {
+	AccessRequestService service.IAccessRequestService
+	AuthService          service.IAuthService
+	UserService          service.IUserService
+}
+
+// Create asks the owner of a note for access to it, on behalf of the current user.
+//
+// Asking again while a request is pending updates that request instead.
+func (c Controller) Create(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a CreateAccessRequestRequest object
+	request := new(CreateAccessRequestRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	accessRequest, err := c.AccessRequestService.Create(userID, request.NoteID, request.Access, request.Message, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(AccessRequestResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access request sent successfully",
+		},
+		AccessRequest: accessRequest,
+	})
+}
+
+// ListReceived lists the access requests sent to the current user for their notes, which is their inbox.
+func (c Controller) ListReceived(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the query parameters into a ListReceivedQuery object
+	query := new(ListReceivedQuery)
+	if err := utils.ParseQuery(ctx, query); err != nil {
+		return err
+	}
+
+	requests, err := c.AccessRequestService.ListReceived(userID, query.Status, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListReceivedResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access requests fetched successfully",
+		},
+		AccessRequests: requests,
+	})
+}
+
+// ListSent lists the access requests sent by the current user, so that they can see what was decided.
+func (c Controller) ListSent(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	requests, err := c.AccessRequestService.ListSent(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListSentResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access requests fetched successfully",
+		},
+		AccessRequests: requests,
+	})
+}
+
+// Approve approves an access request sent to the current user, granting the access given in the request body.
+//
+// The ID of the access request is taken from the path.
+func (c Controller) Approve(ctx *fiber.Ctx) error {
+	userID, id, err := requestFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into an ApproveRequest object
+	request := new(ApproveRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	accessRequest, err := c.AccessRequestService.Approve(userID, id, request.Access, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(AccessRequestResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access request approved successfully",
+		},
+		AccessRequest: accessRequest,
+	})
+}
+
+// Deny denies an access request sent to the current user.
+//
+// The ID of the access request is taken from the path.
+func (c Controller) Deny(ctx *fiber.Ctx) error {
+	userID, id, err := requestFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	accessRequest, err := c.AccessRequestService.Deny(userID, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(AccessRequestResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access request denied successfully",
+		},
+		AccessRequest: accessRequest,
+	})
+}
+
+// ListEvents lists the audit trail of an access request sent by or to the current user.
+//
+// The ID of the access request is taken from the path.
+func (c Controller) ListEvents(ctx *fiber.Ctx) error {
+	userID, id, err := requestFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	events, err := c.AccessRequestService.ListEvents(userID, id, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListEventsResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access request events fetched successfully",
+		},
+		Events: events,
+	})
+}
+
+// requestFromCtx returns the ID of the current user, and the ID of the access request from the path.
+func requestFromCtx(ctx *fiber.Ctx) (uint, uint, error) {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	id, err := ctx.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return 0, 0, apperror.ErrAccessRequestNotFound
+	}
+
+	return userID, uint(id), nil
+}
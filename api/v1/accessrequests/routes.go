@@ -0,0 +1,19 @@
+package accessrequests
+
+import (
+	"notes-app/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(router fiber.Router, controller Controller) {
+	sharesManageMiddleware := controller.AuthService.GenMiddleware(service.ScopeSharesManage)
+	verifiedEmailMiddleware := controller.UserService.GenVerifiedEmailMiddleware()
+
+	router.Post("/", sharesManageMiddleware, verifiedEmailMiddleware, controller.Create)
+	router.Get("/", sharesManageMiddleware, controller.ListReceived)
+	router.Get("/sent", sharesManageMiddleware, controller.ListSent)
+	router.Get("/:id/events", sharesManageMiddleware, controller.ListEvents)
+	router.Post("/:id/approve", sharesManageMiddleware, verifiedEmailMiddleware, controller.Approve)
+	router.Post("/:id/deny", sharesManageMiddleware, controller.Deny)
+}
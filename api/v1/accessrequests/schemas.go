@@ -0,0 +1,58 @@
+package accessrequests
+
+import (
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+)
+
+// CreateAccessRequestRequest is a struct that represents the request for the create access request API.
+type CreateAccessRequestRequest struct {
+	NoteID uint `json:"note_id" validate:"required"`
+	// Access is the access to ask for.
+	Access string `json:"access" validate:"required,oneof=read edit"`
+	// Message is an optional message to the owner of the note.
+	Message string `json:"message" validate:"max=1000"`
+}
+
+// Normalize trims the message.
+func (r *CreateAccessRequestRequest) Normalize() {
+	r.Message = strings.TrimSpace(r.Message)
+}
+
+// ListReceivedQuery is a struct that represents the query parameters for the list received access requests API.
+type ListReceivedQuery struct {
+	// Status only lists the requests with the given status, e.g. pending for the ones waiting on a decision.
+	Status string `query:"status" validate:"omitempty,oneof=pending approved denied"`
+}
+
+// ListReceivedResponse is a struct that represents the response for the list received access requests API.
+type ListReceivedResponse struct {
+	utils.ApiResponse
+	AccessRequests []service.ReceivedAccessRequest `json:"access_requests"`
+}
+
+// ListSentResponse is a struct that represents the response for the list sent access requests API.
+type ListSentResponse struct {
+	utils.ApiResponse
+	AccessRequests []models.AccessRequest `json:"access_requests"`
+}
+
+// ApproveRequest is a struct that represents the request for the approve access request API.
+type ApproveRequest struct {
+	// Access is the access to grant, which can differ from what was asked for.
+	Access string `json:"access" validate:"required,oneof=read edit"`
+}
+
+// AccessRequestResponse is a struct that represents the response for the create, approve and deny access request APIs.
+type AccessRequestResponse struct {
+	utils.ApiResponse
+	AccessRequest models.AccessRequest `json:"access_request"`
+}
+
+// ListEventsResponse is a struct that represents the response for the list access request events API.
+type ListEventsResponse struct {
+	utils.ApiResponse
+	Events []models.AccessRequestEvent `json:"events"`
+}
@@ -0,0 +1,304 @@
+package groups_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"notes-app/api"
+	"notes-app/api/v1/groups"
+	"notes-app/apperror"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+// The current user owns personalGroupID, and is a member of the organization that orgGroupID belongs to, which they
+// can see but not manage. The current user can find user 2, but not user 3.
+const (
+	personalGroupID = 1
+	orgGroupID      = 2
+	orgID           = 5
+)
+
+type mockGroupService struct{}
+
+func (svc mockGroupService) Create(userID uint, orgID *uint, name string, opts *service.DBOpts) (models.UserGroup, error) {
+	if orgID != nil {
+		return models.UserGroup{}, apperror.ErrInsufficientRole
+	}
+	return models.UserGroup{ID: 3, Name: name, OwnerID: &userID}, nil
+}
+
+func (svc mockGroupService) List(userID uint, orgID *uint, opts *service.DBOpts) ([]models.UserGroup, error) {
+	groupID := uint(personalGroupID)
+	if orgID != nil {
+		groupID = orgGroupID
+	}
+	group, err := svc.Get(userID, groupID, opts)
+	if err != nil {
+		return nil, err
+	}
+	return []models.UserGroup{group}, nil
+}
+
+func (svc mockGroupService) Get(userID, groupID uint, opts *service.DBOpts) (models.UserGroup, error) {
+	switch groupID {
+	case personalGroupID:
+		return models.UserGroup{ID: groupID, Name: "Book club", OwnerID: &userID}, nil
+	case orgGroupID:
+		organizationID := uint(orgID)
+		return models.UserGroup{ID: groupID, Name: "Engineering", OrganizationID: &organizationID}, nil
+	}
+	return models.UserGroup{}, apperror.ErrGroupNotFound
+}
+
+// manage checks that the current user can manage the group, same as GroupService does.
+func (svc mockGroupService) manage(userID, groupID uint) (models.UserGroup, error) {
+	group, err := svc.Get(userID, groupID, nil)
+	if err != nil {
+		return models.UserGroup{}, err
+	}
+	if group.OrganizationID != nil {
+		return models.UserGroup{}, apperror.ErrInsufficientRole
+	}
+	return group, nil
+}
+
+func (svc mockGroupService) Rename(userID, groupID uint, name string, opts *service.DBOpts) (models.UserGroup, error) {
+	group, err := svc.manage(userID, groupID)
+	if err != nil {
+		return models.UserGroup{}, err
+	}
+
+	group.Name = name
+	return group, nil
+}
+
+func (svc mockGroupService) Delete(userID, groupID uint, opts *service.DBOpts) error {
+	_, err := svc.manage(userID, groupID)
+	return err
+}
+
+func (svc mockGroupService) ListMembers(userID, groupID uint, opts *service.DBOpts) ([]service.GroupMember, error) {
+	if _, err := svc.Get(userID, groupID, opts); err != nil {
+		return nil, err
+	}
+
+	return []service.GroupMember{{UserID: 2, Name: "Jane Doe"}}, nil
+}
+
+func (svc mockGroupService) AddMember(userID, groupID, memberID uint, opts *service.DBOpts) error {
+	if _, err := svc.manage(userID, groupID); err != nil {
+		return err
+	}
+	if memberID != userID && memberID != 2 {
+		return apperror.ErrUserNotFound
+	}
+	return nil
+}
+
+func (svc mockGroupService) RemoveMember(userID, groupID, memberID uint, opts *service.DBOpts) error {
+	if memberID == userID {
+		if groupID != orgGroupID {
+			return apperror.ErrGroupNotFound
+		}
+		return nil
+	}
+	if _, err := svc.manage(userID, groupID); err != nil {
+		return err
+	}
+	if memberID != 2 {
+		return apperror.ErrMemberNotFound
+	}
+	return nil
+}
+
+type mockAuthService struct {
+	service.IAuthService
+}
+
+func (svc mockAuthService) GenMiddleware(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that sets a user ID and session ID in the context
+		c.Locals("userID", "1")
+		c.Locals("sessionID", "session-1")
+		return c.Next()
+	}
+}
+
+type mockUserService struct {
+	service.IUserService
+}
+
+func (svc mockUserService) GenVerifiedEmailMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that only rejects users who say that they haven't verified their email
+		if c.Get(fiber.HeaderAuthorization) == "Bearer unverified" {
+			return apperror.ErrEmailNotVerified
+		}
+		return c.Next()
+	}
+}
+
+type groupsTestSuite struct {
+	suite.Suite
+	app *fiber.App
+}
+
+func (suite *groupsTestSuite) SetupSuite() {
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	suite.app = fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	groups.RegisterRoutes(suite.app, groups.Controller{
+		GroupService: mockGroupService{},
+		AuthService:  mockAuthService{},
+		UserService:  mockUserService{},
+	})
+}
+
+// send sends a request with the given JSON body, and decodes the response into out.
+//
+// Returns the status code of the response.
+func (suite *groupsTestSuite) send(method, path, body string, out any) int {
+	request, err := http.NewRequest(method, path, strings.NewReader(body))
+	suite.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := suite.app.Test(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	suite.Require().NoError(json.NewDecoder(response.Body).Decode(out))
+	return response.StatusCode
+}
+
+func (suite *groupsTestSuite) TestCreate() {
+	var responseBody groups.GroupResponse
+	status := suite.send(http.MethodPost, "/", `{"name": " Book club "}`, &responseBody)
+
+	// Assert that a personal group is created, without revealing its owner
+	suite.Equal(http.StatusCreated, status)
+	suite.Equal("Book club", responseBody.Group.Name)
+	suite.Nil(responseBody.Group.OwnerID)
+	suite.Nil(responseBody.Group.OrganizationID)
+
+	testCases := map[string]struct {
+		body   string
+		status int
+		code   apperror.Code
+	}{
+		"missing name":           {body: `{"name": " "}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"invalid organization":   {body: `{"name": "Engineering", "organization_id": 0}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"organization as member": {body: `{"name": "Engineering", "organization_id": 5}`, status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var errorBody utils.ErrorResponse
+			status := suite.send(http.MethodPost, "/", tc.body, &errorBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, errorBody.Code)
+		})
+	}
+}
+
+func (suite *groupsTestSuite) TestList() {
+	var responseBody groups.ListGroupsResponse
+	status := suite.send(http.MethodGet, "/", "", &responseBody)
+
+	// Assert that the personal groups are listed by default
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(responseBody.Groups, 1)
+	suite.Equal(uint(personalGroupID), responseBody.Groups[0].ID)
+
+	status = suite.send(http.MethodGet, "/?organization_id=5", "", &responseBody)
+
+	// Assert that the groups of an organization are listed when asked for
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(responseBody.Groups, 1)
+	suite.Equal(uint(orgGroupID), responseBody.Groups[0].ID)
+
+	var errorBody utils.ErrorResponse
+	status = suite.send(http.MethodGet, "/?organization_id=acme", "", &errorBody)
+	suite.Equal(http.StatusBadRequest, status)
+	suite.Equal(apperror.CodeInvalidRequest, errorBody.Code)
+}
+
+// TestPermissions checks that each route reports the errors of the service, and that IDs in the path are checked.
+func (suite *groupsTestSuite) TestPermissions() {
+	testCases := map[string]struct {
+		method string
+		path   string
+		body   string
+		status int
+		code   apperror.Code
+	}{
+		"get":                   {method: http.MethodGet, path: "/1", status: http.StatusOK},
+		"get unknown":           {method: http.MethodGet, path: "/99", status: http.StatusNotFound, code: apperror.CodeGroupNotFound},
+		"get invalid id":        {method: http.MethodGet, path: "/club", status: http.StatusNotFound, code: apperror.CodeGroupNotFound},
+		"rename":                {method: http.MethodPatch, path: "/1", body: `{"name": "Reading club"}`, status: http.StatusOK},
+		"rename without name":   {method: http.MethodPatch, path: "/1", body: `{}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"rename as member":      {method: http.MethodPatch, path: "/2", body: `{"name": "Eng"}`, status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"delete":                {method: http.MethodDelete, path: "/1", status: http.StatusOK},
+		"delete as member":      {method: http.MethodDelete, path: "/2", status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"list members":          {method: http.MethodGet, path: "/2/members", status: http.StatusOK},
+		"list unknown members":  {method: http.MethodGet, path: "/99/members", status: http.StatusNotFound, code: apperror.CodeGroupNotFound},
+		"add member":            {method: http.MethodPut, path: "/1/members/2", status: http.StatusOK},
+		"add unfindable user":   {method: http.MethodPut, path: "/1/members/3", status: http.StatusNotFound, code: apperror.CodeUserNotFound},
+		"add invalid user id":   {method: http.MethodPut, path: "/1/members/jane", status: http.StatusNotFound, code: apperror.CodeUserNotFound},
+		"add as member":         {method: http.MethodPut, path: "/2/members/2", status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"remove member":         {method: http.MethodDelete, path: "/1/members/2", status: http.StatusOK},
+		"remove unknown member": {method: http.MethodDelete, path: "/1/members/3", status: http.StatusNotFound, code: apperror.CodeMemberNotFound},
+		"remove as member":      {method: http.MethodDelete, path: "/2/members/2", status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"leave":                 {method: http.MethodDelete, path: "/2/members/1", status: http.StatusOK},
+		"leave unknown group":   {method: http.MethodDelete, path: "/99/members/1", status: http.StatusNotFound, code: apperror.CodeGroupNotFound},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var responseBody utils.ErrorResponse
+			status := suite.send(tc.method, tc.path, tc.body, &responseBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, responseBody.Code)
+			suite.Equal(tc.status < http.StatusBadRequest, responseBody.Success)
+		})
+	}
+}
+
+func (suite *groupsTestSuite) TestUnverifiedEmail() {
+	testCases := map[string]struct {
+		method string
+		path   string
+		body   string
+	}{
+		"add member": {method: http.MethodPut, path: "/1/members/2"},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			request, err := http.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+			suite.Require().NoError(err)
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("Authorization", "Bearer unverified")
+
+			response, err := suite.app.Test(request)
+			suite.Require().NoError(err)
+			defer response.Body.Close()
+
+			// Assert that users who haven't verified their email can't share with anyone
+			var responseBody utils.ErrorResponse
+			suite.Require().NoError(json.NewDecoder(response.Body).Decode(&responseBody))
+			suite.Equal(http.StatusForbidden, response.StatusCode)
+			suite.Equal(apperror.CodeEmailNotVerified, responseBody.Code)
+		})
+	}
+}
+
+func TestGroupsRoutes(t *testing.T) {
+	suite.Run(t, new(groupsTestSuite))
+}
@@ -0,0 +1,231 @@
+package groups
+
+import (
+	"notes-app/apperror"
+	"notes-app/service"
+	"notes-app/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Controller defines the handlers for the v1/groups API.
+type Controller struct {
+	GroupService service.IGroupService
+	AuthService  service.IAuthService
+	UserService  service.IUserService
+}
+
+// Create creates a group, either a personal one for the current user, or one in an organization that they administer.
+//
+// Returns a 201 Created response with the group in the response body.
+func (c Controller) Create(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a CreateGroupRequest object
+	request := new(CreateGroupRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	group, err := c.GroupService.Create(userID, request.OrganizationID, request.Name, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(GroupResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Group created successfully",
+		},
+		Group: group,
+	})
+}
+
+// List lists the personal groups of the current user, or the groups of an organization if its ID is in the query.
+func (c Controller) List(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the query parameters into a ListGroupsQuery object
+	query := new(ListGroupsQuery)
+	if err := utils.ParseQuery(ctx, query); err != nil {
+		return err
+	}
+
+	groups, err := c.GroupService.List(userID, query.OrganizationID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListGroupsResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Groups fetched successfully",
+		},
+		Groups: groups,
+	})
+}
+
+// Get returns a group that the current user can see.
+//
+// The ID of the group is taken from the path.
+func (c Controller) Get(ctx *fiber.Ctx) error {
+	userID, groupID, err := groupFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	group, err := c.GroupService.Get(userID, groupID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(GroupResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Group fetched successfully",
+		},
+		Group: group,
+	})
+}
+
+// Rename changes the name of a group that the current user manages.
+func (c Controller) Rename(ctx *fiber.Ctx) error {
+	userID, groupID, err := groupFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a RenameGroupRequest object
+	request := new(RenameGroupRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	group, err := c.GroupService.Rename(userID, groupID, request.Name, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(GroupResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Group updated successfully",
+		},
+		Group: group,
+	})
+}
+
+// Delete deletes a group that the current user manages, along with its memberships.
+func (c Controller) Delete(ctx *fiber.Ctx) error {
+	userID, groupID, err := groupFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.GroupService.Delete(userID, groupID, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Group deleted successfully",
+	})
+}
+
+// ListMembers lists the members of a group that the current user can see.
+func (c Controller) ListMembers(ctx *fiber.Ctx) error {
+	userID, groupID, err := groupFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	members, err := c.GroupService.ListMembers(userID, groupID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListMembersResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Members fetched successfully",
+		},
+		Members: members,
+	})
+}
+
+// AddMember adds a user to a group that the current user manages. Adding a member again does nothing.
+//
+// The ID of the user is taken from the path.
+func (c Controller) AddMember(ctx *fiber.Ctx) error {
+	userID, groupID, err := groupFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	memberID, err := idParam(ctx, "userID", apperror.ErrUserNotFound)
+	if err != nil {
+		return err
+	}
+
+	if err := c.GroupService.AddMember(userID, groupID, memberID, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Member added successfully",
+	})
+}
+
+// RemoveMember removes a user from a group that the current user manages. Users can remove themselves to leave a group.
+//
+// The ID of the user is taken from the path.
+func (c Controller) RemoveMember(ctx *fiber.Ctx) error {
+	userID, groupID, err := groupFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	memberID, err := idParam(ctx, "userID", apperror.ErrMemberNotFound)
+	if err != nil {
+		return err
+	}
+
+	if err := c.GroupService.RemoveMember(userID, groupID, memberID, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Member removed successfully",
+	})
+}
+
+// groupFromCtx returns the ID of the current user, and the ID of the group from the path.
+func groupFromCtx(ctx *fiber.Ctx) (uint, uint, error) {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	groupID, err := idParam(ctx, "id", apperror.ErrGroupNotFound)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return userID, groupID, nil
+}
+
+// idParam parses a positive ID from the path, returning notFound if it isn't one, since nothing can have that ID.
+func idParam(ctx *fiber.Ctx, name string, notFound error) (uint, error) {
+	id, err := ctx.ParamsInt(name)
+	if err != nil || id <= 0 {
+		return 0, notFound
+	}
+
+	return uint(id), nil
+}
@@ -0,0 +1,19 @@
+package groups
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(router fiber.Router, controller Controller) {
+	authMiddleware := controller.AuthService.GenMiddleware()
+	verifiedEmailMiddleware := controller.UserService.GenVerifiedEmailMiddleware()
+
+	router.Post("/", authMiddleware, controller.Create)
+	router.Get("/", authMiddleware, controller.List)
+	router.Get("/:id", authMiddleware, controller.Get)
+	router.Patch("/:id", authMiddleware, controller.Rename)
+	router.Delete("/:id", authMiddleware, controller.Delete)
+	router.Get("/:id/members", authMiddleware, controller.ListMembers)
+	router.Put("/:id/members/:userID", authMiddleware, verifiedEmailMiddleware, controller.AddMember)
+	router.Delete("/:id/members/:userID", authMiddleware, controller.RemoveMember)
+}
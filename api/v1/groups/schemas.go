@@ -0,0 +1,54 @@
+package groups
+
+import (
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+)
+
+// CreateGroupRequest is a struct that represents the request for the create group API.
+type CreateGroupRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+	// OrganizationID is the ID of the organization that the group belongs to, or nil for a personal group.
+	OrganizationID *uint `json:"organization_id" validate:"omitnil,min=1"`
+}
+
+// Normalize trims the name.
+func (r *CreateGroupRequest) Normalize() {
+	r.Name = strings.TrimSpace(r.Name)
+}
+
+// RenameGroupRequest is a struct that represents the request for the rename group API.
+type RenameGroupRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+// Normalize trims the name.
+func (r *RenameGroupRequest) Normalize() {
+	r.Name = strings.TrimSpace(r.Name)
+}
+
+// ListGroupsQuery is a struct that represents the query parameters for the list groups API.
+type ListGroupsQuery struct {
+	// OrganizationID is the ID of the organization to list the groups of, or nil to list personal groups.
+	OrganizationID *uint `query:"organization_id" validate:"omitnil,min=1"`
+}
+
+// GroupResponse is a struct that represents the response for the APIs that return a group.
+type GroupResponse struct {
+	utils.ApiResponse
+	Group models.UserGroup `json:"group"`
+}
+
+// ListGroupsResponse is a struct that represents the response for the list groups API.
+type ListGroupsResponse struct {
+	utils.ApiResponse
+	Groups []models.UserGroup `json:"groups"`
+}
+
+// ListMembersResponse is a struct that represents the response for the list group members API.
+type ListMembersResponse struct {
+	utils.ApiResponse
+	Members []service.GroupMember `json:"members"`
+}
@@ -0,0 +1,122 @@
+package notes
+
+import (
+	"notes-app/apperror"
+	"notes-app/service"
+	"notes-app/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Controller defines the handlers for the v1/notes API.
+type Controller struct {
+	NoteService service.INoteService
+	AuthService service.IAuthService
+	UserService service.IUserService
+}
+
+// Access returns the access that the current user has to a note, whether they own it, or it is shared with them
+// directly or through a group.
+//
+// The ID of the note is taken from the path.
+func (c Controller) Access(ctx *fiber.Ctx) error {
+	userID, noteID, err := noteFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	access, err := c.NoteService.Access(userID, noteID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(AccessResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access fetched successfully",
+		},
+		Access: access,
+	})
+}
+
+// ShareWithGroup shares a note owned by the current user with all members of a group, replacing the access that the
+// group had before. Members only have the access while they are in the group.
+//
+// The IDs of the note and the group are taken from the path.
+func (c Controller) ShareWithGroup(ctx *fiber.Ctx) error {
+	userID, noteID, err := noteFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	groupID, err := idParam(ctx, "groupID", apperror.ErrGroupNotFound)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a ShareWithGroupRequest object
+	request := new(ShareWithGroupRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	share, err := c.NoteService.ShareWithGroup(userID, noteID, groupID, request.Access, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(GroupShareResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Note shared successfully",
+		},
+		Share: share,
+	})
+}
+
+// UnshareWithGroup stops sharing a note owned by the current user with a group.
+//
+// The IDs of the note and the group are taken from the path.
+func (c Controller) UnshareWithGroup(ctx *fiber.Ctx) error {
+	userID, noteID, err := noteFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	groupID, err := idParam(ctx, "groupID", apperror.ErrNoteShareNotFound)
+	if err != nil {
+		return err
+	}
+
+	if err := c.NoteService.UnshareWithGroup(userID, noteID, groupID, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Note unshared successfully",
+	})
+}
+
+// noteFromCtx returns the ID of the current user, and the ID of the note from the path.
+func noteFromCtx(ctx *fiber.Ctx) (uint, uint, error) {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	noteID, err := idParam(ctx, "id", apperror.ErrNoteNotFound)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return userID, noteID, nil
+}
+
+// idParam parses a positive ID from the path, returning notFound if it isn't one, since nothing can have that ID.
+func idParam(ctx *fiber.Ctx, name string, notFound error) (uint, error) {
+	id, err := ctx.ParamsInt(name)
+	if err != nil || id <= 0 {
+		return 0, notFound
+	}
+
+	return uint(id), nil
+}
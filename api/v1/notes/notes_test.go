@@ -0,0 +1,210 @@
+package notes_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"notes-app/api"
+	"notes-app/api/v1/notes"
+	"notes-app/apperror"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+// The current user owns ownNoteID, and can read sharedNoteID through a group. They can see groupID, and ownNoteID is
+// already shared with it.
+const (
+	ownNoteID    = 1
+	sharedNoteID = 2
+	groupID      = 3
+)
+
+type mockNoteService struct{}
+
+func (svc mockNoteService) Access(userID, noteID uint, opts *service.DBOpts) (string, error) {
+	switch noteID {
+	case ownNoteID:
+		return service.AccessEdit, nil
+	case sharedNoteID:
+		return service.AccessRead, nil
+	}
+	return service.AccessNone, apperror.ErrNoteNotFound
+}
+
+func (svc mockNoteService) ShareWithGroup(
+	userID, noteID, groupID uint, access string, opts *service.DBOpts,
+) (models.NoteGroupShare, error) {
+	if err := svc.own(noteID, groupID); err != nil {
+		return models.NoteGroupShare{}, err
+	}
+	return models.NoteGroupShare{ID: 1, NoteID: noteID, GroupID: groupID, Access: access}, nil
+}
+
+func (svc mockNoteService) UnshareWithGroup(userID, noteID, groupID uint, opts *service.DBOpts) error {
+	return svc.own(noteID, groupID)
+}
+
+// own checks that the current user owns the note and can see the group, same as NoteService does.
+func (svc mockNoteService) own(noteID, id uint) error {
+	if noteID != ownNoteID {
+		return apperror.ErrNoteNotFound
+	}
+	if id != groupID {
+		return apperror.ErrGroupNotFound
+	}
+	return nil
+}
+
+type mockAuthService struct {
+	service.IAuthService
+}
+
+func (svc mockAuthService) GenMiddleware(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that sets a user ID and session ID in the context
+		c.Locals("userID", "1")
+		c.Locals("sessionID", "session-1")
+		return c.Next()
+	}
+}
+
+type mockUserService struct {
+	service.IUserService
+}
+
+func (svc mockUserService) GenVerifiedEmailMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that only rejects users who say that they haven't verified their email
+		if c.Get(fiber.HeaderAuthorization) == "Bearer unverified" {
+			return apperror.ErrEmailNotVerified
+		}
+		return c.Next()
+	}
+}
+
+type notesTestSuite struct {
+	suite.Suite
+	app *fiber.App
+}
+
+func (suite *notesTestSuite) SetupSuite() {
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	suite.app = fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	notes.RegisterRoutes(suite.app, notes.Controller{
+		NoteService: mockNoteService{},
+		AuthService: mockAuthService{},
+		UserService: mockUserService{},
+	})
+}
+
+// send sends a request with the given JSON body and authorization, and decodes the response into out.
+//
+// Returns the status code of the response.
+func (suite *notesTestSuite) send(method, path, body, authorization string, out any) int {
+	request, err := http.NewRequest(method, path, strings.NewReader(body))
+	suite.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response, err := suite.app.Test(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	suite.Require().NoError(json.NewDecoder(response.Body).Decode(out))
+	return response.StatusCode
+}
+
+func (suite *notesTestSuite) TestAccess() {
+	var responseBody notes.AccessResponse
+	status := suite.send(http.MethodGet, "/2/access", "", "", &responseBody)
+
+	// Assert that the access through the group is returned
+	suite.Equal(http.StatusOK, status)
+	suite.Equal(service.AccessRead, responseBody.Access)
+
+	testCases := map[string]struct {
+		path string
+	}{
+		"unknown note":    {path: "/99/access"},
+		"invalid note id": {path: "/list/access"},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var errorBody utils.ErrorResponse
+			status := suite.send(http.MethodGet, tc.path, "", "", &errorBody)
+			suite.Equal(http.StatusNotFound, status)
+			suite.Equal(apperror.CodeNoteNotFound, errorBody.Code)
+		})
+	}
+}
+
+func (suite *notesTestSuite) TestShareWithGroup() {
+	var responseBody notes.GroupShareResponse
+	status := suite.send(http.MethodPut, "/1/groups/3", `{"access": "edit"}`, "", &responseBody)
+
+	// Assert that the note is shared with the group
+	suite.Equal(http.StatusOK, status)
+	suite.Equal(uint(ownNoteID), responseBody.Share.NoteID)
+	suite.Equal(uint(groupID), responseBody.Share.GroupID)
+	suite.Equal(service.AccessEdit, responseBody.Share.Access)
+
+	testCases := map[string]struct {
+		path          string
+		body          string
+		authorization string
+		status        int
+		code          apperror.Code
+	}{
+		"missing access":   {path: "/1/groups/3", body: `{}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"unknown access":   {path: "/1/groups/3", body: `{"access": "admin"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"shared note":      {path: "/2/groups/3", body: `{"access": "read"}`, status: http.StatusNotFound, code: apperror.CodeNoteNotFound},
+		"unknown group":    {path: "/1/groups/99", body: `{"access": "read"}`, status: http.StatusNotFound, code: apperror.CodeGroupNotFound},
+		"invalid group id": {path: "/1/groups/family", body: `{"access": "read"}`, status: http.StatusNotFound, code: apperror.CodeGroupNotFound},
+		"unverified email": {path: "/1/groups/3", body: `{"access": "read"}`, authorization: "Bearer unverified", status: http.StatusForbidden, code: apperror.CodeEmailNotVerified},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var errorBody utils.ErrorResponse
+			status := suite.send(http.MethodPut, tc.path, tc.body, tc.authorization, &errorBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, errorBody.Code)
+		})
+	}
+}
+
+func (suite *notesTestSuite) TestUnshareWithGroup() {
+	testCases := map[string]struct {
+		path   string
+		status int
+		code   apperror.Code
+	}{
+		"unshare":          {path: "/1/groups/3", status: http.StatusOK},
+		"shared note":      {path: "/2/groups/3", status: http.StatusNotFound, code: apperror.CodeNoteNotFound},
+		"invalid group id": {path: "/1/groups/family", status: http.StatusNotFound, code: apperror.CodeNoteShareNotFound},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var responseBody utils.ErrorResponse
+			status := suite.send(http.MethodDelete, tc.path, "", "", &responseBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, responseBody.Code)
+			suite.Equal(tc.status < http.StatusBadRequest, responseBody.Success)
+		})
+	}
+}
+
+func TestNotesRoutes(t *testing.T) {
+	suite.Run(t, new(notesTestSuite))
+}
@@ -0,0 +1,17 @@
+package notes
+
+import (
+	"notes-app/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(router fiber.Router, controller Controller) {
+	notesReadMiddleware := controller.AuthService.GenMiddleware(service.ScopeNotesRead)
+	sharesManageMiddleware := controller.AuthService.GenMiddleware(service.ScopeSharesManage)
+	verifiedEmailMiddleware := controller.UserService.GenVerifiedEmailMiddleware()
+
+	router.Get("/:id/access", notesReadMiddleware, controller.Access)
+	router.Put("/:id/groups/:groupID", sharesManageMiddleware, verifiedEmailMiddleware, controller.ShareWithGroup)
+	router.Delete("/:id/groups/:groupID", sharesManageMiddleware, controller.UnshareWithGroup)
+}
@@ -0,0 +1,25 @@
+package notes
+
+import (
+	"notes-app/models"
+	"notes-app/utils"
+)
+
+// AccessResponse is a struct that represents the response for the note access API.
+type AccessResponse struct {
+	utils.ApiResponse
+	// Access is the access that the current user has to the note, read or edit.
+	Access string `json:"access"`
+}
+
+// ShareWithGroupRequest is a struct that represents the request for the share note with group API.
+type ShareWithGroupRequest struct {
+	// Access is the access that the members of the group get to the note.
+	Access string `json:"access" validate:"required,oneof=read edit"`
+}
+
+// GroupShareResponse is a struct that represents the response for the share note with group API.
+type GroupShareResponse struct {
+	utils.ApiResponse
+	Share models.NoteGroupShare `json:"share"`
+}
@@ -0,0 +1,336 @@
+package organizations
+
+import (
+	"notes-app/apperror"
+	"notes-app/service"
+	"notes-app/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Controller defines the handlers for the v1/organizations API.
+type Controller struct {
+	OrganizationService service.IOrganizationService
+	AuthService         service.IAuthService
+	UserService         service.IUserService
+}
+
+// Create creates an organization, with the current user as its owner.
+//
+// Returns a 201 Created response with the organization in the response body.
+func (c Controller) Create(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into an OrganizationRequest object
+	request := new(OrganizationRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	organization, err := c.OrganizationService.Create(userID, request.Name, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(OrganizationResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Organization created successfully",
+		},
+		Organization: organization,
+	})
+}
+
+// List lists the organizations that the current user is a member of, along with their role in each.
+func (c Controller) List(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	organizations, err := c.OrganizationService.List(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListOrganizationsResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Organizations fetched successfully",
+		},
+		Organizations: organizations,
+	})
+}
+
+// Get returns an organization that the current user is a member of.
+//
+// The ID of the organization is taken from the path.
+func (c Controller) Get(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	organization, err := c.OrganizationService.Get(userID, orgID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(OrganizationResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Organization fetched successfully",
+		},
+		Organization: organization,
+	})
+}
+
+// Rename changes the name of an organization. Only admins and owners can rename it.
+func (c Controller) Rename(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into an OrganizationRequest object
+	request := new(OrganizationRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	organization, err := c.OrganizationService.Rename(userID, orgID, request.Name, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(OrganizationResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Organization updated successfully",
+		},
+		Organization: organization,
+	})
+}
+
+// Delete deletes an organization, along with its memberships and invitations. Only owners can delete it.
+func (c Controller) Delete(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.OrganizationService.Delete(userID, orgID, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Organization deleted successfully",
+	})
+}
+
+// ListMembers lists the members of an organization, along with their roles. Guests can't see the other members.
+func (c Controller) ListMembers(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	members, err := c.OrganizationService.ListMembers(userID, orgID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListMembersResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Members fetched successfully",
+		},
+		Members: members,
+	})
+}
+
+// UpdateMember changes the role of a member of an organization.
+//
+// The ID of the member's user is taken from the path.
+func (c Controller) UpdateMember(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	memberID, err := idParam(ctx, "userID", apperror.ErrMemberNotFound)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into an UpdateMemberRequest object
+	request := new(UpdateMemberRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	member, err := c.OrganizationService.UpdateMemberRole(userID, orgID, memberID, request.Role, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(MemberResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Member updated successfully",
+		},
+		Member: member,
+	})
+}
+
+// RemoveMember removes a member from an organization. Members can remove themselves to leave it.
+//
+// The ID of the member's user is taken from the path.
+func (c Controller) RemoveMember(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	memberID, err := idParam(ctx, "userID", apperror.ErrMemberNotFound)
+	if err != nil {
+		return err
+	}
+
+	if err := c.OrganizationService.RemoveMember(userID, orgID, memberID, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Member removed successfully",
+	})
+}
+
+// Invite emails an invitation to join an organization to the given email, whether or not it is registered.
+//
+// Returns a 201 Created response with the invitation in the response body. The token is only ever sent in the email.
+func (c Controller) Invite(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into an InviteRequest object
+	request := new(InviteRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	invitation, err := c.OrganizationService.Invite(userID, orgID, request.Email, request.Role, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(InvitationResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Invitation sent successfully",
+		},
+		Invitation: invitation,
+	})
+}
+
+// ListInvitations lists the invitations to an organization that haven't been accepted or expired yet.
+func (c Controller) ListInvitations(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	invitations, err := c.OrganizationService.ListInvitations(userID, orgID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListInvitationsResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Invitations fetched successfully",
+		},
+		Invitations: invitations,
+	})
+}
+
+// RevokeInvitation revokes an invitation to an organization, so that it can't be accepted anymore.
+//
+// The ID of the invitation is taken from the path.
+func (c Controller) RevokeInvitation(ctx *fiber.Ctx) error {
+	userID, orgID, err := orgFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+	invitationID, err := idParam(ctx, "invitationID", apperror.ErrInvitationNotFound)
+	if err != nil {
+		return err
+	}
+
+	if err := c.OrganizationService.RevokeInvitation(userID, orgID, invitationID, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Invitation revoked successfully",
+	})
+}
+
+// AcceptInvitation makes the current user a member of the organization, with the token from the invitation sent to
+// them. The invitation must have been sent to the current user's email.
+func (c Controller) AcceptInvitation(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into an AcceptInvitationRequest object
+	request := new(AcceptInvitationRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	organization, err := c.OrganizationService.AcceptInvitation(userID, request.Token, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(OrganizationResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Invitation accepted successfully",
+		},
+		Organization: organization,
+	})
+}
+
+// orgFromCtx returns the ID of the current user, and the ID of the organization from the path.
+func orgFromCtx(ctx *fiber.Ctx) (uint, uint, error) {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	orgID, err := idParam(ctx, "id", apperror.ErrOrganizationNotFound)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return userID, orgID, nil
+}
+
+// idParam parses a positive ID from the path, returning notFound if it isn't one, since nothing can have that ID.
+func idParam(ctx *fiber.Ctx, name string, notFound error) (uint, error) {
+	id, err := ctx.ParamsInt(name)
+	if err != nil || id <= 0 {
+		return 0, notFound
+	}
+
+	return uint(id), nil
+}
@@ -0,0 +1,347 @@
+package organizations_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"notes-app/api"
+	"notes-app/api/v1/organizations"
+	"notes-app/apperror"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+// The current user owns ownedOrgID, and is a guest in guestOrgID.
+const (
+	ownedOrgID = 1
+	guestOrgID = 2
+)
+
+type mockOrganizationService struct{}
+
+func (svc mockOrganizationService) Create(userID uint, name string, opts *service.DBOpts) (service.OrgMembership, error) {
+	return service.OrgMembership{
+		Organization: models.Organization{ID: 3, CreatedAt: time.Now(), Name: name},
+		Role:         models.OrgRoleOwner,
+	}, nil
+}
+
+func (svc mockOrganizationService) List(userID uint, opts *service.DBOpts) ([]service.OrgMembership, error) {
+	owned, _ := svc.Get(userID, ownedOrgID, opts)
+	guest, _ := svc.Get(userID, guestOrgID, opts)
+	return []service.OrgMembership{owned, guest}, nil
+}
+
+func (svc mockOrganizationService) Get(userID, orgID uint, opts *service.DBOpts) (service.OrgMembership, error) {
+	switch orgID {
+	case ownedOrgID:
+		return service.OrgMembership{Organization: models.Organization{ID: orgID, Name: "Acme"}, Role: models.OrgRoleOwner}, nil
+	case guestOrgID:
+		return service.OrgMembership{Organization: models.Organization{ID: orgID, Name: "Globex"}, Role: models.OrgRoleGuest}, nil
+	}
+	return service.OrgMembership{}, apperror.ErrOrganizationNotFound
+}
+
+func (svc mockOrganizationService) RequireRole(userID, orgID uint, minRole string, opts *service.DBOpts) (string, error) {
+	organization, err := svc.Get(userID, orgID, opts)
+	if err != nil {
+		return "", err
+	}
+	if organization.Role == models.OrgRoleGuest && minRole != models.OrgRoleGuest {
+		return organization.Role, apperror.ErrInsufficientRole
+	}
+	return organization.Role, nil
+}
+
+func (svc mockOrganizationService) Rename(userID, orgID uint, name string, opts *service.DBOpts) (service.OrgMembership, error) {
+	organization, err := svc.Get(userID, orgID, opts)
+	if err != nil {
+		return service.OrgMembership{}, err
+	}
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, opts); err != nil {
+		return service.OrgMembership{}, err
+	}
+
+	organization.Name = name
+	return organization, nil
+}
+
+func (svc mockOrganizationService) Delete(userID, orgID uint, opts *service.DBOpts) error {
+	_, err := svc.RequireRole(userID, orgID, models.OrgRoleOwner, opts)
+	return err
+}
+
+func (svc mockOrganizationService) ListMembers(userID, orgID uint, opts *service.DBOpts) ([]service.OrgMember, error) {
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleMember, opts); err != nil {
+		return nil, err
+	}
+
+	return []service.OrgMember{
+		{UserID: 1, Name: "Kshitish Deshpande", Email: "me@ksdfg.dev", Role: models.OrgRoleOwner},
+		{UserID: 2, Name: "Jane Doe", Email: "jane@ksdfg.dev", Role: models.OrgRoleMember},
+	}, nil
+}
+
+func (svc mockOrganizationService) UpdateMemberRole(userID, orgID, memberID uint, role string, opts *service.DBOpts) (models.OrganizationMember, error) {
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, opts); err != nil {
+		return models.OrganizationMember{}, err
+	}
+
+	// The current user is the only owner
+	switch {
+	case memberID == userID && role != models.OrgRoleOwner:
+		return models.OrganizationMember{}, apperror.ErrLastOwner
+	case memberID != 2:
+		return models.OrganizationMember{}, apperror.ErrMemberNotFound
+	}
+
+	return models.OrganizationMember{OrganizationID: orgID, UserID: memberID, Role: role}, nil
+}
+
+func (svc mockOrganizationService) RemoveMember(userID, orgID, memberID uint, opts *service.DBOpts) error {
+	minRole := models.OrgRoleAdmin
+	if memberID == userID {
+		minRole = models.OrgRoleGuest
+	}
+	role, err := svc.RequireRole(userID, orgID, minRole, opts)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case memberID == userID && role == models.OrgRoleOwner:
+		return apperror.ErrLastOwner
+	case memberID != userID && memberID != 2:
+		return apperror.ErrMemberNotFound
+	}
+
+	return nil
+}
+
+func (svc mockOrganizationService) Invite(userID, orgID uint, email, role string, opts *service.DBOpts) (models.OrganizationInvitation, error) {
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, opts); err != nil {
+		return models.OrganizationInvitation{}, err
+	}
+	if email == "jane@ksdfg.dev" {
+		return models.OrganizationInvitation{}, apperror.ErrAlreadyMember
+	}
+
+	return models.OrganizationInvitation{
+		ID:             1,
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		TokenHash:      "hashed-token",
+		ExpiresAt:      time.Now().Add(7 * 24 * time.Hour),
+	}, nil
+}
+
+func (svc mockOrganizationService) ListInvitations(userID, orgID uint, opts *service.DBOpts) ([]models.OrganizationInvitation, error) {
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, opts); err != nil {
+		return nil, err
+	}
+
+	invitation, _ := svc.Invite(userID, orgID, "new@ksdfg.dev", models.OrgRoleMember, opts)
+	return []models.OrganizationInvitation{invitation}, nil
+}
+
+func (svc mockOrganizationService) RevokeInvitation(userID, orgID, invitationID uint, opts *service.DBOpts) error {
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, opts); err != nil {
+		return err
+	}
+	if invitationID != 1 {
+		return apperror.ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+func (svc mockOrganizationService) AcceptInvitation(userID uint, token string, opts *service.DBOpts) (service.OrgMembership, error) {
+	switch token {
+	case "invitation-token":
+		return svc.Get(userID, guestOrgID, opts)
+	case "other-email-token":
+		return service.OrgMembership{}, apperror.ErrInvitationEmailMismatch
+	case "expired-token":
+		return service.OrgMembership{}, apperror.ErrTokenExpired
+	}
+	return service.OrgMembership{}, apperror.ErrTokenInvalid
+}
+
+type mockAuthService struct {
+	service.IAuthService
+}
+
+func (svc mockAuthService) GenMiddleware(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that sets a user ID and session ID in the context
+		c.Locals("userID", "1")
+		c.Locals("sessionID", "session-1")
+		return c.Next()
+	}
+}
+
+type mockUserService struct {
+	service.IUserService
+}
+
+func (svc mockUserService) GenVerifiedEmailMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.Next()
+	}
+}
+
+type organizationsTestSuite struct {
+	suite.Suite
+	app *fiber.App
+}
+
+func (suite *organizationsTestSuite) SetupSuite() {
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	suite.app = fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	organizations.RegisterRoutes(suite.app, organizations.Controller{
+		OrganizationService: mockOrganizationService{},
+		AuthService:         mockAuthService{},
+		UserService:         mockUserService{},
+	})
+}
+
+// send sends a request with the given JSON body, and decodes the response into out.
+//
+// Returns the status code of the response.
+func (suite *organizationsTestSuite) send(method, path, body string, out any) int {
+	request, err := http.NewRequest(method, path, strings.NewReader(body))
+	suite.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+
+	response, err := suite.app.Test(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	suite.Require().NoError(json.NewDecoder(response.Body).Decode(out))
+	return response.StatusCode
+}
+
+func (suite *organizationsTestSuite) TestCreate() {
+	var responseBody organizations.OrganizationResponse
+	status := suite.send(http.MethodPost, "/", `{"name": " Initech "}`, &responseBody)
+
+	// Assert that the organization is created, with the current user as its owner
+	suite.Equal(http.StatusCreated, status)
+	suite.Equal("Initech", responseBody.Organization.Name)
+	suite.Equal(models.OrgRoleOwner, responseBody.Organization.Role)
+
+	var errorBody utils.ErrorResponse
+	status = suite.send(http.MethodPost, "/", `{"name": " "}`, &errorBody)
+	suite.Equal(http.StatusUnprocessableEntity, status)
+	suite.Equal(apperror.CodeValidationFailed, errorBody.Code)
+}
+
+func (suite *organizationsTestSuite) TestList() {
+	var responseBody organizations.ListOrganizationsResponse
+	status := suite.send(http.MethodGet, "/", "", &responseBody)
+
+	// Assert that the organizations are listed along with the current user's role in each
+	suite.Equal(http.StatusOK, status)
+	suite.Len(responseBody.Organizations, 2)
+	suite.Equal(models.OrgRoleOwner, responseBody.Organizations[0].Role)
+	suite.Equal(models.OrgRoleGuest, responseBody.Organizations[1].Role)
+}
+
+func (suite *organizationsTestSuite) TestAcceptInvitation() {
+	var responseBody organizations.OrganizationResponse
+	status := suite.send(http.MethodPost, "/invitations/accept", `{"token": "invitation-token"}`, &responseBody)
+
+	// Assert that the organization that the user joined is returned
+	suite.Equal(http.StatusOK, status)
+	suite.Equal(uint(guestOrgID), responseBody.Organization.ID)
+
+	testCases := map[string]struct {
+		body   string
+		status int
+		code   apperror.Code
+	}{
+		"other email":   {body: `{"token": "other-email-token"}`, status: http.StatusForbidden, code: apperror.CodeInvitationEmailMismatch},
+		"expired token": {body: `{"token": "expired-token"}`, status: http.StatusUnauthorized, code: apperror.CodeTokenExpired},
+		"invalid token": {body: `{"token": "nosuchtoken"}`, status: http.StatusUnauthorized, code: apperror.CodeTokenInvalid},
+		"missing token": {body: `{}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var errorBody utils.ErrorResponse
+			status := suite.send(http.MethodPost, "/invitations/accept", tc.body, &errorBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, errorBody.Code)
+		})
+	}
+}
+
+// TestPermissions checks that each route reports the errors of the service, and that IDs in the path are checked.
+func (suite *organizationsTestSuite) TestPermissions() {
+	testCases := map[string]struct {
+		method string
+		path   string
+		body   string
+		status int
+		code   apperror.Code
+	}{
+		"get":                      {method: http.MethodGet, path: "/1", status: http.StatusOK},
+		"get unknown":              {method: http.MethodGet, path: "/99", status: http.StatusNotFound, code: apperror.CodeOrganizationNotFound},
+		"get invalid id":           {method: http.MethodGet, path: "/acme", status: http.StatusNotFound, code: apperror.CodeOrganizationNotFound},
+		"rename":                   {method: http.MethodPatch, path: "/1", body: `{"name": "Acme Corp"}`, status: http.StatusOK},
+		"rename as guest":          {method: http.MethodPatch, path: "/2", body: `{"name": "Globex Corp"}`, status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"delete":                   {method: http.MethodDelete, path: "/1", status: http.StatusOK},
+		"delete as guest":          {method: http.MethodDelete, path: "/2", status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"list members":             {method: http.MethodGet, path: "/1/members", status: http.StatusOK},
+		"list members as guest":    {method: http.MethodGet, path: "/2/members", status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"update member":            {method: http.MethodPatch, path: "/1/members/2", body: `{"role": "admin"}`, status: http.StatusOK},
+		"update unknown member":    {method: http.MethodPatch, path: "/1/members/9", body: `{"role": "admin"}`, status: http.StatusNotFound, code: apperror.CodeMemberNotFound},
+		"update member to unknown": {method: http.MethodPatch, path: "/1/members/2", body: `{"role": "boss"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"demote last owner":        {method: http.MethodPatch, path: "/1/members/1", body: `{"role": "member"}`, status: http.StatusConflict, code: apperror.CodeLastOwner},
+		"remove member":            {method: http.MethodDelete, path: "/1/members/2", status: http.StatusOK},
+		"last owner leaves":        {method: http.MethodDelete, path: "/1/members/1", status: http.StatusConflict, code: apperror.CodeLastOwner},
+		"guest leaves":             {method: http.MethodDelete, path: "/2/members/1", status: http.StatusOK},
+		"remove as guest":          {method: http.MethodDelete, path: "/2/members/2", status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"invite":                   {method: http.MethodPost, path: "/1/invitations", body: `{"email": " New@KSDFG.dev", "role": "member"}`, status: http.StatusCreated},
+		"invite member":            {method: http.MethodPost, path: "/1/invitations", body: `{"email": "jane@ksdfg.dev", "role": "member"}`, status: http.StatusConflict, code: apperror.CodeAlreadyMember},
+		"invite as guest":          {method: http.MethodPost, path: "/2/invitations", body: `{"email": "new@ksdfg.dev", "role": "guest"}`, status: http.StatusForbidden, code: apperror.CodeInsufficientRole},
+		"invite invalid email":     {method: http.MethodPost, path: "/1/invitations", body: `{"email": "new", "role": "member"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"list invitations":         {method: http.MethodGet, path: "/1/invitations", status: http.StatusOK},
+		"revoke invitation":        {method: http.MethodDelete, path: "/1/invitations/1", status: http.StatusOK},
+		"revoke unknown":           {method: http.MethodDelete, path: "/1/invitations/9", status: http.StatusNotFound, code: apperror.CodeInvitationNotFound},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var responseBody utils.ErrorResponse
+			status := suite.send(tc.method, tc.path, tc.body, &responseBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, responseBody.Code)
+			suite.Equal(tc.status < http.StatusBadRequest, responseBody.Success)
+		})
+	}
+}
+
+func (suite *organizationsTestSuite) TestInviteNormalizesEmail() {
+	var responseBody organizations.InvitationResponse
+	status := suite.send(http.MethodPost, "/1/invitations", `{"email": " New@KSDFG.dev", "role": "admin"}`, &responseBody)
+
+	// Assert that the invitation is returned without its token
+	suite.Equal(http.StatusCreated, status)
+	suite.Equal("new@ksdfg.dev", responseBody.Invitation.Email)
+	suite.Equal(models.OrgRoleAdmin, responseBody.Invitation.Role)
+	suite.Empty(responseBody.Invitation.TokenHash)
+}
+
+func TestOrganizationsRoutes(t *testing.T) {
+	suite.Run(t, new(organizationsTestSuite))
+}
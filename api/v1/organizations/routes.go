@@ -0,0 +1,23 @@
+package organizations
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(router fiber.Router, controller Controller) {
+	authMiddleware := controller.AuthService.GenMiddleware()
+	verifiedEmailMiddleware := controller.UserService.GenVerifiedEmailMiddleware()
+
+	router.Post("/", authMiddleware, controller.Create)
+	router.Get("/", authMiddleware, controller.List)
+	router.Post("/invitations/accept", authMiddleware, controller.AcceptInvitation)
+	router.Get("/:id", authMiddleware, controller.Get)
+	router.Patch("/:id", authMiddleware, controller.Rename)
+	router.Delete("/:id", authMiddleware, controller.Delete)
+	router.Get("/:id/members", authMiddleware, controller.ListMembers)
+	router.Patch("/:id/members/:userID", authMiddleware, controller.UpdateMember)
+	router.Delete("/:id/members/:userID", authMiddleware, controller.RemoveMember)
+	router.Post("/:id/invitations", authMiddleware, verifiedEmailMiddleware, controller.Invite)
+	router.Get("/:id/invitations", authMiddleware, controller.ListInvitations)
+	router.Delete("/:id/invitations/:invitationID", authMiddleware, controller.RevokeInvitation)
+}
@@ -0,0 +1,76 @@
+package organizations
+
+import (
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+)
+
+// OrganizationRequest is a struct that represents the request for the create and rename organization APIs.
+type OrganizationRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+}
+
+// Normalize trims the name.
+func (r *OrganizationRequest) Normalize() {
+	r.Name = strings.TrimSpace(r.Name)
+}
+
+// OrganizationResponse is a struct that represents the response for the APIs that return an organization, along with
+// the role of the current user in it.
+type OrganizationResponse struct {
+	utils.ApiResponse
+	Organization service.OrgMembership `json:"organization"`
+}
+
+// ListOrganizationsResponse is a struct that represents the response for the list organizations API.
+type ListOrganizationsResponse struct {
+	utils.ApiResponse
+	Organizations []service.OrgMembership `json:"organizations"`
+}
+
+// ListMembersResponse is a struct that represents the response for the list members API.
+type ListMembersResponse struct {
+	utils.ApiResponse
+	Members []service.OrgMember `json:"members"`
+}
+
+// UpdateMemberRequest is a struct that represents the request for the update member API.
+type UpdateMemberRequest struct {
+	Role string `json:"role" validate:"required,oneof=owner admin member guest"`
+}
+
+// MemberResponse is a struct that represents the response for the update member API.
+type MemberResponse struct {
+	utils.ApiResponse
+	Member models.OrganizationMember `json:"member"`
+}
+
+// InviteRequest is a struct that represents the request for the invite API.
+type InviteRequest struct {
+	Email string `json:"email" validate:"required,email,max=255"`
+	Role  string `json:"role" validate:"required,oneof=owner admin member guest"`
+}
+
+// Normalize normalizes the email, so that it matches the email of the user once they register.
+func (r *InviteRequest) Normalize() {
+	r.Email = utils.NormalizeEmail(r.Email)
+}
+
+// InvitationResponse is a struct that represents the response for the invite API.
+type InvitationResponse struct {
+	utils.ApiResponse
+	Invitation models.OrganizationInvitation `json:"invitation"`
+}
+
+// ListInvitationsResponse is a struct that represents the response for the list invitations API.
+type ListInvitationsResponse struct {
+	utils.ApiResponse
+	Invitations []models.OrganizationInvitation `json:"invitations"`
+}
+
+// AcceptInvitationRequest is a struct that represents the request for the accept invitation API.
+type AcceptInvitationRequest struct {
+	Token string `json:"token" validate:"required"`
+}
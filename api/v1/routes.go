@@ -1,15 +1,33 @@
 package v1
 
 import (
+	"notes-app/api/v1/accessrequests"
+	"notes-app/api/v1/groups"
+	"notes-app/api/v1/notes"
+	"notes-app/api/v1/organizations"
+	"notes-app/api/v1/sharelinks"
 	"notes-app/api/v1/users"
 	"notes-app/service"
+	"notes-app/utils"
 
 	"github.com/gofiber/fiber/v2"
 )
 
 type Services struct {
-	UserService service.IUserService
-	AuthService service.IAuthService
+	UserService          service.IUserService
+	AuthService          service.IAuthService
+	SessionService       service.ISessionService
+	AccessTokenService   service.IAccessTokenService
+	TOTPService          service.ITOTPService
+	LoginThrottleService service.ILoginThrottleService
+	OIDCService          service.IOIDCService
+	PrivacyService       service.IPrivacyService
+	OrganizationService  service.IOrganizationService
+	GroupService         service.IGroupService
+	ShareLinkService     service.IShareLinkService
+	AccessRequestService service.IAccessRequestService
+	NoteService          service.INoteService
+	MailQueue            *utils.WorkerPool
 }
 
 // RegisterRoutes registers v1 routes for the API.
@@ -21,7 +39,49 @@ func RegisterRoutes(router fiber.Router, services Services) {
 
 	// Register the routes for the users controller
 	users.RegisterRoutes(router.Group("/users"), users.Controller{
-		UserService: services.UserService,
+		UserService:          services.UserService,
+		AuthService:          services.AuthService,
+		SessionService:       services.SessionService,
+		AccessTokenService:   services.AccessTokenService,
+		TOTPService:          services.TOTPService,
+		LoginThrottleService: services.LoginThrottleService,
+		OIDCService:          services.OIDCService,
+		PrivacyService:       services.PrivacyService,
+		MailQueue:            services.MailQueue,
+	})
+
+	// Register the routes for the organizations controller
+	organizations.RegisterRoutes(router.Group("/organizations"), organizations.Controller{
+		OrganizationService: services.OrganizationService,
+		AuthService:         services.AuthService,
+		UserService:         services.UserService,
+	})
+
+	// Register the routes for the groups controller
+	groups.RegisterRoutes(router.Group("/groups"), groups.Controller{
+		GroupService: services.GroupService,
+		AuthService:  services.AuthService,
+		UserService:  services.UserService,
+	})
+
+	// Register the routes for the share links controller
+	sharelinks.RegisterRoutes(router.Group("/share-links"), sharelinks.Controller{
+		ShareLinkService: services.ShareLinkService,
+		AuthService:      services.AuthService,
+		UserService:      services.UserService,
+	})
+
+	// Register the routes for the access requests controller
+	accessrequests.RegisterRoutes(router.Group("/access-requests"), accessrequests.Controller{
+		AccessRequestService: services.AccessRequestService,
+		AuthService:          services.AuthService,
+		UserService:          services.UserService,
+	})
+
+	// Register the routes for the notes controller
+	notes.RegisterRoutes(router.Group("/notes"), notes.Controller{
+		NoteService: services.NoteService,
 		AuthService: services.AuthService,
+		UserService: services.UserService,
 	})
 }
@@ -0,0 +1,142 @@
+package sharelinks
+
+import (
+	"notes-app/apperror"
+	"notes-app/service"
+	"notes-app/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Controller defines the handlers for the v1/share-links API.
+type Controller struct {
+	ShareLinkService service.IShareLinkService
+	AuthService      service.IAuthService
+	UserService      service.IUserService
+}
+
+// Create creates a share link for a note owned by the current user.
+//
+// The link itself is only returned here, and can't be retrieved again.
+func (c Controller) Create(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a CreateShareLinkRequest object
+	request := new(CreateShareLinkRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	link, url, err := c.ShareLinkService.Create(userID, request.NoteID, service.ShareLinkOptions{
+		Access:    request.Access,
+		Password:  request.Password,
+		ExpiresAt: request.ExpiresAt,
+		MaxUses:   request.MaxUses,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(CreateShareLinkResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Share link created successfully",
+		},
+		ShareLink: link,
+		URL:       url,
+	})
+}
+
+// List lists the share links created by the current user that have not been revoked.
+func (c Controller) List(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	links, err := c.ShareLinkService.List(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListShareLinksResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Share links fetched successfully",
+		},
+		ShareLinks: links,
+	})
+}
+
+// Revoke revokes a share link created by the current user, ending the sessions opened with it right away.
+//
+// The ID of the share link is taken from the path.
+func (c Controller) Revoke(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := ctx.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apperror.ErrShareLinkNotFound
+	}
+
+	if err := c.ShareLinkService.Revoke(userID, uint(id), nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Share link revoked successfully",
+	})
+}
+
+// Open opens a share link, and starts an anonymous session that only gives access to the note it was shared for.
+//
+// Visitors don't need to be logged in, so this route isn't authenticated. Wrong passwords are throttled per link and
+// per IP address instead.
+func (c Controller) Open(ctx *fiber.Ctx) error {
+	// Parse and validate the request body into an OpenShareLinkRequest object
+	request := new(OpenShareLinkRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	link, sessionToken, expiresAt, err := c.ShareLinkService.Open(
+		request.Token, request.Password, service.ClientInfoFromCtx(ctx).IPAddress, nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(OpenShareLinkResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Share link opened successfully",
+		},
+		ShareLink:             link,
+		SessionToken:          sessionToken,
+		SessionTokenExpiresAt: expiresAt,
+	})
+}
+
+// Session returns the share link of the anonymous session making the request, so that visitors can tell what they
+// have access to.
+func (c Controller) Session(ctx *fiber.Ctx) error {
+	link, err := service.ShareLinkFromCtx(ctx)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ShareLinkResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Share link fetched successfully",
+		},
+		ShareLink: link,
+	})
+}
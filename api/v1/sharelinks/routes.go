@@ -0,0 +1,19 @@
+package sharelinks
+
+import (
+	"notes-app/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func RegisterRoutes(router fiber.Router, controller Controller) {
+	sharesManageMiddleware := controller.AuthService.GenMiddleware(service.ScopeSharesManage)
+	shareSessionMiddleware := controller.ShareLinkService.GenMiddleware()
+	verifiedEmailMiddleware := controller.UserService.GenVerifiedEmailMiddleware()
+
+	router.Post("/", sharesManageMiddleware, verifiedEmailMiddleware, controller.Create)
+	router.Get("/", sharesManageMiddleware, controller.List)
+	router.Delete("/:id", sharesManageMiddleware, controller.Revoke)
+	router.Post("/open", controller.Open)
+	router.Get("/session", shareSessionMiddleware, controller.Session)
+}
@@ -0,0 +1,59 @@
+package sharelinks
+
+import (
+	"notes-app/models"
+	"notes-app/utils"
+	"time"
+)
+
+// CreateShareLinkRequest is a struct that represents the request for the create share link API.
+type CreateShareLinkRequest struct {
+	NoteID uint `json:"note_id" validate:"required"`
+	// Access is the access that the link grants to the note.
+	Access string `json:"access" validate:"required,oneof=read edit"`
+	// Password is the password that has to be entered to open the link, or empty for none.
+	Password string `json:"password" validate:"max=128"`
+	// ExpiresAt is when the link stops working, or nil for never.
+	ExpiresAt *time.Time `json:"expires_at"`
+	// MaxUses is how many times the link can be opened, or nil for no limit.
+	MaxUses *int `json:"max_uses" validate:"omitnil,min=1"`
+}
+
+// CreateShareLinkResponse is a struct that represents the response for the create share link API.
+type CreateShareLinkResponse struct {
+	utils.ApiResponse
+	ShareLink models.ShareLink `json:"share_link"`
+
+	// URL is the share link itself, which is only shown once.
+	URL string `json:"url"`
+}
+
+// ListShareLinksResponse is a struct that represents the response for the list share links API.
+type ListShareLinksResponse struct {
+	utils.ApiResponse
+	ShareLinks []models.ShareLink `json:"share_links"`
+}
+
+// OpenShareLinkRequest is a struct that represents the request for the open share link API.
+type OpenShareLinkRequest struct {
+	// Token is the token from the share link.
+	Token string `json:"token" validate:"required"`
+	// Password is the password of the share link, if it has one.
+	Password string `json:"password" validate:"max=128"`
+}
+
+// OpenShareLinkResponse is a struct that represents the response for the open share link API.
+type OpenShareLinkResponse struct {
+	utils.ApiResponse
+	ShareLink models.ShareLink `json:"share_link"`
+
+	// SessionToken is the token of the anonymous session, to send in an Authorization: Bearer header.
+	SessionToken          string    `json:"session_token"`
+	SessionTokenExpiresAt time.Time `json:"session_token_expires_at"`
+}
+
+// ShareLinkResponse is a struct that represents the response for the APIs that return a share link.
+type ShareLinkResponse struct {
+	utils.ApiResponse
+	ShareLink models.ShareLink `json:"share_link"`
+}
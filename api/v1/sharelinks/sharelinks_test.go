@@ -0,0 +1,267 @@
+package sharelinks_test
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"notes-app/api"
+	"notes-app/api/v1/sharelinks"
+	"notes-app/apperror"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/suite"
+)
+
+type mockShareLinkService struct {
+	service.IShareLinkService
+}
+
+func (svc mockShareLinkService) Create(userID, noteID uint, options service.ShareLinkOptions, opts *service.DBOpts) (models.ShareLink, string, error) {
+	if noteID != 7 {
+		return models.ShareLink{}, "", apperror.ErrNoteNotFound
+	}
+
+	link := models.ShareLink{ID: 1, NoteID: noteID, Access: options.Access, TokenHash: "hashed-token", PasswordProtected: options.Password != ""}
+	return link, "http://localhost:3000/share?token=link-token", nil
+}
+
+func (svc mockShareLinkService) List(userID uint, opts *service.DBOpts) ([]models.ShareLink, error) {
+	return []models.ShareLink{{ID: 1, NoteID: 7, Access: service.AccessRead, TokenHash: "hashed-token"}}, nil
+}
+
+func (svc mockShareLinkService) Revoke(userID, id uint, opts *service.DBOpts) error {
+	if id != 1 {
+		return apperror.ErrShareLinkNotFound
+	}
+	return nil
+}
+
+func (svc mockShareLinkService) Open(token, password, ip string, opts *service.DBOpts) (models.ShareLink, string, time.Time, error) {
+	switch {
+	case token == "locked-token":
+		return models.ShareLink{}, "", time.Time{}, apperror.ErrTooManyShareAttempts
+	case token == "expired-token":
+		return models.ShareLink{}, "", time.Time{}, apperror.ErrShareLinkExpired
+	case token == "protected-token" && password != "open sesame":
+		return models.ShareLink{}, "", time.Time{}, apperror.ErrSharePasswordInvalid
+	case token != "link-token" && token != "protected-token":
+		return models.ShareLink{}, "", time.Time{}, apperror.ErrShareLinkNotFound
+	}
+
+	link := models.ShareLink{ID: 1, NoteID: 7, Access: service.AccessEdit, TokenHash: "hashed-token", Uses: 1}
+	return link, service.ShareSessionPrefix + "session", time.Now().Add(time.Hour), nil
+}
+
+func (svc mockShareLinkService) GenMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that only accepts the session token returned by Open
+		if c.Get(fiber.HeaderAuthorization) != "Bearer "+service.ShareSessionPrefix+"session" {
+			return apperror.ErrUnauthorized
+		}
+		c.Locals("shareLink", models.ShareLink{ID: 1, NoteID: 7, Access: service.AccessEdit})
+		return c.Next()
+	}
+}
+
+type mockAuthService struct {
+	service.IAuthService
+}
+
+func (svc mockAuthService) GenMiddleware(scopes ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Personal access tokens are only accepted with the shares:manage scope
+		if c.Get(fiber.HeaderAuthorization) == "Bearer nap_noscopes" && len(scopes) > 0 {
+			return apperror.ErrInsufficientScope
+		}
+
+		// Mock middleware that sets a user ID and session ID in the context
+		c.Locals("userID", "1")
+		c.Locals("sessionID", "session-1")
+		return c.Next()
+	}
+}
+
+type mockUserService struct {
+	service.IUserService
+}
+
+func (svc mockUserService) GenVerifiedEmailMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Mock middleware that only rejects users who say that they haven't verified their email
+		if c.Get(fiber.HeaderAuthorization) == "Bearer unverified" {
+			return apperror.ErrEmailNotVerified
+		}
+		return c.Next()
+	}
+}
+
+type shareLinksTestSuite struct {
+	suite.Suite
+	app *fiber.App
+}
+
+func (suite *shareLinksTestSuite) SetupSuite() {
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	suite.app = fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
+	sharelinks.RegisterRoutes(suite.app, sharelinks.Controller{
+		ShareLinkService: mockShareLinkService{},
+		AuthService:      mockAuthService{},
+		UserService:      mockUserService{},
+	})
+}
+
+// send sends a request with the given JSON body and headers, and decodes the response into out.
+//
+// Returns the status code of the response.
+func (suite *shareLinksTestSuite) send(method, path, body string, headers map[string]string, out any) int {
+	request, err := http.NewRequest(method, path, strings.NewReader(body))
+	suite.Require().NoError(err)
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	response, err := suite.app.Test(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	suite.Require().NoError(json.NewDecoder(response.Body).Decode(out))
+	return response.StatusCode
+}
+
+func (suite *shareLinksTestSuite) TestCreate() {
+	var responseBody sharelinks.CreateShareLinkResponse
+	status := suite.send(http.MethodPost, "/", `{"note_id": 7, "access": "read", "password": "open sesame", "max_uses": 5}`, nil, &responseBody)
+
+	// Assert that the link is returned along with the record, without its hashes
+	suite.Equal(http.StatusCreated, status)
+	suite.Equal("http://localhost:3000/share?token=link-token", responseBody.URL)
+	suite.Equal(uint(7), responseBody.ShareLink.NoteID)
+	suite.True(responseBody.ShareLink.PasswordProtected)
+	suite.Empty(responseBody.ShareLink.TokenHash)
+
+	testCases := map[string]struct {
+		body    string
+		headers map[string]string
+		status  int
+		code    apperror.Code
+	}{
+		"unknown note":   {body: `{"note_id": 9, "access": "read"}`, status: http.StatusNotFound, code: apperror.CodeNoteNotFound},
+		"missing note":   {body: `{"access": "read"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"invalid access": {body: `{"note_id": 7, "access": "admin"}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"invalid uses":   {body: `{"note_id": 7, "access": "read", "max_uses": 0}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"missing scope": {
+			body:    `{"note_id": 7, "access": "read"}`,
+			headers: map[string]string{"Authorization": "Bearer nap_noscopes"},
+			status:  http.StatusForbidden,
+			code:    apperror.CodeInsufficientScope,
+		},
+		"unverified email": {
+			body:    `{"note_id": 7, "access": "read"}`,
+			headers: map[string]string{"Authorization": "Bearer unverified"},
+			status:  http.StatusForbidden,
+			code:    apperror.CodeEmailNotVerified,
+		},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var errorBody utils.ErrorResponse
+			status := suite.send(http.MethodPost, "/", tc.body, tc.headers, &errorBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, errorBody.Code)
+		})
+	}
+}
+
+func (suite *shareLinksTestSuite) TestList() {
+	var responseBody sharelinks.ListShareLinksResponse
+	status := suite.send(http.MethodGet, "/", "", nil, &responseBody)
+
+	// Assert that the links are listed without their token hashes
+	suite.Equal(http.StatusOK, status)
+	suite.Require().Len(responseBody.ShareLinks, 1)
+	suite.Equal(uint(7), responseBody.ShareLinks[0].NoteID)
+	suite.Empty(responseBody.ShareLinks[0].TokenHash)
+}
+
+func (suite *shareLinksTestSuite) TestRevoke() {
+	testCases := map[string]struct {
+		path   string
+		status int
+		code   apperror.Code
+	}{
+		"revoke":         {path: "/1", status: http.StatusOK},
+		"revoke unknown": {path: "/9", status: http.StatusNotFound, code: apperror.CodeShareLinkNotFound},
+		"invalid id":     {path: "/abc", status: http.StatusNotFound, code: apperror.CodeShareLinkNotFound},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var responseBody utils.ErrorResponse
+			status := suite.send(http.MethodDelete, tc.path, "", nil, &responseBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, responseBody.Code)
+		})
+	}
+}
+
+func (suite *shareLinksTestSuite) TestOpen() {
+	var responseBody sharelinks.OpenShareLinkResponse
+	status := suite.send(http.MethodPost, "/open", `{"token": "protected-token", "password": "open sesame"}`, nil, &responseBody)
+
+	// Assert that an anonymous session is started for the note of the link
+	suite.Equal(http.StatusOK, status)
+	suite.Equal(uint(7), responseBody.ShareLink.NoteID)
+	suite.True(strings.HasPrefix(responseBody.SessionToken, service.ShareSessionPrefix))
+	suite.False(responseBody.SessionTokenExpiresAt.IsZero())
+
+	testCases := map[string]struct {
+		body   string
+		status int
+		code   apperror.Code
+	}{
+		"wrong password":   {body: `{"token": "protected-token", "password": "guess"}`, status: http.StatusUnauthorized, code: apperror.CodeSharePasswordInvalid},
+		"missing password": {body: `{"token": "protected-token"}`, status: http.StatusUnauthorized, code: apperror.CodeSharePasswordInvalid},
+		"expired link":     {body: `{"token": "expired-token"}`, status: http.StatusGone, code: apperror.CodeShareLinkExpired},
+		"locked out":       {body: `{"token": "locked-token", "password": "guess"}`, status: http.StatusTooManyRequests, code: apperror.CodeTooManyShareAttempts},
+		"unknown link":     {body: `{"token": "nosuchtoken"}`, status: http.StatusNotFound, code: apperror.CodeShareLinkNotFound},
+		"missing token":    {body: `{}`, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			var errorBody utils.ErrorResponse
+			status := suite.send(http.MethodPost, "/open", tc.body, nil, &errorBody)
+			suite.Equal(tc.status, status)
+			suite.Equal(tc.code, errorBody.Code)
+		})
+	}
+}
+
+func (suite *shareLinksTestSuite) TestSession() {
+	var responseBody sharelinks.ShareLinkResponse
+	headers := map[string]string{"Authorization": "Bearer " + service.ShareSessionPrefix + "session"}
+	status := suite.send(http.MethodGet, "/session", "", headers, &responseBody)
+
+	// Assert that the link of the anonymous session is returned
+	suite.Equal(http.StatusOK, status)
+	suite.Equal(uint(7), responseBody.ShareLink.NoteID)
+	suite.Equal(service.AccessEdit, responseBody.ShareLink.Access)
+
+	var errorBody utils.ErrorResponse
+	status = suite.send(http.MethodGet, "/session", "", nil, &errorBody)
+	suite.Equal(http.StatusUnauthorized, status)
+	suite.Equal(apperror.CodeUnauthorized, errorBody.Code)
+}
+
+func TestShareLinksRoutes(t *testing.T) {
+	suite.Run(t, new(shareLinksTestSuite))
+}
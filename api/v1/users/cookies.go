@@ -0,0 +1,93 @@
+package users
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/service"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// authCookieName is the name of the cookie holding the access token.
+	authCookieName = "authorization"
+	// refreshCookieName is the name of the cookie holding the refresh token.
+	refreshCookieName = "refresh_token"
+	// oidcStateCookieName is the name of the cookie holding the state of a login with an identity provider.
+	oidcStateCookieName = "oidc_state"
+)
+
+// oidcStateTTL is how long users have to log in with the identity provider.
+const oidcStateTTL = 10 * time.Minute
+
+// newCookie creates a cookie with the configured attributes.
+//
+// Only the session cookies are HTTPOnly, since the frontend needs to read the CSRF cookie.
+func newCookie(name, value string, expires time.Time) *fiber.Cookie {
+	cfg := config.Get()
+
+	return &fiber.Cookie{
+		Name:     name,
+		Value:    value,
+		Domain:   cfg.CookieDomain,
+		Path:     cfg.CookiePath,
+		SameSite: cfg.CookieSameSite,
+		HTTPOnly: name != service.CSRFCookieName,
+		Secure:   cfg.CookieSecure,
+		Expires:  expires,
+	}
+}
+
+// setSessionCookies sets the access token and refresh token of a session in secure cookies, along with a new CSRF
+// token that must be sent back in the CSRF header.
+func setSessionCookies(ctx *fiber.Ctx, tokens service.Tokens) error {
+	csrfToken, err := service.GenerateCSRFToken()
+	if err != nil {
+		slog.Error("Failed to generate CSRF token", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+
+	ctx.Cookie(newCookie(authCookieName, tokens.AccessToken, tokens.AccessTokenExpiry))
+	ctx.Cookie(newCookie(refreshCookieName, tokens.RefreshToken, tokens.RefreshTokenExpiry))
+	ctx.Cookie(newCookie(service.CSRFCookieName, csrfToken, tokens.RefreshTokenExpiry))
+
+	return nil
+}
+
+// clearSessionCookies expires the cookies set by setSessionCookies.
+//
+// The cookies are overwritten with the same attributes, since browsers only clear cookies with a matching domain and
+// path.
+func clearSessionCookies(ctx *fiber.Ctx) {
+	expired := time.Unix(0, 0)
+	for _, name := range []string{authCookieName, refreshCookieName, service.CSRFCookieName} {
+		ctx.Cookie(newCookie(name, "", expired))
+	}
+}
+
+// setOIDCStateCookie keeps the state of a login with an identity provider in a cookie until the callback.
+//
+// The cookie is always SameSite=Lax, since a Strict cookie wouldn't be sent when the provider redirects back.
+func setOIDCStateCookie(ctx *fiber.Ctx, state service.OIDCState) {
+	cookie := newCookie(oidcStateCookieName, strings.Join([]string{state.State, state.Nonce, state.CodeVerifier}, "."),
+		time.Now().Add(oidcStateTTL))
+	cookie.SameSite = fiber.CookieSameSiteLaxMode
+	ctx.Cookie(cookie)
+}
+
+// popOIDCStateCookie reads the state set by setOIDCStateCookie, and clears the cookie so that it can't be used again.
+func popOIDCStateCookie(ctx *fiber.Ctx) service.OIDCState {
+	parts := strings.Split(ctx.Cookies(oidcStateCookieName), ".")
+
+	cookie := newCookie(oidcStateCookieName, "", time.Unix(0, 0))
+	cookie.SameSite = fiber.CookieSameSiteLaxMode
+	ctx.Cookie(cookie)
+
+	if len(parts) != 3 {
+		return service.OIDCState{}
+	}
+	return service.OIDCState{State: parts[0], Nonce: parts[1], CodeVerifier: parts[2]}
+}
@@ -2,34 +2,47 @@ package users
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"net/url"
+	"notes-app/apperror"
+	"notes-app/config"
 	"notes-app/models"
 	"notes-app/service"
 	"notes-app/utils"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
-	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
 // Controller defines the handlers for the v1/users API.
 type Controller struct {
-	UserService service.IUserService
-	AuthService service.IAuthService
+	UserService          service.IUserService
+	AuthService          service.IAuthService
+	SessionService       service.ISessionService
+	AccessTokenService   service.IAccessTokenService
+	TOTPService          service.ITOTPService
+	LoginThrottleService service.ILoginThrottleService
+	OIDCService          service.IOIDCService
+	PrivacyService       service.IPrivacyService
+	MailQueue            *utils.WorkerPool
 }
 
 // Register creates a new user in the database.
 //
 // The request body should contain the user data.
 //
+// A link to verify the user's email is sent to them, but they can log in before verifying it.
+//
 // Returns a 201 Created response with the created user in the response body.
 func (c Controller) Register(ctx *fiber.Ctx) error {
-	// Parse the request body into a User struct
+	// Parse and validate the request body into a RegisterRequest object
 	request := new(RegisterRequest)
-	if err := ctx.BodyParser(request); err != nil {
+	if err := utils.ParseBody(ctx, request); err != nil {
 		slog.Error("Failed to parse request body", slog.Any("error", err))
-		// Return a 400 Bad Request response if the request body is invalid
-		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		// Return a 400 Bad Request or 422 Unprocessable Entity response if the request body is invalid
+		return err
 	}
 
 	// Register the user in the database
@@ -38,15 +51,20 @@ func (c Controller) Register(ctx *fiber.Ctx) error {
 		Email:    request.Email,
 		Password: request.Password,
 	}
+	if request.Username != "" {
+		user.Username = &request.Username
+	}
 	if err := c.UserService.Create(user, nil); err != nil {
-		// Return a 409 Conflict response if the user already exists
-		if errors.Is(err, gorm.ErrDuplicatedKey) {
-			return fiber.NewError(fiber.StatusConflict, "User already exists")
-		}
-		// Return the error if anything else goes wrong
+		// Return the error as is, the service reports a duplicate user or a taken username with a 409 Conflict
 		return err
 	}
 
+	// Send the verification email. The user is already created, so a failure here shouldn't fail the request, the user
+	// can ask for the email to be sent again.
+	if err := c.UserService.SendVerificationEmail(*user, nil); err != nil {
+		slog.Error("Failed to send verification email after registering", slog.Any("error", err))
+	}
+
 	// Return a 201 Created response with the created user in the response body
 	return ctx.Status(fiber.StatusCreated).JSON(RegisterResponse{
 		ApiResponse: utils.ApiResponse{
@@ -57,50 +75,889 @@ func (c Controller) Register(ctx *fiber.Ctx) error {
 	})
 }
 
+// GetProfile returns the current user.
+func (c Controller) GetProfile(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := c.UserService.GetByID(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(UserResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "User fetched successfully",
+		},
+		User: user,
+	})
+}
+
+// UpdateProfile changes the name, email, avatar, username or discoverability of the current user.
+//
+// A new email has to be verified again, with the link sent to it.
+func (c Controller) UpdateProfile(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into an UpdateProfileRequest object
+	request := new(UpdateProfileRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	user, err := c.UserService.UpdateProfile(userID, service.ProfileUpdate{
+		Name:         request.Name,
+		Email:        request.Email,
+		AvatarURL:    request.AvatarURL,
+		Username:     request.Username,
+		Discoverable: request.Discoverable,
+	}, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(UserResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "User updated successfully",
+		},
+		User: user,
+	})
+}
+
+// ChangePassword sets a new password for the current user, who has to enter their current one, and logs them out of
+// all their other sessions.
+func (c Controller) ChangePassword(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a ChangePasswordRequest object
+	request := new(ChangePasswordRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	// Requests authenticated with an access token have no session to keep
+	sessionID, _ := utils.GetSessionID(ctx)
+
+	err = c.UserService.ChangePassword(userID, sessionID, request.CurrentPassword, request.NewPassword, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Password changed successfully",
+	})
+}
+
+// DeleteAccount deletes the current user, who has to enter their password to confirm it, and logs them out.
+func (c Controller) DeleteAccount(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a DeleteAccountRequest object
+	request := new(DeleteAccountRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	if err := c.UserService.Delete(userID, request.Password, nil); err != nil {
+		return err
+	}
+
+	clearSessionCookies(ctx)
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "User deleted successfully",
+	})
+}
+
+// RequestDataExport starts exporting all the data kept about the current user, to download once it is ready.
+//
+// The export is built in the background, and the user is emailed when it is done. Asking again while an export is
+// being built returns the same export.
+func (c Controller) RequestDataExport(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	export, created, err := c.PrivacyService.RequestExport(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	if created {
+		go func(id string) {
+			// Failures are logged by the service, and the user can ask again
+			_ = c.PrivacyService.BuildExport(id, nil)
+		}(export.ID)
+	}
+
+	return ctx.Status(fiber.StatusAccepted).JSON(DataExportResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Data export started, you will get an email when it is ready",
+		},
+		Export: export,
+	})
+}
+
+// GetDataExport returns the status of a data export of the current user.
+func (c Controller) GetDataExport(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	export, err := c.PrivacyService.GetExport(userID, ctx.Params("id"), nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(DataExportResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Data export fetched successfully",
+		},
+		Export: export,
+	})
+}
+
+// DownloadDataExport sends the zip archive of a data export of the current user, once it is ready.
+func (c Controller) DownloadDataExport(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	export, err := c.PrivacyService.DownloadExport(userID, ctx.Params("id"), nil)
+	if err != nil {
+		return err
+	}
+
+	ctx.Set(fiber.HeaderContentType, "application/zip")
+	ctx.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="notes-export-%s.zip"`,
+		export.CreatedAt.Format("2006-01-02")))
+	ctx.Set(fiber.HeaderCacheControl, "no-store")
+
+	return ctx.Status(fiber.StatusOK).Send(export.Archive)
+}
+
+// SearchUsers finds other users by the start of their username, name or email, to pick who to share a note with.
+//
+// Only users that made themselves discoverable, or that are in an organization with the caller, are found. Their emails
+// are never returned, so that searching can't be used to collect them.
+func (c Controller) SearchUsers(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the query parameters into a SearchUsersQuery object
+	query := new(SearchUsersQuery)
+	if err := utils.ParseQuery(ctx, query); err != nil {
+		return err
+	}
+
+	users, err := c.UserService.Search(userID, query.Q, query.Limit, nil)
+	if err != nil {
+		return err
+	}
+
+	response := SearchUsersResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Users fetched successfully",
+		},
+		Users: make([]UserSummary, 0, len(users)),
+	}
+	for _, user := range users {
+		response.Users = append(response.Users, UserSummary{
+			ID:        user.ID,
+			Username:  user.Username,
+			Name:      user.Name,
+			AvatarURL: user.AvatarURL,
+		})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(response)
+}
+
 // Login handles user login by validating the provided credentials, then generating a JWT token and setting it in a secure cookie.
+//
+// If the client asks for it, the tokens are also returned in the response body, to be sent in an Authorization header.
+//
+// Failed logins are counted per email and per IP address, and either is locked out for a while after too many of them.
+// The failures for the email are only forgotten once a session is created, not when the password alone is correct.
+//
+// If the user has two-factor authentication enabled, no session is created yet. Instead, a short-lived challenge token
+// is returned, to be sent to LoginTOTP along with a code from the user's authenticator app.
 func (c Controller) Login(ctx *fiber.Ctx) error {
-	// Parse the request body into a LoginRequest object
+	// Parse and validate the request body into a LoginRequest object
 	request := new(LoginRequest)
-	if err := ctx.BodyParser(request); err != nil {
+	if err := utils.ParseBody(ctx, request); err != nil {
 		slog.Error("Failed to parse request body", slog.Any("error", err))
-		// Return a 400 Bad Request response if the request body is invalid
-		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		// Return a 400 Bad Request or 422 Unprocessable Entity response if the request body is invalid
+		return err
+	}
+
+	// Don't check the password at all while the email or the client is locked out
+	ip := service.ClientInfoFromCtx(ctx).IPAddress
+	if err := c.checkLoginThrottle(ctx, request.Email, ip); err != nil {
+		return err
+	}
+
+	// Get the user from the database and check the password
+	user, err := c.UserService.Authenticate(request.Email, request.Password, nil)
+	if errors.Is(err, apperror.ErrInvalidCredentials) {
+		if err := c.LoginThrottleService.RecordFailure(request.Email, ip, nil); err != nil {
+			return err
+		}
+		// Return a 401 Unauthorized, whether the user doesn't exist or the password is incorrect
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	return c.completeLogin(ctx, user, request.IncludeToken)
+}
+
+// checkLoginThrottle checks that logins for the email and from the IP address are not locked out, and sets the
+// Retry-After header if either is.
+func (c Controller) checkLoginThrottle(ctx *fiber.Ctx, email, ip string) error {
+	retryAfter, err := c.LoginThrottleService.Check(email, ip, nil)
+	if retryAfter > 0 {
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	return err
+}
+
+// throttleEmailRequest records a request for an email with a link to the email from the IP address of the client, and
+// sets the Retry-After header if either has asked for too many.
+func (c Controller) throttleEmailRequest(ctx *fiber.Ctx, email string) error {
+	ip := service.ClientInfoFromCtx(ctx).IPAddress
+	retryAfter, err := c.LoginThrottleService.RecordEmailRequest(email, ip, nil)
+	if retryAfter > 0 {
+		ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	return err
+}
+
+// sendInBackground queues the sending of an email on the mail queue. The email is dropped if the queue is full, since
+// the response has to be the same either way.
+func (c Controller) sendInBackground(name string, send func()) {
+	if !c.MailQueue.Submit(name, send) {
+		slog.Error("Dropped email since the mail queue is full", slog.String("email", name))
+	}
+}
+
+// completeLogin starts a session for a user that has proven who they are, unless they have two-factor authentication
+// enabled, in which case a challenge token for LoginTOTP is returned instead.
+func (c Controller) completeLogin(ctx *fiber.Ctx, user models.User, includeToken bool) error {
+	// Ask for the second factor before creating a session, if the user has one
+	challengeToken, err := c.mfaChallenge(user)
+	if err != nil {
+		return err
+	}
+	if challengeToken != "" {
+		return ctx.Status(fiber.StatusOK).JSON(LoginResponse{
+			ApiResponse: utils.ApiResponse{
+				Success: true,
+				Message: "Two-factor authentication code required",
+			},
+			MFARequired:    true,
+			ChallengeToken: challengeToken,
+		})
+	}
+
+	return c.startSession(ctx, user.ID, user.Email, includeToken)
+}
+
+// mfaChallenge creates a challenge token for LoginTOTP if the user has two-factor authentication enabled.
+//
+// Returns an empty token if they don't, in which case a session can be created straight away.
+func (c Controller) mfaChallenge(user models.User) (string, error) {
+	mfaEnabled, err := c.TOTPService.IsEnabled(user.ID, nil)
+	if err != nil || !mfaEnabled {
+		return "", err
+	}
+
+	return c.TOTPService.CreateChallenge(user, nil)
+}
+
+// RequestMagicLink emails a link to log in without a password to the user with the given email.
+//
+// Always returns a 202 Accepted response, whether or not the email is registered, and sends the email in the
+// background, same as ForgotPassword. Too many requests for the email or from the IP address are rejected.
+func (c Controller) RequestMagicLink(ctx *fiber.Ctx) error {
+	// Parse and validate the request body into a MagicLinkRequest object
+	request := new(MagicLinkRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	if err := c.throttleEmailRequest(ctx, request.Email); err != nil {
+		return err
+	}
+
+	c.sendInBackground("magic link", func() {
+		if err := c.UserService.RequestMagicLink(request.Email, nil); err != nil {
+			slog.Error("Failed to request magic link", slog.Any("error", err))
+		}
+	})
+
+	return ctx.Status(fiber.StatusAccepted).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "If the email is registered, a link to log in has been sent to it",
+	})
+}
+
+// MagicLinkLogin logs in the user with the token from the link sent to them by RequestMagicLink, creating the same
+// session as Login. Users with two-factor authentication still have to enter a code through LoginTOTP.
+func (c Controller) MagicLinkLogin(ctx *fiber.Ctx) error {
+	// Parse and validate the request body into a MagicLinkLoginRequest object
+	request := new(MagicLinkLoginRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	user, err := c.UserService.LoginWithMagicLink(request.Token, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.completeLogin(ctx, user, request.IncludeToken)
+}
+
+// LoginTOTP completes the login of a user with two-factor authentication, by exchanging the challenge token from Login
+// and a TOTP code or recovery code for a session.
+//
+// A wrong code uses up the challenge token, so the user has to enter their password again. Wrong codes also count as
+// failed logins for the user's email and the client, the same as wrong passwords in Login, so that someone who knows
+// the password can't keep getting new challenges to guess the code with.
+func (c Controller) LoginTOTP(ctx *fiber.Ctx) error {
+	// Parse and validate the request body into a LoginTOTPRequest object
+	request := new(LoginTOTPRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	// Don't check the code at all while the email or the client is locked out
+	email, err := c.TOTPService.ChallengeEmail(request.ChallengeToken, nil)
+	if err != nil {
+		return err
+	}
+	ip := service.ClientInfoFromCtx(ctx).IPAddress
+	if err := c.checkLoginThrottle(ctx, email, ip); err != nil {
+		return err
+	}
+
+	userID, err := c.TOTPService.VerifyChallenge(request.ChallengeToken, request.Code, nil)
+	if errors.Is(err, apperror.ErrInvalidOTP) {
+		if err := c.LoginThrottleService.RecordFailure(email, ip, nil); err != nil {
+			return err
+		}
+		return err
+	} else if err != nil {
+		return err
+	}
+
+	return c.startSession(ctx, userID, email, request.IncludeToken)
+}
+
+// startSession creates a new session for a user that has logged in, and sets its tokens in secure cookies. Since the
+// user is now fully logged in, their failed logins are forgotten.
+//
+// If includeToken is set, the tokens are also returned in the response body.
+func (c Controller) startSession(ctx *fiber.Ctx, userID uint, email string, includeToken bool) error {
+	tokens, err := c.createSession(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if err := c.LoginThrottleService.RecordSuccess(email, nil); err != nil {
+		return err
+	}
+
+	response := LoginResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "User logged in successfully",
+		},
+	}
+	if includeToken {
+		response.Tokens = newTokenResponse(tokens)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(response)
+}
+
+// Refresh exchanges the refresh token for a new access token and refresh token, and sets them in secure cookies.
+//
+// The refresh token is read from the refresh_token cookie, or from the request body if the cookie is not set, in which
+// case the new tokens are also returned in the response body.
+func (c Controller) Refresh(ctx *fiber.Ctx) error {
+	// Get the refresh token from the cookie, falling back to the request body
+	refreshToken := ctx.Cookies(refreshCookieName)
+	fromBody := refreshToken == ""
+	if !fromBody {
+		// Cookies are sent with cross-site requests too, so check that the request came from our frontend
+		if err := service.VerifyCSRF(ctx); err != nil {
+			return err
+		}
+	} else {
+		request := new(RefreshRequest)
+		if err := utils.ParseBody(ctx, request); err != nil {
+			return err
+		}
+		refreshToken = request.RefreshToken
+	}
+
+	// Rotate the refresh token
+	tokens, err := c.SessionService.Refresh(refreshToken, service.ClientInfoFromCtx(ctx), nil)
+	if err != nil {
+		// Clear the cookies so that the client stops retrying with a dead refresh token
+		clearSessionCookies(ctx)
+		return err
+	}
+
+	if err := setSessionCookies(ctx, tokens); err != nil {
+		return err
+	}
+
+	response := LoginResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Session refreshed successfully",
+		},
+	}
+	if fromBody {
+		response.Tokens = newTokenResponse(tokens)
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(response)
+}
+
+// Logout revokes the current session and clears the session cookies.
+func (c Controller) Logout(ctx *fiber.Ctx) error {
+	sessionID, err := utils.GetSessionID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.SessionService.Revoke(sessionID, nil); err != nil {
+		return err
+	}
+
+	clearSessionCookies(ctx)
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "User logged out successfully",
+	})
+}
+
+// LogoutAll revokes all sessions of the current user, on every device, and clears the session cookies.
+func (c Controller) LogoutAll(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.SessionService.RevokeAll(userID, nil); err != nil {
+		return err
+	}
+
+	clearSessionCookies(ctx)
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "User logged out of all sessions successfully",
+	})
+}
+
+// ListSessions lists all active sessions of the current user, marking the one that made the request.
+func (c Controller) ListSessions(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+	sessionID, err := utils.GetSessionID(ctx)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := c.SessionService.ListActive(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	response := ListSessionsResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Sessions fetched successfully",
+		},
+		Sessions: make([]SessionResponse, 0, len(sessions)),
+	}
+	for _, session := range sessions {
+		response.Sessions = append(response.Sessions, SessionResponse{Session: session, Current: session.ID == sessionID})
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(response)
+}
+
+// RevokeSession revokes one of the current user's sessions, so that it is logged out immediately.
+//
+// The ID of the session is taken from the path.
+func (c Controller) RevokeSession(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := c.SessionService.RevokeForUser(userID, ctx.Params("id"), nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Session revoked successfully",
+	})
+}
+
+// CreateAccessToken creates a personal access token for the current user, for use by scripts and other automation.
+//
+// Returns a 201 Created response with the token in the response body. The token is only ever shown in this response.
+func (c Controller) CreateAccessToken(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a CreateAccessTokenRequest object
+	request := new(CreateAccessTokenRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	accessToken, token, err := c.AccessTokenService.Create(userID, request.Name, request.Scopes, request.ExpiresAt, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusCreated).JSON(CreateAccessTokenResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access token created successfully",
+		},
+		AccessToken: accessToken,
+		Token:       token,
+	})
+}
+
+// ListAccessTokens lists the personal access tokens of the current user that have not been revoked.
+func (c Controller) ListAccessTokens(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	accessTokens, err := c.AccessTokenService.List(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ListAccessTokensResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Access tokens fetched successfully",
+		},
+		AccessTokens: accessTokens,
+	})
+}
+
+// RevokeAccessToken revokes one of the current user's personal access tokens, so that it stops working immediately.
+//
+// The ID of the token is taken from the path.
+func (c Controller) RevokeAccessToken(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	id, err := ctx.ParamsInt("id")
+	if err != nil || id <= 0 {
+		return apperror.ErrAccessTokenNotFound
+	}
+
+	if err := c.AccessTokenService.Revoke(userID, uint(id), nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Access token revoked successfully",
+	})
+}
+
+// VerifyEmail verifies the email of a user with the token from the link sent to them.
+func (c Controller) VerifyEmail(ctx *fiber.Ctx) error {
+	// Parse and validate the request body into a VerifyEmailRequest object
+	request := new(VerifyEmailRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	if _, err := c.UserService.VerifyEmail(request.Token, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Email verified successfully",
+	})
+}
+
+// ResendVerificationEmail sends the current user a new link to verify their email, invalidating the previous one.
+func (c Controller) ResendVerificationEmail(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
 	}
 
-	// Get the user from the database
-	user, err := c.UserService.GetByEmail(request.Email, nil)
+	user, err := c.UserService.GetByID(userID, nil)
 	if err != nil {
-		// Return a 404 response if the user is not found
-		return fiber.NewError(fiber.StatusNotFound, "User not found")
+		return err
+	}
+
+	if err := c.UserService.SendVerificationEmail(user, nil); err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Verification email sent successfully",
+	})
+}
+
+// ForgotPassword emails a link to reset the password to the user with the given email.
+//
+// Always returns a 202 Accepted response, whether or not the email is registered, so that it can't be used to find out
+// who has an account. The email is sent in the background, so that the response time doesn't give it away either.
+// Too many requests for the email or from the IP address are rejected, whether or not the email is registered.
+func (c Controller) ForgotPassword(ctx *fiber.Ctx) error {
+	// Parse and validate the request body into a ForgotPasswordRequest object
+	request := new(ForgotPasswordRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	if err := c.throttleEmailRequest(ctx, request.Email); err != nil {
+		return err
 	}
 
-	// Compare the hashed password with the plaintext password
-	if err := c.AuthService.ComparePasswords(user.Password, request.Password); err != nil {
-		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-			// Return a 401 Unauthorized response if the password is incorrect
-			return fiber.NewError(fiber.StatusUnauthorized, "Incorrect password")
+	c.sendInBackground("password reset", func() {
+		if err := c.UserService.RequestPasswordReset(request.Email, nil); err != nil {
+			slog.Error("Failed to request password reset", slog.Any("error", err))
 		}
+	})
+
+	return ctx.Status(fiber.StatusAccepted).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "If the email is registered, a link to reset the password has been sent to it",
+	})
+}
+
+// ResetPassword sets a new password with the token from the link sent to the user, and logs them out everywhere.
+func (c Controller) ResetPassword(ctx *fiber.Ctx) error {
+	// Parse and validate the request body into a ResetPasswordRequest object
+	request := new(ResetPasswordRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
 
-		// Return the error if anything else goes wrong
-		return fiber.NewError(fiber.StatusInternalServerError, "Failed to compare passwords")
+	if err := c.UserService.ResetPassword(request.Token, request.Password, nil); err != nil {
+		return err
 	}
 
-	// Generete a JWT token for the user
-	token, expiry, err := c.AuthService.GenerateJWT(user.ID)
+	// The current session, if any, was revoked too
+	clearSessionCookies(ctx)
+
+	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
+		Success: true,
+		Message: "Password reset successfully",
+	})
+}
+
+// createSession creates a new session for a user, with an access token and a refresh token, and sets them in secure
+// cookies.
+func (c Controller) createSession(ctx *fiber.Ctx, userID uint) (service.Tokens, error) {
+	tokens, err := c.SessionService.Create(userID, service.ClientInfoFromCtx(ctx), nil)
 	if err != nil {
-		return fiber.NewError(fiber.StatusInternalServerError, "Failed to sign token")
+		return service.Tokens{}, err
 	}
 
-	ctx.Cookie(&fiber.Cookie{
-		Name:     "authorization",
-		Value:    token,
-		HTTPOnly: true,
-		Secure:   true,
-		Expires:  expiry,
+	if err := setSessionCookies(ctx, tokens); err != nil {
+		return service.Tokens{}, err
+	}
+
+	return tokens, nil
+}
+
+// ListOIDCProviders lists the identity providers that users can log in with, for the login page.
+func (c Controller) ListOIDCProviders(ctx *fiber.Ctx) error {
+	return ctx.Status(fiber.StatusOK).JSON(ListOIDCProvidersResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Identity providers fetched successfully",
+		},
+		Providers: c.OIDCService.ProviderNames(),
 	})
+}
+
+// OIDCLogin starts logging in with the identity provider named in the path, by redirecting the user to it.
+//
+// The state of the login is kept in a cookie, so that only this browser can complete it in OIDCCallback.
+func (c Controller) OIDCLogin(ctx *fiber.Ctx) error {
+	url, state, err := c.OIDCService.AuthCodeURL(ctx.Params("provider"))
+	if err != nil {
+		return err
+	}
+
+	setOIDCStateCookie(ctx, state)
+
+	return ctx.Redirect(url, fiber.StatusFound)
+}
+
+// OIDCCallback completes logging in with the identity provider named in the path, once it redirects the user back.
+//
+// The same session as Login is created, and the user is redirected to the configured URL. Users with two-factor
+// authentication are redirected there without a session instead, with mfa_required and the challenge token for
+// LoginTOTP in the URL fragment, so that the token isn't sent to any server or leaked through the Referer header.
+func (c Controller) OIDCCallback(ctx *fiber.Ctx) error {
+	state := popOIDCStateCookie(ctx)
+
+	// The provider redirects back with an error if the user didn't log in or didn't allow access
+	if providerError := ctx.Query("error"); providerError != "" {
+		slog.Error("Identity provider returned an error", slog.String("error", providerError),
+			slog.String("description", ctx.Query("error_description")))
+		return apperror.ErrOIDCLoginFailed
+	}
+
+	user, err := c.OIDCService.Exchange(ctx.Params("provider"), state, ctx.Query("state"), ctx.Query("code"), nil)
+	if err != nil {
+		return err
+	}
+
+	// Ask for the second factor before creating a session, same as any other login
+	challengeToken, err := c.mfaChallenge(user)
+	if err != nil {
+		return err
+	}
+	if challengeToken != "" {
+		fragment := url.Values{"mfa_required": {"true"}, "challenge_token": {challengeToken}}
+		return ctx.Redirect(config.Get().OIDCLoginRedirectURL+"#"+fragment.Encode(), fiber.StatusFound)
+	}
+
+	if _, err := c.createSession(ctx, user.ID); err != nil {
+		return err
+	}
+
+	return ctx.Redirect(config.Get().OIDCLoginRedirectURL, fiber.StatusFound)
+}
+
+// EnrollTOTP starts setting up two-factor authentication for the current user, by generating a new TOTP secret.
+//
+// Returns the secret and an otpauth:// URI to show as a QR code. Two-factor authentication is only enabled once the
+// user confirms it with a code from their authenticator app, through ConfirmTOTP.
+func (c Controller) EnrollTOTP(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	user, err := c.UserService.GetByID(userID, nil)
+	if err != nil {
+		return err
+	}
+
+	secret, uri, err := c.TOTPService.Enroll(user, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(EnrollTOTPResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Two-factor authentication enrollment started",
+		},
+		Secret: secret,
+		URI:    uri,
+	})
+}
+
+// ConfirmTOTP enables two-factor authentication for the current user, with a first code from their authenticator app.
+//
+// Returns the recovery codes in the response body. They are only ever shown in this response.
+func (c Controller) ConfirmTOTP(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a TOTPCodeRequest object
+	request := new(TOTPCodeRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	recoveryCodes, err := c.TOTPService.Confirm(userID, request.Code, nil)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(ConfirmTOTPResponse{
+		ApiResponse: utils.ApiResponse{
+			Success: true,
+			Message: "Two-factor authentication enabled successfully",
+		},
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// DisableTOTP disables two-factor authentication for the current user, with a code from their authenticator app or a
+// recovery code.
+func (c Controller) DisableTOTP(ctx *fiber.Ctx) error {
+	userID, err := utils.GetUserID(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Parse and validate the request body into a TOTPCodeRequest object
+	request := new(TOTPCodeRequest)
+	if err := utils.ParseBody(ctx, request); err != nil {
+		return err
+	}
+
+	if err := c.TOTPService.Disable(userID, request.Code, nil); err != nil {
+		return err
+	}
 
 	return ctx.Status(fiber.StatusOK).JSON(utils.ApiResponse{
 		Success: true,
-		Message: "User logged in successfully",
+		Message: "Two-factor authentication disabled successfully",
 	})
 }
@@ -1,10 +1,45 @@
 package users
 
 import (
+	"notes-app/service"
+
 	"github.com/gofiber/fiber/v2"
 )
 
 func RegisterRoutes(router fiber.Router, controller Controller) {
+	authMiddleware := controller.AuthService.GenMiddleware()
+	profileReadMiddleware := controller.AuthService.GenMiddleware(service.ScopeProfileRead)
+	profileWriteMiddleware := controller.AuthService.GenMiddleware(service.ScopeProfileWrite)
+
 	router.Post("/", controller.Register)
+	router.Get("/me", profileReadMiddleware, controller.GetProfile)
+	router.Patch("/me", profileWriteMiddleware, controller.UpdateProfile)
+	router.Post("/me/password", profileWriteMiddleware, controller.ChangePassword)
+	router.Delete("/me", profileWriteMiddleware, controller.DeleteAccount)
+	router.Post("/me/export", profileReadMiddleware, controller.RequestDataExport)
+	router.Get("/me/export/:id", profileReadMiddleware, controller.GetDataExport)
+	router.Get("/me/export/:id/download", profileReadMiddleware, controller.DownloadDataExport)
+	router.Get("/search", authMiddleware, controller.SearchUsers)
 	router.Post("/login", controller.Login)
+	router.Post("/login/totp", controller.LoginTOTP)
+	router.Post("/login/magic", controller.RequestMagicLink)
+	router.Post("/login/magic/callback", controller.MagicLinkLogin)
+	router.Get("/oidc", controller.ListOIDCProviders)
+	router.Get("/oidc/:provider/login", controller.OIDCLogin)
+	router.Get("/oidc/:provider/callback", controller.OIDCCallback)
+	router.Post("/refresh", controller.Refresh)
+	router.Post("/verify-email", controller.VerifyEmail)
+	router.Post("/verify-email/resend", authMiddleware, controller.ResendVerificationEmail)
+	router.Post("/password/forgot", controller.ForgotPassword)
+	router.Post("/password/reset", controller.ResetPassword)
+	router.Post("/logout", authMiddleware, controller.Logout)
+	router.Post("/logout-all", authMiddleware, controller.LogoutAll)
+	router.Get("/sessions", authMiddleware, controller.ListSessions)
+	router.Delete("/sessions/:id", authMiddleware, controller.RevokeSession)
+	router.Post("/tokens", authMiddleware, controller.CreateAccessToken)
+	router.Get("/tokens", authMiddleware, controller.ListAccessTokens)
+	router.Delete("/tokens/:id", authMiddleware, controller.RevokeAccessToken)
+	router.Post("/totp", authMiddleware, controller.EnrollTOTP)
+	router.Post("/totp/confirm", authMiddleware, controller.ConfirmTOTP)
+	router.Post("/totp/disable", authMiddleware, controller.DisableTOTP)
 }
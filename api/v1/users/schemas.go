@@ -2,14 +2,26 @@ package users
 
 import (
 	"notes-app/models"
+	"notes-app/service"
 	"notes-app/utils"
+	"strings"
+	"time"
 )
 
 // RegisterRequest is a struct that represents the request for a user registration API.
 type RegisterRequest struct {
-	Name     string `json:"name"`
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Name     string `json:"name" validate:"required,max=255"`
+	Email    string `json:"email" validate:"required,email,max=255"`
+	Password string `json:"password" validate:"required"`
+	// Username is optional, and can be set later by updating the profile.
+	Username string `json:"username" validate:"omitempty,username"`
+}
+
+// Normalize trims the name and normalizes the email and username so that duplicates differing by case are caught.
+func (r *RegisterRequest) Normalize() {
+	r.Name = strings.TrimSpace(r.Name)
+	r.Email = utils.NormalizeEmail(r.Email)
+	r.Username = utils.NormalizeUsername(r.Username)
 }
 
 // RegisterResponse is a struct that represents the response for a user registration API.
@@ -18,8 +30,259 @@ type RegisterResponse struct {
 	User models.User `json:"user"`
 }
 
+// UserResponse is a struct that represents the response for the APIs that return the current user.
+type UserResponse struct {
+	utils.ApiResponse
+	User models.User `json:"user"`
+}
+
+// UpdateProfileRequest is a struct that represents the request for the update profile API. Fields that are left out
+// are not changed.
+type UpdateProfileRequest struct {
+	Name  *string `json:"name" validate:"omitnil,min=1,max=255"`
+	Email *string `json:"email" validate:"omitnil,email,max=255"`
+	// AvatarURL can be set to an empty string to remove the avatar.
+	AvatarURL *string `json:"avatar_url" validate:"omitnil,max=2048,len=0|http_url"`
+	// Username can be set to an empty string to remove the username.
+	Username *string `json:"username" validate:"omitnil,len=0|username"`
+	// Discoverable is whether other users can find the current user by searching, to share notes with them.
+	Discoverable *bool `json:"discoverable"`
+}
+
+// Normalize trims the name and normalizes the email and username, same as in RegisterRequest.
+func (r *UpdateProfileRequest) Normalize() {
+	if r.Name != nil {
+		*r.Name = strings.TrimSpace(*r.Name)
+	}
+	if r.Email != nil {
+		*r.Email = utils.NormalizeEmail(*r.Email)
+	}
+	if r.Username != nil {
+		*r.Username = utils.NormalizeUsername(*r.Username)
+	}
+}
+
+// SearchUsersQuery is a struct that represents the query parameters for the search users API.
+type SearchUsersQuery struct {
+	// Q is the start of the username, name or email of the users to find.
+	Q     string `query:"q" validate:"required,min=2,max=100"`
+	Limit int    `query:"limit" validate:"omitempty,min=1,max=25"`
+}
+
+// Normalize trims the search query.
+func (q *SearchUsersQuery) Normalize() {
+	q.Q = strings.TrimSpace(q.Q)
+}
+
+// UserSummary is the public part of a user, that other users can see when searching for who to share notes with.
+type UserSummary struct {
+	ID        uint    `json:"id"`
+	Username  *string `json:"username"`
+	Name      string  `json:"name"`
+	AvatarURL string  `json:"avatar_url"`
+}
+
+// SearchUsersResponse is a struct that represents the response for the search users API.
+type SearchUsersResponse struct {
+	utils.ApiResponse
+	Users []UserSummary `json:"users"`
+}
+
+// ChangePasswordRequest is a struct that represents the request for the change password API.
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// DeleteAccountRequest is a struct that represents the request for the delete account API.
+type DeleteAccountRequest struct {
+	// Password is the user's current password, to confirm that they really want to delete their account.
+	Password string `json:"password" validate:"required"`
+}
+
+// DataExportResponse is a struct that represents the response for the data export APIs.
+type DataExportResponse struct {
+	utils.ApiResponse
+	Export models.DataExport `json:"export"`
+}
+
 // LoginRequest is a struct that represents the request for a user login API.
 type LoginRequest struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+
+	// IncludeToken asks for the tokens to be returned in the response body as well as in cookies, for clients like CLI
+	// tools and mobile apps that authenticate with an Authorization: Bearer header instead.
+	IncludeToken bool `json:"include_token"`
+}
+
+// Normalize normalizes the email so that it matches the one stored at registration.
+func (r *LoginRequest) Normalize() {
+	r.Email = utils.NormalizeEmail(r.Email)
+}
+
+// LoginTOTPRequest is a struct that represents the request for the second step of logging in with two-factor
+// authentication.
+type LoginTOTPRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	// Code is a code from the user's authenticator app, or one of their recovery codes.
+	Code string `json:"code" validate:"required,max=64"`
+
+	// IncludeToken asks for the tokens to be returned in the response body, same as in LoginRequest.
+	IncludeToken bool `json:"include_token"`
+}
+
+// MagicLinkRequest is a struct that represents the request for the API that emails a link to log in without a password.
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func (r *MagicLinkRequest) Normalize() {
+	r.Email = utils.NormalizeEmail(r.Email)
+}
+
+// MagicLinkLoginRequest is a struct that represents the request for logging in with the link sent by the magic link
+// API.
+type MagicLinkLoginRequest struct {
+	// Token is the token from the link sent to the user's email.
+	Token string `json:"token" validate:"required"`
+
+	// IncludeToken asks for the tokens to be returned in the response body, same as in LoginRequest.
+	IncludeToken bool `json:"include_token"`
+}
+
+// TOTPCodeRequest is a struct that represents the request for the APIs that need a two-factor authentication code.
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,max=64"`
+}
+
+// RefreshRequest is a struct that represents the request for a session refresh API.
+//
+// It is only used by clients that don't send the refresh token in a cookie.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// VerifyEmailRequest is a struct that represents the request for the verify email API.
+type VerifyEmailRequest struct {
+	// Token is the token from the link sent to the user's email.
+	Token string `json:"token" validate:"required"`
+}
+
+// ForgotPasswordRequest is a struct that represents the request for the forgot password API.
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+func (r *ForgotPasswordRequest) Normalize() {
+	r.Email = utils.NormalizeEmail(r.Email)
+}
+
+// ResetPasswordRequest is a struct that represents the request for the reset password API.
+type ResetPasswordRequest struct {
+	// Token is the token from the link sent to the user's email.
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// TokenResponse is a struct that represents the tokens of a session in the response of the login and refresh APIs.
+type TokenResponse struct {
+	// TokenType is the scheme to use when sending the access token in the Authorization header, always Bearer.
+	TokenType             string    `json:"token_type"`
+	AccessToken           string    `json:"access_token"`
+	AccessTokenExpiresAt  time.Time `json:"access_token_expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// newTokenResponse converts the tokens issued for a session to a TokenResponse.
+func newTokenResponse(tokens service.Tokens) *TokenResponse {
+	return &TokenResponse{
+		TokenType:             "Bearer",
+		AccessToken:           tokens.AccessToken,
+		AccessTokenExpiresAt:  tokens.AccessTokenExpiry,
+		RefreshToken:          tokens.RefreshToken,
+		RefreshTokenExpiresAt: tokens.RefreshTokenExpiry,
+	}
+}
+
+// LoginResponse is a struct that represents the response for the login and refresh APIs.
+type LoginResponse struct {
+	utils.ApiResponse
+
+	// Tokens is only set if the client asked for the tokens in the response body.
+	Tokens *TokenResponse `json:"tokens,omitempty"`
+
+	// MFARequired is set instead of creating a session if the user has two-factor authentication enabled, in which
+	// case ChallengeToken must be sent to the two-factor login API along with a code.
+	MFARequired    bool   `json:"mfa_required,omitempty"`
+	ChallengeToken string `json:"challenge_token,omitempty"`
+}
+
+// EnrollTOTPResponse is a struct that represents the response for the TOTP enrollment API.
+type EnrollTOTPResponse struct {
+	utils.ApiResponse
+
+	// Secret is the base32 TOTP secret, for users that can't scan the QR code.
+	Secret string `json:"secret"`
+	// URI is the otpauth:// URI to show as a QR code.
+	URI string `json:"otpauth_uri"`
+}
+
+// ListOIDCProvidersResponse is a struct that represents the response for the identity providers API.
+type ListOIDCProvidersResponse struct {
+	utils.ApiResponse
+
+	// Providers are the names of the identity providers, to log in with at /users/oidc/<name>/login.
+	Providers []string `json:"providers"`
+}
+
+// ConfirmTOTPResponse is a struct that represents the response for the TOTP confirmation API.
+type ConfirmTOTPResponse struct {
+	utils.ApiResponse
+
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// SessionResponse is a struct that represents a session in the response of the sessions APIs.
+type SessionResponse struct {
+	models.Session
+
+	// Current is true for the session that made the request.
+	Current bool `json:"current"`
+}
+
+// ListSessionsResponse is a struct that represents the response for the list sessions API.
+type ListSessionsResponse struct {
+	utils.ApiResponse
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// CreateAccessTokenRequest is a struct that represents the request for the create access token API.
+type CreateAccessTokenRequest struct {
+	// Name is a label to help the user recognise the token, e.g. the name of the script using it.
+	Name   string   `json:"name" validate:"required,max=255"`
+	Scopes []string `json:"scopes" validate:"required,min=1"`
+
+	// ExpiresAt is when the token stops working, or nil if it never expires.
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+func (r *CreateAccessTokenRequest) Normalize() {
+	r.Name = strings.TrimSpace(r.Name)
+}
+
+// CreateAccessTokenResponse is a struct that represents the response for the create access token API.
+type CreateAccessTokenResponse struct {
+	utils.ApiResponse
+	AccessToken models.PersonalAccessToken `json:"access_token"`
+
+	// Token is the plaintext token, which is only returned when the token is created.
+	Token string `json:"token"`
+}
+
+// ListAccessTokensResponse is a struct that represents the response for the list access tokens API.
+type ListAccessTokensResponse struct {
+	utils.ApiResponse
+	AccessTokens []models.PersonalAccessToken `json:"access_tokens"`
 }
@@ -8,16 +8,17 @@ import (
 	"net/http"
 	"notes-app/api"
 	"notes-app/api/v1/users"
+	"notes-app/apperror"
 	"notes-app/models"
 	"notes-app/service"
 	"notes-app/utils"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/suite"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
@@ -25,7 +26,10 @@ type mockUserService struct{}
 
 func (svc mockUserService) Create(user *models.User, opts *service.DBOpts) error {
 	if user.Email == "duplicate@ksdfg.dev" {
-		return gorm.ErrDuplicatedKey
+		return apperror.ErrUserExists
+	}
+	if user.Username != nil && *user.Username == "taken" {
+		return apperror.ErrUsernameUnavailable
 	}
 
 	user.ID = 1
@@ -34,26 +38,182 @@ func (svc mockUserService) Create(user *models.User, opts *service.DBOpts) error
 	return nil
 }
 
+func (svc mockUserService) CreateWithRandomPassword(user *models.User, opts *service.DBOpts) error {
+	user.Password = "random"
+	return svc.Create(user, opts)
+}
+
 func (svc mockUserService) GetByEmail(email string, opts *service.DBOpts) (models.User, error) {
-	if email == "nosuchuser@ksdfg.dev" {
-		return models.User{}, gorm.ErrRecordNotFound
+	// mfa@ksdfg.dev has two-factor authentication enabled
+	var id uint
+	switch email {
+	case "me@ksdfg.dev":
+		id = 1
+	case "mfa@ksdfg.dev":
+		id = 2
+	default:
+		return models.User{}, apperror.ErrUserNotFound
 	}
 
 	return models.User{
 		Model: gorm.Model{
-			ID:        1,
+			ID:        id,
 			CreatedAt: time.Now(),
 			UpdatedAt: time.Now(),
 		},
 		Name:     "Kshitish Deshpande",
-		Email:    "me@ksdfg.dev",
+		Email:    email,
 		Password: "hashedpassword",
 	}, nil
 }
 
 func (svc mockUserService) GetByID(id uint, opts *service.DBOpts) (models.User, error) {
-	//TODO implement me
-	panic("implement me")
+	if id != 1 {
+		return models.User{}, apperror.ErrUserNotFound
+	}
+
+	return svc.GetByEmail("me@ksdfg.dev", opts)
+}
+
+func (svc mockUserService) Authenticate(email, password string, opts *service.DBOpts) (models.User, error) {
+	if email == "ldap-down@ksdfg.dev" {
+		return models.User{}, apperror.ErrDirectoryUnavailable
+	}
+
+	user, err := svc.GetByEmail(email, opts)
+	if err != nil {
+		return models.User{}, apperror.ErrInvalidCredentials
+	}
+
+	if err := (mockAuthService{}).ComparePasswords(user.Password, password); err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+func (svc mockUserService) SendVerificationEmail(user models.User, opts *service.DBOpts) error {
+	if user.EmailVerified {
+		return apperror.ErrEmailAlreadyVerified
+	}
+
+	return nil
+}
+
+func (svc mockUserService) VerifyEmail(token string, opts *service.DBOpts) (models.User, error) {
+	switch token {
+	case "verification-token":
+		user, err := svc.GetByID(1, opts)
+		user.EmailVerified = true
+		return user, err
+	case "expired-verification-token":
+		return models.User{}, apperror.ErrTokenExpired
+	}
+
+	return models.User{}, apperror.ErrTokenInvalid
+}
+
+func (svc mockUserService) RequestPasswordReset(email string, opts *service.DBOpts) error {
+	return nil
+}
+
+func (svc mockUserService) ResetPassword(token, password string, opts *service.DBOpts) error {
+	switch {
+	case token != "reset-token":
+		return apperror.ErrTokenInvalid
+	case password == "password", len(password) > service.MaxPasswordLength:
+		return apperror.ErrWeakPassword
+	}
+
+	return nil
+}
+
+func (svc mockUserService) RequestMagicLink(email string, opts *service.DBOpts) error {
+	return nil
+}
+
+func (svc mockUserService) LoginWithMagicLink(token string, opts *service.DBOpts) (models.User, error) {
+	switch token {
+	case "magic-token":
+		return svc.GetByID(1, opts)
+	case "mfa-magic-token":
+		return svc.GetByEmail("mfa@ksdfg.dev", opts)
+	case "expired-magic-token":
+		return models.User{}, apperror.ErrTokenExpired
+	}
+
+	return models.User{}, apperror.ErrTokenInvalid
+}
+
+func (svc mockUserService) UpdateProfile(userID uint, update service.ProfileUpdate, opts *service.DBOpts) (models.User, error) {
+	user, err := svc.GetByID(userID, opts)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.AvatarURL != nil {
+		user.AvatarURL = *update.AvatarURL
+	}
+	if update.Username != nil {
+		if *update.Username == "taken" {
+			return models.User{}, apperror.ErrUsernameUnavailable
+		}
+		user.Username = update.Username
+	}
+	if update.Discoverable != nil {
+		user.Discoverable = *update.Discoverable
+	}
+	if update.Email != nil && *update.Email != user.Email {
+		if *update.Email == "mfa@ksdfg.dev" {
+			return models.User{}, apperror.ErrUserExists
+		}
+		user.Email = *update.Email
+		user.EmailVerified = false
+	}
+
+	return user, nil
+}
+
+func (svc mockUserService) ChangePassword(userID uint, sessionID, currentPassword, newPassword string, opts *service.DBOpts) error {
+	switch {
+	case currentPassword != "securepassword":
+		return apperror.ErrIncorrectPassword
+	case newPassword == "password":
+		return apperror.ErrWeakPassword
+	}
+
+	return nil
+}
+
+func (svc mockUserService) Delete(userID uint, password string, opts *service.DBOpts) error {
+	if password != "securepassword" {
+		return apperror.ErrIncorrectPassword
+	}
+
+	return nil
+}
+
+func (svc mockUserService) Search(userID uint, query string, limit int, opts *service.DBOpts) ([]models.User, error) {
+	if !strings.HasPrefix("jane", utils.NormalizeUsername(query)) {
+		return []models.User{}, nil
+	}
+
+	username := "jane_doe"
+	return []models.User{{
+		Model:        gorm.Model{ID: 3},
+		Username:     &username,
+		Name:         "Jane Doe",
+		Email:        "jane@ksdfg.dev",
+		AvatarURL:    "https://example.com/jane.png",
+		Discoverable: true,
+	}}, nil
+}
+
+func (svc mockUserService) GenVerifiedEmailMiddleware() fiber.Handler {
+	panic("not implemented") // TODO: Implement
 }
 
 type mockAuthService struct{}
@@ -62,46 +222,345 @@ func (svc mockAuthService) HashPassword(password string) (string, error) {
 	return "hashedpassword", nil
 }
 
+func (svc mockAuthService) ValidatePassword(password string, userInputs ...string) error {
+	return nil
+}
+
 func (svc mockAuthService) ComparePasswords(hashedPassword string, password string) error {
 	if hashedPassword == "hashedpassword" && password == "securepassword" {
 		return nil
 	}
 
-	return bcrypt.ErrMismatchedHashAndPassword
+	return apperror.ErrInvalidCredentials
+}
+
+func (svc mockAuthService) NeedsRehash(hashedPassword string) bool {
+	return false
+}
+
+func (svc mockAuthService) DirectoryEnabled() bool {
+	return false
+}
+
+func (svc mockAuthService) AuthenticateDirectory(email, password string) (service.DirectoryUser, error) {
+	panic("not implemented") // TODO: Implement
 }
 
-func (svc mockAuthService) GenerateJWT(id uint) (string, time.Time, error) {
-	return "jwt-token", time.Now().Add(24 * time.Hour), nil
+func (svc mockAuthService) GenerateJWT(id uint, sessionID string) (string, time.Time, error) {
+	return "jwt-token", time.Now().Add(15 * time.Minute), nil
 }
 
 func (svc mockAuthService) ParseJWT(tokenString string) (*jwt.RegisteredClaims, error) {
 	panic("not implemented") // TODO: Implement
 }
 
-func (svc mockAuthService) GenMiddleware() fiber.Handler {
+func (svc mockAuthService) JWKS() (service.JWKSet, error) {
+	return service.JWKSet{}, nil
+}
+
+func (svc mockAuthService) GenMiddleware(scopes ...string) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		// Mock middleware that sets a user ID in the context
+		// Mock middleware that sets a user ID and session ID in the context
 		c.Locals("userID", "1")
+		c.Locals("sessionID", "session-1")
 		return c.Next()
 	}
 }
 
+type mockSessionService struct{}
+
+func (svc mockSessionService) Create(userID uint, client service.ClientInfo, opts *service.DBOpts) (service.Tokens, error) {
+	return service.Tokens{
+		SessionID:          "session-1",
+		AccessToken:        "jwt-token",
+		AccessTokenExpiry:  time.Now().Add(15 * time.Minute),
+		RefreshToken:       "refresh-token",
+		RefreshTokenExpiry: time.Now().Add(30 * 24 * time.Hour),
+	}, nil
+}
+
+func (svc mockSessionService) Refresh(refreshToken string, client service.ClientInfo, opts *service.DBOpts) (service.Tokens, error) {
+	switch refreshToken {
+	case "refresh-token":
+		return svc.Create(1, client, opts)
+	case "used-refresh-token":
+		return service.Tokens{}, apperror.ErrTokenReused
+	}
+
+	return service.Tokens{}, apperror.ErrTokenInvalid
+}
+
+func (svc mockSessionService) GetActive(id string, opts *service.DBOpts) (models.Session, error) {
+	return models.Session{ID: id, UserID: 1, ExpiresAt: time.Now().Add(time.Hour)}, nil
+}
+
+func (svc mockSessionService) ListActive(userID uint, opts *service.DBOpts) ([]models.Session, error) {
+	return []models.Session{
+		{ID: "session-1", UserID: userID, IPAddress: "0.0.0.0", UserAgent: "curl/8.0", ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: "session-2", UserID: userID, IPAddress: "10.0.0.1", UserAgent: "Firefox", ExpiresAt: time.Now().Add(time.Hour)},
+	}, nil
+}
+
+func (svc mockSessionService) Touch(id string, client service.ClientInfo, opts *service.DBOpts) error {
+	return nil
+}
+
+func (svc mockSessionService) Revoke(id string, opts *service.DBOpts) error {
+	return nil
+}
+
+func (svc mockSessionService) RevokeForUser(userID uint, id string, opts *service.DBOpts) error {
+	if id != "session-1" && id != "session-2" {
+		return apperror.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+func (svc mockSessionService) RevokeAll(userID uint, opts *service.DBOpts) error {
+	return nil
+}
+
+func (svc mockSessionService) RevokeOthers(userID uint, keepID string, opts *service.DBOpts) error {
+	return nil
+}
+
+type mockAccessTokenService struct{}
+
+func (svc mockAccessTokenService) Create(userID uint, name string, scopes []string, expiresAt *time.Time, opts *service.DBOpts) (models.PersonalAccessToken, string, error) {
+	for _, scope := range scopes {
+		if scope != service.ScopeNotesRead {
+			return models.PersonalAccessToken{}, "", apperror.ErrValidationFailed.WithDetails(apperror.FieldError{
+				Field:   "scopes",
+				Message: "must be one of: notes:read",
+			})
+		}
+	}
+
+	return models.PersonalAccessToken{ID: 1, Name: name, Prefix: "nap_abcdef", Scopes: scopes, ExpiresAt: expiresAt}, "nap_abcdefghijkl", nil
+}
+
+func (svc mockAccessTokenService) List(userID uint, opts *service.DBOpts) ([]models.PersonalAccessToken, error) {
+	return []models.PersonalAccessToken{
+		{ID: 1, Name: "backup script", Prefix: "nap_abcdef", Scopes: models.Scopes{service.ScopeNotesRead}},
+	}, nil
+}
+
+func (svc mockAccessTokenService) Revoke(userID uint, id uint, opts *service.DBOpts) error {
+	if id != 1 {
+		return apperror.ErrAccessTokenNotFound
+	}
+
+	return nil
+}
+
+func (svc mockAccessTokenService) Authenticate(token string, opts *service.DBOpts) (models.PersonalAccessToken, error) {
+	panic("not implemented") // TODO: Implement
+}
+
+type mockTOTPService struct{}
+
+func (svc mockTOTPService) IsEnabled(userID uint, opts *service.DBOpts) (bool, error) {
+	return userID == 2, nil
+}
+
+func (svc mockTOTPService) Enroll(user models.User, opts *service.DBOpts) (string, string, error) {
+	return "JBSWY3DPEHPK3PXP", "otpauth://totp/Notes:" + user.Email + "?secret=JBSWY3DPEHPK3PXP", nil
+}
+
+func (svc mockTOTPService) Confirm(userID uint, code string, opts *service.DBOpts) ([]string, error) {
+	if code != "123456" {
+		return nil, apperror.ErrInvalidOTP
+	}
+
+	return []string{"ABCDE-FGHIJ", "KLMNO-PQRST"}, nil
+}
+
+func (svc mockTOTPService) Disable(userID uint, code string, opts *service.DBOpts) error {
+	return svc.Verify(userID, code, opts)
+}
+
+func (svc mockTOTPService) Verify(userID uint, code string, opts *service.DBOpts) error {
+	if code != "123456" && code != "ABCDE-FGHIJ" {
+		return apperror.ErrInvalidOTP
+	}
+
+	return nil
+}
+
+func (svc mockTOTPService) CreateChallenge(user models.User, opts *service.DBOpts) (string, error) {
+	return "challenge-token", nil
+}
+
+func (svc mockTOTPService) ChallengeEmail(challengeToken string, opts *service.DBOpts) (string, error) {
+	if challengeToken != "challenge-token" {
+		return "", apperror.ErrTokenInvalid
+	}
+
+	return "mfa@ksdfg.dev", nil
+}
+
+func (svc mockTOTPService) VerifyChallenge(challengeToken, code string, opts *service.DBOpts) (uint, error) {
+	if challengeToken != "challenge-token" {
+		return 0, apperror.ErrTokenInvalid
+	}
+	if err := svc.Verify(2, code, opts); err != nil {
+		return 0, err
+	}
+
+	return 2, nil
+}
+
+// mockMaxLoginFailures is how many failed logins lock out an email in mockLoginThrottleService.
+const mockMaxLoginFailures = 3
+
+// mockLoginThrottleService counts failed logins and requests for emails per email, and locks out emails with too many
+// of either.
+type mockLoginThrottleService struct {
+	failures      map[string]int
+	emailRequests map[string]int
+}
+
+func (svc mockLoginThrottleService) Check(email, ip string, opts *service.DBOpts) (time.Duration, error) {
+	if email == "locked@ksdfg.dev" || svc.failures[email] >= mockMaxLoginFailures {
+		return 90 * time.Second, apperror.ErrTooManyLoginAttempts
+	}
+
+	return 0, nil
+}
+
+func (svc mockLoginThrottleService) RecordFailure(email, ip string, opts *service.DBOpts) error {
+	svc.failures[email]++
+	return nil
+}
+
+func (svc mockLoginThrottleService) RecordSuccess(email string, opts *service.DBOpts) error {
+	delete(svc.failures, email)
+	return nil
+}
+
+func (svc mockLoginThrottleService) RecordEmailRequest(email, ip string, opts *service.DBOpts) (time.Duration, error) {
+	if svc.emailRequests[email] >= mockMaxLoginFailures {
+		return 90 * time.Second, apperror.ErrTooManyEmailRequests
+	}
+
+	svc.emailRequests[email]++
+	return 0, nil
+}
+
+type mockPrivacyService struct{}
+
+const (
+	readyExportID   = "6f9619ff-8b86-d011-b42d-00cf4fc964ff"
+	pendingExportID = "7c9e6679-7425-40de-944b-e07fc1f90ae7"
+)
+
+func (svc mockPrivacyService) RequestExport(userID uint, opts *service.DBOpts) (models.DataExport, bool, error) {
+	return models.DataExport{ID: pendingExportID, UserID: userID, Status: models.DataExportPending}, true, nil
+}
+
+func (svc mockPrivacyService) BuildExport(id string, opts *service.DBOpts) error {
+	return nil
+}
+
+func (svc mockPrivacyService) GetExport(userID uint, id string, opts *service.DBOpts) (models.DataExport, error) {
+	switch id {
+	case readyExportID:
+		return models.DataExport{ID: id, UserID: userID, Status: models.DataExportReady, CreatedAt: time.Now()}, nil
+	case pendingExportID:
+		return models.DataExport{ID: id, UserID: userID, Status: models.DataExportPending, CreatedAt: time.Now()}, nil
+	}
+
+	return models.DataExport{}, apperror.ErrDataExportNotFound
+}
+
+func (svc mockPrivacyService) DownloadExport(userID uint, id string, opts *service.DBOpts) (models.DataExport, error) {
+	export, err := svc.GetExport(userID, id, opts)
+	if err != nil {
+		return models.DataExport{}, err
+	}
+	if export.Status != models.DataExportReady {
+		return models.DataExport{}, apperror.ErrDataExportNotReady
+	}
+
+	export.Archive = []byte("PK archive")
+	return export, nil
+}
+
+func (svc mockPrivacyService) EraseExpiredData(opts *service.DBOpts) (int, error) {
+	return 0, nil
+}
+
+type mockOIDCService struct{}
+
+func (svc mockOIDCService) ProviderNames() []string {
+	return []string{"okta"}
+}
+
+func (svc mockOIDCService) AuthCodeURL(provider string) (string, service.OIDCState, error) {
+	if provider != "okta" {
+		return "", service.OIDCState{}, apperror.ErrOIDCProviderNotFound
+	}
+
+	state := service.OIDCState{State: "state", Nonce: "nonce", CodeVerifier: "verifier"}
+	return "https://idp.example.com/authorize?state=state", state, nil
+}
+
+func (svc mockOIDCService) Exchange(provider string, state service.OIDCState, returnedState, code string, opts *service.DBOpts) (models.User, error) {
+	if provider != "okta" {
+		return models.User{}, apperror.ErrOIDCProviderNotFound
+	}
+	if state.State != returnedState || state.Nonce != "nonce" || state.CodeVerifier != "verifier" {
+		return models.User{}, apperror.ErrOIDCLoginFailed
+	}
+
+	// The mfa code logs in the user with two-factor authentication enabled
+	switch code {
+	case "code":
+		return (mockUserService{}).GetByID(1, opts)
+	case "mfa":
+		return (mockUserService{}).GetByEmail("mfa@ksdfg.dev", opts)
+	default:
+		return models.User{}, apperror.ErrOIDCLoginFailed
+	}
+}
+
 type usersTestSuite struct {
 	suite.Suite
-	app *fiber.App
+	app      *fiber.App
+	throttle mockLoginThrottleService
 }
 
 func (suite *usersTestSuite) SetupSuite() {
 	utils.SetDefaultLogger(slog.LevelDebug)
 
 	suite.app = fiber.New(fiber.Config{ErrorHandler: api.ErrorHandler})
-	users.RegisterRoutes(suite.app, users.Controller{UserService: mockUserService{}, AuthService: mockAuthService{}})
+	suite.throttle = mockLoginThrottleService{failures: map[string]int{}, emailRequests: map[string]int{}}
+	users.RegisterRoutes(suite.app, users.Controller{
+		UserService:          mockUserService{},
+		AuthService:          mockAuthService{},
+		SessionService:       mockSessionService{},
+		AccessTokenService:   mockAccessTokenService{},
+		TOTPService:          mockTOTPService{},
+		LoginThrottleService: suite.throttle,
+		OIDCService:          mockOIDCService{},
+		PrivacyService:       mockPrivacyService{},
+		MailQueue:            utils.NewWorkerPool(1, 10),
+	})
+}
+
+func (suite *usersTestSuite) SetupTest() {
+	// Forget the failed logins and requests for emails of other tests
+	clear(suite.throttle.failures)
+	clear(suite.throttle.emailRequests)
 }
 
 func (suite *usersTestSuite) TestRegister() {
 	type testCaseOutput struct {
-		status int
-		body   users.RegisterResponse
+		status  int
+		body    users.RegisterResponse
+		code    apperror.Code
+		details []apperror.FieldError
 	}
 
 	type testCase struct {
@@ -144,69 +603,184 @@ func (suite *usersTestSuite) TestRegister() {
 						Message: "User already exists",
 					},
 				},
+				code: apperror.CodeUserExists,
 			},
 		},
-	}
-
-	for name, tc := range testCases {
-		suite.Run(name, func() {
-			// Marshal test user to json []byte body
-			requestBody, err := json.Marshal(tc.input)
-			if err != nil {
-				suite.T().Error(err)
-				return
-			}
-
-			// Generate POST request to /api/v1/users endpoint with body generated above
-			request, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(requestBody))
-			if err != nil {
-				suite.T().Error(err)
-				return
-			}
-
-			// Add content type header so that the app can parse the body
-			request.Header.Add("Content-Type", "application/json")
-
-			// Send the request
-			response, err := suite.app.Test(request)
-			if err != nil {
-				suite.T().Error(err)
-				return
-			}
-			defer response.Body.Close()
-
-			// Assert that the response status code is as expected
-			suite.Equal(tc.output.status, response.StatusCode)
-
-			// Read the response body
-			body, err := io.ReadAll(response.Body)
-			if err != nil {
-				suite.T().Error(err)
-				return
-			}
-
-			// Unmarshal the response body into the expected response type
-			var responseBody users.RegisterResponse
-			if err = json.Unmarshal(body, &responseBody); err != nil {
-				suite.T().Error(err)
-				return
-			}
-
-			// Assert that the response body matches the expected output
-			suite.Equal(tc.output.body.Success, responseBody.Success)
-			suite.Equal(tc.output.body.Message, responseBody.Message)
-			suite.Equal(tc.output.body.User.Name, responseBody.User.Name)
-			suite.Equal(tc.output.body.User.Email, responseBody.User.Email)
-			suite.Empty(responseBody.User.Password) // Password should not be returned in the response
-		})
-	}
-}
+		"duplicate email differing by case": {
+			input: users.RegisterRequest{
+				Name:     "Kshitish Deshpande",
+				Email:    "  Duplicate@KSDFG.dev ",
+				Password: "securepassword",
+			},
+			output: testCaseOutput{
+				status: http.StatusConflict,
+				body: users.RegisterResponse{
+					ApiResponse: utils.ApiResponse{
+						Success: false,
+						Message: "User already exists",
+					},
+				},
+				code: apperror.CodeUserExists,
+			},
+		},
+		"with username": {
+			input: users.RegisterRequest{
+				Name:     "Kshitish Deshpande",
+				Email:    "me@ksdfg.dev",
+				Password: "securepassword",
+				Username: " @KSDFG ",
+			},
+			output: testCaseOutput{
+				status: http.StatusCreated,
+				body: users.RegisterResponse{
+					ApiResponse: utils.ApiResponse{
+						Success: true,
+						Message: "User created successfully",
+					},
+					User: models.User{
+						Name:     "Kshitish Deshpande",
+						Email:    "me@ksdfg.dev",
+						Username: ptr("ksdfg"),
+					},
+				},
+			},
+		},
+		"taken username": {
+			input: users.RegisterRequest{
+				Name:     "Kshitish Deshpande",
+				Email:    "me@ksdfg.dev",
+				Password: "securepassword",
+				Username: "taken",
+			},
+			output: testCaseOutput{
+				status: http.StatusConflict,
+				body: users.RegisterResponse{
+					ApiResponse: utils.ApiResponse{
+						Success: false,
+						Message: "Username is not available",
+					},
+				},
+				code: apperror.CodeUsernameUnavailable,
+			},
+		},
+		"invalid username": {
+			input: users.RegisterRequest{
+				Name:     "Kshitish Deshpande",
+				Email:    "me@ksdfg.dev",
+				Password: "securepassword",
+				Username: "1-ksdfg",
+			},
+			output: testCaseOutput{
+				status: http.StatusUnprocessableEntity,
+				body: users.RegisterResponse{
+					ApiResponse: utils.ApiResponse{
+						Success: false,
+						Message: "Validation failed",
+					},
+				},
+				code: apperror.CodeValidationFailed,
+				details: []apperror.FieldError{
+					{Field: "username", Message: utils.UsernameFormatMessage},
+				},
+			},
+		},
+		"invalid fields": {
+			input: users.RegisterRequest{
+				Name:     "  ",
+				Email:    "not-an-email",
+				Password: "",
+			},
+			output: testCaseOutput{
+				status: http.StatusUnprocessableEntity,
+				body: users.RegisterResponse{
+					ApiResponse: utils.ApiResponse{
+						Success: false,
+						Message: "Validation failed",
+					},
+				},
+				code: apperror.CodeValidationFailed,
+				details: []apperror.FieldError{
+					{Field: "name", Message: "is required"},
+					{Field: "email", Message: "must be a valid email address"},
+					{Field: "password", Message: "is required"},
+				},
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal test user to json []byte body
+			requestBody, err := json.Marshal(tc.input)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			// Generate POST request to /api/v1/users endpoint with body generated above
+			request, err := http.NewRequest(http.MethodPost, "/", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			// Add content type header so that the app can parse the body
+			request.Header.Add("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.output.status, response.StatusCode)
+
+			// Read the response body
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			// Unmarshal the response body into the expected response type
+			var responseBody users.RegisterResponse
+			if err = json.Unmarshal(body, &responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			// Assert that the response body matches the expected output
+			suite.Equal(tc.output.body.Success, responseBody.Success)
+			suite.Equal(tc.output.body.Message, responseBody.Message)
+			suite.Equal(tc.output.body.User.Name, responseBody.User.Name)
+			suite.Equal(tc.output.body.User.Email, responseBody.User.Email)
+			suite.Equal(tc.output.body.User.Username, responseBody.User.Username)
+			suite.Empty(responseBody.User.Password) // Password should not be returned in the response
+
+			// Assert that the error code matches the expected output
+			var errorBody utils.ErrorResponse
+			if err = json.Unmarshal(body, &errorBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.output.code, errorBody.Code)
+			suite.Equal(tc.output.details, errorBody.Details)
+		})
+	}
+}
 
 func (suite *usersTestSuite) TestLogin() {
 	type testCaseOutput struct {
-		status int
-		body   utils.ApiResponse
-		userId string
+		status     int
+		body       utils.ApiResponse
+		code       apperror.Code
+		userId     string
+		tokens     bool
+		challenge  bool
+		retryAfter string
 	}
 
 	type testCase struct {
@@ -229,17 +803,49 @@ func (suite *usersTestSuite) TestLogin() {
 				userId: "1",
 			},
 		},
+		"successful with token in body": {
+			input: users.LoginRequest{
+				Email:        "me@ksdfg.dev",
+				Password:     "securepassword",
+				IncludeToken: true,
+			},
+			output: testCaseOutput{
+				status: http.StatusOK,
+				body: utils.ApiResponse{
+					Success: true,
+					Message: "User logged in successfully",
+				},
+				userId: "1",
+				tokens: true,
+			},
+		},
+		"two-factor authentication required": {
+			input: users.LoginRequest{
+				Email:        "mfa@ksdfg.dev",
+				Password:     "securepassword",
+				IncludeToken: true,
+			},
+			output: testCaseOutput{
+				status: http.StatusOK,
+				body: utils.ApiResponse{
+					Success: true,
+					Message: "Two-factor authentication code required",
+				},
+				challenge: true,
+			},
+		},
 		"user not found": {
 			input: users.LoginRequest{
 				Email:    "nosuchuser@ksdfg.dev",
 				Password: "securepassword",
 			},
 			output: testCaseOutput{
-				status: http.StatusNotFound,
+				status: http.StatusUnauthorized,
 				body: utils.ApiResponse{
 					Success: false,
-					Message: "User not found",
+					Message: "Incorrect email or password",
 				},
+				code: apperror.CodeInvalidCredentials,
 			},
 		},
 		"incorrect password": {
@@ -251,8 +857,65 @@ func (suite *usersTestSuite) TestLogin() {
 				status: http.StatusUnauthorized,
 				body: utils.ApiResponse{
 					Success: false,
-					Message: "Incorrect password",
+					Message: "Incorrect email or password",
+				},
+				code: apperror.CodeInvalidCredentials,
+			},
+		},
+		"locked out": {
+			input: users.LoginRequest{
+				Email:    "locked@ksdfg.dev",
+				Password: "securepassword",
+			},
+			output: testCaseOutput{
+				status: http.StatusTooManyRequests,
+				body: utils.ApiResponse{
+					Success: false,
+					Message: "Too many failed login attempts, try again later",
 				},
+				code:       apperror.CodeTooManyLoginAttempts,
+				retryAfter: "90",
+			},
+		},
+		"directory unavailable": {
+			input: users.LoginRequest{
+				Email:    "ldap-down@ksdfg.dev",
+				Password: "securepassword",
+			},
+			output: testCaseOutput{
+				status: http.StatusServiceUnavailable,
+				body: utils.ApiResponse{
+					Success: false,
+					Message: "Unable to check the password, try again later",
+				},
+				code: apperror.CodeDirectoryUnavailable,
+			},
+		},
+		"email differing by case": {
+			input: users.LoginRequest{
+				Email:    " Me@KSDFG.dev",
+				Password: "securepassword",
+			},
+			output: testCaseOutput{
+				status: http.StatusOK,
+				body: utils.ApiResponse{
+					Success: true,
+					Message: "User logged in successfully",
+				},
+				userId: "1",
+			},
+		},
+		"missing password": {
+			input: users.LoginRequest{
+				Email: "me@ksdfg.dev",
+			},
+			output: testCaseOutput{
+				status: http.StatusUnprocessableEntity,
+				body: utils.ApiResponse{
+					Success: false,
+					Message: "Validation failed",
+				},
+				code: apperror.CodeValidationFailed,
 			},
 		},
 	}
@@ -287,6 +950,9 @@ func (suite *usersTestSuite) TestLogin() {
 			// Assert that the response status code is as expected
 			suite.Equal(tc.output.status, response.StatusCode)
 
+			// Assert that locked out clients are told when to try again
+			suite.Equal(tc.output.retryAfter, response.Header.Get(fiber.HeaderRetryAfter))
+
 			// Read the response body
 			body, err := io.ReadAll(response.Body)
 			if err != nil {
@@ -295,7 +961,7 @@ func (suite *usersTestSuite) TestLogin() {
 			}
 
 			// Unmarshal the response body into the expected response type
-			var responseBody utils.ApiResponse
+			var responseBody utils.ErrorResponse
 			if err = json.Unmarshal(body, &responseBody); err != nil {
 				suite.T().Error(err)
 				return
@@ -304,6 +970,30 @@ func (suite *usersTestSuite) TestLogin() {
 			// Assert that the response body matches the expected output
 			suite.Equal(tc.output.body.Success, responseBody.Success)
 			suite.Equal(tc.output.body.Message, responseBody.Message)
+			suite.Equal(tc.output.code, responseBody.Code)
+
+			// Assert that the tokens are only in the response body if the client asked for them
+			var loginResponse users.LoginResponse
+			if err = json.Unmarshal(body, &loginResponse); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			if tc.output.tokens {
+				suite.Require().NotNil(loginResponse.Tokens)
+				suite.Equal("Bearer", loginResponse.Tokens.TokenType)
+				suite.Equal("jwt-token", loginResponse.Tokens.AccessToken)
+				suite.Equal("refresh-token", loginResponse.Tokens.RefreshToken)
+			} else {
+				suite.Nil(loginResponse.Tokens)
+			}
+
+			// Assert that a challenge is returned instead of a session if the user has two-factor authentication
+			suite.Equal(tc.output.challenge, loginResponse.MFARequired)
+			if tc.output.challenge {
+				suite.Equal("challenge-token", loginResponse.ChallengeToken)
+			} else {
+				suite.Empty(loginResponse.ChallengeToken)
+			}
 
 			// Search for the authorization cookie in the response
 			foundAuthCookie := false
@@ -327,6 +1017,1390 @@ func (suite *usersTestSuite) TestLogin() {
 	}
 }
 
+func (suite *usersTestSuite) TestSessionCookies() {
+	requestBody, err := json.Marshal(users.LoginRequest{Email: "me@ksdfg.dev", Password: "securepassword"})
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+
+	request, err := http.NewRequest(http.MethodPost, "/login", bytes.NewBuffer(requestBody))
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	request.Header.Add("Content-Type", "application/json")
+
+	// Send the request
+	response, err := suite.app.Test(request)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	defer response.Body.Close()
+	suite.Equal(http.StatusOK, response.StatusCode)
+
+	// Assert that all cookies have the configured attributes, and that only the CSRF cookie is readable by scripts
+	cookies := map[string]*http.Cookie{}
+	for _, cookie := range response.Cookies() {
+		cookies[cookie.Name] = cookie
+	}
+	suite.Require().Len(cookies, 3)
+	for name, cookie := range cookies {
+		suite.Equal("/", cookie.Path, name)
+		suite.Equal(http.SameSiteLaxMode, cookie.SameSite, name)
+		suite.True(cookie.Secure, name)
+		suite.Equal(name != "csrf_token", cookie.HttpOnly, name)
+	}
+	suite.NotEmpty(cookies["csrf_token"].Value)
+}
+
+func (suite *usersTestSuite) TestRefresh() {
+	type testCaseOutput struct {
+		status int
+		code   apperror.Code
+	}
+
+	type testCase struct {
+		cookie string
+		csrf   string
+		input  users.RefreshRequest
+		output testCaseOutput
+	}
+
+	testCases := map[string]testCase{
+		"from cookie": {
+			cookie: "refresh-token",
+			csrf:   "csrf-token",
+			output: testCaseOutput{status: http.StatusOK},
+		},
+		"from cookie without csrf token": {
+			cookie: "refresh-token",
+			output: testCaseOutput{status: http.StatusForbidden, code: apperror.CodeCSRFTokenInvalid},
+		},
+		"from body": {
+			input:  users.RefreshRequest{RefreshToken: "refresh-token"},
+			output: testCaseOutput{status: http.StatusOK},
+		},
+		"reused token": {
+			cookie: "used-refresh-token",
+			csrf:   "csrf-token",
+			output: testCaseOutput{status: http.StatusUnauthorized, code: apperror.CodeTokenReused},
+		},
+		"invalid token": {
+			input:  users.RefreshRequest{RefreshToken: "nosuchtoken"},
+			output: testCaseOutput{status: http.StatusUnauthorized, code: apperror.CodeTokenInvalid},
+		},
+		"missing token": {
+			output: testCaseOutput{status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(tc.input)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			// Generate POST request to /api/v1/users/refresh endpoint with body generated above
+			request, err := http.NewRequest(http.MethodPost, "/refresh", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Add("Content-Type", "application/json")
+			if tc.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: "refresh_token", Value: tc.cookie})
+				request.AddCookie(&http.Cookie{Name: "csrf_token", Value: "csrf-token"})
+			}
+			if tc.csrf != "" {
+				request.Header.Add("X-CSRF-Token", tc.csrf)
+			}
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.output.status, response.StatusCode)
+
+			// Read and unmarshal the response body
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			var responseBody utils.ErrorResponse
+			if err = json.Unmarshal(body, &responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.output.code, responseBody.Code)
+
+			// Assert that the new tokens are only in the response body if the refresh token was sent in the body
+			var refreshResponse users.LoginResponse
+			if err = json.Unmarshal(body, &refreshResponse); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			if tc.output.status == http.StatusOK && tc.cookie == "" {
+				suite.Require().NotNil(refreshResponse.Tokens)
+				suite.Equal("refresh-token", refreshResponse.Tokens.RefreshToken)
+			} else {
+				suite.Nil(refreshResponse.Tokens)
+			}
+
+			// Assert that new cookies are set on success, and cleared on failure of a cookie refresh
+			cookies := map[string]string{}
+			for _, cookie := range response.Cookies() {
+				cookies[cookie.Name] = cookie.Value
+			}
+			if tc.output.status == http.StatusOK {
+				suite.Equal("jwt-token", cookies["authorization"])
+				suite.Equal("refresh-token", cookies["refresh_token"])
+				suite.NotEmpty(cookies["csrf_token"])
+			} else if tc.cookie != "" && tc.output.status == http.StatusUnauthorized {
+				suite.Empty(cookies["authorization"])
+				suite.Empty(cookies["refresh_token"])
+			}
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestLogout() {
+	for _, path := range []string{"/logout", "/logout-all"} {
+		suite.Run(path, func() {
+			request, err := http.NewRequest(http.MethodPost, path, nil)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.AddCookie(&http.Cookie{Name: "authorization", Value: "jwt-token"})
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(http.StatusOK, response.StatusCode)
+
+			// Assert that the session cookies are cleared
+			cleared := map[string]bool{}
+			for _, cookie := range response.Cookies() {
+				cleared[cookie.Name] = cookie.Value == "" && cookie.Expires.Before(time.Now())
+			}
+			suite.True(cleared["authorization"])
+			suite.True(cleared["refresh_token"])
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestListSessions() {
+	request, err := http.NewRequest(http.MethodGet, "/sessions", nil)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+
+	// Send the request
+	response, err := suite.app.Test(request)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	defer response.Body.Close()
+
+	// Assert that the response status code is as expected
+	suite.Equal(http.StatusOK, response.StatusCode)
+
+	// Read and unmarshal the response body
+	var responseBody users.ListSessionsResponse
+	if err = json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+		suite.T().Error(err)
+		return
+	}
+
+	// Assert that the session that made the request is marked as current
+	suite.Len(responseBody.Sessions, 2)
+	suite.Equal("session-1", responseBody.Sessions[0].ID)
+	suite.True(responseBody.Sessions[0].Current)
+	suite.Equal("curl/8.0", responseBody.Sessions[0].UserAgent)
+	suite.False(responseBody.Sessions[1].Current)
+}
+
+func (suite *usersTestSuite) TestRevokeSession() {
+	testCases := map[string]struct {
+		id     string
+		status int
+	}{
+		"own session":     {id: "session-2", status: http.StatusOK},
+		"unknown session": {id: "session-3", status: http.StatusNotFound},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			request, err := http.NewRequest(http.MethodDelete, "/sessions/"+tc.id, nil)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.status, response.StatusCode)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestVerifyEmail() {
+	testCases := map[string]struct {
+		token  string
+		status int
+		code   apperror.Code
+	}{
+		"valid token":   {token: "verification-token", status: http.StatusOK},
+		"expired token": {token: "expired-verification-token", status: http.StatusUnauthorized, code: apperror.CodeTokenExpired},
+		"invalid token": {token: "nosuchtoken", status: http.StatusUnauthorized, code: apperror.CodeTokenInvalid},
+		"missing token": {status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(users.VerifyEmailRequest{Token: tc.token})
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/verify-email", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Add("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code and error code are as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			var responseBody utils.ErrorResponse
+			if err = json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.code, responseBody.Code)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestResendVerificationEmail() {
+	request, err := http.NewRequest(http.MethodPost, "/verify-email/resend", nil)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+
+	// Send the request
+	response, err := suite.app.Test(request)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	defer response.Body.Close()
+
+	// Assert that the response status code is as expected
+	suite.Equal(http.StatusOK, response.StatusCode)
+}
+
+func (suite *usersTestSuite) TestForgotPassword() {
+	testCases := map[string]struct {
+		email  string
+		status int
+	}{
+		"registered email":   {email: "me@ksdfg.dev", status: http.StatusAccepted},
+		"unregistered email": {email: "nosuchuser@ksdfg.dev", status: http.StatusAccepted},
+		"invalid email":      {email: "me", status: http.StatusUnprocessableEntity},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(users.ForgotPasswordRequest{Email: tc.email})
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/password/forgot", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Add("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response doesn't tell whether the email is registered
+			suite.Equal(tc.status, response.StatusCode)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestEmailRequestThrottle() {
+	// post sends a JSON body to the given path and returns the response
+	post := func(path string, body any) *http.Response {
+		requestBody, err := json.Marshal(body)
+		suite.Require().NoError(err)
+		request, err := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(requestBody))
+		suite.Require().NoError(err)
+		request.Header.Set("Content-Type", "application/json")
+		response, err := suite.app.Test(request)
+		suite.Require().NoError(err)
+		return response
+	}
+
+	for range mockMaxLoginFailures {
+		response := post("/password/forgot", users.ForgotPasswordRequest{Email: "nosuchuser@ksdfg.dev"})
+		suite.Equal(http.StatusAccepted, response.StatusCode)
+		response.Body.Close()
+	}
+
+	// Assert that further requests for the email are rejected, whether for a reset or a magic link
+	response := post("/password/forgot", users.ForgotPasswordRequest{Email: "nosuchuser@ksdfg.dev"})
+	suite.Equal(http.StatusTooManyRequests, response.StatusCode)
+	suite.Equal("90", response.Header.Get(fiber.HeaderRetryAfter))
+	response.Body.Close()
+
+	response = post("/login/magic", users.MagicLinkRequest{Email: "nosuchuser@ksdfg.dev"})
+	suite.Equal(http.StatusTooManyRequests, response.StatusCode)
+	response.Body.Close()
+
+	// Assert that other emails can still ask for one
+	response = post("/login/magic", users.MagicLinkRequest{Email: "me@ksdfg.dev"})
+	suite.Equal(http.StatusAccepted, response.StatusCode)
+	response.Body.Close()
+}
+
+func (suite *usersTestSuite) TestResetPassword() {
+	testCases := map[string]struct {
+		input  users.ResetPasswordRequest
+		status int
+		code   apperror.Code
+	}{
+		"valid token":   {input: users.ResetPasswordRequest{Token: "reset-token", Password: "new secure password"}, status: http.StatusOK},
+		"invalid token": {input: users.ResetPasswordRequest{Token: "nosuchtoken", Password: "new secure password"}, status: http.StatusUnauthorized, code: apperror.CodeTokenInvalid},
+		"weak password": {input: users.ResetPasswordRequest{Token: "reset-token", Password: "password"}, status: http.StatusUnprocessableEntity, code: apperror.CodeWeakPassword},
+		"missing token": {input: users.ResetPasswordRequest{Password: "new secure password"}, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"long password": {input: users.ResetPasswordRequest{Token: "reset-token", Password: strings.Repeat("correct horse ", 6)}, status: http.StatusOK},
+		"huge password": {input: users.ResetPasswordRequest{Token: "reset-token", Password: strings.Repeat("a", service.MaxPasswordLength+1)}, status: http.StatusUnprocessableEntity, code: apperror.CodeWeakPassword},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(tc.input)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/password/reset", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Add("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code and error code are as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			var responseBody utils.ErrorResponse
+			if err = json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.code, responseBody.Code)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestCreateAccessToken() {
+	type testCaseOutput struct {
+		status int
+		code   apperror.Code
+		token  string
+	}
+
+	type testCase struct {
+		input  users.CreateAccessTokenRequest
+		output testCaseOutput
+	}
+
+	testCases := map[string]testCase{
+		"successful": {
+			input:  users.CreateAccessTokenRequest{Name: " backup script ", Scopes: []string{"notes:read"}},
+			output: testCaseOutput{status: http.StatusCreated, token: "nap_abcdefghijkl"},
+		},
+		"unknown scope": {
+			input:  users.CreateAccessTokenRequest{Name: "backup script", Scopes: []string{"notes:delete"}},
+			output: testCaseOutput{status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		},
+		"missing scopes": {
+			input:  users.CreateAccessTokenRequest{Name: "backup script"},
+			output: testCaseOutput{status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		},
+		"missing name": {
+			input:  users.CreateAccessTokenRequest{Scopes: []string{"notes:read"}},
+			output: testCaseOutput{status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(tc.input)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/tokens", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Add("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.output.status, response.StatusCode)
+
+			// Read and unmarshal the response body
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			var errorBody utils.ErrorResponse
+			if err = json.Unmarshal(body, &errorBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.output.code, errorBody.Code)
+
+			// Assert that the plaintext token is returned, and that the name was trimmed
+			var responseBody users.CreateAccessTokenResponse
+			if err = json.Unmarshal(body, &responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.output.token, responseBody.Token)
+			if tc.output.token != "" {
+				suite.Equal("backup script", responseBody.AccessToken.Name)
+			}
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestListAccessTokens() {
+	request, err := http.NewRequest(http.MethodGet, "/tokens", nil)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+
+	// Send the request
+	response, err := suite.app.Test(request)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	defer response.Body.Close()
+
+	// Assert that the response status code is as expected
+	suite.Equal(http.StatusOK, response.StatusCode)
+
+	// Read the raw response body, to check that token hashes are never returned
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	suite.NotContains(string(body), "token_hash")
+
+	var responseBody users.ListAccessTokensResponse
+	if err = json.Unmarshal(body, &responseBody); err != nil {
+		suite.T().Error(err)
+		return
+	}
+	suite.Require().Len(responseBody.AccessTokens, 1)
+	suite.Equal("nap_abcdef", responseBody.AccessTokens[0].Prefix)
+	suite.Equal(models.Scopes{"notes:read"}, responseBody.AccessTokens[0].Scopes)
+}
+
+func (suite *usersTestSuite) TestRevokeAccessToken() {
+	testCases := map[string]struct {
+		id     string
+		status int
+	}{
+		"own token":     {id: "1", status: http.StatusOK},
+		"unknown token": {id: "2", status: http.StatusNotFound},
+		"invalid id":    {id: "abc", status: http.StatusNotFound},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			request, err := http.NewRequest(http.MethodDelete, "/tokens/"+tc.id, nil)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.status, response.StatusCode)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestLoginTOTP() {
+	testCases := map[string]struct {
+		input  users.LoginTOTPRequest
+		status int
+		code   apperror.Code
+	}{
+		"successful with totp code": {
+			input:  users.LoginTOTPRequest{ChallengeToken: "challenge-token", Code: "123456"},
+			status: http.StatusOK,
+		},
+		"successful with recovery code": {
+			input:  users.LoginTOTPRequest{ChallengeToken: "challenge-token", Code: "ABCDE-FGHIJ"},
+			status: http.StatusOK,
+		},
+		"wrong code": {
+			input:  users.LoginTOTPRequest{ChallengeToken: "challenge-token", Code: "654321"},
+			status: http.StatusUnauthorized,
+			code:   apperror.CodeInvalidOTP,
+		},
+		"invalid challenge": {
+			input:  users.LoginTOTPRequest{ChallengeToken: "other-token", Code: "123456"},
+			status: http.StatusUnauthorized,
+			code:   apperror.CodeTokenInvalid,
+		},
+		"missing code": {
+			input:  users.LoginTOTPRequest{ChallengeToken: "challenge-token"},
+			status: http.StatusUnprocessableEntity,
+			code:   apperror.CodeValidationFailed,
+		},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(tc.input)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/login/totp", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			var responseBody utils.ErrorResponse
+			if err := json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.code, responseBody.Code)
+
+			// Assert that the session cookie is only set once the code is verified
+			foundAuthCookie := false
+			for _, cookie := range response.Cookies() {
+				if cookie.Name == "authorization" {
+					foundAuthCookie = true
+				}
+			}
+			suite.Equal(tc.status == http.StatusOK, foundAuthCookie)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestLoginTOTPThrottle() {
+	// post sends a JSON body to the given path and returns the response
+	post := func(path string, body any) *http.Response {
+		requestBody, err := json.Marshal(body)
+		suite.Require().NoError(err)
+		request, err := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(requestBody))
+		suite.Require().NoError(err)
+		request.Header.Set("Content-Type", "application/json")
+		response, err := suite.app.Test(request)
+		suite.Require().NoError(err)
+		return response
+	}
+
+	// Guess the code with a new challenge every time, since entering the password again doesn't reset the failures
+	login := users.LoginRequest{Email: "mfa@ksdfg.dev", Password: "securepassword"}
+	for range mockMaxLoginFailures {
+		response := post("/login", login)
+		suite.Equal(http.StatusOK, response.StatusCode)
+		response.Body.Close()
+
+		response = post("/login/totp", users.LoginTOTPRequest{ChallengeToken: "challenge-token", Code: "654321"})
+		suite.Equal(http.StatusUnauthorized, response.StatusCode)
+		response.Body.Close()
+	}
+
+	// Assert that even the right code is rejected now, and so is the password
+	response := post("/login/totp", users.LoginTOTPRequest{ChallengeToken: "challenge-token", Code: "123456"})
+	suite.Equal(http.StatusTooManyRequests, response.StatusCode)
+	suite.Equal("90", response.Header.Get(fiber.HeaderRetryAfter))
+	response.Body.Close()
+
+	response = post("/login", login)
+	suite.Equal(http.StatusTooManyRequests, response.StatusCode)
+	response.Body.Close()
+}
+
+func (suite *usersTestSuite) TestTOTPEnrollment() {
+	// Start enrolling
+	request, err := http.NewRequest(http.MethodPost, "/totp", nil)
+	suite.Require().NoError(err)
+	response, err := suite.app.Test(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+	suite.Equal(http.StatusOK, response.StatusCode)
+
+	var enrollResponse users.EnrollTOTPResponse
+	suite.Require().NoError(json.NewDecoder(response.Body).Decode(&enrollResponse))
+	suite.Equal("JBSWY3DPEHPK3PXP", enrollResponse.Secret)
+	suite.True(strings.HasPrefix(enrollResponse.URI, "otpauth://totp/"))
+
+	testCases := map[string]struct {
+		path   string
+		code   string
+		status int
+	}{
+		"confirm":                    {path: "/totp/confirm", code: "123456", status: http.StatusOK},
+		"confirm with wrong code":    {path: "/totp/confirm", code: "654321", status: http.StatusUnauthorized},
+		"confirm without code":       {path: "/totp/confirm", status: http.StatusUnprocessableEntity},
+		"disable":                    {path: "/totp/disable", code: "123456", status: http.StatusOK},
+		"disable with recovery code": {path: "/totp/disable", code: "ABCDE-FGHIJ", status: http.StatusOK},
+		"disable with wrong code":    {path: "/totp/disable", code: "654321", status: http.StatusUnauthorized},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			requestBody, err := json.Marshal(users.TOTPCodeRequest{Code: tc.code})
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, tc.path, bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			// Assert that the recovery codes are returned when confirming
+			if tc.path == "/totp/confirm" && tc.status == http.StatusOK {
+				var confirmResponse users.ConfirmTOTPResponse
+				if err := json.NewDecoder(response.Body).Decode(&confirmResponse); err != nil {
+					suite.T().Error(err)
+					return
+				}
+				suite.Len(confirmResponse.RecoveryCodes, 2)
+			}
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestOIDCLogin() {
+	// Start logging in with a configured provider
+	request, err := http.NewRequest(http.MethodGet, "/oidc/okta/login", nil)
+	suite.Require().NoError(err)
+	response, err := suite.app.Test(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+
+	// Assert that the user is sent to the provider, with the state kept in a cookie
+	suite.Equal(http.StatusFound, response.StatusCode)
+	suite.Equal("https://idp.example.com/authorize?state=state", response.Header.Get(fiber.HeaderLocation))
+	var stateCookie *http.Cookie
+	for _, cookie := range response.Cookies() {
+		if cookie.Name == "oidc_state" {
+			stateCookie = cookie
+		}
+	}
+	suite.Require().NotNil(stateCookie)
+	suite.Equal("state.nonce.verifier", stateCookie.Value)
+	suite.True(stateCookie.HttpOnly)
+	suite.Equal(http.SameSiteLaxMode, stateCookie.SameSite)
+
+	// Unknown providers are not found
+	request, err = http.NewRequest(http.MethodGet, "/oidc/unknown/login", nil)
+	suite.Require().NoError(err)
+	response, err = suite.app.Test(request)
+	suite.Require().NoError(err)
+	defer response.Body.Close()
+	suite.Equal(http.StatusNotFound, response.StatusCode)
+}
+
+func (suite *usersTestSuite) TestOIDCCallback() {
+	testCases := map[string]struct {
+		query   string
+		cookie  string
+		status  int
+		session bool
+		mfa     bool
+	}{
+		"successful":           {query: "state=state&code=code", cookie: "state.nonce.verifier", status: http.StatusFound, session: true},
+		"mfa required":         {query: "state=state&code=mfa", cookie: "state.nonce.verifier", status: http.StatusFound, mfa: true},
+		"state mismatch":       {query: "state=other&code=code", cookie: "state.nonce.verifier", status: http.StatusUnauthorized},
+		"missing state cookie": {query: "state=state&code=code", status: http.StatusUnauthorized},
+		"provider error":       {query: "error=access_denied&state=state", cookie: "state.nonce.verifier", status: http.StatusUnauthorized},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			request, err := http.NewRequest(http.MethodGet, "/oidc/okta/callback?"+tc.query, nil)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			if tc.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: "oidc_state", Value: tc.cookie})
+			}
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			// Assert that the session is only created, and the user sent back to the app, if the login succeeded
+			foundAuthCookie := false
+			for _, cookie := range response.Cookies() {
+				if cookie.Name == "authorization" {
+					foundAuthCookie = true
+				}
+				// The state can't be used again either way
+				if cookie.Name == "oidc_state" {
+					suite.Empty(cookie.Value)
+				}
+			}
+			suite.Equal(tc.session, foundAuthCookie)
+			if tc.session {
+				suite.Equal("http://localhost:3000", response.Header.Get(fiber.HeaderLocation))
+			}
+
+			// Users with two-factor authentication are sent back with a challenge token instead of a session
+			if tc.mfa {
+				suite.Equal("http://localhost:3000#challenge_token=challenge-token&mfa_required=true",
+					response.Header.Get(fiber.HeaderLocation))
+			}
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestRequestMagicLink() {
+	testCases := map[string]struct {
+		email  string
+		status int
+	}{
+		"registered email":   {email: "me@ksdfg.dev", status: http.StatusAccepted},
+		"unregistered email": {email: "nosuchuser@ksdfg.dev", status: http.StatusAccepted},
+		"invalid email":      {email: "me", status: http.StatusUnprocessableEntity},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(users.MagicLinkRequest{Email: tc.email})
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/login/magic", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Add("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response doesn't tell whether the email is registered
+			suite.Equal(tc.status, response.StatusCode)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestMagicLinkLogin() {
+	testCases := map[string]struct {
+		input       users.MagicLinkLoginRequest
+		status      int
+		code        apperror.Code
+		mfaRequired bool
+	}{
+		"successful": {
+			input:  users.MagicLinkLoginRequest{Token: "magic-token"},
+			status: http.StatusOK,
+		},
+		"two-factor authentication enabled": {
+			input:       users.MagicLinkLoginRequest{Token: "mfa-magic-token"},
+			status:      http.StatusOK,
+			mfaRequired: true,
+		},
+		"expired token": {
+			input:  users.MagicLinkLoginRequest{Token: "expired-magic-token"},
+			status: http.StatusUnauthorized,
+			code:   apperror.CodeTokenExpired,
+		},
+		"invalid token": {
+			input:  users.MagicLinkLoginRequest{Token: "other-token"},
+			status: http.StatusUnauthorized,
+			code:   apperror.CodeTokenInvalid,
+		},
+		"missing token": {
+			input:  users.MagicLinkLoginRequest{},
+			status: http.StatusUnprocessableEntity,
+			code:   apperror.CodeValidationFailed,
+		},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(tc.input)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/login/magic/callback", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Set("Content-Type", "application/json")
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			var responseBody struct {
+				utils.ErrorResponse
+				MFARequired bool `json:"mfa_required"`
+			}
+			if err := json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.code, responseBody.Code)
+			suite.Equal(tc.mfaRequired, responseBody.MFARequired)
+
+			// Assert that the session cookie is only set once the user is fully logged in
+			foundAuthCookie := false
+			for _, cookie := range response.Cookies() {
+				if cookie.Name == "authorization" {
+					foundAuthCookie = true
+				}
+			}
+			suite.Equal(tc.status == http.StatusOK && !tc.mfaRequired, foundAuthCookie)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestGetProfile() {
+	request, err := http.NewRequest(http.MethodGet, "/me", nil)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	request.AddCookie(&http.Cookie{Name: "authorization", Value: "jwt-token"})
+
+	// Send the request
+	response, err := suite.app.Test(request)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	defer response.Body.Close()
+
+	// Assert that the current user is returned
+	suite.Equal(http.StatusOK, response.StatusCode)
+
+	var responseBody users.UserResponse
+	if err := json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+		suite.T().Error(err)
+		return
+	}
+	suite.Equal(uint(1), responseBody.User.ID)
+	suite.Equal("me@ksdfg.dev", responseBody.User.Email)
+}
+
+func (suite *usersTestSuite) TestUpdateProfile() {
+	testCases := map[string]struct {
+		input         string
+		status        int
+		code          apperror.Code
+		name          string
+		email         string
+		avatarURL     string
+		username      *string
+		discoverable  bool
+		emailVerified bool
+	}{
+		"name and avatar": {
+			input:     `{"name": " Kshitish ", "avatar_url": "https://example.com/avatar.png"}`,
+			status:    http.StatusOK,
+			name:      "Kshitish",
+			email:     "me@ksdfg.dev",
+			avatarURL: "https://example.com/avatar.png",
+		},
+		"remove avatar": {
+			input:  `{"avatar_url": ""}`,
+			status: http.StatusOK,
+			name:   "Kshitish Deshpande",
+			email:  "me@ksdfg.dev",
+		},
+		"new email": {
+			input:  `{"email": " Me+New@KSDFG.dev"}`,
+			status: http.StatusOK,
+			name:   "Kshitish Deshpande",
+			email:  "me+new@ksdfg.dev",
+		},
+		"email of another user": {
+			input:  `{"email": "mfa@ksdfg.dev"}`,
+			status: http.StatusConflict,
+			code:   apperror.CodeUserExists,
+		},
+		"empty name": {
+			input:  `{"name": " "}`,
+			status: http.StatusUnprocessableEntity,
+			code:   apperror.CodeValidationFailed,
+		},
+		"invalid avatar": {
+			input:  `{"avatar_url": "javascript:alert(1)"}`,
+			status: http.StatusUnprocessableEntity,
+			code:   apperror.CodeValidationFailed,
+		},
+		"username and discoverable": {
+			input:        `{"username": "@Kshitish_D", "discoverable": true}`,
+			status:       http.StatusOK,
+			name:         "Kshitish Deshpande",
+			email:        "me@ksdfg.dev",
+			username:     ptr("kshitish_d"),
+			discoverable: true,
+		},
+		"taken username": {
+			input:  `{"username": "taken"}`,
+			status: http.StatusConflict,
+			code:   apperror.CodeUsernameUnavailable,
+		},
+		"invalid username": {
+			input:  `{"username": "ks"}`,
+			status: http.StatusUnprocessableEntity,
+			code:   apperror.CodeValidationFailed,
+		},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			request, err := http.NewRequest(http.MethodPatch, "/me", strings.NewReader(tc.input))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Set("Content-Type", "application/json")
+			request.AddCookie(&http.Cookie{Name: "authorization", Value: "jwt-token"})
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			var responseBody struct {
+				users.UserResponse
+				Code apperror.Code `json:"code"`
+			}
+			if err := json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.code, responseBody.Code)
+
+			// Assert that the updated user is returned
+			if tc.status == http.StatusOK {
+				suite.Equal(tc.name, responseBody.User.Name)
+				suite.Equal(tc.email, responseBody.User.Email)
+				suite.Equal(tc.avatarURL, responseBody.User.AvatarURL)
+				suite.Equal(tc.username, responseBody.User.Username)
+				suite.Equal(tc.discoverable, responseBody.User.Discoverable)
+				suite.Equal(tc.emailVerified, responseBody.User.EmailVerified)
+			}
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestChangePassword() {
+	testCases := map[string]struct {
+		input  users.ChangePasswordRequest
+		status int
+		code   apperror.Code
+	}{
+		"successful":         {input: users.ChangePasswordRequest{CurrentPassword: "securepassword", NewPassword: "new secure password"}, status: http.StatusOK},
+		"incorrect password": {input: users.ChangePasswordRequest{CurrentPassword: "wrongpassword", NewPassword: "new secure password"}, status: http.StatusForbidden, code: apperror.CodeIncorrectPassword},
+		"weak password":      {input: users.ChangePasswordRequest{CurrentPassword: "securepassword", NewPassword: "password"}, status: http.StatusUnprocessableEntity, code: apperror.CodeWeakPassword},
+		"missing password":   {input: users.ChangePasswordRequest{NewPassword: "new secure password"}, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(tc.input)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodPost, "/me/password", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Add("Content-Type", "application/json")
+			request.AddCookie(&http.Cookie{Name: "authorization", Value: "jwt-token"})
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code and error code are as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			var responseBody utils.ErrorResponse
+			if err = json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.code, responseBody.Code)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestDeleteAccount() {
+	testCases := map[string]struct {
+		input  users.DeleteAccountRequest
+		status int
+		code   apperror.Code
+	}{
+		"successful":         {input: users.DeleteAccountRequest{Password: "securepassword"}, status: http.StatusOK},
+		"incorrect password": {input: users.DeleteAccountRequest{Password: "wrongpassword"}, status: http.StatusForbidden, code: apperror.CodeIncorrectPassword},
+		"missing password":   {input: users.DeleteAccountRequest{}, status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			// Marshal the request to json []byte body
+			requestBody, err := json.Marshal(tc.input)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			request, err := http.NewRequest(http.MethodDelete, "/me", bytes.NewBuffer(requestBody))
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.Header.Add("Content-Type", "application/json")
+			request.AddCookie(&http.Cookie{Name: "authorization", Value: "jwt-token"})
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code and error code are as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			var responseBody utils.ErrorResponse
+			if err = json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.code, responseBody.Code)
+
+			// Assert that the session cookies are only cleared once the account is deleted
+			cleared := false
+			for _, cookie := range response.Cookies() {
+				if cookie.Name == "authorization" {
+					cleared = cookie.Value == "" && cookie.Expires.Before(time.Now())
+				}
+			}
+			suite.Equal(tc.status == http.StatusOK, cleared)
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestDataExport() {
+	// Start an export
+	request, err := http.NewRequest(http.MethodPost, "/me/export", nil)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	request.AddCookie(&http.Cookie{Name: "authorization", Value: "jwt-token"})
+
+	response, err := suite.app.Test(request)
+	if err != nil {
+		suite.T().Error(err)
+		return
+	}
+	defer response.Body.Close()
+
+	suite.Equal(http.StatusAccepted, response.StatusCode)
+	var responseBody users.DataExportResponse
+	if err := json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+		suite.T().Error(err)
+		return
+	}
+	suite.Equal(pendingExportID, responseBody.Export.ID)
+	suite.Equal(models.DataExportPending, responseBody.Export.Status)
+
+	testCases := map[string]struct {
+		path        string
+		status      int
+		code        apperror.Code
+		contentType string
+	}{
+		"status":             {path: "/me/export/" + pendingExportID, status: http.StatusOK, contentType: fiber.MIMEApplicationJSON},
+		"unknown export":     {path: "/me/export/nosuchexport", status: http.StatusNotFound, code: apperror.CodeDataExportNotFound},
+		"download":           {path: "/me/export/" + readyExportID + "/download", status: http.StatusOK, contentType: "application/zip"},
+		"download not ready": {path: "/me/export/" + pendingExportID + "/download", status: http.StatusConflict, code: apperror.CodeDataExportNotReady},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			request, err := http.NewRequest(http.MethodGet, tc.path, nil)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.AddCookie(&http.Cookie{Name: "authorization", Value: "jwt-token"})
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code and content are as expected
+			suite.Equal(tc.status, response.StatusCode)
+			if tc.status != http.StatusOK {
+				var responseBody utils.ErrorResponse
+				if err := json.NewDecoder(response.Body).Decode(&responseBody); err != nil {
+					suite.T().Error(err)
+					return
+				}
+				suite.Equal(tc.code, responseBody.Code)
+				return
+			}
+
+			suite.Contains(response.Header.Get(fiber.HeaderContentType), tc.contentType)
+			if tc.contentType == "application/zip" {
+				suite.Contains(response.Header.Get(fiber.HeaderContentDisposition), "attachment")
+			}
+		})
+	}
+}
+
+func (suite *usersTestSuite) TestSearchUsers() {
+	testCases := map[string]struct {
+		query  string
+		status int
+		code   apperror.Code
+		found  int
+	}{
+		"found":           {query: "q=%40Ja", status: http.StatusOK, found: 1},
+		"no match":        {query: "q=zz&limit=5", status: http.StatusOK},
+		"missing query":   {query: "", status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"query too short": {query: "q=+j+", status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"limit too high":  {query: "q=ja&limit=100", status: http.StatusUnprocessableEntity, code: apperror.CodeValidationFailed},
+		"invalid limit":   {query: "q=ja&limit=ten", status: http.StatusBadRequest, code: apperror.CodeInvalidRequest},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			request, err := http.NewRequest(http.MethodGet, "/search?"+tc.query, nil)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			request.AddCookie(&http.Cookie{Name: "authorization", Value: "jwt-token"})
+
+			// Send the request
+			response, err := suite.app.Test(request)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+			defer response.Body.Close()
+
+			// Assert that the response status code is as expected
+			suite.Equal(tc.status, response.StatusCode)
+
+			body, err := io.ReadAll(response.Body)
+			if err != nil {
+				suite.T().Error(err)
+				return
+			}
+
+			var responseBody struct {
+				users.SearchUsersResponse
+				Code apperror.Code `json:"code"`
+			}
+			if err := json.Unmarshal(body, &responseBody); err != nil {
+				suite.T().Error(err)
+				return
+			}
+			suite.Equal(tc.code, responseBody.Code)
+
+			// Assert that the users are found, without giving away their emails
+			if tc.status == http.StatusOK {
+				suite.Len(responseBody.Users, tc.found)
+				suite.NotContains(string(body), "email")
+			}
+			if tc.found > 0 {
+				suite.Equal("jane_doe", *responseBody.Users[0].Username)
+				suite.Equal("Jane Doe", responseBody.Users[0].Name)
+			}
+		})
+	}
+}
+
+// ptr returns a pointer to the given value, for optional fields in test cases.
+func ptr[T any](v T) *T {
+	return &v
+}
+
 func TestUsersRoutes(t *testing.T) {
 	suite.Run(t, new(usersTestSuite))
 }
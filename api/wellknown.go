@@ -0,0 +1,22 @@
+package api
+
+import (
+	"notes-app/service"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// jwksHandler serves the public keys used to sign tokens as a JSON Web Key Set, so that other services can verify
+// them.
+func jwksHandler(authService service.IAuthService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		jwks, err := authService.JWKS()
+		if err != nil {
+			return err
+		}
+
+		// Allow verifiers to cache the keys, but not for longer than it takes to roll out a key rotation
+		c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+		return c.JSON(jwks)
+	}
+}
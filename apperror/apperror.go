@@ -0,0 +1,312 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Code is a stable, machine-readable identifier for an application error.
+//
+// Clients should branch on codes rather than on messages, since messages are meant for humans and may change.
+type Code string
+
+const (
+	// CodeInternal is used for any unexpected error that the client cannot act upon.
+	CodeInternal Code = "INTERNAL_ERROR"
+	// CodeInvalidRequest is used when the request could not be parsed.
+	CodeInvalidRequest Code = "INVALID_REQUEST"
+	// CodeValidationFailed is used when the request was parsed but one or more fields are invalid.
+	CodeValidationFailed Code = "VALIDATION_FAILED"
+	// CodeNotFound is used when the requested resource does not exist.
+	CodeNotFound Code = "NOT_FOUND"
+	// CodeUnauthorized is used when the request is missing valid credentials.
+	CodeUnauthorized Code = "UNAUTHORIZED"
+	// CodeForbidden is used when the caller is authenticated but not allowed to perform the action.
+	CodeForbidden Code = "FORBIDDEN"
+
+	// CodeUserExists is used when a user with the same unique details is already registered.
+	CodeUserExists Code = "USER_EXISTS"
+	// CodeUsernameUnavailable is used when a username is reserved or already taken by another user.
+	CodeUsernameUnavailable Code = "USERNAME_UNAVAILABLE"
+	// CodeUserNotFound is used when the requested user does not exist.
+	CodeUserNotFound Code = "USER_NOT_FOUND"
+	// CodeInvalidCredentials is used when the provided credentials do not match.
+	CodeInvalidCredentials Code = "INVALID_CREDENTIALS"
+	// CodeTooManyLoginAttempts is used when logins are temporarily blocked after too many failed attempts.
+	CodeTooManyLoginAttempts Code = "TOO_MANY_LOGIN_ATTEMPTS"
+	// CodeTooManyEmailRequests is used when emails with links, like password resets, are asked for too often.
+	CodeTooManyEmailRequests Code = "TOO_MANY_EMAIL_REQUESTS"
+	// CodeDirectoryUnavailable is used when the directory that passwords are checked against can't be reached.
+	CodeDirectoryUnavailable Code = "DIRECTORY_UNAVAILABLE"
+	// CodeOIDCProviderNotFound is used when logging in with an identity provider that is not configured.
+	CodeOIDCProviderNotFound Code = "OIDC_PROVIDER_NOT_FOUND"
+	// CodeOIDCLoginFailed is used when the identity provider doesn't confirm who the user is.
+	CodeOIDCLoginFailed Code = "OIDC_LOGIN_FAILED"
+	// CodeInvalidOTP is used when a two-factor authentication code is incorrect, expired or already used.
+	CodeInvalidOTP Code = "INVALID_OTP"
+	// CodeTOTPAlreadyEnabled is used when enrolling in two-factor authentication while it is already enabled.
+	CodeTOTPAlreadyEnabled Code = "TOTP_ALREADY_ENABLED"
+	// CodeTOTPNotEnabled is used when confirming or disabling two-factor authentication while it is not set up.
+	CodeTOTPNotEnabled Code = "TOTP_NOT_ENABLED"
+	// CodeEmailNotVerified is used when an action requires the user to have verified their email.
+	CodeEmailNotVerified Code = "EMAIL_NOT_VERIFIED"
+	// CodeEmailAlreadyVerified is used when asking to verify an email that is already verified.
+	CodeEmailAlreadyVerified Code = "EMAIL_ALREADY_VERIFIED"
+	// CodeWeakPassword is used when a password does not satisfy the password policy.
+	CodeWeakPassword Code = "WEAK_PASSWORD"
+	// CodeBreachedPassword is used when a password is known to have been compromised in a data breach.
+	CodeBreachedPassword Code = "BREACHED_PASSWORD"
+	// CodeIncorrectPassword is used when the current password, asked for to confirm a sensitive action, is incorrect.
+	CodeIncorrectPassword Code = "INCORRECT_PASSWORD"
+	// CodeManagedByDirectory is used when changing details of a user that are managed by the directory.
+	CodeManagedByDirectory Code = "MANAGED_BY_DIRECTORY"
+
+	// CodeTokenExpired is used when an authentication token has expired.
+	CodeTokenExpired Code = "TOKEN_EXPIRED"
+	// CodeTokenInvalid is used when an authentication token is malformed or its signature cannot be verified.
+	CodeTokenInvalid Code = "TOKEN_INVALID"
+	// CodeTokenReused is used when a single-use token is presented again after it was already used.
+	CodeTokenReused Code = "TOKEN_REUSED"
+	// CodeSessionRevoked is used when the session of an authentication token has been revoked or has expired.
+	CodeSessionRevoked Code = "SESSION_REVOKED"
+	// CodeSessionNotFound is used when the requested session does not exist or belongs to another user.
+	CodeSessionNotFound Code = "SESSION_NOT_FOUND"
+	// CodeInsufficientScope is used when an access token does not have the scopes required by an API.
+	CodeInsufficientScope Code = "INSUFFICIENT_SCOPE"
+	// CodeAccessTokenNotFound is used when the requested access token does not exist or belongs to another user.
+	CodeAccessTokenNotFound Code = "ACCESS_TOKEN_NOT_FOUND"
+	// CodeDataExportNotFound is used when the requested data export does not exist, belongs to another user or has
+	// expired.
+	CodeDataExportNotFound Code = "DATA_EXPORT_NOT_FOUND"
+	// CodeDataExportNotReady is used when downloading a data export that is still being built or failed.
+	CodeDataExportNotReady Code = "DATA_EXPORT_NOT_READY"
+	// CodeOrganizationNotFound is used when the requested organization does not exist or the user is not a member.
+	CodeOrganizationNotFound Code = "ORGANIZATION_NOT_FOUND"
+	// CodeMemberNotFound is used when the requested user is not a member of the organization.
+	CodeMemberNotFound Code = "MEMBER_NOT_FOUND"
+	// CodeAlreadyMember is used when inviting a user who is already a member of the organization.
+	CodeAlreadyMember Code = "ALREADY_MEMBER"
+	// CodeInvitationNotFound is used when the requested invitation does not exist or has expired.
+	CodeInvitationNotFound Code = "INVITATION_NOT_FOUND"
+	// CodeInvitationEmailMismatch is used when accepting an invitation that was sent to another email.
+	CodeInvitationEmailMismatch Code = "INVITATION_EMAIL_MISMATCH"
+	// CodeInsufficientRole is used when the user's role in an organization doesn't allow the action.
+	CodeInsufficientRole Code = "INSUFFICIENT_ROLE"
+	// CodeLastOwner is used when an action would leave an organization without an owner.
+	CodeLastOwner Code = "LAST_OWNER"
+	// CodeGroupNotFound is used when the requested group does not exist or the user can't see it.
+	CodeGroupNotFound Code = "GROUP_NOT_FOUND"
+	// CodeNoteNotFound is used when the requested note does not exist or the user can't see it.
+	CodeNoteNotFound Code = "NOTE_NOT_FOUND"
+	// CodeNoteShareNotFound is used when unsharing a note from a group that it isn't shared with.
+	CodeNoteShareNotFound Code = "NOTE_SHARE_NOT_FOUND"
+	// CodeShareLinkNotFound is used when the requested share link does not exist or has been revoked.
+	CodeShareLinkNotFound Code = "SHARE_LINK_NOT_FOUND"
+	// CodeShareLinkExpired is used when a share link has expired or has been used as many times as it allows.
+	CodeShareLinkExpired Code = "SHARE_LINK_EXPIRED"
+	// CodeSharePasswordInvalid is used when a password protected share link is opened without the right password.
+	CodeSharePasswordInvalid Code = "SHARE_PASSWORD_INVALID"
+	// CodeTooManyShareAttempts is used when opening a share link is temporarily blocked after too many wrong passwords.
+	CodeTooManyShareAttempts Code = "TOO_MANY_SHARE_ATTEMPTS"
+	// CodeAccessRequestNotFound is used when the requested access request does not exist or the user can't see it.
+	CodeAccessRequestNotFound Code = "ACCESS_REQUEST_NOT_FOUND"
+	// CodeAccessRequestDecided is used when deciding on an access request that has already been approved or denied.
+	CodeAccessRequestDecided Code = "ACCESS_REQUEST_DECIDED"
+	// CodeCSRFTokenInvalid is used when a cookie authenticated request is missing a valid CSRF token.
+	CodeCSRFTokenInvalid Code = "CSRF_TOKEN_INVALID"
+)
+
+// statuses maps each code to the HTTP status code that should be used when it is returned from an API.
+var statuses = map[Code]int{
+	CodeInternal:                fiber.StatusInternalServerError,
+	CodeInvalidRequest:          fiber.StatusBadRequest,
+	CodeValidationFailed:        fiber.StatusUnprocessableEntity,
+	CodeNotFound:                fiber.StatusNotFound,
+	CodeUnauthorized:            fiber.StatusUnauthorized,
+	CodeForbidden:               fiber.StatusForbidden,
+	CodeUserExists:              fiber.StatusConflict,
+	CodeUsernameUnavailable:     fiber.StatusConflict,
+	CodeUserNotFound:            fiber.StatusNotFound,
+	CodeInvalidCredentials:      fiber.StatusUnauthorized,
+	CodeTooManyLoginAttempts:    fiber.StatusTooManyRequests,
+	CodeTooManyEmailRequests:    fiber.StatusTooManyRequests,
+	CodeDirectoryUnavailable:    fiber.StatusServiceUnavailable,
+	CodeOIDCProviderNotFound:    fiber.StatusNotFound,
+	CodeOIDCLoginFailed:         fiber.StatusUnauthorized,
+	CodeInvalidOTP:              fiber.StatusUnauthorized,
+	CodeTOTPAlreadyEnabled:      fiber.StatusConflict,
+	CodeTOTPNotEnabled:          fiber.StatusConflict,
+	CodeEmailNotVerified:        fiber.StatusForbidden,
+	CodeEmailAlreadyVerified:    fiber.StatusConflict,
+	CodeWeakPassword:            fiber.StatusUnprocessableEntity,
+	CodeBreachedPassword:        fiber.StatusUnprocessableEntity,
+	CodeIncorrectPassword:       fiber.StatusForbidden,
+	CodeManagedByDirectory:      fiber.StatusConflict,
+	CodeTokenExpired:            fiber.StatusUnauthorized,
+	CodeTokenInvalid:            fiber.StatusUnauthorized,
+	CodeTokenReused:             fiber.StatusUnauthorized,
+	CodeSessionRevoked:          fiber.StatusUnauthorized,
+	CodeSessionNotFound:         fiber.StatusNotFound,
+	CodeInsufficientScope:       fiber.StatusForbidden,
+	CodeAccessTokenNotFound:     fiber.StatusNotFound,
+	CodeDataExportNotFound:      fiber.StatusNotFound,
+	CodeDataExportNotReady:      fiber.StatusConflict,
+	CodeOrganizationNotFound:    fiber.StatusNotFound,
+	CodeMemberNotFound:          fiber.StatusNotFound,
+	CodeAlreadyMember:           fiber.StatusConflict,
+	CodeInvitationNotFound:      fiber.StatusNotFound,
+	CodeInvitationEmailMismatch: fiber.StatusForbidden,
+	CodeInsufficientRole:        fiber.StatusForbidden,
+	CodeLastOwner:               fiber.StatusConflict,
+	CodeGroupNotFound:           fiber.StatusNotFound,
+	CodeNoteNotFound:            fiber.StatusNotFound,
+	CodeNoteShareNotFound:       fiber.StatusNotFound,
+	CodeShareLinkNotFound:       fiber.StatusNotFound,
+	CodeShareLinkExpired:        fiber.StatusGone,
+	CodeSharePasswordInvalid:    fiber.StatusUnauthorized,
+	CodeTooManyShareAttempts:    fiber.StatusTooManyRequests,
+	CodeAccessRequestNotFound:   fiber.StatusNotFound,
+	CodeAccessRequestDecided:    fiber.StatusConflict,
+	CodeCSRFTokenInvalid:        fiber.StatusForbidden,
+}
+
+// Status returns the HTTP status code for the code, defaulting to 500 for unknown codes.
+func (code Code) Status() int {
+	if status, ok := statuses[code]; ok {
+		return status
+	}
+	return fiber.StatusInternalServerError
+}
+
+// FieldError describes a problem with a single field of a request.
+type FieldError struct {
+	// Field is the name of the field as the client sent it, e.g. the JSON key.
+	Field string `json:"field"`
+
+	// Message is a human-readable description of what is wrong with the field.
+	Message string `json:"message"`
+}
+
+// Error is an application error with a stable code, a human-readable message and optional field-level details.
+type Error struct {
+	// Code is the machine-readable identifier of the error.
+	Code Code
+
+	// Message is a human-readable message that is safe to show to the client.
+	Message string
+
+	// Details contains field-level errors, if any.
+	Details []FieldError
+
+	// cause is the underlying error, if any. It is never exposed to clients.
+	cause error
+
+	// status overrides the HTTP status code derived from the code, if set.
+	status int
+}
+
+// New creates a new application error with the given code and message.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap creates a new application error with the given code and message, wrapping the given cause.
+//
+// The cause is available through errors.Unwrap, but is never exposed to clients.
+func Wrap(cause error, code Code, message string) *Error {
+	return &Error{Code: code, Message: message, cause: cause}
+}
+
+// Internal wraps an unexpected error so that it is reported to clients as a generic internal error.
+func Internal(cause error) *Error {
+	return Wrap(cause, CodeInternal, "Internal server error")
+}
+
+// Error returns the message of the error, along with the cause if there is one.
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.cause)
+	}
+	return e.Message
+}
+
+// Unwrap returns the underlying cause of the error.
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is reports whether the target is an application error with the same code.
+//
+// This allows comparing against the predefined errors with errors.Is, even if they were wrapped or had details
+// added to them.
+func (e *Error) Is(target error) bool {
+	var t *Error
+	if !errors.As(target, &t) {
+		return false
+	}
+	return e.Code == t.Code
+}
+
+// Status returns the HTTP status code that should be used for the error.
+func (e *Error) Status() int {
+	if e.status != 0 {
+		return e.status
+	}
+	return e.Code.Status()
+}
+
+// WithDetails returns a copy of the error with the given field errors appended.
+func (e *Error) WithDetails(details ...FieldError) *Error {
+	clone := *e
+	clone.Details = append(append([]FieldError{}, e.Details...), details...)
+	return &clone
+}
+
+// WithCause returns a copy of the error wrapping the given cause.
+func (e *Error) WithCause(cause error) *Error {
+	clone := *e
+	clone.cause = cause
+	return &clone
+}
+
+// From converts any error into an application error.
+//
+// Application errors are returned as is, *fiber.Error values are mapped to the closest code for their status, and
+// everything else is treated as an internal error.
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return &Error{Code: codeForStatus(fiberErr.Code), Message: fiberErr.Message, cause: err, status: fiberErr.Code}
+	}
+
+	return Internal(err)
+}
+
+// codeForStatus returns the generic code to use for errors that only carry an HTTP status code.
+func codeForStatus(status int) Code {
+	switch status {
+	case fiber.StatusBadRequest:
+		return CodeInvalidRequest
+	case fiber.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	case fiber.StatusUnauthorized:
+		return CodeUnauthorized
+	case fiber.StatusForbidden:
+		return CodeForbidden
+	case fiber.StatusNotFound:
+		return CodeNotFound
+	}
+
+	// Fall back to the generic codes for anything that doesn't have a dedicated one
+	if status >= fiber.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
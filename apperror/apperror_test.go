@@ -0,0 +1,68 @@
+package apperror_test
+
+import (
+	"errors"
+	"fmt"
+	"notes-app/apperror"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIs(t *testing.T) {
+	cause := errors.New("duplicate key value violates unique constraint")
+
+	// Copies with causes or details attached should still match the predefined error
+	err := apperror.ErrUserExists.WithCause(cause).WithDetails(apperror.FieldError{Field: "email", Message: "is taken"})
+	assert.ErrorIs(t, err, apperror.ErrUserExists)
+	assert.ErrorIs(t, err, cause)
+	assert.NotErrorIs(t, err, apperror.ErrUserNotFound)
+
+	// Wrapping with fmt.Errorf should not hide the code either
+	assert.ErrorIs(t, fmt.Errorf("creating user: %w", err), apperror.ErrUserExists)
+
+	// The predefined error must not be modified by the copies
+	assert.Empty(t, apperror.ErrUserExists.Details)
+	assert.Nil(t, errors.Unwrap(apperror.ErrUserExists))
+}
+
+func TestFrom(t *testing.T) {
+	testCases := map[string]struct {
+		input  error
+		code   apperror.Code
+		status int
+	}{
+		"application error": {
+			input:  apperror.ErrInvalidCredentials,
+			code:   apperror.CodeInvalidCredentials,
+			status: fiber.StatusUnauthorized,
+		},
+		"fiber error": {
+			input:  fiber.ErrNotFound,
+			code:   apperror.CodeNotFound,
+			status: fiber.StatusNotFound,
+		},
+		"fiber error without dedicated code": {
+			input:  fiber.ErrMethodNotAllowed,
+			code:   apperror.CodeInvalidRequest,
+			status: fiber.StatusMethodNotAllowed,
+		},
+		"unknown error": {
+			input:  errors.New("connection refused"),
+			code:   apperror.CodeInternal,
+			status: fiber.StatusInternalServerError,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := apperror.From(tc.input)
+			assert.Equal(t, tc.code, err.Code)
+			assert.Equal(t, tc.status, err.Status())
+		})
+	}
+
+	// Internal errors must never leak their cause in the message
+	assert.Equal(t, "Internal server error", apperror.From(errors.New("connection refused")).Message)
+}
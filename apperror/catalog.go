@@ -0,0 +1,70 @@
+package apperror
+
+// Predefined errors that are returned by the services and APIs.
+//
+// Compare against these with errors.Is, which matches on the code, so that copies with details or causes attached
+// still match.
+var (
+	ErrInternal           = New(CodeInternal, "Internal server error")
+	ErrInvalidRequestBody = New(CodeInvalidRequest, "Invalid request body")
+	ErrInvalidQuery       = New(CodeInvalidRequest, "Invalid query parameters")
+	ErrValidationFailed   = New(CodeValidationFailed, "Validation failed")
+	ErrNotFound           = New(CodeNotFound, "Not found")
+	ErrUnauthorized       = New(CodeUnauthorized, "Missing or malformed authentication token")
+	ErrForbidden          = New(CodeForbidden, "Forbidden")
+
+	ErrUserExists           = New(CodeUserExists, "User already exists")
+	ErrUsernameUnavailable  = New(CodeUsernameUnavailable, "Username is not available")
+	ErrUserNotFound         = New(CodeUserNotFound, "User not found")
+	ErrInvalidCredentials   = New(CodeInvalidCredentials, "Incorrect email or password")
+	ErrTooManyLoginAttempts = New(CodeTooManyLoginAttempts, "Too many failed login attempts, try again later")
+	ErrTooManyEmailRequests = New(CodeTooManyEmailRequests, "Too many emails asked for, try again later")
+	ErrDirectoryUnavailable = New(CodeDirectoryUnavailable, "Unable to check the password, try again later")
+	ErrOIDCProviderNotFound = New(CodeOIDCProviderNotFound, "Identity provider not found")
+	ErrOIDCLoginFailed      = New(CodeOIDCLoginFailed, "Login with the identity provider failed")
+	ErrInvalidOTP           = New(CodeInvalidOTP, "Invalid two-factor authentication code")
+	ErrTOTPAlreadyEnabled   = New(CodeTOTPAlreadyEnabled, "Two-factor authentication is already enabled")
+	ErrTOTPNotEnabled       = New(CodeTOTPNotEnabled, "Two-factor authentication is not enabled")
+	ErrEmailNotVerified     = New(CodeEmailNotVerified, "Email address has not been verified")
+	ErrEmailAlreadyVerified = New(CodeEmailAlreadyVerified, "Email address is already verified")
+	ErrWeakPassword         = New(CodeWeakPassword, "Password does not satisfy the password policy")
+	ErrBreachedPassword     = New(CodeBreachedPassword, "Password is known to be compromised")
+	ErrIncorrectPassword    = New(CodeIncorrectPassword, "Current password is incorrect")
+	ErrManagedByDirectory   = New(CodeManagedByDirectory, "This is managed by your organization's directory")
+
+	ErrTokenExpired = New(CodeTokenExpired, "Token has expired")
+	ErrTokenInvalid = New(CodeTokenInvalid, "Invalid token")
+	ErrTokenReused  = New(CodeTokenReused, "Token has already been used, the session has been revoked")
+
+	ErrSessionRevoked  = New(CodeSessionRevoked, "Session has been revoked or has expired")
+	ErrSessionNotFound = New(CodeSessionNotFound, "Session not found")
+
+	ErrInsufficientScope   = New(CodeInsufficientScope, "Access token does not have the required scopes")
+	ErrAccessTokenNotFound = New(CodeAccessTokenNotFound, "Access token not found")
+
+	ErrDataExportNotFound = New(CodeDataExportNotFound, "Data export not found")
+	ErrDataExportNotReady = New(CodeDataExportNotReady, "Data export is not ready to download")
+
+	ErrOrganizationNotFound    = New(CodeOrganizationNotFound, "Organization not found")
+	ErrMemberNotFound          = New(CodeMemberNotFound, "Member not found")
+	ErrAlreadyMember           = New(CodeAlreadyMember, "User is already a member of the organization")
+	ErrInvitationNotFound      = New(CodeInvitationNotFound, "Invitation not found")
+	ErrInvitationEmailMismatch = New(CodeInvitationEmailMismatch, "This invitation was sent to another email address")
+	ErrInsufficientRole        = New(CodeInsufficientRole, "Your role in the organization doesn't allow this")
+	ErrLastOwner               = New(CodeLastOwner, "An organization must have at least one owner")
+
+	ErrGroupNotFound = New(CodeGroupNotFound, "Group not found")
+
+	ErrNoteNotFound      = New(CodeNoteNotFound, "Note not found")
+	ErrNoteShareNotFound = New(CodeNoteShareNotFound, "Note is not shared with the group")
+
+	ErrShareLinkNotFound    = New(CodeShareLinkNotFound, "Share link not found")
+	ErrShareLinkExpired     = New(CodeShareLinkExpired, "Share link has expired")
+	ErrSharePasswordInvalid = New(CodeSharePasswordInvalid, "Missing or incorrect password for the share link")
+	ErrTooManyShareAttempts = New(CodeTooManyShareAttempts, "Too many wrong passwords for the share link, try again later")
+
+	ErrAccessRequestNotFound = New(CodeAccessRequestNotFound, "Access request not found")
+	ErrAccessRequestDecided  = New(CodeAccessRequestDecided, "Access request has already been decided")
+
+	ErrCSRFTokenInvalid = New(CodeCSRFTokenInvalid, "Missing or invalid CSRF token")
+)
@@ -2,7 +2,11 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"net"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -22,13 +26,221 @@ type Config struct {
 
 	// Port is the port that the server will listen on, defaults to 3000
 	Port int `mapstructure:"PORT"`
+	// AppURL is the public URL of the app, used to build links in emails, defaults to http://localhost:3000
+	AppURL string `mapstructure:"APP_URL"`
+	// ProblemTypeBaseURI is the base URI for the type of RFC 7807 problem details, defaults to about:blank if unset
+	ProblemTypeBaseURI string `mapstructure:"PROBLEM_TYPE_BASE_URI"`
+	// ProxyHeader is the header that a reverse proxy in front of the app sets to the IP address of the client, e.g.
+	// X-Real-IP, defaults to unset so that the address of the connection is used. The client's address is what login
+	// and share link throttling is counted against, so without this every client behind a proxy shares one address.
+	// The proxy must overwrite the header rather than append to it, since the first address in it is used.
+	ProxyHeader string `mapstructure:"PROXY_HEADER"`
+	// TrustedProxies is the comma separated list of IP addresses and CIDR ranges of the reverse proxies whose
+	// ProxyHeader is trusted, e.g. 10.0.0.0/8. It must be set along with ProxyHeader, and the header is ignored in
+	// requests from anywhere else, since clients could set it to anything.
+	TrustedProxies []string `mapstructure:"TRUSTED_PROXIES"`
 
 	/*
 	   JWT configuration
 	*/
 
-	// JWTSecret is the secret key used to sign JWT tokens
+	// JWTSecret is the secret key used to sign JWT tokens with HS512, and to verify tokens without a kid header. It is
+	// added to the key ring with the key ID "default".
 	JWTSecret string `mapstructure:"JWT_SECRET"`
+	// JWTKeysDir is an optional directory of keys for signing and verifying JWT tokens, named by their key ID. RSA and
+	// Ed25519 keys are PEM encoded, and any other file is an HMAC secret.
+	JWTKeysDir string `mapstructure:"JWT_KEYS_DIR"`
+	// JWTActiveKeyID is the ID of the key used to sign new JWT tokens. The other keys are only used to verify tokens,
+	// so that retired keys keep working until their tokens expire. It may be unset if there is only one key.
+	JWTActiveKeyID string `mapstructure:"JWT_ACTIVE_KEY_ID"`
+	// AccessTokenTTL is how long access tokens are valid for, defaults to 15 minutes
+	AccessTokenTTL time.Duration `mapstructure:"ACCESS_TOKEN_TTL"`
+	// RefreshTokenTTL is how long refresh tokens are valid for, defaults to 30 days
+	RefreshTokenTTL time.Duration `mapstructure:"REFRESH_TOKEN_TTL"`
+	// SessionLastSeenInterval is how often the last seen time of a session is updated, defaults to 1 minute
+	SessionLastSeenInterval time.Duration `mapstructure:"SESSION_LAST_SEEN_INTERVAL"`
+	// AuthTokenSources is where the access token is looked for, in order of precedence, as a comma separated list of
+	// "header" (an Authorization: Bearer header) and "cookie" (the authorization cookie), defaults to header,cookie
+	AuthTokenSources []string `mapstructure:"AUTH_TOKEN_SOURCES"`
+
+	/*
+	   Cookie configuration
+	*/
+
+	// CookieDomain is the Domain attribute of the session cookies, defaults to unset so that they are only sent to the
+	// host that set them
+	CookieDomain string `mapstructure:"COOKIE_DOMAIN"`
+	// CookiePath is the Path attribute of the session cookies, defaults to /
+	CookiePath string `mapstructure:"COOKIE_PATH"`
+	// CookieSameSite is the SameSite attribute of the session cookies, either Strict, Lax or None, defaults to Lax
+	CookieSameSite string `mapstructure:"COOKIE_SAME_SITE"`
+	// CookieSecure is whether the session cookies are only sent over HTTPS, defaults to true. It can only be disabled
+	// for local development, and must be enabled when CookieSameSite is None.
+	CookieSecure bool `mapstructure:"COOKIE_SECURE"`
+
+	/*
+	   Login throttling configuration
+	*/
+
+	// LoginMaxFailuresPerAccount is the number of failed logins for an email before it is locked out, defaults to 5
+	LoginMaxFailuresPerAccount int `mapstructure:"LOGIN_MAX_FAILURES_PER_ACCOUNT"`
+	// LoginMaxFailuresPerIP is the number of failed logins from an IP address before it is locked out, defaults to 50.
+	// It is higher than the per-account limit since many users can share an IP address.
+	LoginMaxFailuresPerIP int `mapstructure:"LOGIN_MAX_FAILURES_PER_IP"`
+	// LoginFailureWindow is how long failed logins are remembered after the last one, defaults to 15 minutes
+	LoginFailureWindow time.Duration `mapstructure:"LOGIN_FAILURE_WINDOW"`
+	// LoginLockoutBase is how long the first lockout lasts, doubling with every further failure, defaults to 1 minute
+	LoginLockoutBase time.Duration `mapstructure:"LOGIN_LOCKOUT_BASE"`
+	// LoginLockoutMax is the longest that a lockout can last, defaults to 1 hour
+	LoginLockoutMax time.Duration `mapstructure:"LOGIN_LOCKOUT_MAX"`
+
+	/*
+	   Two-factor authentication configuration
+	*/
+
+	// TOTPIssuer is the name shown for the account in authenticator apps, defaults to Notes
+	TOTPIssuer string `mapstructure:"TOTP_ISSUER"`
+	// MFAChallengeTTL is how long users have to enter their two-factor authentication code after entering their
+	// password, defaults to 5 minutes
+	MFAChallengeTTL time.Duration `mapstructure:"MFA_CHALLENGE_TTL"`
+
+	/*
+	   LDAP configuration
+	*/
+
+	// AuthBackend is where passwords are checked, either local or ldap, defaults to local. With ldap, local accounts
+	// with the admin role can still log in with their local password, in case the directory is down.
+	AuthBackend string `mapstructure:"AUTH_BACKEND"`
+	// LDAPURL is the URL of the LDAP server, e.g. ldaps://ldap.example.com:636
+	LDAPURL string `mapstructure:"LDAP_URL"`
+	// LDAPStartTLS upgrades ldap:// connections with StartTLS, defaults to false
+	LDAPStartTLS bool `mapstructure:"LDAP_START_TLS"`
+	// LDAPBindDN and LDAPBindPassword are the credentials of the service account used to search for users, defaults
+	// to an anonymous bind
+	LDAPBindDN       string `mapstructure:"LDAP_BIND_DN"`
+	LDAPBindPassword string `mapstructure:"LDAP_BIND_PASSWORD"`
+	// LDAPBaseDN is where to search for users, e.g. ou=people,dc=example,dc=com
+	LDAPBaseDN string `mapstructure:"LDAP_BASE_DN"`
+	// LDAPUserFilter is the filter to find a user by email, with %s replaced by the escaped email, defaults to
+	// (&(objectClass=person)(mail=%s))
+	LDAPUserFilter string `mapstructure:"LDAP_USER_FILTER"`
+	// LDAPEmailAttribute is the attribute holding the email of a user, defaults to mail
+	LDAPEmailAttribute string `mapstructure:"LDAP_EMAIL_ATTRIBUTE"`
+	// LDAPNameAttribute is the attribute holding the name of a user, defaults to cn
+	LDAPNameAttribute string `mapstructure:"LDAP_NAME_ATTRIBUTE"`
+	// LDAPGroupAttribute is the attribute listing the DNs of the groups of a user, defaults to memberOf
+	LDAPGroupAttribute string `mapstructure:"LDAP_GROUP_ATTRIBUTE"`
+	// LDAPGroupRoles maps groups to roles, as role:groupDN pairs separated by semicolons, e.g.
+	// admin:cn=notes-admins,ou=groups,dc=example,dc=com. Users get the highest role of their groups, or user.
+	LDAPGroupRoles string `mapstructure:"LDAP_GROUP_ROLES"`
+	// LDAPTimeout is how long to wait for the LDAP server, defaults to 10 seconds
+	LDAPTimeout time.Duration `mapstructure:"LDAP_TIMEOUT"`
+
+	/*
+	   OpenID Connect configuration
+	*/
+
+	// OIDCProviderNames is the list of OpenID Connect identity providers that users can log in with, e.g. okta,google,
+	// defaults to none. Each provider is configured with the OIDC_<NAME>_* variables, see OIDCProvider.
+	OIDCProviderNames []string `mapstructure:"OIDC_PROVIDERS"`
+	// OIDCProviders is the configuration of each provider in OIDCProviderNames, by name
+	OIDCProviders map[string]OIDCProvider `mapstructure:"-"`
+	// OIDCLoginRedirectURL is where users are sent after logging in with an identity provider, defaults to APP_URL
+	OIDCLoginRedirectURL string `mapstructure:"OIDC_LOGIN_REDIRECT_URL"`
+
+	/*
+	   Password policy configuration
+	*/
+
+	// PasswordMinLength is the minimum number of characters in a password, defaults to 8
+	PasswordMinLength int `mapstructure:"PASSWORD_MIN_LENGTH"`
+	// PasswordMinScore is the minimum zxcvbn strength score (0-4) of a password, defaults to 2
+	PasswordMinScore int `mapstructure:"PASSWORD_MIN_SCORE"`
+	// PasswordBreachedHashesPath is an optional file or directory of breached password SHA-1 hashes, in addition to
+	// the bundled list
+	PasswordBreachedHashesPath string `mapstructure:"PASSWORD_BREACHED_HASHES_PATH"`
+
+	/*
+	   Password hashing configuration
+	*/
+
+	// PasswordHashAlgorithm is the algorithm used to hash new passwords, either argon2id or bcrypt, defaults to
+	// argon2id. Existing hashes using another algorithm are upgraded on the next successful login.
+	PasswordHashAlgorithm string `mapstructure:"PASSWORD_HASH_ALGORITHM"`
+	// BcryptCost is the cost used when hashing passwords with bcrypt, defaults to 10
+	BcryptCost int `mapstructure:"BCRYPT_COST"`
+	// Argon2Memory is the amount of memory in KiB used when hashing passwords with argon2id, defaults to 65536
+	Argon2Memory uint32 `mapstructure:"ARGON2_MEMORY"`
+	// Argon2Iterations is the number of passes over the memory when hashing passwords with argon2id, defaults to 3
+	Argon2Iterations uint32 `mapstructure:"ARGON2_ITERATIONS"`
+	// Argon2Parallelism is the number of threads used when hashing passwords with argon2id, defaults to 2
+	Argon2Parallelism uint8 `mapstructure:"ARGON2_PARALLELISM"`
+	// Argon2SaltLength is the length in bytes of the random salt for argon2id hashes, defaults to 16
+	Argon2SaltLength int `mapstructure:"ARGON2_SALT_LENGTH"`
+	// Argon2KeyLength is the length in bytes of argon2id hashes, defaults to 32
+	Argon2KeyLength uint32 `mapstructure:"ARGON2_KEY_LENGTH"`
+
+	/*
+	   Mail configuration
+	*/
+
+	// MailTransport is how emails are sent, either smtp, file or log, defaults to log
+	MailTransport string `mapstructure:"MAIL_TRANSPORT"`
+	// MailFrom is the address that emails are sent from, defaults to Notes <no-reply@localhost>
+	MailFrom string `mapstructure:"MAIL_FROM"`
+	// MailFileDir is the directory that emails are written to with the file transport, defaults to mail
+	MailFileDir string `mapstructure:"MAIL_FILE_DIR"`
+	// SMTPHost is the host of the SMTP server for the smtp transport
+	SMTPHost string `mapstructure:"SMTP_HOST"`
+	// SMTPPort is the port of the SMTP server for the smtp transport, defaults to 587
+	SMTPPort int `mapstructure:"SMTP_PORT"`
+	// SMTPUsername is the username for authenticating to the SMTP server, if it requires authentication
+	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
+	// SMTPPassword is the password for authenticating to the SMTP server
+	SMTPPassword string `mapstructure:"SMTP_PASSWORD"`
+	// MailWorkers is how many emails requested by anonymous users, like password resets, are sent at the same time in
+	// the background, defaults to 4
+	MailWorkers int `mapstructure:"MAIL_WORKERS"`
+	// MailQueueSize is how many of those emails can wait for a worker, defaults to 100. Requests past that are dropped
+	// and logged, rather than piling up in memory.
+	MailQueueSize int `mapstructure:"MAIL_QUEUE_SIZE"`
+
+	/*
+	   Email verification and password reset configuration
+	*/
+
+	// EmailVerificationTTL is how long email verification links are valid for, defaults to 48 hours
+	EmailVerificationTTL time.Duration `mapstructure:"EMAIL_VERIFICATION_TTL"`
+	// PasswordResetTTL is how long password reset links are valid for, defaults to 1 hour
+	PasswordResetTTL time.Duration `mapstructure:"PASSWORD_RESET_TTL"`
+	// MagicLinkTTL is how long passwordless login links are valid for, defaults to 15 minutes
+	MagicLinkTTL time.Duration `mapstructure:"MAGIC_LINK_TTL"`
+	// OrgInvitationTTL is how long invitations to join an organization are valid for, defaults to 7 days
+	OrgInvitationTTL time.Duration `mapstructure:"ORG_INVITATION_TTL"`
+
+	/*
+	   Data export and erasure configuration
+	*/
+
+	// DataExportTTL is how long users can download their data exports for, defaults to 7 days
+	DataExportTTL time.Duration `mapstructure:"DATA_EXPORT_TTL"`
+	// AccountErasureGracePeriod is how long deleted accounts are kept before their data is erased for good, so that
+	// accidental deletions can still be undone, defaults to 30 days
+	AccountErasureGracePeriod time.Duration `mapstructure:"ACCOUNT_ERASURE_GRACE_PERIOD"`
+	// AccountErasureInterval is how often deleted accounts past the grace period are erased, defaults to 1 hour
+	AccountErasureInterval time.Duration `mapstructure:"ACCOUNT_ERASURE_INTERVAL"`
+	// AccountDeletionNotes is what happens to the notes of an account when it is deleted, defaults to keep. With
+	// delete, they are deleted right away. With transfer, the notes of organizations are handed over to them right
+	// away. With keep, they are kept until the account is erased, so that they come back if the deletion is undone.
+	AccountDeletionNotes string `mapstructure:"ACCOUNT_DELETION_NOTES"`
+
+	/*
+	   Share link configuration
+	*/
+
+	// ShareSessionTTL is how long the anonymous sessions opened with share links last, defaults to 24 hours. They never
+	// outlive the link itself.
+	ShareSessionTTL time.Duration `mapstructure:"SHARE_SESSION_TTL"`
 
 	/*
 	   DB configuration
@@ -50,6 +262,61 @@ type Config struct {
 	DBSSLMode string `mapstructure:"DB_SSL_MODE"`
 }
 
+// OIDCProvider is the configuration of an OpenID Connect identity provider, read from the variables prefixed with
+// OIDC_<NAME>_, where <NAME> is the upper-cased name of the provider in OIDC_PROVIDERS.
+type OIDCProvider struct {
+	// Name is the name of the provider in OIDC_PROVIDERS, which is also used in the login URLs
+	Name string
+	// IssuerURL is the issuer of the provider, used to discover its endpoints and keys (OIDC_<NAME>_ISSUER_URL)
+	IssuerURL string
+	// ClientID is the client ID of the app registered with the provider (OIDC_<NAME>_CLIENT_ID)
+	ClientID string
+	// ClientSecret is the client secret of the app registered with the provider (OIDC_<NAME>_CLIENT_SECRET)
+	ClientSecret string
+	// RedirectURL is the callback URL registered with the provider (OIDC_<NAME>_REDIRECT_URL), defaults to
+	// APP_URL/api/v1/users/oidc/<name>/callback
+	RedirectURL string
+	// Scopes are the scopes to ask for in addition to openid (OIDC_<NAME>_SCOPES), defaults to email and profile
+	Scopes []string
+}
+
+// loadOIDCProviders reads the configuration of each provider in OIDCProviderNames.
+//
+// The variables of each provider depend on its name, so they can't be unmarshalled along with the rest of the config.
+func (c *Config) loadOIDCProviders() {
+	c.OIDCProviders = make(map[string]OIDCProvider)
+	for _, name := range c.OIDCProviderNames {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		viper.SetDefault(prefix+"REDIRECT_URL", strings.TrimSuffix(c.AppURL, "/")+"/api/v1/users/oidc/"+name+"/callback")
+		viper.SetDefault(prefix+"SCOPES", "email,profile")
+
+		var scopes []string
+		for _, scope := range strings.Split(viper.GetString(prefix+"SCOPES"), ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+
+		c.OIDCProviders[name] = OIDCProvider{
+			Name:         name,
+			IssuerURL:    viper.GetString(prefix + "ISSUER_URL"),
+			ClientID:     viper.GetString(prefix + "CLIENT_ID"),
+			ClientSecret: viper.GetString(prefix + "CLIENT_SECRET"),
+			RedirectURL:  viper.GetString(prefix + "REDIRECT_URL"),
+			Scopes:       scopes,
+		}
+	}
+
+	if c.OIDCLoginRedirectURL == "" {
+		c.OIDCLoginRedirectURL = c.AppURL
+	}
+}
+
 // validate checks if the required configuration fields are set and logs a fatal error if any are missing.
 func (c Config) validate() {
 	if c.DBName == "" {
@@ -71,6 +338,84 @@ func (c Config) validate() {
 	if c.DBPort == 0 {
 		panic("DB_PORT must be set")
 	}
+
+	if c.PasswordHashAlgorithm != "argon2id" && c.PasswordHashAlgorithm != "bcrypt" {
+		panic("PASSWORD_HASH_ALGORITHM must be argon2id or bcrypt")
+	}
+
+	if c.ProxyHeader != "" && len(c.TrustedProxies) == 0 {
+		panic("TRUSTED_PROXIES must be set when PROXY_HEADER is set")
+	}
+	for _, proxy := range c.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			panic("TRUSTED_PROXIES must only contain IP addresses and CIDR ranges")
+		}
+	}
+
+	if len(c.AuthTokenSources) == 0 {
+		panic("AUTH_TOKEN_SOURCES must be set")
+	}
+	for _, source := range c.AuthTokenSources {
+		if source = strings.TrimSpace(source); source != "header" && source != "cookie" {
+			panic("AUTH_TOKEN_SOURCES must only contain header and cookie")
+		}
+	}
+
+	if c.LoginMaxFailuresPerAccount < 1 || c.LoginMaxFailuresPerIP < 1 {
+		panic("LOGIN_MAX_FAILURES_PER_ACCOUNT and LOGIN_MAX_FAILURES_PER_IP must be at least 1")
+	}
+
+	if c.AccountErasureInterval <= 0 {
+		panic("ACCOUNT_ERASURE_INTERVAL must be positive")
+	}
+
+	switch c.AccountDeletionNotes {
+	case "delete", "transfer", "keep":
+	default:
+		panic("ACCOUNT_DELETION_NOTES must be delete, transfer or keep")
+	}
+
+	switch c.AuthBackend {
+	case "local":
+	case "ldap":
+		if c.LDAPURL == "" || c.LDAPBaseDN == "" {
+			panic("LDAP_URL and LDAP_BASE_DN must be set when AUTH_BACKEND is ldap")
+		}
+		if strings.Count(c.LDAPUserFilter, "%s") != 1 {
+			panic("LDAP_USER_FILTER must contain %s once")
+		}
+	default:
+		panic("AUTH_BACKEND must be local or ldap")
+	}
+
+	for name, provider := range c.OIDCProviders {
+		if provider.IssuerURL == "" || provider.ClientID == "" {
+			panic(fmt.Sprintf("OIDC_%[1]s_ISSUER_URL and OIDC_%[1]s_CLIENT_ID must be set", strings.ToUpper(name)))
+		}
+	}
+
+	switch c.MailTransport {
+	case "smtp":
+		if c.SMTPHost == "" {
+			panic("SMTP_HOST must be set when MAIL_TRANSPORT is smtp")
+		}
+	case "file", "log":
+	default:
+		panic("MAIL_TRANSPORT must be smtp, file or log")
+	}
+	if c.MailWorkers < 1 || c.MailQueueSize < 1 {
+		panic("MAIL_WORKERS and MAIL_QUEUE_SIZE must be at least 1")
+	}
+
+	switch strings.ToLower(c.CookieSameSite) {
+	case "strict", "lax":
+	case "none":
+		if !c.CookieSecure {
+			panic("COOKIE_SECURE must be true when COOKIE_SAME_SITE is None")
+		}
+	default:
+		panic("COOKIE_SAME_SITE must be Strict, Lax or None")
+	}
 }
 
 // Unexported variable to implement singleton pattern
@@ -87,6 +432,69 @@ func init() {
 	// Set default values for config vars
 	viper.SetDefault("LOG_LEVEL", "info")
 	viper.SetDefault("PORT", 3000)
+	viper.SetDefault("APP_URL", "http://localhost:3000")
+	viper.SetDefault("PROBLEM_TYPE_BASE_URI", "")
+	viper.SetDefault("PROXY_HEADER", "")
+	viper.SetDefault("TRUSTED_PROXIES", []string{})
+	viper.SetDefault("JWT_KEYS_DIR", "")
+	viper.SetDefault("JWT_ACTIVE_KEY_ID", "")
+	viper.SetDefault("ACCESS_TOKEN_TTL", 15*time.Minute)
+	viper.SetDefault("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	viper.SetDefault("SESSION_LAST_SEEN_INTERVAL", time.Minute)
+	viper.SetDefault("AUTH_TOKEN_SOURCES", []string{"header", "cookie"})
+	viper.SetDefault("COOKIE_DOMAIN", "")
+	viper.SetDefault("COOKIE_PATH", "/")
+	viper.SetDefault("COOKIE_SAME_SITE", "Lax")
+	viper.SetDefault("COOKIE_SECURE", true)
+	viper.SetDefault("LOGIN_MAX_FAILURES_PER_ACCOUNT", 5)
+	viper.SetDefault("LOGIN_MAX_FAILURES_PER_IP", 50)
+	viper.SetDefault("LOGIN_FAILURE_WINDOW", 15*time.Minute)
+	viper.SetDefault("LOGIN_LOCKOUT_BASE", time.Minute)
+	viper.SetDefault("LOGIN_LOCKOUT_MAX", time.Hour)
+	viper.SetDefault("TOTP_ISSUER", "Notes")
+	viper.SetDefault("MFA_CHALLENGE_TTL", 5*time.Minute)
+	viper.SetDefault("AUTH_BACKEND", "local")
+	viper.SetDefault("LDAP_URL", "")
+	viper.SetDefault("LDAP_START_TLS", false)
+	viper.SetDefault("LDAP_BIND_DN", "")
+	viper.SetDefault("LDAP_BIND_PASSWORD", "")
+	viper.SetDefault("LDAP_BASE_DN", "")
+	viper.SetDefault("LDAP_USER_FILTER", "(&(objectClass=person)(mail=%s))")
+	viper.SetDefault("LDAP_EMAIL_ATTRIBUTE", "mail")
+	viper.SetDefault("LDAP_NAME_ATTRIBUTE", "cn")
+	viper.SetDefault("LDAP_GROUP_ATTRIBUTE", "memberOf")
+	viper.SetDefault("LDAP_GROUP_ROLES", "")
+	viper.SetDefault("LDAP_TIMEOUT", 10*time.Second)
+	viper.SetDefault("OIDC_PROVIDERS", []string{})
+	viper.SetDefault("OIDC_LOGIN_REDIRECT_URL", "")
+	viper.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	viper.SetDefault("PASSWORD_MIN_SCORE", 2)
+	viper.SetDefault("PASSWORD_BREACHED_HASHES_PATH", "")
+	viper.SetDefault("PASSWORD_HASH_ALGORITHM", "argon2id")
+	viper.SetDefault("BCRYPT_COST", 10)
+	viper.SetDefault("ARGON2_MEMORY", 64*1024)
+	viper.SetDefault("ARGON2_ITERATIONS", 3)
+	viper.SetDefault("ARGON2_PARALLELISM", 2)
+	viper.SetDefault("ARGON2_SALT_LENGTH", 16)
+	viper.SetDefault("ARGON2_KEY_LENGTH", 32)
+	viper.SetDefault("MAIL_TRANSPORT", "log")
+	viper.SetDefault("MAIL_FROM", "Notes <no-reply@localhost>")
+	viper.SetDefault("MAIL_FILE_DIR", "mail")
+	viper.SetDefault("SMTP_HOST", "")
+	viper.SetDefault("SMTP_PORT", 587)
+	viper.SetDefault("SMTP_USERNAME", "")
+	viper.SetDefault("SMTP_PASSWORD", "")
+	viper.SetDefault("MAIL_WORKERS", 4)
+	viper.SetDefault("MAIL_QUEUE_SIZE", 100)
+	viper.SetDefault("EMAIL_VERIFICATION_TTL", 48*time.Hour)
+	viper.SetDefault("PASSWORD_RESET_TTL", time.Hour)
+	viper.SetDefault("MAGIC_LINK_TTL", 15*time.Minute)
+	viper.SetDefault("ORG_INVITATION_TTL", 7*24*time.Hour)
+	viper.SetDefault("DATA_EXPORT_TTL", 7*24*time.Hour)
+	viper.SetDefault("ACCOUNT_ERASURE_GRACE_PERIOD", 30*24*time.Hour)
+	viper.SetDefault("ACCOUNT_ERASURE_INTERVAL", time.Hour)
+	viper.SetDefault("ACCOUNT_DELETION_NOTES", "keep")
+	viper.SetDefault("SHARE_SESSION_TTL", 24*time.Hour)
 	viper.SetDefault("DB_SSL_MODE", "disable")
 
 	// Automatically override values in config file with those in environment
@@ -109,6 +517,8 @@ func init() {
 		log.Fatalln(err)
 	}
 
+	config.loadOIDCProviders()
+
 	// Validate that all config vars are set
 	config.validate()
 }
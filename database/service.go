@@ -27,7 +27,30 @@ func (svc *Service) Connect(host string, port int, user string, password string,
 	}
 	slog.Debug("Connected to DB")
 
-	err = svc.db.AutoMigrate(&models.User{})
+	err = svc.db.AutoMigrate(
+		&models.User{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.PersonalAccessToken{},
+		&models.OneTimeToken{},
+		&models.TOTPCredential{},
+		&models.RecoveryCode{},
+		&models.LoginThrottle{},
+		&models.OIDCIdentity{},
+		&models.DataExport{},
+		&models.Organization{},
+		&models.OrganizationMember{},
+		&models.OrganizationInvitation{},
+		&models.UserGroup{},
+		&models.UserGroupMember{},
+		&models.Note{},
+		&models.ShareLink{},
+		&models.ShareSession{},
+		&models.NoteShare{},
+		&models.NoteGroupShare{},
+		&models.AccessRequest{},
+		&models.AccessRequestEvent{},
+	)
 	if err != nil {
 		panic(err)
 	}
@@ -50,5 +73,26 @@ func (svc *Service) GetDB() *gorm.DB {
 func (svc *Service) ClearAllTables() {
 	dbSession := svc.db.Unscoped().Session(&gorm.Session{AllowGlobalUpdate: true})
 
+	dbSession.Delete(&models.AccessRequestEvent{})
+	dbSession.Delete(&models.AccessRequest{})
+	dbSession.Delete(&models.NoteGroupShare{})
+	dbSession.Delete(&models.NoteShare{})
+	dbSession.Delete(&models.ShareSession{})
+	dbSession.Delete(&models.ShareLink{})
+	dbSession.Delete(&models.Note{})
+	dbSession.Delete(&models.UserGroupMember{})
+	dbSession.Delete(&models.UserGroup{})
+	dbSession.Delete(&models.OrganizationInvitation{})
+	dbSession.Delete(&models.OrganizationMember{})
+	dbSession.Delete(&models.Organization{})
+	dbSession.Delete(&models.DataExport{})
+	dbSession.Delete(&models.OIDCIdentity{})
+	dbSession.Delete(&models.LoginThrottle{})
+	dbSession.Delete(&models.RecoveryCode{})
+	dbSession.Delete(&models.TOTPCredential{})
+	dbSession.Delete(&models.OneTimeToken{})
+	dbSession.Delete(&models.PersonalAccessToken{})
+	dbSession.Delete(&models.RefreshToken{})
+	dbSession.Delete(&models.Session{})
 	dbSession.Delete(&models.User{})
 }
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// unsafeFileNameChars matches the characters that are replaced in file names.
+var unsafeFileNameChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]+`)
+
+// FileMailer writes emails as .eml files in a directory instead of sending them, for development and testing.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// Send writes the message to a new file in the directory, named after the time and the recipient.
+func (m FileMailer) Send(msg Message) error {
+	data, err := format(m.From, msg)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), unsafeFileNameChars.ReplaceAllString(msg.To, "_"))
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		return err
+	}
+
+	slog.Debug("Wrote mail to file", slog.String("path", path))
+	return nil
+}
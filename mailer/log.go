@@ -0,0 +1,19 @@
+package mailer
+
+import "log/slog"
+
+// LogMailer writes emails to the log instead of sending them, for development.
+type LogMailer struct {
+	From string
+}
+
+// Send logs the message.
+func (m LogMailer) Send(msg Message) error {
+	// Format the message anyway, so that invalid messages fail the same way as with the other mailers
+	if _, err := format(m.From, msg); err != nil {
+		return err
+	}
+
+	slog.Info("Mail", slog.String("to", msg.To), slog.String("subject", msg.Subject), slog.String("body", msg.Body))
+	return nil
+}
@@ -0,0 +1,92 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"notes-app/config"
+	"strings"
+	"time"
+)
+
+const (
+	// TransportSMTP sends emails through an SMTP server.
+	TransportSMTP = "smtp"
+	// TransportFile writes emails to files in a directory, for development and testing.
+	TransportFile = "file"
+	// TransportLog writes emails to the log, for development.
+	TransportLog = "log"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails.
+type Mailer interface {
+	// Send sends the message, returning an error if it could not be handed off to the transport.
+	Send(msg Message) error
+}
+
+// New creates a mailer for the transport in the config.
+func New(cfg *config.Config) (Mailer, error) {
+	switch cfg.MailTransport {
+	case TransportSMTP:
+		return SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		}, nil
+	case TransportFile:
+		return FileMailer{Dir: cfg.MailFileDir, From: cfg.MailFrom}, nil
+	case TransportLog:
+		return LogMailer{From: cfg.MailFrom}, nil
+	}
+
+	return nil, fmt.Errorf("unknown mail transport %q", cfg.MailTransport)
+}
+
+// format renders the message in the Internet Message Format (RFC 5322), ready to be sent or saved.
+//
+// The addresses are parsed so that they can't be used to inject headers, and the subject and body are encoded so that
+// any characters are allowed.
+func format(from string, msg Message) ([]byte, error) {
+	fromAddress, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address: %w", err)
+	}
+	toAddress, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return nil, fmt.Errorf("invalid to address: %w", err)
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, fmt.Errorf("subject must be a single line")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", fromAddress.String())
+	fmt.Fprintf(&buf, "To: %s\r\n", toAddress.String())
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+	buf.WriteString("\r\n")
+
+	writer := quotedprintable.NewWriter(&buf)
+	if _, err := writer.Write([]byte(strings.ReplaceAll(msg.Body, "\n", "\r\n"))); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
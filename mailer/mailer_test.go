@@ -0,0 +1,146 @@
+package mailer_test
+
+import (
+	"bufio"
+	"net"
+	"net/mail"
+	"notes-app/mailer"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// smtpMessage is a message received by the fake SMTP server.
+type smtpMessage struct {
+	from string
+	to   []string
+	data string
+}
+
+// startSMTPServer starts a minimal SMTP server on a random local port, which accepts a single message and sends it to
+// the returned channel.
+func startSMTPServer(t *testing.T) (string, int, <-chan smtpMessage) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan smtpMessage, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		var msg smtpMessage
+		reply("220 localhost ESMTP")
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+			switch {
+			case command == "EHLO" || command == "HELO":
+				reply("250 localhost")
+			case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+				msg.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+				reply("250 OK")
+			case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+				msg.to = append(msg.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+				reply("250 OK")
+			case command == "DATA":
+				reply("354 End data with <CR><LF>.<CR><LF>")
+				var data strings.Builder
+				for {
+					dataLine, err := reader.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if dataLine == ".\r\n" {
+						break
+					}
+					data.WriteString(dataLine)
+				}
+				msg.data = data.String()
+				reply("250 OK")
+				messages <- msg
+			case command == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	portNumber, err := strconv.Atoi(port)
+	require.NoError(t, err)
+
+	return host, portNumber, messages
+}
+
+func TestSMTPMailer(t *testing.T) {
+	host, port, messages := startSMTPServer(t)
+
+	m := mailer.SMTPMailer{Host: host, Port: port, From: "Notes <no-reply@notes.test>"}
+	err := m.Send(mailer.Message{
+		To:      "me@ksdfg.dev",
+		Subject: "Vérifiez votre email",
+		Body:    "Open this link:\nhttps://notes.test/verify-email?token=abc",
+	})
+	require.NoError(t, err)
+
+	msg := <-messages
+	assert.Equal(t, "no-reply@notes.test", msg.from)
+	assert.Equal(t, []string{"me@ksdfg.dev"}, msg.to)
+
+	// Parse the message to check that it is well formed
+	parsed, err := mail.ReadMessage(strings.NewReader(msg.data))
+	require.NoError(t, err)
+	assert.Equal(t, `"Notes" <no-reply@notes.test>`, parsed.Header.Get("From"))
+	assert.Equal(t, "<me@ksdfg.dev>", parsed.Header.Get("To"))
+
+	subject, err := new(mail.AddressParser).WordDecoder.DecodeHeader(parsed.Header.Get("Subject"))
+	require.NoError(t, err)
+	assert.Equal(t, "Vérifiez votre email", subject)
+	assert.Contains(t, msg.data, "https://notes.test/verify-email?token=3Dabc")
+}
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+
+	m := mailer.FileMailer{Dir: dir, From: "no-reply@notes.test"}
+	require.NoError(t, m.Send(mailer.Message{To: "me@ksdfg.dev", Subject: "Hello", Body: "Hello, World!"}))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.True(t, strings.HasSuffix(entries[0].Name(), "-me@ksdfg.dev.eml"))
+
+	data, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "Subject: Hello\r\n")
+	assert.Contains(t, string(data), "Hello, World!")
+}
+
+func TestHeaderInjection(t *testing.T) {
+	m := mailer.LogMailer{From: "no-reply@notes.test"}
+
+	// Addresses and subjects can't be used to add headers
+	assert.Error(t, m.Send(mailer.Message{To: "me@ksdfg.dev\r\nBcc: victim@example.com", Subject: "Hello"}))
+	assert.Error(t, m.Send(mailer.Message{To: "me@ksdfg.dev", Subject: "Hello\r\nBcc: victim@example.com"}))
+	assert.NoError(t, m.Send(mailer.Message{To: "me@ksdfg.dev", Subject: "Hello"}))
+}
@@ -7,6 +7,7 @@ import (
 	"notes-app/api"
 	"notes-app/config"
 	"notes-app/database"
+	"notes-app/mailer"
 	"notes-app/service"
 	"notes-app/utils"
 )
@@ -36,12 +37,98 @@ func main() {
 	dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBSSLMode)
 	dbService.GetDB()
 
+	// Load the breached passwords up front, since accepting them silently would be worse than not starting
+	breachedPasswords, err := service.LoadBreachedPasswords(cfg.PasswordBreachedHashesPath)
+	if err != nil {
+		log.Fatalln(fmt.Errorf("failed to load breached passwords: %w", err))
+	}
+
+	// Load the keys for signing tokens up front too, so that missing keys are noticed before anyone tries to log in
+	keyRing, err := service.LoadKeyRing(cfg.JWTKeysDir, cfg.JWTActiveKeyID, cfg.JWTSecret)
+	if err != nil {
+		log.Fatalln(fmt.Errorf("failed to load JWT keys: %w", err))
+	}
+
+	// Set up the directory up front as well, so that a broken LDAP config is noticed before anyone tries to log in
+	var directory service.Directory
+	if cfg.AuthBackend == "ldap" {
+		ldapDirectory, err := service.NewLDAPDirectory(cfg)
+		if err != nil {
+			log.Fatalln(fmt.Errorf("failed to set up the LDAP directory: %w", err))
+		}
+		directory = ldapDirectory
+	}
+
+	// Create the mailer once, and share it between all services that send emails
+	emailSender, err := mailer.New(cfg)
+	if err != nil {
+		log.Fatalln(fmt.Errorf("failed to set up the mailer: %w", err))
+	}
+
 	// Initialize services
-	authService := service.AuthService{}
-	userService := service.UserService{Service: service.Service{DBService: dbService}, AuthService: authService}
+	authService := service.AuthService{BreachedPasswords: breachedPasswords, KeyRing: keyRing, Directory: directory}
+	sessionService := service.SessionService{Service: service.Service{DBService: dbService}, AuthService: authService}
+	authService.SessionService = sessionService
+	accessTokenService := service.AccessTokenService{Service: service.Service{DBService: dbService}}
+	authService.AccessTokenService = accessTokenService
+	totpService := service.TOTPService{Service: service.Service{DBService: dbService}}
+	loginThrottleService := service.LoginThrottleService{Service: service.Service{DBService: dbService}}
+	userService := service.UserService{
+		Service:        service.Service{DBService: dbService},
+		AuthService:    authService,
+		Mailer:         emailSender,
+		SessionService: sessionService,
+	}
+	oidcService := service.OIDCService{Service: service.Service{DBService: dbService}, UserService: userService}
+	organizationService := service.OrganizationService{Service: service.Service{DBService: dbService}, Mailer: emailSender}
+	privacyService := service.PrivacyService{
+		Service:             service.Service{DBService: dbService},
+		Mailer:              emailSender,
+		OrganizationService: organizationService,
+	}
+	groupService := service.GroupService{
+		Service:             service.Service{DBService: dbService},
+		OrganizationService: organizationService,
+	}
+	shareLinkService := service.ShareLinkService{
+		Service:             service.Service{DBService: dbService},
+		AuthService:         authService,
+		OrganizationService: organizationService,
+	}
+	accessRequestService := service.AccessRequestService{
+		Service:             service.Service{DBService: dbService},
+		Mailer:              emailSender,
+		OrganizationService: organizationService,
+	}
+	noteService := service.NoteService{
+		Service:             service.Service{DBService: dbService},
+		GroupService:        groupService,
+		OrganizationService: organizationService,
+	}
+
+	// Send the emails asked for by anonymous users on a bounded number of workers
+	mailQueue := utils.NewWorkerPool(cfg.MailWorkers, cfg.MailQueueSize)
+
+	// Erase deleted accounts in the background once their grace period is over
+	go privacyService.RunErasureJob(cfg.AccountErasureInterval)
 
 	// Generate the app
-	app := api.GenApp(api.Services{UserService: userService, AuthService: authService})
+	app := api.GenApp(api.Services{
+		UserService:          userService,
+		AuthService:          authService,
+		SessionService:       sessionService,
+		AccessTokenService:   accessTokenService,
+		TOTPService:          totpService,
+		LoginThrottleService: loginThrottleService,
+		OIDCService:          oidcService,
+		PrivacyService:       privacyService,
+		OrganizationService:  organizationService,
+		GroupService:         groupService,
+		ShareLinkService:     shareLinkService,
+		AccessRequestService: accessRequestService,
+		NoteService:          noteService,
+		MailQueue:            mailQueue,
+	})
 
 	// Start the server
 	log.Fatalln(app.Listen(fmt.Sprintf(":%d", cfg.Port)))
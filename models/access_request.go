@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// Statuses of access requests.
+const (
+	// AccessRequestPending is the status of access requests that the owner hasn't decided on yet.
+	AccessRequestPending = "pending"
+	// AccessRequestApproved is the status of access requests that the owner approved.
+	AccessRequestApproved = "approved"
+	// AccessRequestDenied is the status of access requests that the owner denied.
+	AccessRequestDenied = "denied"
+)
+
+// Actions recorded in the audit trail of access requests.
+const (
+	// AccessRequestEventRequested is recorded when a user asks for access, or changes a pending request.
+	AccessRequestEventRequested = "requested"
+	// AccessRequestEventApproved is recorded when the owner approves a request and grants access.
+	AccessRequestEventApproved = "approved"
+	// AccessRequestEventDenied is recorded when the owner denies a request.
+	AccessRequestEventDenied = "denied"
+)
+
+// AccessRequest is a request from a user for access to a note that they can't see, which the owner of the note
+// approves or denies.
+//
+// Requests are kept once they are decided, along with who decided and when, so that there is a record of who was given
+// access and why.
+type AccessRequest struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"-"`
+	NoteID      uint      `gorm:"not null;index" json:"note_id"`
+	RequesterID uint      `gorm:"not null;index" json:"requester_id"`
+	// OwnerID is the ID of the owner of the note, who decides on the request.
+	OwnerID uint `gorm:"not null;index" json:"-"`
+	// Access is the access that the requester asked for, read or edit.
+	Access  string `gorm:"not null" json:"access"`
+	Message string `gorm:"not null" json:"message"`
+	// Status is one of AccessRequestPending, AccessRequestApproved or AccessRequestDenied.
+	Status string `gorm:"not null;index" json:"status"`
+	// GrantedAccess is the access that the owner granted when approving, which may differ from what was asked for.
+	GrantedAccess string     `json:"granted_access"`
+	DecidedAt     *time.Time `json:"decided_at"`
+	DecidedByID   *uint      `json:"-"`
+}
+
+// AccessRequestEvent is an entry in the audit trail of an access request, recording who did what and when.
+//
+// Events are only ever added, so the trail shows every change to the request, including pending requests that were
+// changed before the owner decided on them.
+type AccessRequestEvent struct {
+	ID              uint      `gorm:"primarykey" json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	AccessRequestID uint      `gorm:"not null;index" json:"access_request_id"`
+	NoteID          uint      `gorm:"not null;index" json:"note_id"`
+	ActorID         uint      `gorm:"not null" json:"actor_id"`
+	// Action is one of AccessRequestEventRequested, AccessRequestEventApproved or AccessRequestEventDenied.
+	Action string `gorm:"not null" json:"action"`
+	// Access is the access that was asked for or granted, or empty when denying.
+	Access string `gorm:"not null" json:"access"`
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scopes is a list of scopes that an access token is allowed to use, stored as a space separated string.
+type Scopes []string
+
+// Has checks whether all the given scopes are in the list.
+func (scopes Scopes) Has(required ...string) bool {
+	for _, scope := range required {
+		if !slices.Contains(scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// Value implements driver.Valuer, to store the scopes as a space separated string.
+func (scopes Scopes) Value() (driver.Value, error) {
+	return strings.Join(scopes, " "), nil
+}
+
+// Scan implements sql.Scanner, to read the scopes from a space separated string.
+func (scopes *Scopes) Scan(value any) error {
+	switch v := value.(type) {
+	case string:
+		*scopes = strings.Fields(v)
+	case []byte:
+		*scopes = strings.Fields(string(v))
+	case nil:
+		*scopes = nil
+	default:
+		return fmt.Errorf("can not scan %T into Scopes", value)
+	}
+	return nil
+}
+
+// PersonalAccessToken is a long-lived token that a user creates for scripts and other automation, so that they don't
+// need the user's password.
+//
+// Only the SHA-256 hash of the token is stored, so the token itself is only shown once when it is created. The prefix
+// is kept to help users recognise their tokens.
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"-"`
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"not null" json:"prefix"`
+	TokenHash  string     `gorm:"uniqueIndex;not null" json:"-"`
+	Scopes     Scopes     `gorm:"type:text;not null" json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// Statuses of data exports.
+const (
+	DataExportPending = "pending"
+	DataExportReady   = "ready"
+	DataExportFailed  = "failed"
+)
+
+// DataExport is a copy of all the data kept about a user, packaged as a zip archive for them to download.
+//
+// Exports are built in the background, since they can take a while, and are deleted once they expire.
+type DataExport struct {
+	ID        string    `gorm:"primaryKey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"-"`
+	UserID    uint      `gorm:"not null;index" json:"-"`
+	// Status is either DataExportPending, DataExportReady or DataExportFailed.
+	Status string `gorm:"not null" json:"status"`
+	// Archive is the zip archive, only set once the export is ready.
+	Archive     []byte     `json:"-"`
+	CompletedAt *time.Time `json:"completed_at"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
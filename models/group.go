@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// UserGroup is a named group of users that notes can be shared with all at once.
+//
+// Groups either belong to a user, who manages them on their own, or to an organization, whose admins manage them and
+// whose members are the only ones that can be in them.
+type UserGroup struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"-"`
+	Name      string    `gorm:"not null" json:"name"`
+	// OwnerID is the user that the group belongs to, or nil if it belongs to an organization.
+	OwnerID *uint `gorm:"index" json:"-"`
+	// OrganizationID is the organization that the group belongs to, or nil if it belongs to a user.
+	OrganizationID *uint `gorm:"index" json:"organization_id"`
+}
+
+// UserGroupMember is the membership of a user in a group.
+type UserGroupMember struct {
+	GroupID   uint `gorm:"primaryKey;autoIncrement:false"`
+	UserID    uint `gorm:"primaryKey;autoIncrement:false;index"`
+	CreatedAt time.Time
+}
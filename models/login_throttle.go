@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// LoginThrottle tracks the recent failed logins for an account or an IP address, to slow down password guessing.
+type LoginThrottle struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	// Key identifies what is being throttled, e.g. "account:<email>" or "ip:<address>". Accounts are tracked by email
+	// rather than by user, so that unknown emails are throttled the same way as registered ones.
+	Key string `gorm:"uniqueIndex;not null"`
+
+	// Failures is the number of failed logins since the counter was last reset.
+	Failures      int `gorm:"not null;default:0"`
+	LastFailureAt time.Time
+	LockedUntil   *time.Time
+}
@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Note is a note written by a user, which they can share with others.
+type Note struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// OwnerID is the ID of the user who owns the note. Notes of an organization are kept for it without an owner once
+	// their owner's account is erased, and are then managed by its admins.
+	OwnerID *uint `gorm:"index" json:"owner_id"`
+	// OrganizationID is the ID of the organization that the note belongs to, if any. Only its members can see the
+	// note, even if it is shared with others.
+	OrganizationID *uint  `gorm:"index" json:"organization_id"`
+	Title          string `gorm:"not null" json:"title"`
+	Content        string `gorm:"not null" json:"content"`
+}
+
+// NoteShare grants a user access to a note that they don't own, e.g. when the owner approves their access request.
+type NoteShare struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	NoteID    uint      `gorm:"not null;uniqueIndex:idx_note_share_user" json:"note_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_note_share_user;index" json:"user_id"`
+	// Access is the access that the user has to the note, read or edit.
+	Access string `gorm:"not null" json:"access"`
+}
+
+// NoteGroupShare grants the members of a group access to a note. Members only have the access while they are in the
+// group, since it is looked up through the group whenever their access is checked.
+type NoteGroupShare struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	NoteID    uint      `gorm:"not null;uniqueIndex:idx_note_group_share_group" json:"note_id"`
+	GroupID   uint      `gorm:"not null;uniqueIndex:idx_note_group_share_group;index" json:"group_id"`
+	// Access is the access that the members of the group have to the note, read or edit.
+	Access string `gorm:"not null" json:"access"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// OIDCIdentity links a user to their account with an OpenID Connect identity provider, so that they can log in with it.
+type OIDCIdentity struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint `gorm:"not null;index"`
+
+	// Provider is the name of the provider in the config, and Subject is the ID of the user with the provider, which
+	// unlike the email never changes.
+	Provider string `gorm:"not null;uniqueIndex:idx_oidc_identities_provider_subject"`
+	Subject  string `gorm:"not null;uniqueIndex:idx_oidc_identities_provider_subject"`
+
+	// Email is the email that the provider had for the user when they last logged in, for reference only.
+	Email       string
+	LastLoginAt time.Time
+}
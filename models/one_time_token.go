@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// OneTimeToken is a single-use token sent to a user's email, e.g. to verify the email address.
+//
+// Only the SHA-256 hash of the token is stored. The token is only valid for the purpose and email it was issued for, so
+// that it can't be used for anything else, or after the user changes their email.
+type OneTimeToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint      `gorm:"not null;index"`
+	Purpose   string    `gorm:"not null"`
+	Email     string    `gorm:"not null"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
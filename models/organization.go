@@ -0,0 +1,53 @@
+package models
+
+import "time"
+
+// Roles of organization members, from the most to the least privileged.
+const (
+	// OrgRoleOwner can do everything, including deleting the organization and managing other owners.
+	OrgRoleOwner = "owner"
+	// OrgRoleAdmin can manage the organization and its members, except for owners.
+	OrgRoleAdmin = "admin"
+	// OrgRoleMember can see the organization's members and work with its notes.
+	OrgRoleMember = "member"
+	// OrgRoleGuest can only see what is shared with them in the organization.
+	OrgRoleGuest = "guest"
+)
+
+// OrgRoles is the list of all roles of organization members, from the most to the least privileged.
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleMember, OrgRoleGuest}
+
+// Organization is a workspace that owns notes and notebooks, shared by its members.
+type Organization struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"-"`
+	Name      string    `gorm:"not null" json:"name"`
+}
+
+// OrganizationMember is the membership of a user in an organization.
+type OrganizationMember struct {
+	ID             uint      `gorm:"primarykey" json:"-"`
+	CreatedAt      time.Time `json:"joined_at"`
+	UpdatedAt      time.Time `json:"-"`
+	OrganizationID uint      `gorm:"not null;uniqueIndex:idx_organization_members_user" json:"organization_id"`
+	UserID         uint      `gorm:"not null;uniqueIndex:idx_organization_members_user;index" json:"user_id"`
+	// Role is one of OrgRoles.
+	Role string `gorm:"not null" json:"role"`
+}
+
+// OrganizationInvitation invites whoever owns an email to join an organization, whether or not they are registered.
+//
+// Only the SHA-256 hash of the token is stored, same as for one-time tokens. Inviting the same email again replaces the
+// invitation, and it is deleted once it is accepted.
+type OrganizationInvitation struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"-"`
+	OrganizationID uint      `gorm:"not null;uniqueIndex:idx_organization_invitations_email" json:"organization_id"`
+	Email          string    `gorm:"not null;uniqueIndex:idx_organization_invitations_email" json:"email"`
+	// Role is the role that the invited user gets when they accept, one of OrgRoles.
+	Role      string    `gorm:"not null" json:"role"`
+	TokenHash string    `gorm:"uniqueIndex;not null" json:"-"`
+	ExpiresAt time.Time `gorm:"not null" json:"expires_at"`
+}
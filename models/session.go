@@ -0,0 +1,31 @@
+package models
+
+import "time"
+
+// Session is a login of a user, identified by the JWT ID (jti) of the access tokens issued for it.
+//
+// A session lives as long as its refresh tokens keep getting rotated, and stops working as soon as it is revoked.
+type Session struct {
+	ID         string     `gorm:"primaryKey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"-"`
+	UserID     uint       `gorm:"not null;index" json:"-"`
+	LastSeenAt time.Time  `gorm:"not null" json:"last_seen_at"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+// RefreshToken is a single-use token for getting a new access token for a session.
+//
+// Only the SHA-256 hash of the token is stored. Each use rotates it, and reusing an already used token revokes the
+// whole session, since that means it was stolen.
+type RefreshToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	SessionID string    `gorm:"not null;index"`
+	TokenHash string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+}
@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// ShareLink is a secret link that grants access to a note to anyone who has it, whether or not they are registered.
+//
+// Only the SHA-256 hash of the token is stored, so the link itself is only shown once when it is created. Links can be
+// protected with a password, limited to a number of uses and set to expire, and stop working as soon as they are
+// revoked.
+type ShareLink struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"-"`
+	UserID    uint      `gorm:"not null;index" json:"-"`
+	NoteID    uint      `gorm:"not null;index" json:"note_id"`
+	// Access is the access that the link grants to the note, read or edit.
+	Access            string     `gorm:"not null" json:"access"`
+	TokenHash         string     `gorm:"uniqueIndex;not null" json:"-"`
+	PasswordHash      string     `json:"-"`
+	PasswordProtected bool       `gorm:"not null" json:"password_protected"`
+	ExpiresAt         *time.Time `json:"expires_at"`
+	// MaxUses is how many times the link can be opened, or nil for no limit.
+	MaxUses   *int       `json:"max_uses"`
+	Uses      int        `gorm:"not null;default:0" json:"uses"`
+	RevokedAt *time.Time `json:"-"`
+}
+
+// ShareSession is an anonymous session opened with a share link, which only gives access to the note of the link.
+//
+// Only the SHA-256 hash of the token is stored. The session ends when it expires, or as soon as its link is revoked or
+// expires.
+type ShareSession struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	ShareLinkID uint      `gorm:"not null;index"`
+	TokenHash   string    `gorm:"uniqueIndex;not null"`
+	ExpiresAt   time.Time `gorm:"not null"`
+}
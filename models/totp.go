@@ -0,0 +1,30 @@
+package models
+
+import "time"
+
+// TOTPCredential is the TOTP (authenticator app) secret of a user with two-factor authentication.
+//
+// The secret is stored as is, since it is needed to check codes. Two-factor authentication is only enabled once the
+// user confirms that their app works by entering a first code.
+type TOTPCredential struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserID      uint   `gorm:"uniqueIndex;not null"`
+	Secret      string `gorm:"not null"`
+	ConfirmedAt *time.Time
+
+	// LastUsedStep is the time step of the last accepted code, so that a code can't be used twice.
+	LastUsedStep int64 `gorm:"not null;default:0"`
+}
+
+// RecoveryCode is a single-use code that can be used instead of a TOTP code, e.g. if the user loses their phone.
+//
+// Only the SHA-256 hash of the code is stored.
+type RecoveryCode struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"not null;index"`
+	CodeHash  string `gorm:"uniqueIndex;not null"`
+	UsedAt    *time.Time
+}
@@ -2,9 +2,42 @@ package models
 
 import "gorm.io/gorm"
 
+// Roles of users.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// Sources of the credentials of users.
+const (
+	// AuthSourceLocal is for users whose password hash is stored locally.
+	AuthSourceLocal = "local"
+	// AuthSourceLDAP is for users whose password is checked against the LDAP directory.
+	AuthSourceLDAP = "ldap"
+)
+
 type User struct {
 	gorm.Model
 	Name     string `gorm:"not null" json:"name"`
 	Email    string `gorm:"uniqueIndex;not null" json:"email"`
 	Password string `gorm:"not null" json:"-"`
+
+	// Username is the user's unique handle, stored in lowercase so that it is unique ignoring case, or nil if they
+	// haven't picked one.
+	Username *string `gorm:"uniqueIndex" json:"username"`
+
+	// AvatarURL is the URL of the user's profile picture, or empty if they don't have one.
+	AvatarURL string `gorm:"not null;default:''" json:"avatar_url"`
+
+	// Discoverable lets other users find the user by searching, to share notes with them.
+	Discoverable bool `gorm:"not null;default:false" json:"discoverable"`
+
+	// EmailVerified is set once the user opens the link sent to their email, proving that they own it.
+	EmailVerified bool `gorm:"not null;default:false" json:"email_verified"`
+
+	// Role is what the user is allowed to do, either RoleUser or RoleAdmin.
+	Role string `gorm:"not null;default:user" json:"role"`
+
+	// AuthSource is where the user's password is checked, either AuthSourceLocal or AuthSourceLDAP.
+	AuthSource string `gorm:"not null;default:local" json:"-"`
 }
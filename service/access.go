@@ -0,0 +1,135 @@
+package service
+
+import (
+	"errors"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/models"
+	"slices"
+
+	"gorm.io/gorm"
+)
+
+// Levels of access to a note, from the least to the most permissive.
+const (
+	AccessNone = ""
+	AccessRead = "read"
+	AccessEdit = "edit"
+)
+
+// accessLevels is the list of all levels of access to a note, from the least to the most permissive.
+var accessLevels = []string{AccessNone, AccessRead, AccessEdit}
+
+// grantableAccess is the list of levels of access that can be granted to someone, e.g. through a share link.
+var grantableAccess = []string{AccessRead, AccessEdit}
+
+// EffectiveAccess resolves the access of a user who is granted access to a note through several paths, e.g. directly,
+// through their groups and through a notebook, to the most permissive of them. Unknown levels grant nothing.
+//
+// Grants are meant to be collected when the note is accessed, rather than stored, so that changes to group memberships
+// take effect immediately.
+func EffectiveAccess(grants ...string) string {
+	effective := AccessNone
+	for _, grant := range grants {
+		if slices.Index(accessLevels, grant) > slices.Index(accessLevels, effective) {
+			effective = grant
+		}
+	}
+
+	return effective
+}
+
+// findOwnedNote retrieves the note with the given ID, as long as it can be managed by the given user.
+//
+// Users manage the notes that they own, as long as they are still members of the organization that the note belongs
+// to, if any. Notes of an organization that have no owner are managed by its admins.
+//
+// Returns apperror.ErrNoteNotFound if the user can't manage such a note.
+func findOwnedNote(db *gorm.DB, orgs IOrganizationService, userID, noteID uint) (models.Note, error) {
+	var note models.Note
+	result := db.Where("id = ?", noteID).First(&note)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return models.Note{}, apperror.ErrNoteNotFound
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch note", slog.Any("error", result.Error))
+		return models.Note{}, apperror.Internal(result.Error)
+	}
+
+	minRole := models.OrgRoleMember
+	if note.OwnerID == nil {
+		if note.OrganizationID == nil {
+			return models.Note{}, apperror.ErrNoteNotFound
+		}
+		minRole = models.OrgRoleAdmin
+	} else if *note.OwnerID != userID {
+		return models.Note{}, apperror.ErrNoteNotFound
+	}
+
+	if _, err := checkNoteOrganization(db, orgs, userID, note, minRole); err != nil {
+		return models.Note{}, err
+	}
+
+	return note, nil
+}
+
+// checkNoteOrganization checks that the user is a member of the organization that the note belongs to, if any, with at
+// least the given role.
+//
+// Returns the user's role, which is empty for notes that don't belong to an organization, or apperror.ErrNoteNotFound
+// if they aren't a member with the role, so that the notes of other organizations aren't revealed to exist.
+func checkNoteOrganization(
+	db *gorm.DB, orgs IOrganizationService, userID uint, note models.Note, minRole string,
+) (string, error) {
+	if note.OrganizationID == nil {
+		return "", nil
+	}
+
+	role, err := orgs.RequireRole(userID, *note.OrganizationID, minRole, &DBOpts{db: db})
+	if errors.Is(err, apperror.ErrOrganizationNotFound) || errors.Is(err, apperror.ErrInsufficientRole) {
+		return "", apperror.ErrNoteNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	return role, nil
+}
+
+// noteAccess resolves the access of a user to a note, from owning it, from the note being shared with them directly,
+// and from it being shared with the groups that they are in right now.
+//
+// Notes of an organization are only accessible to its members, whichever way they are shared. Admins of the
+// organization can edit the notes of the organization that have no owner.
+func noteAccess(db *gorm.DB, orgs IOrganizationService, userID uint, note models.Note) (string, error) {
+	role, err := checkNoteOrganization(db, orgs, userID, note, models.OrgRoleGuest)
+	if errors.Is(err, apperror.ErrNoteNotFound) {
+		return AccessNone, nil
+	} else if err != nil {
+		return AccessNone, err
+	}
+
+	if note.OwnerID != nil && *note.OwnerID == userID {
+		return AccessEdit, nil
+	}
+	if note.OwnerID == nil && orgRoleRank(role) >= orgRoleRank(models.OrgRoleAdmin) {
+		return AccessEdit, nil
+	}
+
+	var grants []string
+	result := db.Model(&models.NoteShare{}).Where("note_id = ? AND user_id = ?", note.ID, userID).Pluck("access", &grants)
+	if result.Error != nil {
+		slog.Error("Failed to fetch note shares", slog.Any("error", result.Error))
+		return AccessNone, apperror.Internal(result.Error)
+	}
+
+	var groupGrants []string
+	result = db.Model(&models.NoteGroupShare{}).
+		Joins("JOIN user_group_members ON user_group_members.group_id = note_group_shares.group_id").
+		Where("note_group_shares.note_id = ? AND user_group_members.user_id = ?", note.ID, userID).
+		Pluck("note_group_shares.access", &groupGrants)
+	if result.Error != nil {
+		slog.Error("Failed to fetch note group shares", slog.Any("error", result.Error))
+		return AccessNone, apperror.Internal(result.Error)
+	}
+
+	return EffectiveAccess(append(grants, groupGrants...)...), nil
+}
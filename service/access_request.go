@@ -0,0 +1,456 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/mailer"
+	"notes-app/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReceivedAccessRequest is an access request sent to the owner of a note, along with who sent it.
+type ReceivedAccessRequest struct {
+	models.AccessRequest
+	RequesterName     string  `json:"requester_name"`
+	RequesterUsername *string `json:"requester_username"`
+	RequesterEmail    string  `json:"requester_email"`
+}
+
+type IAccessRequestService interface {
+	// Create requests access to a note from its owner, on behalf of the given user, and notifies the owner.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the request, apperror.ErrNoteNotFound if the note or its owner doesn't exist, or
+	// apperror.ErrValidationFailed if the access is unknown, or the user owns the note or already has the access.
+	Create(requesterID, noteID uint, access, message string, opts *DBOpts) (models.AccessRequest, error)
+
+	// ListReceived lists the access requests sent to the given user, newest first, optionally only those with the
+	// given status.
+	// Accepts optional DBOpts to specify a DB instance.
+	ListReceived(userID uint, status string, opts *DBOpts) ([]ReceivedAccessRequest, error)
+
+	// ListSent lists the access requests sent by the given user, newest first.
+	// Accepts optional DBOpts to specify a DB instance.
+	ListSent(userID uint, opts *DBOpts) ([]models.AccessRequest, error)
+
+	// Approve approves an access request sent to the given user, shares the note with the requester with the given
+	// access, and notifies the requester.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the decided request, apperror.ErrAccessRequestNotFound, apperror.ErrAccessRequestDecided,
+	// apperror.ErrNoteNotFound if the user can no longer manage the note, or apperror.ErrValidationFailed if the
+	// access is unknown.
+	Approve(userID, id uint, access string, opts *DBOpts) (models.AccessRequest, error)
+
+	// Deny denies an access request sent to the given user, and notifies the requester.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the decided request, apperror.ErrAccessRequestNotFound or apperror.ErrAccessRequestDecided.
+	Deny(userID, id uint, opts *DBOpts) (models.AccessRequest, error)
+
+	// ListEvents retrieves the audit trail of an access request sent by or to the given user, oldest first.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrAccessRequestNotFound if the user can't see the request.
+	ListEvents(userID, id uint, opts *DBOpts) ([]models.AccessRequestEvent, error)
+}
+
+type AccessRequestService struct {
+	Service
+
+	// Mailer is used to notify owners of new requests and requesters of decisions, which should be created from config
+	// at startup with mailer.New.
+	Mailer mailer.Mailer
+
+	// OrganizationService is used to check that users are members of the organizations that notes belong to.
+	OrganizationService IOrganizationService
+}
+
+// Create requests access to a note from its owner, on behalf of the given user, and notifies the owner.
+//
+// The request goes to the owner of the note, who is looked up here rather than trusted from the caller. Requesting
+// access again while a request is pending updates it instead, and doesn't notify the owner again, so that owners can't
+// be spammed. Either way, the request is recorded in its audit trail. Notes of an organization can only be asked for by
+// its members, and other users are told that they don't exist.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the request, apperror.ErrNoteNotFound if the note or its owner doesn't exist, or
+// apperror.ErrValidationFailed if the access is unknown, or the user owns the note or already has the access.
+func (svc AccessRequestService) Create(
+	requesterID, noteID uint, access, message string, opts *DBOpts,
+) (models.AccessRequest, error) {
+	db := svc.getDB(opts)
+
+	if err := checkGrantableAccess(access); err != nil {
+		return models.AccessRequest{}, err
+	}
+
+	var request models.AccessRequest
+	var requester, owner models.User
+	created := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", requesterID).First(&requester)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return apperror.ErrUserNotFound
+		} else if result.Error != nil {
+			slog.Error("Failed to fetch user", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		// The request goes to whoever owns the note, which the requester has no say in
+		var note models.Note
+		result = tx.Where("id = ?", noteID).First(&note)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return apperror.ErrNoteNotFound
+		} else if result.Error != nil {
+			slog.Error("Failed to fetch note", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+		// Notes of an organization can only be asked for by its members
+		_, err := checkNoteOrganization(tx, svc.organizationService(), requesterID, note, models.OrgRoleGuest)
+		if err != nil {
+			return err
+		}
+		if note.OwnerID != nil && *note.OwnerID == requesterID {
+			return apperror.ErrValidationFailed.WithDetails(apperror.FieldError{
+				Field:   "note_id",
+				Message: "is already yours",
+			})
+		}
+
+		// Notes of deleted accounts, and notes without an owner, can't be asked for
+		if note.OwnerID == nil {
+			return apperror.ErrNoteNotFound
+		}
+		result = tx.Where("id = ?", *note.OwnerID).First(&owner)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return apperror.ErrNoteNotFound
+		} else if result.Error != nil {
+			slog.Error("Failed to fetch user", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		// There is nothing to ask for if the note is already shared with at least that access, directly or not
+		current, err := noteAccess(tx, svc.organizationService(), requesterID, note)
+		if err != nil {
+			return err
+		}
+		if EffectiveAccess(current, access) == current {
+			return apperror.ErrValidationFailed.WithDetails(apperror.FieldError{
+				Field:   "access",
+				Message: "is already granted to you",
+			})
+		}
+
+		result = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("note_id = ? AND requester_id = ? AND status = ?", noteID, requesterID, models.AccessRequestPending).
+			First(&request)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			request = models.AccessRequest{
+				NoteID:      noteID,
+				RequesterID: requesterID,
+				OwnerID:     owner.ID,
+				Access:      access,
+				Message:     message,
+				Status:      models.AccessRequestPending,
+			}
+			created = true
+			result = tx.Create(&request)
+		} else if result.Error == nil {
+			result = tx.Model(&request).Updates(map[string]any{"access": access, "message": message})
+		}
+		if result.Error != nil {
+			slog.Error("Failed to save access request", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		err = recordAccessRequestEvent(tx, request, requesterID, models.AccessRequestEventRequested, access)
+		if err != nil {
+			slog.Error("Failed to record access request event", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.AccessRequest{}, err
+	}
+
+	if created {
+		// The request shows up in the owner's inbox either way, so only log failures
+		body := fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"%s (%s) asked for %s access to one of your notes.\n\n",
+			owner.Name, requester.Name, requester.Email, access,
+		)
+		if message != "" {
+			body += fmt.Sprintf("They said:\n\n%s\n\n", message)
+		}
+		body += fmt.Sprintf("You can approve or deny the request here:\n\n%s/access-requests\n",
+			strings.TrimSuffix(config.Get().AppURL, "/"))
+
+		err := sendEmail(svc.Mailer, mailer.Message{
+			To:      owner.Email,
+			Subject: fmt.Sprintf("%s asked for access to your note", requester.Name),
+			Body:    body,
+		})
+		if err != nil {
+			slog.Error("Failed to send access request email", slog.Any("error", err), slog.Any("requestID", request.ID))
+		}
+	}
+
+	return request, nil
+}
+
+// ListReceived lists the access requests sent to the given user, newest first, optionally only those with the given
+// status.
+//
+// Requests from deleted accounts are left out.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc AccessRequestService) ListReceived(userID uint, status string, opts *DBOpts) ([]ReceivedAccessRequest, error) {
+	db := svc.getDB(opts)
+
+	query := db.Table("access_requests").
+		Select("access_requests.*, users.name AS requester_name, users.username AS requester_username, "+
+			"users.email AS requester_email").
+		Joins("JOIN users ON users.id = access_requests.requester_id AND users.deleted_at IS NULL").
+		Where("access_requests.owner_id = ?", userID)
+	if status != "" {
+		query = query.Where("access_requests.status = ?", status)
+	}
+
+	requests := make([]ReceivedAccessRequest, 0)
+	if err := query.Order("access_requests.created_at DESC").Scan(&requests).Error; err != nil {
+		slog.Error("Failed to list received access requests", slog.Any("error", err))
+		return nil, apperror.Internal(err)
+	}
+
+	return requests, nil
+}
+
+// ListSent lists the access requests sent by the given user, newest first.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc AccessRequestService) ListSent(userID uint, opts *DBOpts) ([]models.AccessRequest, error) {
+	db := svc.getDB(opts)
+
+	requests := make([]models.AccessRequest, 0)
+	if err := db.Where("requester_id = ?", userID).Order("created_at DESC").Find(&requests).Error; err != nil {
+		slog.Error("Failed to list sent access requests", slog.Any("error", err))
+		return nil, apperror.Internal(err)
+	}
+
+	return requests, nil
+}
+
+// Approve approves an access request sent to the given user, shares the note with the requester with the given access,
+// and notifies the requester.
+//
+// The owner can grant different access than was asked for, e.g. read access when edit access was requested. Access
+// that the requester already has is never taken away by approving, e.g. approving read access for an editor.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the decided request, apperror.ErrAccessRequestNotFound, apperror.ErrAccessRequestDecided,
+// apperror.ErrNoteNotFound if the user can no longer manage the note, e.g. because they left its organization, or
+// apperror.ErrValidationFailed if the access is unknown.
+func (svc AccessRequestService) Approve(userID, id uint, access string, opts *DBOpts) (models.AccessRequest, error) {
+	if err := checkGrantableAccess(access); err != nil {
+		return models.AccessRequest{}, err
+	}
+
+	return svc.decide(svc.getDB(opts), userID, id, models.AccessRequestApproved, access)
+}
+
+// Deny denies an access request sent to the given user, and notifies the requester.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the decided request, apperror.ErrAccessRequestNotFound or apperror.ErrAccessRequestDecided.
+func (svc AccessRequestService) Deny(userID, id uint, opts *DBOpts) (models.AccessRequest, error) {
+	return svc.decide(svc.getDB(opts), userID, id, models.AccessRequestDenied, AccessNone)
+}
+
+// decide records the decision of the owner on a pending access request, shares the note with the requester if it was
+// approved, records the decision in the audit trail, and notifies the requester.
+//
+// The request is locked while deciding, so that concurrent decisions can't both go through.
+func (svc AccessRequestService) decide(
+	db *gorm.DB, userID, id uint, status, grantedAccess string,
+) (models.AccessRequest, error) {
+	var request models.AccessRequest
+	err := db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND owner_id = ?", id, userID).
+			First(&request)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return apperror.ErrAccessRequestNotFound
+		} else if result.Error != nil {
+			slog.Error("Failed to fetch access request", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		if request.Status != models.AccessRequestPending {
+			return apperror.ErrAccessRequestDecided
+		}
+
+		now := time.Now()
+		err := tx.Model(&request).Updates(map[string]any{
+			"status":         status,
+			"granted_access": grantedAccess,
+			"decided_at":     now,
+			"decided_by_id":  userID,
+		}).Error
+		if err != nil {
+			slog.Error("Failed to decide access request", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+		request.Status = status
+		request.GrantedAccess = grantedAccess
+		request.DecidedAt = &now
+		request.DecidedByID = &userID
+
+		action := models.AccessRequestEventDenied
+		if status == models.AccessRequestApproved {
+			action = models.AccessRequestEventApproved
+			if _, err := findOwnedNote(tx, svc.organizationService(), userID, request.NoteID); err != nil {
+				return err
+			}
+			if err := grantNoteAccess(tx, request.NoteID, request.RequesterID, grantedAccess); err != nil {
+				slog.Error("Failed to share note", slog.Any("error", err))
+				return apperror.Internal(err)
+			}
+		}
+
+		if err := recordAccessRequestEvent(tx, request, userID, action, grantedAccess); err != nil {
+			slog.Error("Failed to record access request event", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.AccessRequest{}, err
+	}
+
+	svc.notifyDecision(db, request)
+
+	return request, nil
+}
+
+// ListEvents retrieves the audit trail of an access request sent by or to the given user, oldest first.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrAccessRequestNotFound if the user can't see the request.
+func (svc AccessRequestService) ListEvents(userID, id uint, opts *DBOpts) ([]models.AccessRequestEvent, error) {
+	db := svc.getDB(opts)
+
+	var request models.AccessRequest
+	result := db.Where("id = ? AND (owner_id = ? OR requester_id = ?)", id, userID, userID).First(&request)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return nil, apperror.ErrAccessRequestNotFound
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch access request", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	events := make([]models.AccessRequestEvent, 0)
+	if err := db.Where("access_request_id = ?", id).Order("created_at, id").Find(&events).Error; err != nil {
+		slog.Error("Failed to list access request events", slog.Any("error", err))
+		return nil, apperror.Internal(err)
+	}
+
+	return events, nil
+}
+
+// notifyDecision emails the requester of an access request about the owner's decision.
+//
+// The decision stands either way, so failures are only logged.
+func (svc AccessRequestService) notifyDecision(db *gorm.DB, request models.AccessRequest) {
+	var requester models.User
+	if err := db.Where("id = ?", request.RequesterID).First(&requester).Error; err != nil {
+		slog.Error("Failed to fetch requester", slog.Any("error", err), slog.Any("requestID", request.ID))
+		return
+	}
+
+	subject := "Your access request was denied"
+	outcome := "denied your request for access to their note"
+	if request.Status == models.AccessRequestApproved {
+		subject = "Your access request was approved"
+		outcome = fmt.Sprintf("approved your request, and gave you %s access to their note", request.GrantedAccess)
+	}
+
+	err := sendEmail(svc.Mailer, mailer.Message{
+		To:      requester.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf("Hi %s,\n\nThe owner %s.\n", requester.Name, outcome),
+	})
+	if err != nil {
+		slog.Error("Failed to send access request decision email", slog.Any("error", err),
+			slog.Any("requestID", request.ID))
+	}
+}
+
+// organizationService returns the organization service, defaulting to one using the same DB.
+func (svc AccessRequestService) organizationService() IOrganizationService {
+	if svc.OrganizationService != nil {
+		return svc.OrganizationService
+	}
+	return OrganizationService{Service: svc.Service}
+}
+
+// checkGrantableAccess checks that an access level can be granted to someone.
+//
+// Returns apperror.ErrValidationFailed if it can't.
+func checkGrantableAccess(access string) error {
+	if !slices.Contains(grantableAccess, access) {
+		return apperror.ErrValidationFailed.WithDetails(apperror.FieldError{
+			Field:   "access",
+			Message: fmt.Sprintf("must be one of: %s", strings.Join(grantableAccess, " ")),
+		})
+	}
+	return nil
+}
+
+// recordAccessRequestEvent adds an entry to the audit trail of an access request.
+func recordAccessRequestEvent(tx *gorm.DB, request models.AccessRequest, actorID uint, action, access string) error {
+	return tx.Create(&models.AccessRequestEvent{
+		AccessRequestID: request.ID,
+		NoteID:          request.NoteID,
+		ActorID:         actorID,
+		Action:          action,
+		Access:          access,
+	}).Error
+}
+
+// grantNoteAccess shares a note with a user, keeping the access that they already have if it is more permissive.
+func grantNoteAccess(tx *gorm.DB, noteID, userID uint, access string) error {
+	// Make sure that the share exists, then lock it so that concurrent grants can't overwrite each other
+	share := models.NoteShare{NoteID: noteID, UserID: userID, Access: access}
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&share).Error; err != nil {
+		return err
+	}
+
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("note_id = ? AND user_id = ?", noteID, userID).
+		First(&share).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&share).Update("access", EffectiveAccess(share.Access, access)).Error
+}
+
+// deleteAccessRequests deletes the access requests sent by or to the user, along with their audit trails, when their
+// account is erased.
+func deleteAccessRequests(tx *gorm.DB, userID uint) error {
+	requestIDs := tx.Model(&models.AccessRequest{}).Select("id").Where("requester_id = ? OR owner_id = ?", userID, userID)
+	if err := tx.Where("access_request_id IN (?)", requestIDs).Delete(&models.AccessRequestEvent{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("requester_id = ? OR owner_id = ?", userID, userID).Delete(&models.AccessRequest{}).Error
+}
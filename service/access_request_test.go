@@ -0,0 +1,190 @@
+package service_test
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AccessRequestServiceTestSuite struct {
+	suite.Suite
+	dbService            database.Service
+	accessRequestService service.AccessRequestService
+	mailer               *recordingMailer
+	owner                models.User
+	requester            models.User
+	note                 models.Note
+}
+
+func (suite *AccessRequestServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the service instances to use for testing
+	suite.mailer = &recordingMailer{}
+	suite.accessRequestService = service.AccessRequestService{
+		Service: service.Service{DBService: suite.dbService},
+		Mailer:  suite.mailer,
+	}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *AccessRequestServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+	suite.mailer.messages = nil
+
+	suite.owner = models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "hashedpassword"}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&suite.owner).Error)
+	suite.requester = models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "hashedpassword"}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&suite.requester).Error)
+	suite.note = models.Note{OwnerID: &suite.owner.ID, Title: "Shopping list"}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&suite.note).Error)
+
+	slog.Debug("Setup test")
+}
+
+func (suite *AccessRequestServiceTestSuite) TestCreate() {
+	svc := suite.accessRequestService
+
+	request, err := svc.Create(suite.requester.ID, suite.note.ID, service.AccessEdit, "Can I help?", nil)
+	suite.NoError(err)
+	suite.Equal(models.AccessRequestPending, request.Status)
+
+	// The owner is notified, along with the message
+	suite.Require().Len(suite.mailer.messages, 1)
+	suite.Equal(suite.owner.Email, suite.mailer.messages[0].To)
+	suite.Contains(suite.mailer.messages[0].Body, "Can I help?")
+
+	// Asking again updates the pending request, without notifying the owner again
+	again, err := svc.Create(suite.requester.ID, suite.note.ID, service.AccessRead, "Just reading then", nil)
+	suite.NoError(err)
+	suite.Equal(request.ID, again.ID)
+	suite.Len(suite.mailer.messages, 1)
+
+	received, err := svc.ListReceived(suite.owner.ID, models.AccessRequestPending, nil)
+	suite.NoError(err)
+	suite.Require().Len(received, 1)
+	suite.Equal(service.AccessRead, received[0].Access)
+	suite.Equal("Just reading then", received[0].Message)
+	suite.Equal(suite.requester.Name, received[0].RequesterName)
+
+	sent, err := svc.ListSent(suite.requester.ID, nil)
+	suite.NoError(err)
+	suite.Len(sent, 1)
+
+	// Owners can't ask for their own notes, nobody can ask for unknown access, and the note has to exist
+	_, err = svc.Create(suite.owner.ID, suite.note.ID, service.AccessRead, "", nil)
+	suite.ErrorIs(err, apperror.ErrValidationFailed)
+	_, err = svc.Create(suite.requester.ID, suite.note.ID, "admin", "", nil)
+	suite.ErrorIs(err, apperror.ErrValidationFailed)
+	_, err = svc.Create(suite.requester.ID, suite.note.ID+100, service.AccessRead, "", nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+
+	// The request always goes to the owner of the note
+	suite.Equal(suite.owner.ID, request.OwnerID)
+}
+
+func (suite *AccessRequestServiceTestSuite) TestDecide() {
+	svc := suite.accessRequestService
+	request, err := svc.Create(suite.requester.ID, suite.note.ID, service.AccessEdit, "", nil)
+	suite.NoError(err)
+
+	// Only the owner can decide
+	_, err = svc.Approve(suite.requester.ID, request.ID, service.AccessEdit, nil)
+	suite.ErrorIs(err, apperror.ErrAccessRequestNotFound)
+	_, err = svc.Approve(suite.owner.ID, request.ID, "admin", nil)
+	suite.ErrorIs(err, apperror.ErrValidationFailed)
+
+	// The owner can grant less than was asked for, and the decision is recorded
+	approved, err := svc.Approve(suite.owner.ID, request.ID, service.AccessRead, nil)
+	suite.NoError(err)
+	suite.Equal(models.AccessRequestApproved, approved.Status)
+	suite.Equal(service.AccessRead, approved.GrantedAccess)
+	suite.NotNil(approved.DecidedAt)
+	suite.Equal(suite.owner.ID, *approved.DecidedByID)
+
+	// The note is shared with the requester
+	var share models.NoteShare
+	suite.NoError(suite.dbService.GetDB().Where("note_id = ? AND user_id = ?", suite.note.ID, suite.requester.ID).First(&share).Error)
+	suite.Equal(service.AccessRead, share.Access)
+
+	// The requester is notified
+	suite.Equal(suite.requester.Email, suite.mailer.messages[len(suite.mailer.messages)-1].To)
+	suite.Contains(suite.mailer.messages[len(suite.mailer.messages)-1].Subject, "approved")
+
+	// Decisions are final
+	_, err = svc.Deny(suite.owner.ID, request.ID, nil)
+	suite.ErrorIs(err, apperror.ErrAccessRequestDecided)
+
+	// Decided requests are kept, so that there is a record of them
+	received, err := svc.ListReceived(suite.owner.ID, "", nil)
+	suite.NoError(err)
+	suite.Require().Len(received, 1)
+	suite.Equal(models.AccessRequestApproved, received[0].Status)
+	received, err = svc.ListReceived(suite.owner.ID, models.AccessRequestPending, nil)
+	suite.NoError(err)
+	suite.Empty(received)
+
+	// Access that has already been granted can't be asked for again
+	_, err = svc.Create(suite.requester.ID, suite.note.ID, service.AccessRead, "", nil)
+	suite.ErrorIs(err, apperror.ErrValidationFailed)
+
+	// Asking for more after a decision starts a new request
+	request, err = svc.Create(suite.requester.ID, suite.note.ID, service.AccessEdit, "Edit, please?", nil)
+	suite.NoError(err)
+	denied, err := svc.Deny(suite.owner.ID, request.ID, nil)
+	suite.NoError(err)
+	suite.Equal(models.AccessRequestDenied, denied.Status)
+	suite.Empty(denied.GrantedAccess)
+
+	// Denying doesn't take away access that was granted before
+	suite.NoError(suite.dbService.GetDB().First(&share, share.ID).Error)
+	suite.Equal(service.AccessRead, share.Access)
+}
+
+func (suite *AccessRequestServiceTestSuite) TestEvents() {
+	svc := suite.accessRequestService
+	request, err := svc.Create(suite.requester.ID, suite.note.ID, service.AccessRead, "", nil)
+	suite.NoError(err)
+	_, err = svc.Create(suite.requester.ID, suite.note.ID, service.AccessEdit, "Edit, actually", nil)
+	suite.NoError(err)
+	_, err = svc.Approve(suite.owner.ID, request.ID, service.AccessEdit, nil)
+	suite.NoError(err)
+
+	// Every change to the request is recorded, along with who made it
+	events, err := svc.ListEvents(suite.owner.ID, request.ID, nil)
+	suite.NoError(err)
+	suite.Require().Len(events, 3)
+	suite.Equal(models.AccessRequestEventRequested, events[0].Action)
+	suite.Equal(service.AccessRead, events[0].Access)
+	suite.Equal(suite.requester.ID, events[0].ActorID)
+	suite.Equal(service.AccessEdit, events[1].Access)
+	suite.Equal(models.AccessRequestEventApproved, events[2].Action)
+	suite.Equal(suite.owner.ID, events[2].ActorID)
+
+	// The requester can see the trail too, but nobody else can
+	events, err = svc.ListEvents(suite.requester.ID, request.ID, nil)
+	suite.NoError(err)
+	suite.Len(events, 3)
+	_, err = svc.ListEvents(suite.requester.ID+100, request.ID, nil)
+	suite.ErrorIs(err, apperror.ErrAccessRequestNotFound)
+}
+
+func TestAccessRequestService(t *testing.T) {
+	suite.Run(t, new(AccessRequestServiceTestSuite))
+}
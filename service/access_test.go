@@ -0,0 +1,27 @@
+package service_test
+
+import (
+	"notes-app/service"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEffectiveAccess(t *testing.T) {
+	testCases := map[string]struct {
+		grants   []string
+		expected string
+	}{
+		"no grants":              {grants: nil, expected: service.AccessNone},
+		"direct only":            {grants: []string{service.AccessRead}, expected: service.AccessRead},
+		"group grants more":      {grants: []string{service.AccessRead, service.AccessEdit}, expected: service.AccessEdit},
+		"order doesn't matter":   {grants: []string{service.AccessEdit, service.AccessNone, service.AccessRead}, expected: service.AccessEdit},
+		"unknown grants nothing": {grants: []string{"admin", service.AccessRead}, expected: service.AccessRead},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, service.EffectiveAccess(tc.grants...))
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/models"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AccessTokenPrefix is the prefix of personal access tokens, which tells them apart from JWTs and makes leaked tokens
+// easy to find with secret scanners.
+const AccessTokenPrefix = "nap_"
+
+// accessTokenDisplayLength is the number of characters of a token, including the prefix, that are stored to help
+// users recognise their tokens.
+const accessTokenDisplayLength = len(AccessTokenPrefix) + 6
+
+// Scopes that personal access tokens can be granted.
+const (
+	ScopeNotesRead    = "notes:read"
+	ScopeNotesWrite   = "notes:write"
+	ScopeSharesManage = "shares:manage"
+	ScopeProfileRead  = "profile:read"
+	ScopeProfileWrite = "profile:write"
+)
+
+// AccessTokenScopes is the list of all scopes that personal access tokens can be granted.
+var AccessTokenScopes = []string{ScopeNotesRead, ScopeNotesWrite, ScopeSharesManage, ScopeProfileRead, ScopeProfileWrite}
+
+type IAccessTokenService interface {
+	// Create creates a new personal access token for the given user, with the given scopes and an optional expiry.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the token record and the plaintext token, which is not stored and can't be retrieved again, or
+	// apperror.ErrValidationFailed if a scope is unknown or the expiry is in the past.
+	Create(userID uint, name string, scopes []string, expiresAt *time.Time, opts *DBOpts) (models.PersonalAccessToken, string, error)
+
+	// List retrieves all personal access tokens of the given user that have not been revoked, newest first.
+	// Accepts optional DBOpts to specify a DB instance.
+	List(userID uint, opts *DBOpts) ([]models.PersonalAccessToken, error)
+
+	// Revoke revokes the personal access token with the given ID, as long as it belongs to the given user.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrAccessTokenNotFound if the user has no such token.
+	Revoke(userID uint, id uint, opts *DBOpts) error
+
+	// Authenticate retrieves the personal access token matching the given plaintext token, and records that it was
+	// used.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTokenInvalid if the token doesn't exist or was revoked, or apperror.ErrTokenExpired.
+	Authenticate(token string, opts *DBOpts) (models.PersonalAccessToken, error)
+}
+
+type AccessTokenService struct {
+	Service
+}
+
+// Create creates a new personal access token for the given user, with the given scopes and an optional expiry.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the token record and the plaintext token, which is not stored and can't be retrieved again, or
+// apperror.ErrValidationFailed if a scope is unknown or the expiry is in the past.
+func (svc AccessTokenService) Create(
+	userID uint, name string, scopes []string, expiresAt *time.Time, opts *DBOpts,
+) (models.PersonalAccessToken, string, error) {
+	db := svc.getDB(opts)
+
+	// Check the scopes and expiry
+	var details []apperror.FieldError
+	for _, scope := range scopes {
+		if !slices.Contains(AccessTokenScopes, scope) {
+			details = append(details, apperror.FieldError{
+				Field:   "scopes",
+				Message: fmt.Sprintf("must be one of: %s", strings.Join(AccessTokenScopes, " ")),
+			})
+			break
+		}
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		details = append(details, apperror.FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+	if len(details) > 0 {
+		return models.PersonalAccessToken{}, "", apperror.ErrValidationFailed.WithDetails(details...)
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		slog.Error("Failed to generate access token", slog.Any("error", err))
+		return models.PersonalAccessToken{}, "", apperror.Internal(err)
+	}
+	token := AccessTokenPrefix + secret
+
+	// Remove duplicate scopes, and sort them so that they are listed consistently
+	scopes = slices.Clone(scopes)
+	slices.Sort(scopes)
+
+	accessToken := models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    token[:accessTokenDisplayLength],
+		TokenHash: hashToken(token),
+		Scopes:    slices.Compact(scopes),
+		ExpiresAt: expiresAt,
+	}
+	if err := db.Create(&accessToken).Error; err != nil {
+		slog.Error("Failed to create access token", slog.Any("error", err))
+		return models.PersonalAccessToken{}, "", apperror.Internal(err)
+	}
+
+	return accessToken, token, nil
+}
+
+// List retrieves all personal access tokens of the given user that have not been revoked, newest first.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc AccessTokenService) List(userID uint, opts *DBOpts) ([]models.PersonalAccessToken, error) {
+	db := svc.getDB(opts)
+
+	var accessTokens []models.PersonalAccessToken
+	result := db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&accessTokens)
+	if result.Error != nil {
+		slog.Error("Failed to fetch access tokens", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	return accessTokens, nil
+}
+
+// Revoke revokes the personal access token with the given ID, as long as it belongs to the given user.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrAccessTokenNotFound if the user has no such token.
+func (svc AccessTokenService) Revoke(userID uint, id uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	result := db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		slog.Error("Failed to revoke access token", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return apperror.ErrAccessTokenNotFound
+	}
+
+	return nil
+}
+
+// Authenticate retrieves the personal access token matching the given plaintext token, and records that it was used.
+//
+// The last used time is only updated periodically, so that it doesn't write on every request.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTokenInvalid if the token doesn't exist or was revoked, or apperror.ErrTokenExpired.
+func (svc AccessTokenService) Authenticate(token string, opts *DBOpts) (models.PersonalAccessToken, error) {
+	db := svc.getDB(opts)
+
+	var accessToken models.PersonalAccessToken
+	result := db.Where("token_hash = ? AND revoked_at IS NULL", hashToken(token)).First(&accessToken)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return accessToken, apperror.ErrTokenInvalid
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch access token", slog.Any("error", result.Error))
+		return accessToken, apperror.Internal(result.Error)
+	}
+
+	if accessToken.ExpiresAt != nil && time.Now().After(*accessToken.ExpiresAt) {
+		return accessToken, apperror.ErrTokenExpired
+	}
+
+	// Record that the token was used, throttled like the last seen time of sessions
+	if accessToken.LastUsedAt == nil || time.Since(*accessToken.LastUsedAt) > config.Get().SessionLastSeenInterval {
+		now := time.Now()
+		if err := db.Model(&accessToken).Update("last_used_at", now).Error; err != nil {
+			// The token is still valid, so don't fail the request
+			slog.Error("Failed to update access token", slog.Any("error", err))
+		}
+		accessToken.LastUsedAt = &now
+	}
+
+	return accessToken, nil
+}
@@ -0,0 +1,136 @@
+package service_test
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type AccessTokenServiceTestSuite struct {
+	suite.Suite
+	dbService          database.Service
+	accessTokenService service.AccessTokenService
+	user               models.User
+}
+
+func (suite *AccessTokenServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the access token service instance to use for testing
+	suite.accessTokenService = service.AccessTokenService{Service: service.Service{DBService: suite.dbService}}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *AccessTokenServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+
+	// Create a user to own the tokens
+	suite.user = models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "password"}
+	errCreate := suite.dbService.GetDB().Create(&suite.user).Error
+	suite.NoError(errCreate)
+
+	slog.Debug("Setup test")
+}
+
+func (suite *AccessTokenServiceTestSuite) TestCreate() {
+	svc := suite.accessTokenService
+
+	// Create a token using the service
+	scopes := []string{service.ScopeNotesWrite, service.ScopeNotesRead, service.ScopeNotesRead}
+	accessToken, token, errCreate := svc.Create(suite.user.ID, "backup script", scopes, nil, nil)
+	suite.NoError(errCreate)
+	suite.True(strings.HasPrefix(token, service.AccessTokenPrefix))
+	suite.True(strings.HasPrefix(token, accessToken.Prefix))
+	suite.Equal(models.Scopes{service.ScopeNotesRead, service.ScopeNotesWrite}, accessToken.Scopes)
+
+	// Assert that only the hash of the token is stored
+	var count int64
+	suite.dbService.GetDB().Model(&models.PersonalAccessToken{}).Where("token_hash = ?", token).Count(&count)
+	suite.Zero(count)
+
+	// Assert that the token can be used
+	authenticated, errAuthenticate := svc.Authenticate(token, nil)
+	suite.NoError(errAuthenticate)
+	suite.Equal(accessToken.ID, authenticated.ID)
+	suite.Equal(accessToken.Scopes, authenticated.Scopes)
+	suite.NotNil(authenticated.LastUsedAt)
+
+	// Unknown scopes and expiries in the past are rejected
+	_, _, errCreate = svc.Create(suite.user.ID, "bad scope", []string{"notes:delete"}, nil, nil)
+	suite.ErrorIs(errCreate, apperror.ErrValidationFailed)
+
+	past := time.Now().Add(-time.Hour)
+	_, _, errCreate = svc.Create(suite.user.ID, "expired", []string{service.ScopeNotesRead}, &past, nil)
+	suite.ErrorIs(errCreate, apperror.ErrValidationFailed)
+}
+
+func (suite *AccessTokenServiceTestSuite) TestAuthenticate() {
+	svc := suite.accessTokenService
+
+	// A token that is about to expire works until it does
+	expiry := time.Now().Add(time.Second)
+	_, token, errCreate := svc.Create(suite.user.ID, "short lived", []string{service.ScopeNotesRead}, &expiry, nil)
+	suite.NoError(errCreate)
+
+	_, errAuthenticate := svc.Authenticate(token, nil)
+	suite.NoError(errAuthenticate)
+
+	suite.dbService.GetDB().Model(&models.PersonalAccessToken{}).
+		Where("user_id = ?", suite.user.ID).
+		Update("expires_at", time.Now().Add(-time.Minute))
+	_, errAuthenticate = svc.Authenticate(token, nil)
+	suite.ErrorIs(errAuthenticate, apperror.ErrTokenExpired)
+
+	// Unknown tokens are rejected
+	_, errAuthenticate = svc.Authenticate(service.AccessTokenPrefix+"nosuchtoken", nil)
+	suite.ErrorIs(errAuthenticate, apperror.ErrTokenInvalid)
+}
+
+func (suite *AccessTokenServiceTestSuite) TestRevoke() {
+	svc := suite.accessTokenService
+
+	accessToken, token, errCreate := svc.Create(suite.user.ID, "ci", []string{service.ScopeNotesRead}, nil, nil)
+	suite.NoError(errCreate)
+
+	// Other users can't revoke the token
+	errRevoke := svc.Revoke(suite.user.ID+1, accessToken.ID, nil)
+	suite.ErrorIs(errRevoke, apperror.ErrAccessTokenNotFound)
+
+	// Revoke the token
+	errRevoke = svc.Revoke(suite.user.ID, accessToken.ID, nil)
+	suite.NoError(errRevoke)
+
+	// Assert that the token stops working and is no longer listed
+	_, errAuthenticate := svc.Authenticate(token, nil)
+	suite.ErrorIs(errAuthenticate, apperror.ErrTokenInvalid)
+
+	accessTokens, errList := svc.List(suite.user.ID, nil)
+	suite.NoError(errList)
+	suite.Empty(accessTokens)
+
+	// Revoking it again fails
+	errRevoke = svc.Revoke(suite.user.ID, accessToken.ID, nil)
+	suite.ErrorIs(errRevoke, apperror.ErrAccessTokenNotFound)
+}
+
+func TestAccessTokenService(t *testing.T) {
+	suite.Run(t, new(AccessTokenServiceTestSuite))
+}
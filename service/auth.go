@@ -1,95 +1,228 @@
 package service
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
+	"notes-app/apperror"
 	"notes-app/config"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/gofiber/fiber/v2/middleware/keyauth"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type IAuthService interface {
-	// HashPassword hashes the given password using bcrypt.
-	// Returns the hashed password or an error if hashing fails.
+	// HashPassword hashes the given password using the configured algorithm (argon2id or bcrypt).
+	// Returns the hashed password, apperror.ErrWeakPassword if the password is too long, or an error if hashing fails.
 	HashPassword(password string) (string, error)
 
+	// ValidatePassword checks the given password against the password policy.
+	// The password must be long enough but not longer than MaxPasswordLength, must not contain any of the user inputs
+	// (like the user's name or email), must not be in the list of breached passwords, and must be strong enough.
+	// Returns apperror.ErrWeakPassword or apperror.ErrBreachedPassword if the password is not allowed.
+	ValidatePassword(password string, userInputs ...string) error
+
 	// ComparePasswords compares a hashed password with a plaintext password.
-	// Returns an error if the passwords do not match.
+	// The algorithm is detected from the hash, so both argon2id and bcrypt hashes are supported.
+	// Returns apperror.ErrInvalidCredentials if the passwords do not match.
 	ComparePasswords(hashedPassword, password string) error
 
-	// GenerateJWT generates a JWT token for the given user ID.
+	// NeedsRehash checks whether a hashed password was created with a different algorithm or weaker parameters than
+	// the configured ones, and so should be rehashed the next time the plaintext password is available.
+	NeedsRehash(hashedPassword string) bool
+
+	// DirectoryEnabled checks whether passwords are checked against a directory, like LDAP, instead of the local
+	// password hashes.
+	DirectoryEnabled() bool
+
+	// AuthenticateDirectory checks the password of the user with the given email in the directory.
+	// Must only be called if DirectoryEnabled.
+	// Returns the user's details in the directory, apperror.ErrInvalidCredentials if there is no such user or the
+	// password is incorrect, or apperror.ErrDirectoryUnavailable if the directory can't be reached.
+	AuthenticateDirectory(email, password string) (DirectoryUser, error)
+
+	// GenerateJWT generates a JWT token for the given user ID and session ID.
 	//
-	// The token is signed using the HS512 algorithm and includes the user's ID as the subject,
-	// the current time as the issued-at claim, and an expiry time 24 hours from now.
+	// The token is signed with the active key of the key ring, tagged with its ID in the kid header, and includes the
+	// user's ID as the subject, the session ID as the JWT ID, the current time as the issued-at claim, and an expiry
+	// time based on the configured access token TTL.
 	//
 	// Returns the signed JWT token string, the expiry time, or an error if signing fails.
-	GenerateJWT(id uint) (string, time.Time, error)
+	GenerateJWT(id uint, sessionID string) (string, time.Time, error)
 
 	// ParseJWT parses and validates a JWT token string.
 	//
-	// It uses the configured JWT secret to validate the token signature.
-	// If the token is valid, it returns the registered claims; otherwise, it returns an error.
+	// It uses the key in the key ring matching the kid header of the token to validate the token signature, so that
+	// tokens signed with retired keys keep working until they expire.
+	// If the token is valid, it returns the registered claims; otherwise, it returns apperror.ErrTokenExpired or
+	// apperror.ErrTokenInvalid.
 	ParseJWT(tokenString string) (*jwt.RegisteredClaims, error)
 
+	// JWKS returns the public keys used to sign tokens as a JSON Web Key Set, so that other services can verify them.
+	JWKS() (JWKSet, error)
+
 	// GenMiddleware generates a Fiber middleware for JWT authentication.
 	//
-	// The middleware validates the JWT token from the request's authorization cookie.
-	// If the token is valid, it sets the user ID in the context for further use.
-	GenMiddleware() fiber.Handler
+	// The middleware looks for the JWT token in an Authorization: Bearer header or in the authorization cookie, in
+	// the configured order of precedence, and checks that its session has not been revoked. If the token is valid, it
+	// sets the user ID, session ID and auth method in the context for further use, and periodically records when the
+	// session was last seen.
+	// Personal access tokens are only accepted if scopes are given, and must have all of them.
+	// Unsafe requests authenticated with the cookie must also send the CSRF token, see VerifyCSRF.
+	GenMiddleware(scopes ...string) fiber.Handler
 }
 
+const (
+	// AuthMethodBearer is the auth method of requests authenticated with an Authorization: Bearer header.
+	AuthMethodBearer = "bearer"
+	// AuthMethodCookie is the auth method of requests authenticated with the authorization cookie.
+	AuthMethodCookie = "cookie"
+	// AuthMethodAccessToken is the auth method of requests authenticated with a personal access token.
+	AuthMethodAccessToken = "access_token"
+)
+
 var (
-	ErrFailedToSignToken = fmt.Errorf("failed to sign token")
-	ErrInvalidToken      = fmt.Errorf("invalid token")
+	ErrFailedToSignToken = apperror.New(apperror.CodeInternal, "Failed to sign token")
+	ErrInvalidToken      = apperror.ErrTokenInvalid
 )
 
-type AuthService struct{}
+type AuthService struct {
+	// BreachedPasswords is the list of breached passwords to reject, which should be loaded from config at startup with
+	// LoadBreachedPasswords, defaults to the bundled list only if nil.
+	BreachedPasswords *BreachedPasswords
+
+	// KeyRing holds the keys used to sign and verify tokens, which should be loaded from config at startup with
+	// LoadKeyRing, defaults to loading them from config on first use if nil.
+	KeyRing *KeyRing
+
+	// Directory is where passwords are checked instead of the local hashes, which should be created from config at
+	// startup with NewLDAPDirectory for the ldap backend. Passwords are checked locally if nil.
+	Directory Directory
 
-// HashPassword hashes the given password using bcrypt.
+	// SessionService is used by the middleware to check that sessions have not been revoked.
+	SessionService ISessionService
+
+	// AccessTokenService is used by the middleware to authenticate personal access tokens, which are rejected if nil.
+	AccessTokenService IAccessTokenService
+}
+
+// HashPassword hashes the given password using the configured algorithm (argon2id or bcrypt).
+//
+// Argon2id hashes are encoded in the PHC string format, and bcrypt hashes in the modular crypt format, so that the
+// algorithm and parameters are recorded in the hash itself.
 //
-// Returns the hashed password or an error if hashing fails.
+// Returns the hashed password, apperror.ErrWeakPassword if the password is longer than MaxPasswordLength, or the 72
+// bytes that bcrypt can hash if it is configured, or an error if hashing fails.
 func (svc AuthService) HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err := checkPasswordMaxLength(password); err != nil {
+		return "", err
+	}
+
+	var hashedPassword string
+	var err error
+
+	switch algorithm := config.Get().PasswordHashAlgorithm; algorithm {
+	case HashAlgorithmArgon2id:
+		hashedPassword, err = hashArgon2id(password)
+	case HashAlgorithmBcrypt:
+		var hash []byte
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), config.Get().BcryptCost)
+		hashedPassword = string(hash)
+	default:
+		err = fmt.Errorf("unknown password hash algorithm %q", algorithm)
+	}
+
 	if err != nil {
 		slog.Error("Failed to hash password", slog.Any("error", err))
-		return "", err
+		return "", apperror.Internal(err)
 	}
 
-	return string(hashedPassword), nil
+	return hashedPassword, nil
 }
 
 // ComparePasswords compares a hashed password with a plaintext password.
 //
-// Returns an error if the passwords do not match.
+// The algorithm is detected from the hash, so both argon2id and bcrypt hashes are supported. Passwords longer than
+// MaxPasswordLength can't match any hash, and are rejected without hashing them.
+//
+// Returns apperror.ErrInvalidCredentials if the passwords do not match, or an internal error if the comparison fails.
 func (svc AuthService) ComparePasswords(hashedPassword, password string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	// No password that long could have been set, so don't spend a hash on it
+	if len(password) > MaxPasswordLength {
+		return apperror.ErrInvalidCredentials
+	}
+
+	var err error
+	switch hashAlgorithm(hashedPassword) {
+	case HashAlgorithmArgon2id:
+		err = compareArgon2id(hashedPassword, password)
+	case HashAlgorithmBcrypt:
+		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	default:
+		err = ErrUnknownHashFormat
+	}
+
 	if err != nil {
 		slog.Error("Password comparison failed", slog.Any("error", err))
+
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return apperror.ErrInvalidCredentials.WithCause(err)
+		}
+		return apperror.Internal(err)
 	}
 
-	return err
+	return nil
 }
 
-// GenerateJWT generates a JWT token for the given user ID.
+// NeedsRehash checks whether a hashed password was created with a different algorithm or weaker parameters than the
+// configured ones, and so should be rehashed the next time the plaintext password is available.
+func (svc AuthService) NeedsRehash(hashedPassword string) bool {
+	cfg := config.Get()
+
+	algorithm := hashAlgorithm(hashedPassword)
+	if algorithm != cfg.PasswordHashAlgorithm {
+		return true
+	}
+
+	switch algorithm {
+	case HashAlgorithmArgon2id:
+		hash, err := decodeArgon2id(hashedPassword)
+		return err != nil || hash.params != configuredArgon2Params()
+	case HashAlgorithmBcrypt:
+		cost, err := bcrypt.Cost([]byte(hashedPassword))
+		return err != nil || cost != cfg.BcryptCost
+	}
+
+	return true
+}
+
+// GenerateJWT generates a JWT token for the given user ID and session ID.
 //
-// The token is signed using the HS512 algorithm and includes the user's ID as the subject,
-// the current time as the issued-at claim, and an expiry time 24 hours from now.
+// The token is signed with the active key of the key ring, tagged with its ID in the kid header, and includes the
+// user's ID as the subject, the session ID as the JWT ID, the current time as the issued-at claim, and an expiry time
+// based on the configured access token TTL.
 //
 // Returns the signed JWT token string, the expiry time, or an error if signing fails.
-func (svc AuthService) GenerateJWT(id uint) (string, time.Time, error) {
-	expiry := time.Now().Add(24 * time.Hour)
+func (svc AuthService) GenerateJWT(id uint, sessionID string) (string, time.Time, error) {
+	expiry := time.Now().Add(config.Get().AccessTokenTTL)
+	ring, err := svc.keyRing()
+	if err != nil {
+		return "", expiry, err
+	}
+	key := ring.Active()
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, jwt.RegisteredClaims{
+	token := jwt.NewWithClaims(key.Method, jwt.RegisteredClaims{
+		ID:        sessionID,
 		Subject:   fmt.Sprintf("%d", id),
 		IssuedAt:  jwt.NewNumericDate(time.Now()),
 		ExpiresAt: jwt.NewNumericDate(expiry),
 	})
+	token.Header["kid"] = key.ID
 
-	signedToken, err := token.SignedString([]byte(config.Get().JWTSecret))
+	signedToken, err := token.SignedString(key.signKey)
 	if err != nil {
 		slog.Error("Failed to sign token", slog.Any("error", err))
 		return "", expiry, ErrFailedToSignToken
@@ -100,17 +233,46 @@ func (svc AuthService) GenerateJWT(id uint) (string, time.Time, error) {
 
 // ParseJWT parses and validates a JWT token string.
 //
-// It uses the configured JWT secret to validate the token signature.
-// If the token is valid, it returns the registered claims; otherwise, it returns an error.
+// It uses the key in the key ring matching the kid header of the token to validate the token signature, so that
+// tokens signed with retired keys keep working until they expire. Tokens without a kid header are validated with the
+// key derived from the JWT secret, since they were issued before keys were rotated.
+// If the token is valid, it returns the registered claims; otherwise, it returns apperror.ErrTokenExpired or
+// apperror.ErrTokenInvalid.
 func (svc AuthService) ParseJWT(tokenString string) (*jwt.RegisteredClaims, error) {
-	// keyFunc provides the secret key for validating the token signature.
-	keyFunc := func(t *jwt.Token) (any, error) { return []byte(config.Get().JWTSecret), nil }
+	ring, err := svc.keyRing()
+	if err != nil {
+		return nil, err
+	}
+
+	// keyFunc provides the key for validating the token signature.
+	keyFunc := func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		if kid == "" {
+			kid = legacyKeyID
+		}
+
+		key, ok := ring.Get(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key ID %q", kid)
+		}
+
+		// Only accept the algorithm of the key, so that a public key can't be passed off as an HMAC secret
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %q for key %q", t.Method.Alg(), kid)
+		}
+
+		return key.verifyKey, nil
+	}
 
 	// Parse the token with the expected claims structure.
 	token, err := jwt.ParseWithClaims(tokenString, &jwt.RegisteredClaims{}, keyFunc)
 	if err != nil {
 		slog.Error("Failed to parse JWT token", slog.Any("error", err))
-		return nil, err
+
+		if errors.Is(err, jwt.ErrTokenExpired) {
+			return nil, apperror.ErrTokenExpired.WithCause(err)
+		}
+		return nil, apperror.ErrTokenInvalid.WithCause(err)
 	}
 
 	// Assert the claims type and check token validity.
@@ -123,24 +285,146 @@ func (svc AuthService) ParseJWT(tokenString string) (*jwt.RegisteredClaims, erro
 	return claims, nil
 }
 
+// JWKS returns the public keys used to sign tokens as a JSON Web Key Set, so that other services can verify them.
+//
+// HMAC keys are never included, since they are secret.
+func (svc AuthService) JWKS() (JWKSet, error) {
+	ring, err := svc.keyRing()
+	if err != nil {
+		return JWKSet{}, err
+	}
+
+	return ring.JWKS(), nil
+}
+
 // GenMiddleware generates a Fiber middleware for JWT authentication.
 //
-// The middleware validates the JWT token from the request's authorization cookie.
-// If the token is valid, it sets the user ID in the context for further use.
-func (svc AuthService) GenMiddleware() fiber.Handler {
-	return keyauth.New(keyauth.Config{
-		Validator: func(c *fiber.Ctx, key string) (bool, error) {
-			// Parse the JWT token from the key
-			claims, err := svc.ParseJWT(key)
-			if err != nil {
-				return false, err // Return false if token parsing fails
+// The middleware looks for the JWT token in an Authorization: Bearer header or in the authorization cookie, in the
+// configured order of precedence, and checks that its session has not been revoked. If the token is valid, it sets the
+// user ID, session ID and auth method in the context for further use, and periodically records when the session was
+// last seen.
+//
+// Personal access tokens are only accepted if scopes are given, and must have all of them, so that routes have to opt
+// in to being used by automation. Requests authenticated with a session can use all routes.
+//
+// Unsafe requests authenticated with the cookie must also send the CSRF token, see VerifyCSRF.
+func (svc AuthService) GenMiddleware(scopes ...string) fiber.Handler {
+	if svc.SessionService == nil {
+		panic("AuthService.SessionService must be set to generate the auth middleware ^._.^")
+	}
+
+	return func(c *fiber.Ctx) error {
+		// Get the JWT token from the request
+		token, method := tokenFromRequest(c, config.Get().AuthTokenSources)
+		if token == "" {
+			return apperror.ErrUnauthorized
+		}
+
+		// Personal access tokens are handled separately, since they aren't JWTs. They are only accepted in the header,
+		// since a cookie holding one could be used for CSRF.
+		if strings.HasPrefix(token, AccessTokenPrefix) {
+			if method != AuthMethodBearer {
+				return apperror.ErrTokenInvalid
 			}
+			return svc.authenticateAccessToken(c, token, scopes)
+		}
 
-			// Set the user ID in the context for further use
-			c.Locals("userID", claims.Subject)
+		// Parse the JWT token
+		claims, err := svc.ParseJWT(token)
+		if err != nil {
+			return err
+		}
 
-			return true, nil // Return true if authentication is successful
-		},
-		KeyLookup: "cookie:authorization",
-	})
+		// Check that the session of the token is still active, so that revoked sessions stop working immediately
+		session, err := svc.SessionService.GetActive(claims.ID, nil)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprintf("%d", session.UserID) != claims.Subject {
+			return apperror.ErrTokenInvalid
+		}
+
+		// Browsers send cookies with cross-site requests, so cookie authenticated requests must prove where they came
+		// from. Bearer tokens have to be added explicitly, so they are exempt.
+		if method == AuthMethodCookie {
+			if err := VerifyCSRF(c); err != nil {
+				return err
+			}
+		}
+
+		// Update the last seen time of the session, throttled so that it doesn't write on every request
+		if time.Since(session.LastSeenAt) > config.Get().SessionLastSeenInterval {
+			// Failures are logged by the service, and shouldn't fail the request
+			_ = svc.SessionService.Touch(session.ID, ClientInfoFromCtx(c), nil)
+		}
+
+		// Set the user ID, session ID and auth method in the context for further use
+		c.Locals("userID", claims.Subject)
+		c.Locals("sessionID", claims.ID)
+		c.Locals("authMethod", method)
+
+		return c.Next()
+	}
+}
+
+// authenticateAccessToken authenticates a request with a personal access token, checking that it has all the given
+// scopes.
+func (svc AuthService) authenticateAccessToken(c *fiber.Ctx, token string, scopes []string) error {
+	if svc.AccessTokenService == nil {
+		return apperror.ErrTokenInvalid
+	}
+
+	accessToken, err := svc.AccessTokenService.Authenticate(token, nil)
+	if err != nil {
+		return err
+	}
+
+	// Routes that don't list any scopes can't be used with personal access tokens at all
+	if len(scopes) == 0 {
+		return apperror.ErrInsufficientScope.WithDetails(apperror.FieldError{
+			Field:   "scopes",
+			Message: "this route requires logging in, and can't be used with a personal access token",
+		})
+	}
+	if !accessToken.Scopes.Has(scopes...) {
+		return apperror.ErrInsufficientScope.WithDetails(apperror.FieldError{
+			Field:   "scopes",
+			Message: fmt.Sprintf("must include %s", strings.Join(scopes, " ")),
+		})
+	}
+
+	// Set the user ID, scopes and auth method in the context for further use
+	c.Locals("userID", fmt.Sprintf("%d", accessToken.UserID))
+	c.Locals("scopes", []string(accessToken.Scopes))
+	c.Locals("authMethod", AuthMethodAccessToken)
+
+	return c.Next()
+}
+
+// tokenFromRequest returns the first access token found in the given sources ("header" or "cookie") of the request,
+// along with the auth method it was found with.
+//
+// Authorization headers that don't use the Bearer scheme are ignored, so that they can be used by proxies.
+func tokenFromRequest(c *fiber.Ctx, sources []string) (string, string) {
+	for _, source := range sources {
+		switch strings.TrimSpace(source) {
+		case "header":
+			scheme, token, ok := strings.Cut(strings.TrimSpace(c.Get(fiber.HeaderAuthorization)), " ")
+			if token = strings.TrimSpace(token); ok && strings.EqualFold(scheme, "Bearer") && token != "" {
+				return token, AuthMethodBearer
+			}
+		case "cookie":
+			if token := c.Cookies("authorization"); token != "" {
+				return token, AuthMethodCookie
+			}
+		}
+	}
+
+	return "", ""
+}
+
+// ClientInfoFromCtx extracts the details of the client of a session from the request. The IP address is read from the
+// PROXY_HEADER of requests from the TRUSTED_PROXIES, and is the address of the connection otherwise.
+func ClientInfoFromCtx(c *fiber.Ctx) ClientInfo {
+	return ClientInfo{IPAddress: c.IP(), UserAgent: c.Get(fiber.HeaderUserAgent)}
 }
@@ -2,13 +2,20 @@ package service_test
 
 import (
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"notes-app/apperror"
 	"notes-app/config"
+	"notes-app/models"
 	"notes-app/service"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
@@ -23,13 +30,24 @@ func TestHashPassword(t *testing.T) {
 	assert.NotEmpty(t, hashedPassword)
 	slog.Debug("Hashed password", slog.String("hash", hashedPassword))
 
+	// The hash should record the algorithm and parameters in the PHC string format
+	cfg := config.Get()
+	assert.True(t, strings.HasPrefix(hashedPassword, fmt.Sprintf(
+		"$argon2id$v=19$m=%d,t=%d,p=%d$", cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism,
+	)))
+
 	// Compare the hashed password
-	errCompare := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	errCompare := service.AuthService{}.ComparePasswords(hashedPassword, password)
 	assert.NoError(t, errCompare)
 
 	// Compare with an incorrect password
-	errIncorrectCompare := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte("wrongpassword"))
-	assert.Error(t, errIncorrectCompare)
+	errIncorrectCompare := service.AuthService{}.ComparePasswords(hashedPassword, "wrongpassword")
+	assert.ErrorIs(t, errIncorrectCompare, apperror.ErrInvalidCredentials)
+
+	// Hashing the same password again should use a different salt
+	otherHashedPassword, errHash := service.AuthService{}.HashPassword(password)
+	assert.NoError(t, errHash)
+	assert.NotEqual(t, hashedPassword, otherHashedPassword)
 }
 
 func TestComparePassword(t *testing.T) {
@@ -50,10 +68,30 @@ func TestComparePassword(t *testing.T) {
 	assert.Error(t, errIncorrectCompare)
 }
 
+func TestNeedsRehash(t *testing.T) {
+	cfg := config.Get()
+
+	// Generate hashes with the current and outdated parameters
+	currentHash, err := service.AuthService{}.HashPassword("password")
+	assert.NoError(t, err)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	weakArgon2Hash := fmt.Sprintf(
+		"$argon2id$v=19$m=%d,t=1,p=%d$c2FsdHNhbHRzYWx0c2FsdA$aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g",
+		cfg.Argon2Memory, cfg.Argon2Parallelism,
+	)
+
+	assert.False(t, service.AuthService{}.NeedsRehash(currentHash))
+	assert.True(t, service.AuthService{}.NeedsRehash(string(bcryptHash)))
+	assert.True(t, service.AuthService{}.NeedsRehash(weakArgon2Hash))
+	assert.True(t, service.AuthService{}.NeedsRehash("not-a-hash"))
+}
+
 func TestGenerateJWT(t *testing.T) {
 	userID := uint(1)
+	sessionID := "session-1"
 
-	token, expiry, err := service.AuthService{}.GenerateJWT(userID)
+	token, expiry, err := service.AuthService{}.GenerateJWT(userID, sessionID)
 	assert.NoError(t, err)
 	assert.NotEmpty(t, token)
 	slog.Debug("Generated JWT token", slog.String("token", token), slog.Time("expiry", expiry))
@@ -71,6 +109,7 @@ func TestGenerateJWT(t *testing.T) {
 	}
 
 	assert.Equal(t, strconv.FormatUint(uint64(userID), 10), claims.Subject)
+	assert.Equal(t, sessionID, claims.ID)
 	assert.Equal(t, expiry.Unix(), claims.ExpiresAt.Unix())
 }
 
@@ -99,3 +138,221 @@ func TestParseJWT(t *testing.T) {
 
 	assert.Equal(t, strconv.FormatUint(uint64(userID), 10), claims.Subject)
 }
+
+// stubSessionService is a session service where every session is active, and belongs to the user with the same ID.
+type stubSessionService struct {
+	service.ISessionService
+}
+
+func (svc stubSessionService) GetActive(id string, opts *service.DBOpts) (models.Session, error) {
+	userID, err := strconv.ParseUint(id, 10, 0)
+	if err != nil {
+		return models.Session{}, apperror.ErrSessionRevoked
+	}
+	return models.Session{ID: id, UserID: uint(userID), LastSeenAt: time.Now()}, nil
+}
+
+func TestGenMiddleware(t *testing.T) {
+	authService := service.AuthService{SessionService: stubSessionService{}}
+
+	// Generate tokens for two different users, one for the header and one for the cookie
+	headerToken, _, err := authService.GenerateJWT(1, "1")
+	assert.NoError(t, err)
+	cookieToken, _, err := authService.GenerateJWT(2, "2")
+	assert.NoError(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: func(c *fiber.Ctx, err error) error {
+		return c.Status(apperror.From(err).Status()).SendString(string(apperror.From(err).Code))
+	}})
+	app.Get("/", authService.GenMiddleware(), func(c *fiber.Ctx) error {
+		return c.SendString(fmt.Sprintf("%s:%s", c.Locals("userID"), c.Locals("authMethod")))
+	})
+
+	type testCase struct {
+		sources       []string
+		authorization string
+		cookie        string
+		status        int
+		body          string
+	}
+
+	testCases := map[string]testCase{
+		"bearer header":        {authorization: "Bearer " + headerToken, status: 200, body: "1:bearer"},
+		"lowercase scheme":     {authorization: "bearer " + headerToken, status: 200, body: "1:bearer"},
+		"cookie":               {cookie: cookieToken, status: 200, body: "2:cookie"},
+		"header before cookie": {authorization: "Bearer " + headerToken, cookie: cookieToken, status: 200, body: "1:bearer"},
+		"cookie before header": {sources: []string{"cookie", "header"}, authorization: "Bearer " + headerToken, cookie: cookieToken, status: 200, body: "2:cookie"},
+		"header disabled":      {sources: []string{"cookie"}, authorization: "Bearer " + headerToken, status: 401, body: "UNAUTHORIZED"},
+		"other scheme":         {authorization: "Basic dXNlcjpwYXNz", cookie: cookieToken, status: 200, body: "2:cookie"},
+		"invalid bearer token": {authorization: "Bearer invalid", cookie: cookieToken, status: 401, body: "TOKEN_INVALID"},
+		"missing token":        {status: 401, body: "UNAUTHORIZED"},
+		"empty bearer header":  {authorization: "Bearer ", status: 401, body: "UNAUTHORIZED"},
+		"malformed cookie":     {cookie: "not-a-jwt", status: 401, body: "TOKEN_INVALID"},
+	}
+
+	cfg := config.Get()
+	defaultSources := cfg.AuthTokenSources
+	defer func() { cfg.AuthTokenSources = defaultSources }()
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg.AuthTokenSources = defaultSources
+			if tc.sources != nil {
+				cfg.AuthTokenSources = tc.sources
+			}
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.authorization != "" {
+				request.Header.Set("Authorization", tc.authorization)
+			}
+			if tc.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: "authorization", Value: tc.cookie})
+			}
+
+			response, err := app.Test(request)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, response.StatusCode)
+			assert.Equal(t, tc.body, string(body))
+		})
+	}
+}
+
+// stubAccessTokenService is an access token service that only knows the tokens in the map.
+type stubAccessTokenService struct {
+	service.IAccessTokenService
+	tokens map[string]models.PersonalAccessToken
+}
+
+func (svc stubAccessTokenService) Authenticate(token string, opts *service.DBOpts) (models.PersonalAccessToken, error) {
+	accessToken, ok := svc.tokens[token]
+	if !ok {
+		return accessToken, apperror.ErrTokenInvalid
+	}
+	return accessToken, nil
+}
+
+func TestGenMiddlewareAccessToken(t *testing.T) {
+	readToken := service.AccessTokenPrefix + "read"
+	authService := service.AuthService{
+		SessionService: stubSessionService{},
+		AccessTokenService: stubAccessTokenService{tokens: map[string]models.PersonalAccessToken{
+			readToken: {UserID: 3, Scopes: models.Scopes{service.ScopeNotesRead}},
+		}},
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: func(c *fiber.Ctx, err error) error {
+		return c.Status(apperror.From(err).Status()).SendString(string(apperror.From(err).Code))
+	}})
+	handler := func(c *fiber.Ctx) error {
+		return c.SendString(fmt.Sprintf("%s:%s", c.Locals("userID"), c.Locals("authMethod")))
+	}
+	app.Get("/session-only", authService.GenMiddleware(), handler)
+	app.Get("/read", authService.GenMiddleware(service.ScopeNotesRead), handler)
+	app.Get("/write", authService.GenMiddleware(service.ScopeNotesRead, service.ScopeNotesWrite), handler)
+
+	type testCase struct {
+		path   string
+		token  string
+		status int
+		body   string
+	}
+
+	testCases := map[string]testCase{
+		"route with scope":         {path: "/read", token: readToken, status: 200, body: "3:access_token"},
+		"route missing a scope":    {path: "/write", token: readToken, status: 403, body: "INSUFFICIENT_SCOPE"},
+		"route without scopes":     {path: "/session-only", token: readToken, status: 403, body: "INSUFFICIENT_SCOPE"},
+		"unknown token":            {path: "/read", token: service.AccessTokenPrefix + "nosuchtoken", status: 401, body: "TOKEN_INVALID"},
+		"session on scoped route":  {path: "/write", status: 200, body: "1:bearer"},
+		"session on session route": {path: "/session-only", status: 200, body: "1:bearer"},
+	}
+
+	sessionToken, _, err := authService.GenerateJWT(1, "1")
+	assert.NoError(t, err)
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			token := tc.token
+			if token == "" {
+				token = sessionToken
+			}
+
+			request := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			request.Header.Set("Authorization", "Bearer "+token)
+
+			response, err := app.Test(request)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer response.Body.Close()
+
+			body, err := io.ReadAll(response.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.status, response.StatusCode)
+			assert.Equal(t, tc.body, string(body))
+		})
+	}
+}
+
+func TestGenMiddlewareCSRF(t *testing.T) {
+	authService := service.AuthService{SessionService: stubSessionService{}}
+
+	token, _, err := authService.GenerateJWT(1, "1")
+	assert.NoError(t, err)
+
+	app := fiber.New(fiber.Config{ErrorHandler: func(c *fiber.Ctx, err error) error {
+		return c.Status(apperror.From(err).Status()).SendString(string(apperror.From(err).Code))
+	}})
+	app.All("/", authService.GenMiddleware(), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	type testCase struct {
+		method     string
+		bearer     bool
+		csrfCookie string
+		csrfHeader string
+		status     int
+	}
+
+	testCases := map[string]testCase{
+		"safe method":            {method: http.MethodGet, status: 200},
+		"matching token":         {method: http.MethodPost, csrfCookie: "csrf", csrfHeader: "csrf", status: 200},
+		"missing token":          {method: http.MethodPost, status: 403},
+		"missing header":         {method: http.MethodDelete, csrfCookie: "csrf", status: 403},
+		"mismatched token":       {method: http.MethodPut, csrfCookie: "csrf", csrfHeader: "other", status: 403},
+		"header without cookie":  {method: http.MethodPatch, csrfHeader: "csrf", status: 403},
+		"bearer token is exempt": {method: http.MethodPost, bearer: true, status: 200},
+		"bearer with bad csrf":   {method: http.MethodPost, bearer: true, csrfCookie: "csrf", csrfHeader: "other", status: 200},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(tc.method, "/", nil)
+			if tc.bearer {
+				request.Header.Set("Authorization", "Bearer "+token)
+			} else {
+				request.AddCookie(&http.Cookie{Name: "authorization", Value: token})
+			}
+			if tc.csrfCookie != "" {
+				request.AddCookie(&http.Cookie{Name: service.CSRFCookieName, Value: tc.csrfCookie})
+			}
+			if tc.csrfHeader != "" {
+				request.Header.Set(service.CSRFHeaderName, tc.csrfHeader)
+			}
+
+			response, err := app.Test(request)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer response.Body.Close()
+
+			assert.Equal(t, tc.status, response.StatusCode)
+		})
+	}
+}
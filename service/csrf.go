@@ -0,0 +1,41 @@
+package service
+
+import (
+	"crypto/subtle"
+	"notes-app/apperror"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// CSRFCookieName is the name of the cookie holding the CSRF token. Unlike the session cookies, it is readable by
+	// scripts, so that the frontend can echo it back in the CSRF header.
+	CSRFCookieName = "csrf_token"
+	// CSRFHeaderName is the header that cookie authenticated requests must send the CSRF token in.
+	CSRFHeaderName = "X-CSRF-Token"
+)
+
+// GenerateCSRFToken generates a random token for the CSRF cookie.
+func GenerateCSRFToken() (string, error) {
+	return generateToken()
+}
+
+// VerifyCSRF checks the CSRF token of a request that is authenticated with cookies, using the double submit pattern.
+//
+// Other sites can make the browser send cookies, but can't read them, so a CSRF header matching the CSRF cookie proves
+// that the request was made by our frontend. Safe methods are not checked, since they must not change anything.
+//
+// Returns apperror.ErrCSRFTokenInvalid if the token is missing or doesn't match.
+func VerifyCSRF(c *fiber.Ctx) error {
+	switch c.Method() {
+	case fiber.MethodGet, fiber.MethodHead, fiber.MethodOptions, fiber.MethodTrace:
+		return nil
+	}
+
+	cookie, header := c.Cookies(CSRFCookieName), c.Get(CSRFHeaderName)
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) != 1 {
+		return apperror.ErrCSRFTokenInvalid
+	}
+
+	return nil
+}
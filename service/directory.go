@@ -0,0 +1,33 @@
+package service
+
+// Directory checks passwords against an external directory of users, like LDAP or Active Directory, instead of the
+// password hashes stored locally.
+type Directory interface {
+	// Authenticate checks the password of the user with the given email in the directory.
+	// Returns the user's details in the directory, apperror.ErrInvalidCredentials if there is no such user or the
+	// password is incorrect, or apperror.ErrDirectoryUnavailable if the directory can't be reached.
+	Authenticate(email, password string) (DirectoryUser, error)
+}
+
+// DirectoryUser is a user as found in a Directory, used to provision and update the local user.
+type DirectoryUser struct {
+	Email string
+	Name  string
+	// Role is the role that the user's groups in the directory map to.
+	Role string
+}
+
+// DirectoryEnabled checks whether passwords are checked against a directory, instead of the local password hashes.
+func (svc AuthService) DirectoryEnabled() bool {
+	return svc.Directory != nil
+}
+
+// AuthenticateDirectory checks the password of the user with the given email in the directory.
+//
+// Must only be called if DirectoryEnabled.
+//
+// Returns the user's details in the directory, apperror.ErrInvalidCredentials if there is no such user or the
+// password is incorrect, or apperror.ErrDirectoryUnavailable if the directory can't be reached.
+func (svc AuthService) AuthenticateDirectory(email, password string) (DirectoryUser, error) {
+	return svc.Directory.Authenticate(email, password)
+}
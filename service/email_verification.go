@@ -0,0 +1,130 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/mailer"
+	"notes-app/models"
+	"notes-app/utils"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// errNoMailer is returned when sending an email with a service that has no mailer set.
+var errNoMailer = errors.New("no mailer is set")
+
+// sendEmail sends the message with the given mailer.
+//
+// Returns errNoMailer if the mailer is nil, so that a service that was set up without one fails like any other broken
+// transport would.
+func sendEmail(m mailer.Mailer, msg mailer.Message) error {
+	if m == nil {
+		return errNoMailer
+	}
+
+	return m.Send(msg)
+}
+
+// appLink builds a link to the given path of the app, with the token in the query.
+func appLink(path, token string) string {
+	return fmt.Sprintf("%s%s?token=%s", strings.TrimSuffix(config.Get().AppURL, "/"), path, url.QueryEscape(token))
+}
+
+// SendVerificationEmail sends the user a link to verify their email, replacing any link sent before.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrEmailAlreadyVerified if the user's email is already verified.
+func (svc UserService) SendVerificationEmail(user models.User, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	if user.EmailVerified {
+		return apperror.ErrEmailAlreadyVerified
+	}
+
+	ttl := config.Get().EmailVerificationTTL
+	token, err := issueOneTimeToken(db, user, tokenPurposeEmailVerification, ttl)
+	if err != nil {
+		return err
+	}
+
+	err = sendEmail(svc.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"Please verify your email address by opening this link:\n\n%s\n\n"+
+				"The link expires in %s. If you didn't create an account, you can ignore this email.\n",
+			user.Name, appLink("/verify-email", token), ttl,
+		),
+	})
+	if err != nil {
+		slog.Error("Failed to send verification email", slog.Any("error", err), slog.Any("userID", user.ID))
+		return apperror.Internal(err)
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user that the verification token was sent to as verified.
+//
+// The token can only be used once, and only while the user still has the email it was sent to.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the verified user, or apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the token can't be used.
+func (svc UserService) VerifyEmail(token string, opts *DBOpts) (models.User, error) {
+	db := svc.getDB(opts)
+
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		oneTimeToken, err := consumeOneTimeToken(tx, token, tokenPurposeEmailVerification)
+		if err != nil {
+			return err
+		}
+
+		user, err = svc.GetByID(oneTimeToken.UserID, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+		if user.Email != oneTimeToken.Email {
+			return apperror.ErrTokenInvalid
+		}
+
+		if err := tx.Model(&user).Update("email_verified", true).Error; err != nil {
+			slog.Error("Failed to verify email", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+
+	return user, err
+}
+
+// GenVerifiedEmailMiddleware generates a Fiber middleware that only lets users with a verified email through, for
+// actions that could be abused with throwaway accounts, like sharing notes.
+//
+// It must be used after the auth middleware.
+func (svc UserService) GenVerifiedEmailMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID, err := utils.GetUserID(c)
+		if err != nil {
+			return err
+		}
+
+		user, err := svc.GetByID(userID, nil)
+		if err != nil {
+			return err
+		}
+		if !user.EmailVerified {
+			return apperror.ErrEmailNotVerified
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,346 @@
+package service
+
+import (
+	"errors"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GroupMember is a member of a group, along with the details that anyone who can see the group can see.
+type GroupMember struct {
+	UserID    uint      `json:"user_id"`
+	Name      string    `json:"name"`
+	Username  *string   `json:"username"`
+	AvatarURL string    `json:"avatar_url"`
+	AddedAt   time.Time `json:"added_at"`
+}
+
+type IGroupService interface {
+	// Create creates a group, belonging to the organization with the given ID, or to the user if it is nil. Only admins
+	// and owners can create groups in an organization.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the group, apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+	Create(userID uint, orgID *uint, name string, opts *DBOpts) (models.UserGroup, error)
+
+	// List lists the groups of the organization with the given ID, or of the user if it is nil, sorted by name.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+	List(userID uint, orgID *uint, opts *DBOpts) ([]models.UserGroup, error)
+
+	// Get retrieves a group that the user with the given ID can see.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrGroupNotFound if there is no such group, or the user can't see it.
+	Get(userID, groupID uint, opts *DBOpts) (models.UserGroup, error)
+
+	// Rename changes the name of a group that the user with the given ID manages.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the renamed group, apperror.ErrGroupNotFound or apperror.ErrInsufficientRole.
+	Rename(userID, groupID uint, name string, opts *DBOpts) (models.UserGroup, error)
+
+	// Delete deletes a group that the user with the given ID manages, along with its memberships and the notes shared
+	// with it.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrGroupNotFound or apperror.ErrInsufficientRole.
+	Delete(userID, groupID uint, opts *DBOpts) error
+
+	// ListMembers lists the members of a group that the user with the given ID can see, sorted by name.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrGroupNotFound or apperror.ErrInsufficientRole.
+	ListMembers(userID, groupID uint, opts *DBOpts) ([]GroupMember, error)
+
+	// AddMember adds a user to a group that the user with the given ID manages. Adding a member again does nothing.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrGroupNotFound, apperror.ErrInsufficientRole, apperror.ErrUserNotFound if the user can't be
+	// found by the owner of a personal group, or apperror.ErrMemberNotFound if they aren't a member of the organization
+	// that the group belongs to.
+	AddMember(userID, groupID, memberID uint, opts *DBOpts) error
+
+	// RemoveMember removes a user from a group that the user with the given ID manages. Users can always remove
+	// themselves from a group.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrGroupNotFound, apperror.ErrInsufficientRole or apperror.ErrMemberNotFound.
+	RemoveMember(userID, groupID, memberID uint, opts *DBOpts) error
+}
+
+type GroupService struct {
+	Service
+
+	// OrganizationService is used to check the roles of users in the organizations that groups belong to.
+	OrganizationService IOrganizationService
+}
+
+// Create creates a group, belonging to the organization with the given ID, or to the user if it is nil. Only admins and
+// owners can create groups in an organization.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the group, apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+func (svc GroupService) Create(userID uint, orgID *uint, name string, opts *DBOpts) (models.UserGroup, error) {
+	db := svc.getDB(opts)
+
+	group := models.UserGroup{Name: name, OrganizationID: orgID}
+	if orgID == nil {
+		group.OwnerID = &userID
+	} else if _, err := svc.organizationService().RequireRole(userID, *orgID, models.OrgRoleAdmin, opts); err != nil {
+		return models.UserGroup{}, err
+	}
+
+	if err := db.Create(&group).Error; err != nil {
+		slog.Error("Failed to create group", slog.Any("error", err))
+		return models.UserGroup{}, apperror.Internal(err)
+	}
+
+	return group, nil
+}
+
+// List lists the groups of the organization with the given ID, or of the user if it is nil, sorted by name.
+//
+// Guests of an organization can't see its groups, same as its members.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+func (svc GroupService) List(userID uint, orgID *uint, opts *DBOpts) ([]models.UserGroup, error) {
+	db := svc.getDB(opts)
+
+	if orgID == nil {
+		db = db.Where("owner_id = ?", userID)
+	} else {
+		if _, err := svc.organizationService().RequireRole(userID, *orgID, models.OrgRoleMember, opts); err != nil {
+			return nil, err
+		}
+		db = db.Where("organization_id = ?", *orgID)
+	}
+
+	groups := make([]models.UserGroup, 0)
+	if err := db.Order("name, id").Find(&groups).Error; err != nil {
+		slog.Error("Failed to list groups", slog.Any("error", err))
+		return nil, apperror.Internal(err)
+	}
+
+	return groups, nil
+}
+
+// Get retrieves a group that the user with the given ID can see.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrGroupNotFound if there is no such group, or the user can't see it.
+func (svc GroupService) Get(userID, groupID uint, opts *DBOpts) (models.UserGroup, error) {
+	return svc.findGroup(svc.getDB(opts), userID, groupID, false)
+}
+
+// Rename changes the name of a group that the user with the given ID manages.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the renamed group, apperror.ErrGroupNotFound or apperror.ErrInsufficientRole.
+func (svc GroupService) Rename(userID, groupID uint, name string, opts *DBOpts) (models.UserGroup, error) {
+	db := svc.getDB(opts)
+
+	group, err := svc.findGroup(db, userID, groupID, true)
+	if err != nil {
+		return models.UserGroup{}, err
+	}
+
+	if err := db.Model(&group).Update("name", name).Error; err != nil {
+		slog.Error("Failed to rename group", slog.Any("error", err))
+		return models.UserGroup{}, apperror.Internal(err)
+	}
+
+	return group, nil
+}
+
+// Delete deletes a group that the user with the given ID manages, along with its memberships and the notes shared with
+// it.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrGroupNotFound or apperror.ErrInsufficientRole.
+func (svc GroupService) Delete(userID, groupID uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	group, err := svc.findGroup(db, userID, groupID, true)
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return deleteGroups(tx, tx.Model(&models.UserGroup{}).Select("id").Where("id = ?", group.ID))
+	})
+	if err != nil {
+		slog.Error("Failed to delete group", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+
+	return nil
+}
+
+// ListMembers lists the members of a group that the user with the given ID can see, sorted by name.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrGroupNotFound or apperror.ErrInsufficientRole.
+func (svc GroupService) ListMembers(userID, groupID uint, opts *DBOpts) ([]GroupMember, error) {
+	db := svc.getDB(opts)
+
+	if _, err := svc.findGroup(db, userID, groupID, false); err != nil {
+		return nil, err
+	}
+
+	members := make([]GroupMember, 0)
+	result := db.Table("user_group_members").
+		Select("users.id AS user_id, users.name, users.username, users.avatar_url, "+
+			"user_group_members.created_at AS added_at").
+		Joins("JOIN users ON users.id = user_group_members.user_id AND users.deleted_at IS NULL").
+		Where("user_group_members.group_id = ?", groupID).
+		Order("users.name, users.id").
+		Scan(&members)
+	if result.Error != nil {
+		slog.Error("Failed to list group members", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	return members, nil
+}
+
+// AddMember adds a user to a group that the user with the given ID manages. Adding a member again does nothing.
+//
+// Personal groups can only have users that their owner can find, the same as when searching for users, so that groups
+// can't be used to find out who has an account. Groups of an organization can only have its members, so that nothing
+// shared with a group reaches outside the organization.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrGroupNotFound, apperror.ErrInsufficientRole, apperror.ErrUserNotFound if the user can't be
+// found by the owner of a personal group, or apperror.ErrMemberNotFound if they aren't a member of the organization
+// that the group belongs to.
+func (svc GroupService) AddMember(userID, groupID, memberID uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		group, err := svc.findGroup(tx, userID, groupID, true)
+		if err != nil {
+			return err
+		}
+
+		if group.OrganizationID != nil {
+			if _, err := findMember(tx, *group.OrganizationID, memberID, false); err != nil {
+				return err
+			}
+		} else if memberID != userID {
+			var findable int64
+			if err := findableBy(tx, userID).Where("users.id = ?", memberID).Count(&findable).Error; err != nil {
+				slog.Error("Failed to check user", slog.Any("error", err))
+				return apperror.Internal(err)
+			}
+			if findable == 0 {
+				return apperror.ErrUserNotFound
+			}
+		}
+
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.UserGroupMember{GroupID: group.ID, UserID: memberID})
+		if result.Error != nil {
+			slog.Error("Failed to add group member", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		return nil
+	})
+}
+
+// RemoveMember removes a user from a group that the user with the given ID manages. Users can always remove themselves
+// from a group, even one they can't see.
+//
+// The user loses whatever was shared with the group right away, since access through groups is resolved whenever it
+// is checked.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrGroupNotFound, apperror.ErrInsufficientRole or apperror.ErrMemberNotFound.
+func (svc GroupService) RemoveMember(userID, groupID, memberID uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	notFound := apperror.ErrGroupNotFound
+	if memberID != userID {
+		if _, err := svc.findGroup(db, userID, groupID, true); err != nil {
+			return err
+		}
+		notFound = apperror.ErrMemberNotFound
+	}
+
+	result := db.Where("group_id = ? AND user_id = ?", groupID, memberID).Delete(&models.UserGroupMember{})
+	if result.Error != nil {
+		slog.Error("Failed to remove group member", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return notFound
+	}
+
+	return nil
+}
+
+// findGroup retrieves a group that the user can see, checking that they can manage it if manage is set.
+//
+// Personal groups can only be seen and managed by their owner. Groups of an organization can be seen by its members,
+// and managed by its admins and owners. Groups that the user can't see are reported as not found.
+func (svc GroupService) findGroup(db *gorm.DB, userID, groupID uint, manage bool) (models.UserGroup, error) {
+	var group models.UserGroup
+	result := db.Where("id = ?", groupID).First(&group)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return models.UserGroup{}, apperror.ErrGroupNotFound
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch group", slog.Any("error", result.Error))
+		return models.UserGroup{}, apperror.Internal(result.Error)
+	}
+
+	if group.OrganizationID == nil {
+		if group.OwnerID == nil || *group.OwnerID != userID {
+			return models.UserGroup{}, apperror.ErrGroupNotFound
+		}
+		return group, nil
+	}
+
+	minRole := models.OrgRoleMember
+	if manage {
+		minRole = models.OrgRoleAdmin
+	}
+	_, err := svc.organizationService().RequireRole(userID, *group.OrganizationID, minRole, &DBOpts{db: db})
+	if errors.Is(err, apperror.ErrOrganizationNotFound) {
+		return models.UserGroup{}, apperror.ErrGroupNotFound
+	} else if err != nil {
+		return models.UserGroup{}, err
+	}
+
+	return group, nil
+}
+
+// organizationService returns the organization service, defaulting to one using the same DB.
+func (svc GroupService) organizationService() IOrganizationService {
+	if svc.OrganizationService != nil {
+		return svc.OrganizationService
+	}
+	return OrganizationService{Service: svc.Service}
+}
+
+// deleteGroups deletes the groups with the IDs selected by the given query, along with their memberships and the notes
+// shared with them.
+func deleteGroups(tx *gorm.DB, groupIDs *gorm.DB) error {
+	if err := tx.Where("group_id IN (?)", groupIDs).Delete(&models.UserGroupMember{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("group_id IN (?)", groupIDs).Delete(&models.NoteGroupShare{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("id IN (?)", groupIDs).Delete(&models.UserGroup{}).Error
+}
+
+// leaveGroups removes the user from all groups, and deletes the groups that belong to them, when their account is
+// deleted.
+func leaveGroups(tx *gorm.DB, userID uint) error {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.UserGroupMember{}).Error; err != nil {
+		return err
+	}
+
+	return deleteGroups(tx, tx.Model(&models.UserGroup{}).Select("id").Where("owner_id = ?", userID))
+}
@@ -0,0 +1,216 @@
+package service_test
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type GroupServiceTestSuite struct {
+	suite.Suite
+	dbService           database.Service
+	userService         service.UserService
+	organizationService service.OrganizationService
+	groupService        service.GroupService
+	mailer              *recordingMailer
+}
+
+func (suite *GroupServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the service instances to use for testing
+	suite.mailer = &recordingMailer{}
+	suite.userService = service.UserService{
+		Service:     service.Service{DBService: suite.dbService},
+		AuthService: service.AuthService{},
+		Mailer:      suite.mailer,
+		SessionService: service.SessionService{
+			Service:     service.Service{DBService: suite.dbService},
+			AuthService: service.AuthService{},
+		},
+	}
+	suite.organizationService = service.OrganizationService{
+		Service: service.Service{DBService: suite.dbService},
+		Mailer:  suite.mailer,
+	}
+	suite.groupService = service.GroupService{
+		Service:             service.Service{DBService: suite.dbService},
+		OrganizationService: suite.organizationService,
+	}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *GroupServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+	suite.mailer.messages = nil
+
+	slog.Debug("Setup test")
+}
+
+// createUser creates a user with the given name and email, which is already verified.
+func (suite *GroupServiceTestSuite) createUser(name, email string) models.User {
+	user := models.User{Name: name, Email: email, Password: "correct horse battery stapler"}
+	suite.Require().NoError(suite.userService.Create(&user, nil))
+	suite.Require().NoError(suite.dbService.GetDB().Model(&user).Update("email_verified", true).Error)
+
+	return user
+}
+
+// join invites the user to the organization with the given role, and accepts the invitation as them.
+func (suite *GroupServiceTestSuite) join(inviterID, orgID uint, user models.User, role string) {
+	_, err := suite.organizationService.Invite(inviterID, orgID, user.Email, role, nil)
+	suite.Require().NoError(err)
+
+	_, err = suite.organizationService.AcceptInvitation(user.ID, suite.mailer.lastToken(), nil)
+	suite.Require().NoError(err)
+}
+
+func (suite *GroupServiceTestSuite) TestPersonalGroup() {
+	svc := suite.groupService
+	john := suite.createUser("John Doe", "john.doe@example.com")
+	jane := suite.createUser("Jane Doe", "jane.doe@example.com")
+	mallory := suite.createUser("Mallory", "mallory@example.com")
+
+	group, err := svc.Create(john.ID, nil, "Book club", nil)
+	suite.NoError(err)
+	suite.Equal(john.ID, *group.OwnerID)
+
+	// Only users that the owner could find by searching can be added
+	suite.ErrorIs(svc.AddMember(john.ID, group.ID, jane.ID, nil), apperror.ErrUserNotFound)
+	suite.NoError(suite.dbService.GetDB().Model(&jane).Update("discoverable", true).Error)
+	suite.NoError(svc.AddMember(john.ID, group.ID, jane.ID, nil))
+	suite.NoError(svc.AddMember(john.ID, group.ID, jane.ID, nil))
+	suite.NoError(svc.AddMember(john.ID, group.ID, john.ID, nil))
+
+	members, err := svc.ListMembers(john.ID, group.ID, nil)
+	suite.NoError(err)
+	suite.Len(members, 2)
+
+	// Nobody else can see the group, not even its members
+	for _, user := range []models.User{jane, mallory} {
+		_, err = svc.Get(user.ID, group.ID, nil)
+		suite.ErrorIs(err, apperror.ErrGroupNotFound)
+		suite.ErrorIs(svc.AddMember(user.ID, group.ID, user.ID, nil), apperror.ErrGroupNotFound)
+		suite.ErrorIs(svc.Delete(user.ID, group.ID, nil), apperror.ErrGroupNotFound)
+
+		groups, err := svc.List(user.ID, nil, nil)
+		suite.NoError(err)
+		suite.Empty(groups)
+	}
+
+	// Members can still leave the group, and others can't remove them
+	suite.ErrorIs(svc.RemoveMember(mallory.ID, group.ID, jane.ID, nil), apperror.ErrGroupNotFound)
+	suite.NoError(svc.RemoveMember(jane.ID, group.ID, jane.ID, nil))
+	suite.ErrorIs(svc.RemoveMember(jane.ID, group.ID, jane.ID, nil), apperror.ErrGroupNotFound)
+	suite.ErrorIs(svc.RemoveMember(john.ID, group.ID, jane.ID, nil), apperror.ErrMemberNotFound)
+
+	renamed, err := svc.Rename(john.ID, group.ID, "Reading club", nil)
+	suite.NoError(err)
+	suite.Equal("Reading club", renamed.Name)
+
+	suite.NoError(svc.Delete(john.ID, group.ID, nil))
+	_, err = svc.Get(john.ID, group.ID, nil)
+	suite.ErrorIs(err, apperror.ErrGroupNotFound)
+}
+
+func (suite *GroupServiceTestSuite) TestOrganizationGroup() {
+	svc := suite.groupService
+	owner := suite.createUser("John Doe", "john.doe@example.com")
+	member := suite.createUser("Jane Doe", "jane.doe@example.com")
+	guest := suite.createUser("Richard Roe", "richard.roe@example.com")
+	outsider := suite.createUser("Mallory", "mallory@example.com")
+	suite.NoError(suite.dbService.GetDB().Model(&outsider).Update("discoverable", true).Error)
+
+	organization, err := suite.organizationService.Create(owner.ID, "Acme", nil)
+	suite.NoError(err)
+	suite.join(owner.ID, organization.ID, member, models.OrgRoleMember)
+	suite.join(owner.ID, organization.ID, guest, models.OrgRoleGuest)
+
+	// Only admins and owners can create groups in the organization
+	_, err = svc.Create(member.ID, &organization.ID, "Engineering", nil)
+	suite.ErrorIs(err, apperror.ErrInsufficientRole)
+	_, err = svc.Create(outsider.ID, &organization.ID, "Engineering", nil)
+	suite.ErrorIs(err, apperror.ErrOrganizationNotFound)
+	group, err := svc.Create(owner.ID, &organization.ID, "Engineering", nil)
+	suite.NoError(err)
+	suite.Nil(group.OwnerID)
+
+	// Only members of the organization can be added, even if others are discoverable
+	suite.ErrorIs(svc.AddMember(owner.ID, group.ID, outsider.ID, nil), apperror.ErrMemberNotFound)
+	suite.NoError(svc.AddMember(owner.ID, group.ID, member.ID, nil))
+	suite.NoError(svc.AddMember(owner.ID, group.ID, guest.ID, nil))
+
+	// Members can see the group but not manage it, and guests and outsiders can't see it at all
+	groups, err := svc.List(member.ID, &organization.ID, nil)
+	suite.NoError(err)
+	suite.Len(groups, 1)
+	_, err = svc.Rename(member.ID, group.ID, "Eng", nil)
+	suite.ErrorIs(err, apperror.ErrInsufficientRole)
+	_, err = svc.ListMembers(guest.ID, group.ID, nil)
+	suite.ErrorIs(err, apperror.ErrInsufficientRole)
+	_, err = svc.Get(outsider.ID, group.ID, nil)
+	suite.ErrorIs(err, apperror.ErrGroupNotFound)
+
+	// Leaving the organization leaves its groups too
+	suite.NoError(suite.organizationService.RemoveMember(guest.ID, organization.ID, guest.ID, nil))
+	members, err := svc.ListMembers(owner.ID, group.ID, nil)
+	suite.NoError(err)
+	suite.Len(members, 1)
+	suite.Equal(member.ID, members[0].UserID)
+
+	// Deleting the organization deletes its groups
+	suite.NoError(suite.organizationService.Delete(owner.ID, organization.ID, nil))
+	var count int64
+	suite.NoError(suite.dbService.GetDB().Model(&models.UserGroup{}).Count(&count).Error)
+	suite.Zero(count)
+	suite.NoError(suite.dbService.GetDB().Model(&models.UserGroupMember{}).Count(&count).Error)
+	suite.Zero(count)
+}
+
+func (suite *GroupServiceTestSuite) TestAccountDeletion() {
+	svc := suite.groupService
+	john := suite.createUser("John Doe", "john.doe@example.com")
+	jane := suite.createUser("Jane Doe", "jane.doe@example.com")
+	suite.NoError(suite.dbService.GetDB().Model(&models.User{}).Where("1 = 1").Update("discoverable", true).Error)
+
+	johns, err := svc.Create(john.ID, nil, "John's", nil)
+	suite.NoError(err)
+	suite.NoError(svc.AddMember(john.ID, johns.ID, jane.ID, nil))
+	janes, err := svc.Create(jane.ID, nil, "Jane's", nil)
+	suite.NoError(err)
+	suite.NoError(svc.AddMember(jane.ID, janes.ID, john.ID, nil))
+
+	// Deleting an account deletes the groups it owns, and removes it from the groups of others
+	suite.NoError(suite.userService.Delete(john.ID, "correct horse battery stapler", nil))
+
+	groups, err := svc.List(jane.ID, nil, nil)
+	suite.NoError(err)
+	suite.Len(groups, 1)
+	members, err := svc.ListMembers(jane.ID, janes.ID, nil)
+	suite.NoError(err)
+	suite.Empty(members)
+
+	var count int64
+	suite.NoError(suite.dbService.GetDB().Model(&models.UserGroupMember{}).Count(&count).Error)
+	suite.Zero(count)
+}
+
+func TestGroupService(t *testing.T) {
+	suite.Run(t, new(GroupServiceTestSuite))
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"notes-app/config"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// HashAlgorithmArgon2id is the name of the argon2id password hashing algorithm.
+	HashAlgorithmArgon2id = "argon2id"
+	// HashAlgorithmBcrypt is the name of the bcrypt password hashing algorithm.
+	HashAlgorithmBcrypt = "bcrypt"
+)
+
+// ErrUnknownHashFormat is returned when a stored password hash is in a format that is not recognised.
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// argon2Params holds the parameters of an argon2id hash.
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	keyLength   uint32
+}
+
+// configuredArgon2Params returns the argon2id parameters from config.
+func configuredArgon2Params() argon2Params {
+	cfg := config.Get()
+	return argon2Params{
+		memory:      cfg.Argon2Memory,
+		iterations:  cfg.Argon2Iterations,
+		parallelism: cfg.Argon2Parallelism,
+		keyLength:   cfg.Argon2KeyLength,
+	}
+}
+
+// argon2Hash is a decoded argon2id hash.
+type argon2Hash struct {
+	params argon2Params
+	salt   []byte
+	key    []byte
+}
+
+// hashArgon2id hashes the password with argon2id using the configured parameters and a random salt.
+//
+// The hash is encoded in the PHC string format, e.g. $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>, which records the
+// algorithm and parameters so that they can be changed later without breaking existing hashes.
+func hashArgon2id(password string) (string, error) {
+	params := configuredArgon2Params()
+
+	salt := make([]byte, config.Get().Argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, params.iterations, params.memory, params.parallelism, params.keyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		params.memory,
+		params.iterations,
+		params.parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// decodeArgon2id decodes an argon2id hash in the PHC string format.
+func decodeArgon2id(encoded string) (argon2Hash, error) {
+	// The leading $ results in an empty first part
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != HashAlgorithmArgon2id {
+		return argon2Hash{}, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return argon2Hash{}, err
+	}
+	if version != argon2.Version {
+		return argon2Hash{}, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	var hash argon2Hash
+	_, err := fmt.Sscanf(
+		parts[3], "m=%d,t=%d,p=%d", &hash.params.memory, &hash.params.iterations, &hash.params.parallelism,
+	)
+	if err != nil {
+		return argon2Hash{}, err
+	}
+
+	if hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Hash{}, err
+	}
+	if hash.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2Hash{}, err
+	}
+	hash.params.keyLength = uint32(len(hash.key))
+
+	return hash, nil
+}
+
+// compareArgon2id compares an argon2id hash with a plaintext password in constant time.
+//
+// Returns bcrypt.ErrMismatchedHashAndPassword if they do not match, so that both algorithms report mismatches the same
+// way.
+func compareArgon2id(encoded, password string) error {
+	hash, err := decodeArgon2id(encoded)
+	if err != nil {
+		return err
+	}
+
+	params := hash.params
+	key := argon2.IDKey([]byte(password), hash.salt, params.iterations, params.memory, params.parallelism, params.keyLength)
+	if subtle.ConstantTimeCompare(key, hash.key) != 1 {
+		return bcrypt.ErrMismatchedHashAndPassword
+	}
+
+	return nil
+}
+
+// hashAlgorithm returns the algorithm that was used for the given hash, or an empty string if it is not recognised.
+func hashAlgorithm(hash string) string {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return HashAlgorithmArgon2id
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return HashAlgorithmBcrypt
+	}
+	return ""
+}
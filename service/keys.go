@@ -0,0 +1,255 @@
+package service
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"notes-app/apperror"
+	"notes-app/config"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// legacyKeyID is the ID of the key derived from the JWT_SECRET config, which is also used to verify tokens that were
+// issued before tokens were tagged with a kid header.
+const legacyKeyID = "default"
+
+// SigningKey is a key that can sign and/or verify JWT tokens.
+type SigningKey struct {
+	// ID is the key ID, which is set as the kid header of tokens signed with the key.
+	ID string
+
+	// Method is the signing method (algorithm) used with the key.
+	Method jwt.SigningMethod
+
+	// signKey is the key used to sign tokens, or nil if the key can only verify tokens.
+	signKey any
+
+	// verifyKey is the key used to verify tokens.
+	verifyKey any
+}
+
+// KeyRing holds the keys used to sign and verify JWT tokens.
+//
+// Only the active key is used to sign new tokens, but all keys in the ring are accepted when verifying tokens, so that
+// tokens signed with a retired key keep working until they expire.
+type KeyRing struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// LoadKeyRing loads the keys in the given directory into a key ring.
+//
+// Each file in the directory is a key, and its name without the extension is the key ID:
+//   - PEM encoded RSA private keys are used for RS256, and Ed25519 private keys for EdDSA.
+//   - PEM encoded public keys can only be used to verify tokens, e.g. for retired keys.
+//   - Any other file is treated as a raw secret for HS512.
+//
+// If legacySecret is set, it is added as an HS512 key with the ID "default", which is also used for tokens without a
+// kid header. The active key is the one with the given ID, or the only key in the ring if no ID is given.
+func LoadKeyRing(dir, activeKeyID, legacySecret string) (*KeyRing, error) {
+	ring := &KeyRing{keys: map[string]*SigningKey{}}
+
+	if legacySecret != "" {
+		ring.keys[legacyKeyID] = &SigningKey{
+			ID:        legacyKeyID,
+			Method:    jwt.SigningMethodHS512,
+			signKey:   []byte(legacySecret),
+			verifyKey: []byte(legacySecret),
+		}
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+				continue
+			}
+
+			key, err := loadSigningKey(filepath.Join(dir, entry.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("loading key %s: %w", entry.Name(), err)
+			}
+
+			if _, ok := ring.keys[key.ID]; ok {
+				return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+			}
+			ring.keys[key.ID] = key
+		}
+	}
+
+	// Pick the active key
+	if activeKeyID == "" {
+		if len(ring.keys) != 1 {
+			return nil, fmt.Errorf("the active key ID must be set when there are %d keys", len(ring.keys))
+		}
+		for id := range ring.keys {
+			activeKeyID = id
+		}
+	}
+
+	active, ok := ring.keys[activeKeyID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q not found", activeKeyID)
+	}
+	if active.signKey == nil {
+		return nil, fmt.Errorf("active key %q can not sign tokens", activeKeyID)
+	}
+	ring.active = active
+
+	return ring, nil
+}
+
+// loadSigningKey loads a single key from a file, using the file name without the extension as the key ID.
+func loadSigningKey(path string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &SigningKey{ID: strings.SplitN(filepath.Base(path), ".", 2)[0]}
+
+	// Anything that isn't PEM encoded is an HMAC secret
+	block, _ := pem.Decode(data)
+	if block == nil {
+		secret := []byte(strings.TrimSpace(string(data)))
+		if len(secret) == 0 {
+			return nil, errors.New("empty secret")
+		}
+
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodHS512, secret, secret
+		return key, nil
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		err = fmt.Errorf("unsupported PEM block type %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.verifyKey = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.signKey, key.verifyKey = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.verifyKey = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+
+	return key, nil
+}
+
+// Active returns the key used to sign new tokens.
+func (ring *KeyRing) Active() *SigningKey {
+	return ring.active
+}
+
+// Get returns the key with the given ID.
+func (ring *KeyRing) Get(id string) (*SigningKey, bool) {
+	key, ok := ring.keys[id]
+	return key, ok
+}
+
+// JWK is a JSON Web Key, as defined in RFC 7517.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+
+	// N and E are the modulus and exponent of RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Curve and X are the curve and public key of OKP (Ed25519) keys.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet is a JSON Web Key Set, as defined in RFC 7517.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys in the ring as a JSON Web Key Set, so that other services can verify tokens.
+//
+// HMAC keys are never included, since they are secret.
+func (ring *KeyRing) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+
+	for _, key := range ring.keys {
+		jwk := JWK{KeyID: key.ID, Algorithm: key.Method.Alg(), Use: "sig"}
+
+		switch k := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	// Sort the keys so that the output is stable
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+
+	return set
+}
+
+// defaultKeyRing returns the key ring loaded from config, shared by all AuthService instances that aren't given one.
+var defaultKeyRing = sync.OnceValues(func() (*KeyRing, error) {
+	cfg := config.Get()
+	return LoadKeyRing(cfg.JWTKeysDir, cfg.JWTActiveKeyID, cfg.JWTSecret)
+})
+
+// keyRing returns the key ring of the service, falling back to the one loaded from config.
+//
+// The key ring is loaded at startup rather than here, so that missing or broken keys stop the server from starting
+// instead of failing requests.
+func (svc AuthService) keyRing() (*KeyRing, error) {
+	if svc.KeyRing != nil {
+		return svc.KeyRing, nil
+	}
+
+	ring, err := defaultKeyRing()
+	if err != nil {
+		slog.Error("Failed to load key ring", slog.Any("error", err))
+		return nil, apperror.Internal(err)
+	}
+
+	return ring, nil
+}
@@ -0,0 +1,139 @@
+package service_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"notes-app/apperror"
+	"notes-app/service"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeKeys writes an RSA key, an Ed25519 key and an HMAC secret to a temporary directory.
+func writeKeys(t *testing.T) (dir string, rsaKey *rsa.PrivateKey) {
+	dir = t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaBytes := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "rsa-2025.pem"), rsaBytes, 0o600))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	require.NoError(t, err)
+	edBytes := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: edDER})
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ed-2026.pem"), edBytes, 0o600))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "hmac-2024.key"), []byte("supersecret\n"), 0o600))
+
+	return dir, rsaKey
+}
+
+func TestKeyRingRotation(t *testing.T) {
+	dir, _ := writeKeys(t)
+
+	// Sign a token with each key, as if each was the active one at some point
+	tokens := map[string]string{}
+	for _, kid := range []string{"hmac-2024", "rsa-2025", "ed-2026"} {
+		ring, err := service.LoadKeyRing(dir, kid, "")
+		require.NoError(t, err)
+
+		token, _, err := service.AuthService{KeyRing: ring}.GenerateJWT(1, "session-1")
+		require.NoError(t, err)
+
+		// Assert that the token is tagged with the key ID and signed with the expected algorithm
+		parsed, _, err := jwt.NewParser().ParseUnverified(token, &jwt.RegisteredClaims{})
+		require.NoError(t, err)
+		assert.Equal(t, kid, parsed.Header["kid"])
+		tokens[kid] = token
+	}
+	assert.Contains(t, tokens["rsa-2025"], "eyJhbGciOiJSUzI1NiI")
+
+	// After rotating to the latest key, tokens signed with the retired keys should still be accepted
+	ring, err := service.LoadKeyRing(dir, "ed-2026", "")
+	require.NoError(t, err)
+	svc := service.AuthService{KeyRing: ring}
+	for kid, token := range tokens {
+		claims, err := svc.ParseJWT(token)
+		if assert.NoError(t, err, kid) {
+			assert.Equal(t, "session-1", claims.ID)
+		}
+	}
+
+	// Once a retired key is removed, its tokens should be rejected
+	require.NoError(t, os.Remove(filepath.Join(dir, "hmac-2024.key")))
+	ring, err = service.LoadKeyRing(dir, "ed-2026", "")
+	require.NoError(t, err)
+	_, err = service.AuthService{KeyRing: ring}.ParseJWT(tokens["hmac-2024"])
+	assert.ErrorIs(t, err, apperror.ErrTokenInvalid)
+}
+
+func TestKeyRingRejectsAlgorithmConfusion(t *testing.T) {
+	dir, rsaKey := writeKeys(t)
+
+	ring, err := service.LoadKeyRing(dir, "rsa-2025", "")
+	require.NoError(t, err)
+
+	// Sign a token with HS256, using the RSA public key as the HMAC secret
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	token.Header["kid"] = "rsa-2025"
+	forged, err := token.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}))
+	require.NoError(t, err)
+
+	_, err = service.AuthService{KeyRing: ring}.ParseJWT(forged)
+	assert.ErrorIs(t, err, apperror.ErrTokenInvalid)
+}
+
+func TestKeyRingJWKS(t *testing.T) {
+	dir, _ := writeKeys(t)
+
+	ring, err := service.LoadKeyRing(dir, "ed-2026", "legacysecret")
+	require.NoError(t, err)
+
+	// Only the public keys should be published, never the HMAC secrets
+	jwks := ring.JWKS()
+	require.Len(t, jwks.Keys, 2)
+
+	assert.Equal(t, "ed-2026", jwks.Keys[0].KeyID)
+	assert.Equal(t, "OKP", jwks.Keys[0].KeyType)
+	assert.Equal(t, "Ed25519", jwks.Keys[0].Curve)
+	assert.Equal(t, "EdDSA", jwks.Keys[0].Algorithm)
+
+	assert.Equal(t, "rsa-2025", jwks.Keys[1].KeyID)
+	assert.Equal(t, "RSA", jwks.Keys[1].KeyType)
+	assert.Equal(t, "RS256", jwks.Keys[1].Algorithm)
+	assert.Equal(t, "AQAB", jwks.Keys[1].E)
+	assert.NotEmpty(t, jwks.Keys[1].N)
+}
+
+func TestLoadKeyRingErrors(t *testing.T) {
+	dir, _ := writeKeys(t)
+
+	// The active key must be chosen when there are multiple keys
+	_, err := service.LoadKeyRing(dir, "", "")
+	assert.Error(t, err)
+
+	// The active key must exist
+	_, err = service.LoadKeyRing(dir, "nosuchkey", "")
+	assert.Error(t, err)
+
+	// The legacy secret alone is enough
+	ring, err := service.LoadKeyRing("", "", "legacysecret")
+	require.NoError(t, err)
+	assert.Equal(t, "default", ring.Active().ID)
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/models"
+	"notes-app/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// roleRanks orders the roles that groups can map to, so that users with several groups get the highest one.
+var roleRanks = []string{models.RoleUser, models.RoleAdmin}
+
+// LDAPDirectory checks passwords by binding to an LDAP server, like OpenLDAP or Active Directory, as the user.
+//
+// Users are first found by email with a search, since the DN to bind with can't be derived from the email in general.
+type LDAPDirectory struct {
+	URL      string
+	StartTLS bool
+	// BindDN and BindPassword are the credentials used to search for users, or empty for an anonymous bind.
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter is the filter to find a user by email, with %s replaced by the escaped email.
+	UserFilter     string
+	EmailAttribute string
+	NameAttribute  string
+	GroupAttribute string
+	// GroupRoles maps the lower-cased DNs of groups to roles.
+	GroupRoles map[string]string
+	Timeout    time.Duration
+	// TLSConfig is used for ldaps:// and StartTLS, defaults to verifying the server's certificate with the system
+	// roots if nil.
+	TLSConfig *tls.Config
+}
+
+// NewLDAPDirectory creates an LDAPDirectory from the LDAP_* config variables.
+//
+// Returns an error if LDAP_GROUP_ROLES is invalid.
+func NewLDAPDirectory(cfg *config.Config) (*LDAPDirectory, error) {
+	groupRoles, err := ParseGroupRoles(cfg.LDAPGroupRoles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LDAPDirectory{
+		URL:            cfg.LDAPURL,
+		StartTLS:       cfg.LDAPStartTLS,
+		BindDN:         cfg.LDAPBindDN,
+		BindPassword:   cfg.LDAPBindPassword,
+		BaseDN:         cfg.LDAPBaseDN,
+		UserFilter:     cfg.LDAPUserFilter,
+		EmailAttribute: cfg.LDAPEmailAttribute,
+		NameAttribute:  cfg.LDAPNameAttribute,
+		GroupAttribute: cfg.LDAPGroupAttribute,
+		GroupRoles:     groupRoles,
+		Timeout:        cfg.LDAPTimeout,
+	}, nil
+}
+
+// ParseGroupRoles parses a mapping of groups to roles, as role:groupDN pairs separated by semicolons.
+//
+// Returns the roles by lower-cased group DN, or an error if a pair is malformed or a role is unknown.
+func ParseGroupRoles(value string) (map[string]string, error) {
+	groupRoles := make(map[string]string)
+	for _, pair := range strings.Split(value, ";") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+
+		role, groupDN, ok := strings.Cut(pair, ":")
+		role, groupDN = strings.TrimSpace(role), strings.TrimSpace(groupDN)
+		if !ok || groupDN == "" {
+			return nil, fmt.Errorf("invalid group role %q, expected role:groupDN", pair)
+		}
+		if !slices.Contains(roleRanks, role) {
+			return nil, fmt.Errorf("unknown role %q for group %q", role, groupDN)
+		}
+
+		groupRoles[strings.ToLower(groupDN)] = role
+	}
+
+	return groupRoles, nil
+}
+
+// Authenticate checks the password of the user with the given email in the directory.
+// Returns the user's details in the directory, apperror.ErrInvalidCredentials if there is no such user or the
+// password is incorrect, or apperror.ErrDirectoryUnavailable if the directory can't be reached.
+func (dir *LDAPDirectory) Authenticate(email, password string) (DirectoryUser, error) {
+	// An empty password would be an unauthenticated bind, which most servers accept without checking anything
+	if password == "" {
+		return DirectoryUser{}, apperror.ErrInvalidCredentials
+	}
+
+	conn, err := dir.connect()
+	if err != nil {
+		slog.Error("Failed to connect to LDAP server", slog.Any("error", err))
+		return DirectoryUser{}, apperror.ErrDirectoryUnavailable.WithCause(err)
+	}
+	defer conn.Close()
+
+	// Find the user with the service account
+	if dir.BindDN != "" {
+		err = conn.Bind(dir.BindDN, dir.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		slog.Error("Failed to bind to LDAP server with the service account", slog.Any("error", err))
+		return DirectoryUser{}, apperror.ErrDirectoryUnavailable.WithCause(err)
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		dir.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		// Ask for two entries, to tell when the filter is ambiguous
+		2, int(dir.Timeout.Seconds()), false,
+		fmt.Sprintf(dir.UserFilter, ldap.EscapeFilter(email)),
+		[]string{dir.EmailAttribute, dir.NameAttribute, dir.GroupAttribute},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		slog.Error("Failed to search for LDAP user", slog.Any("error", err))
+		return DirectoryUser{}, apperror.ErrDirectoryUnavailable.WithCause(err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return DirectoryUser{}, apperror.ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	// Check the password by binding as the user
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return DirectoryUser{}, apperror.ErrInvalidCredentials.WithCause(err)
+		}
+		slog.Error("Failed to bind to LDAP server as the user", slog.Any("error", err))
+		return DirectoryUser{}, apperror.ErrDirectoryUnavailable.WithCause(err)
+	}
+
+	user := DirectoryUser{
+		Email: utils.NormalizeEmail(entry.GetAttributeValue(dir.EmailAttribute)),
+		Name:  entry.GetAttributeValue(dir.NameAttribute),
+		Role:  dir.role(entry.GetAttributeValues(dir.GroupAttribute)),
+	}
+	if user.Email == "" {
+		user.Email = utils.NormalizeEmail(email)
+	}
+
+	return user, nil
+}
+
+// connect opens a connection to the LDAP server, upgrading it with StartTLS if configured.
+func (dir *LDAPDirectory) connect() (*ldap.Conn, error) {
+	tlsConfig := dir.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	conn, err := ldap.DialURL(dir.URL,
+		ldap.DialWithDialer(&net.Dialer{Timeout: dir.Timeout}),
+		ldap.DialWithTLSConfig(tlsConfig),
+	)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(dir.Timeout)
+
+	if dir.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, errors.Join(errors.New("StartTLS failed"), err)
+		}
+	}
+
+	return conn, nil
+}
+
+// role returns the highest role that the given groups map to, or models.RoleUser if none do.
+func (dir *LDAPDirectory) role(groups []string) string {
+	rank := 0
+	for _, group := range groups {
+		if role, ok := dir.GroupRoles[strings.ToLower(group)]; ok {
+			rank = max(rank, slices.Index(roleRanks, role))
+		}
+	}
+
+	return roleRanks[rank]
+}
@@ -0,0 +1,446 @@
+package service_test
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	ldapServiceDN       = "cn=notes,ou=services,dc=example,dc=com"
+	ldapServicePassword = "service-password"
+	ldapAdminsGroup     = "cn=Admins,ou=groups,dc=example,dc=com"
+	ldapStaffGroup      = "cn=Staff,ou=groups,dc=example,dc=com"
+)
+
+// ldapEntry is a user in the directory of a fakeLDAPServer.
+type ldapEntry struct {
+	dn         string
+	password   string
+	attributes map[string][]string
+}
+
+// fakeLDAPServer is an in-process stand-in for an LDAP server, which understands just enough of the protocol for
+// LDAPDirectory: simple binds, searches for users by email, and unbinds.
+type fakeLDAPServer struct {
+	listener net.Listener
+	entries  []ldapEntry
+}
+
+var ldapMailFilter = regexp.MustCompile(`\(mail=([^)]*)\)`)
+
+// newFakeLDAPServer starts a fakeLDAPServer with the given users, which is stopped when the test ends.
+func newFakeLDAPServer(t *testing.T, entries ...ldapEntry) *fakeLDAPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	server := &fakeLDAPServer{listener: listener, entries: entries}
+	go server.serve()
+	t.Cleanup(func() { _ = listener.Close() })
+
+	return server
+}
+
+// URL returns the URL to connect to the server with.
+func (s *fakeLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *fakeLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle answers the requests on a connection until the client unbinds or disconnects.
+func (s *fakeLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	boundDN := ""
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				slog.Debug("Fake LDAP server failed to read request", slog.Any("error", err))
+			}
+			return
+		}
+		messageID := packet.Children[0].Value.(int64)
+		request := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch request.Tag {
+		case ldap.ApplicationBindRequest:
+			dn, password := request.Children[1].Data.String(), request.Children[2].Data.String()
+			code := s.bind(dn, password)
+			if code == ldap.LDAPResultSuccess {
+				boundDN = dn
+			}
+			responses = append(responses, ldapResult(ldap.ApplicationBindResponse, code))
+		case ldap.ApplicationSearchRequest:
+			// Only the service account may search, like most directories set up for applications
+			if boundDN != ldapServiceDN {
+				responses = append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultInsufficientAccessRights))
+				break
+			}
+
+			filter, err := ldap.DecompileFilter(request.Children[6])
+			if err != nil {
+				responses = append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError))
+				break
+			}
+			for _, entry := range s.search(filter) {
+				responses = append(responses, ldapSearchResultEntry(entry))
+			}
+			responses = append(responses, ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess))
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			return
+		}
+
+		for _, response := range responses {
+			if _, err := conn.Write(ldapMessage(messageID, response).Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// bind checks the credentials of a simple bind, returning the LDAP result code.
+func (s *fakeLDAPServer) bind(dn, password string) uint16 {
+	if dn == "" && password == "" {
+		return ldap.LDAPResultSuccess
+	}
+	if dn == ldapServiceDN && password == ldapServicePassword {
+		return ldap.LDAPResultSuccess
+	}
+	for _, entry := range s.entries {
+		if strings.EqualFold(entry.dn, dn) && entry.password == password {
+			return ldap.LDAPResultSuccess
+		}
+	}
+
+	return ldap.LDAPResultInvalidCredentials
+}
+
+// search returns the users matching the email in the given filter, ignoring the rest of it. Unescaped asterisks in the
+// email are wildcards.
+func (s *fakeLDAPServer) search(filter string) []ldapEntry {
+	match := ldapMailFilter.FindStringSubmatch(filter)
+	if match == nil {
+		return nil
+	}
+
+	parts := strings.Split(match[1], "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	pattern := regexp.MustCompile("(?i)^" + strings.Join(parts, ".*") + "$")
+
+	var entries []ldapEntry
+	for _, entry := range s.entries {
+		for _, mail := range entry.attributes["mail"] {
+			if pattern.MatchString(mail) {
+				entries = append(entries, entry)
+			}
+		}
+	}
+
+	return entries
+}
+
+// ldapMessage wraps a response in an LDAP message with the given ID.
+func ldapMessage(messageID int64, response *ber.Packet) *ber.Packet {
+	packet := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, messageID, "Message ID"))
+	packet.AppendChild(response)
+
+	return packet
+}
+
+// ldapResult builds a response with the given application tag and result code.
+func ldapResult(tag ber.Tag, code uint16) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	packet.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Diagnostic Message"))
+
+	return packet
+}
+
+// ldapSearchResultEntry builds a search result with the DN and attributes of the given user.
+func ldapSearchResultEntry(entry ldapEntry) *ber.Packet {
+	packet := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	packet.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, "DN"))
+
+	attributes := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range entry.attributes {
+		attribute := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attribute.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, "Value"))
+		}
+		attribute.AppendChild(set)
+		attributes.AppendChild(attribute)
+	}
+	packet.AppendChild(attributes)
+
+	return packet
+}
+
+// newLDAPDirectory creates an LDAPDirectory for the given server, with the default config.
+func newLDAPDirectory(t *testing.T, server *fakeLDAPServer) *service.LDAPDirectory {
+	cfg := *config.Get()
+	cfg.LDAPURL = server.URL()
+	cfg.LDAPBindDN = ldapServiceDN
+	cfg.LDAPBindPassword = ldapServicePassword
+	cfg.LDAPBaseDN = "dc=example,dc=com"
+	cfg.LDAPGroupRoles = "admin:" + ldapAdminsGroup + "; user:" + ldapStaffGroup
+	cfg.LDAPTimeout = 5 * time.Second
+
+	directory, err := service.NewLDAPDirectory(&cfg)
+	require.NoError(t, err)
+
+	return directory
+}
+
+// ldapUsers returns the users in the directory for tests: an admin, a user in no mapped groups, and two users sharing
+// an email.
+func ldapUsers() []ldapEntry {
+	return []ldapEntry{
+		{
+			dn:       "uid=john,ou=people,dc=example,dc=com",
+			password: "john-password",
+			attributes: map[string][]string{
+				"mail":     {"John.Doe@example.com"},
+				"cn":       {"John Doe"},
+				"memberOf": {strings.ToUpper(ldapAdminsGroup), ldapStaffGroup},
+			},
+		},
+		{
+			dn:       "uid=jane,ou=people,dc=example,dc=com",
+			password: "jane-password",
+			attributes: map[string][]string{
+				"mail": {"jane.doe@example.com"},
+				"cn":   {"Jane Doe"},
+			},
+		},
+		{
+			dn:         "uid=shared1,ou=people,dc=example,dc=com",
+			password:   "shared-password",
+			attributes: map[string][]string{"mail": {"shared@example.com"}},
+		},
+		{
+			dn:         "uid=shared2,ou=people,dc=example,dc=com",
+			password:   "shared-password",
+			attributes: map[string][]string{"mail": {"shared@example.com"}},
+		},
+	}
+}
+
+func TestLDAPDirectoryAuthenticate(t *testing.T) {
+	directory := newLDAPDirectory(t, newFakeLDAPServer(t, ldapUsers()...))
+
+	// Users are found by email, and get the highest role of their groups, compared ignoring case
+	user, err := directory.Authenticate("john.doe@example.com", "john-password")
+	assert.NoError(t, err)
+	assert.Equal(t, service.DirectoryUser{Email: "john.doe@example.com", Name: "John Doe", Role: models.RoleAdmin}, user)
+
+	// Users without mapped groups are plain users
+	user, err = directory.Authenticate("jane.doe@example.com", "jane-password")
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoleUser, user.Role)
+
+	tests := map[string]struct {
+		email    string
+		password string
+	}{
+		"incorrect password": {email: "john.doe@example.com", password: "jane-password"},
+		"unknown email":      {email: "nobody@example.com", password: "john-password"},
+		// An empty password would be accepted by the server as an unauthenticated bind
+		"empty password":  {email: "john.doe@example.com", password: ""},
+		"ambiguous email": {email: "shared@example.com", password: "shared-password"},
+		// The email must be escaped, or it could match other users
+		"filter injection": {email: "john*", password: "john-password"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := directory.Authenticate(test.email, test.password)
+			assert.ErrorIs(t, err, apperror.ErrInvalidCredentials)
+		})
+	}
+}
+
+func TestLDAPDirectoryUnavailable(t *testing.T) {
+	server := newFakeLDAPServer(t, ldapUsers()...)
+
+	// The service account can't bind
+	directory := newLDAPDirectory(t, server)
+	directory.BindPassword = "wrong-password"
+	_, err := directory.Authenticate("john.doe@example.com", "john-password")
+	assert.ErrorIs(t, err, apperror.ErrDirectoryUnavailable)
+
+	// The server is down
+	directory = newLDAPDirectory(t, server)
+	require.NoError(t, server.listener.Close())
+	_, err = directory.Authenticate("john.doe@example.com", "john-password")
+	assert.ErrorIs(t, err, apperror.ErrDirectoryUnavailable)
+}
+
+func TestParseGroupRoles(t *testing.T) {
+	groupRoles, err := service.ParseGroupRoles(" admin:" + ldapAdminsGroup + ";;user:" + ldapStaffGroup + ";")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		strings.ToLower(ldapAdminsGroup): models.RoleAdmin,
+		strings.ToLower(ldapStaffGroup):  models.RoleUser,
+	}, groupRoles)
+
+	_, err = service.ParseGroupRoles(ldapAdminsGroup)
+	assert.Error(t, err)
+
+	_, err = service.ParseGroupRoles("owner:" + ldapAdminsGroup)
+	assert.Error(t, err)
+}
+
+type LDAPAuthenticationTestSuite struct {
+	suite.Suite
+	dbService   database.Service
+	server      *fakeLDAPServer
+	userService service.UserService
+}
+
+func (suite *LDAPAuthenticationTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *LDAPAuthenticationTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+
+	// Create the user service instance to use for testing, with a fresh directory
+	suite.server = newFakeLDAPServer(suite.T(), ldapUsers()...)
+	suite.userService = service.UserService{
+		Service:     service.Service{DBService: suite.dbService},
+		AuthService: service.AuthService{Directory: newLDAPDirectory(suite.T(), suite.server)},
+	}
+
+	slog.Debug("Setup test")
+}
+
+func (suite *LDAPAuthenticationTestSuite) TestProvisioning() {
+	svc := suite.userService
+
+	// The random password of new users isn't held to the password policy, which it could fail by chance
+	cfg := config.Get()
+	defaultMinLength := cfg.PasswordMinLength
+	cfg.PasswordMinLength = 1000
+	defer func() { cfg.PasswordMinLength = defaultMinLength }()
+
+	// Users are created the first time they log in
+	user, err := svc.Authenticate("John.Doe@example.com", "john-password", nil)
+	suite.NoError(err)
+	suite.Equal("john.doe@example.com", user.Email)
+	suite.Equal("John Doe", user.Name)
+	suite.Equal(models.RoleAdmin, user.Role)
+	suite.Equal(models.AuthSourceLDAP, user.AuthSource)
+	suite.True(user.EmailVerified)
+
+	// And found again after that
+	userAgain, err := svc.Authenticate("john.doe@example.com", "john-password", nil)
+	suite.NoError(err)
+	suite.Equal(user.ID, userAgain.ID)
+
+	// The directory decides, not the local password
+	_, err = svc.Authenticate("john.doe@example.com", "wrongpassword", nil)
+	suite.ErrorIs(err, apperror.ErrInvalidCredentials)
+}
+
+func (suite *LDAPAuthenticationTestSuite) TestTakeOverLocalUser() {
+	svc := suite.userService
+
+	// A local user registered with the email of a directory user
+	user := models.User{Name: "Jane", Email: "jane.doe@example.com", Password: "local-password", Role: models.RoleAdmin}
+	suite.NoError(suite.dbService.GetDB().Create(&user).Error)
+
+	// Their local password no longer works
+	_, err := svc.Authenticate("jane.doe@example.com", "local-password", nil)
+	suite.ErrorIs(err, apperror.ErrInvalidCredentials)
+
+	// And the directory updates them once they log in with it
+	authenticatedUser, err := svc.Authenticate("jane.doe@example.com", "jane-password", nil)
+	suite.NoError(err)
+	suite.Equal(user.ID, authenticatedUser.ID)
+
+	var userFromDB models.User
+	suite.NoError(suite.dbService.GetDB().Where("id = ?", user.ID).First(&userFromDB).Error)
+	suite.Equal("Jane Doe", userFromDB.Name)
+	suite.Equal(models.RoleUser, userFromDB.Role)
+	suite.Equal(models.AuthSourceLDAP, userFromDB.AuthSource)
+	suite.True(userFromDB.EmailVerified)
+}
+
+func (suite *LDAPAuthenticationTestSuite) TestBreakGlassAdmin() {
+	svc := suite.userService
+	password := "correct horse battery stapler"
+
+	// A local admin that isn't in the directory
+	hashedPassword, err := service.AuthService{}.HashPassword(password)
+	suite.NoError(err)
+	admin := models.User{
+		Name:       "Break Glass",
+		Email:      "admin@example.com",
+		Password:   hashedPassword,
+		Role:       models.RoleAdmin,
+		AuthSource: models.AuthSourceLocal,
+	}
+	suite.NoError(suite.dbService.GetDB().Create(&admin).Error)
+
+	// Can still log in with their local password when the directory is down
+	suite.NoError(suite.server.listener.Close())
+	user, err := svc.Authenticate("admin@example.com", password, nil)
+	suite.NoError(err)
+	suite.Equal(admin.ID, user.ID)
+
+	_, err = svc.Authenticate("admin@example.com", "wrongpassword", nil)
+	suite.ErrorIs(err, apperror.ErrInvalidCredentials)
+
+	// While everyone else has to wait for the directory
+	_, err = svc.Authenticate("john.doe@example.com", "john-password", nil)
+	suite.ErrorIs(err, apperror.ErrDirectoryUnavailable)
+}
+
+func TestLDAPAuthentication(t *testing.T) {
+	suite.Run(t, new(LDAPAuthenticationTestSuite))
+}
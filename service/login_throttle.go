@@ -0,0 +1,206 @@
+package service
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ILoginThrottleService interface {
+	// Check checks that logins for the given email, and from the given IP address, are not locked out.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTooManyLoginAttempts along with how long until the lockout ends if either is locked out.
+	Check(email, ip string, opts *DBOpts) (time.Duration, error)
+
+	// RecordFailure records a failed login for the given email from the given IP address, locking either out for an
+	// exponentially increasing time once they have too many recent failures.
+	// Accepts optional DBOpts to specify a DB instance.
+	RecordFailure(email, ip string, opts *DBOpts) error
+
+	// RecordSuccess forgets the failed logins for the given email, after the user logs in.
+	//
+	// Failed logins from the IP address are kept, so that an attacker can't reset their counter by logging in to their
+	// own account in between guesses.
+	// Accepts optional DBOpts to specify a DB instance.
+	RecordSuccess(email string, opts *DBOpts) error
+
+	// RecordEmailRequest records a request for an email with a link, like a password reset, to the given email from
+	// the given IP address. Requests are limited the same way as failed logins, but counted separately from them.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTooManyEmailRequests along with how long until the lockout ends if either is locked out,
+	// in which case the request isn't recorded and the email must not be sent.
+	RecordEmailRequest(email, ip string, opts *DBOpts) (time.Duration, error)
+}
+
+type LoginThrottleService struct {
+	Service
+}
+
+// Check checks that logins for the given email, and from the given IP address, are not locked out.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTooManyLoginAttempts along with how long until the lockout ends if either is locked out.
+func (svc LoginThrottleService) Check(email, ip string, opts *DBOpts) (time.Duration, error) {
+	db := svc.getDB(opts)
+
+	retryAfter, err := throttleLockout(db, accountThrottleKey(email), ipThrottleKey(ip))
+	if err != nil {
+		slog.Error("Failed to check login throttles", slog.Any("error", err))
+		return 0, apperror.Internal(err)
+	}
+	if retryAfter > 0 {
+		return retryAfter, apperror.ErrTooManyLoginAttempts
+	}
+
+	return 0, nil
+}
+
+// RecordFailure records a failed login for the given email from the given IP address, locking either out for an
+// exponentially increasing time once they have too many recent failures.
+// Accepts optional DBOpts to specify a DB instance.
+func (svc LoginThrottleService) RecordFailure(email, ip string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+	cfg := config.Get()
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := recordThrottleFailure(tx, accountThrottleKey(email), cfg.LoginMaxFailuresPerAccount); err != nil {
+			return err
+		}
+		return recordThrottleFailure(tx, ipThrottleKey(ip), cfg.LoginMaxFailuresPerIP)
+	})
+	if err != nil {
+		slog.Error("Failed to record failed login", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+
+	return nil
+}
+
+// RecordSuccess forgets the failed logins for the given email, after the user logs in.
+//
+// Failed logins from the IP address are kept, so that an attacker can't reset their counter by logging in to their
+// own account in between guesses.
+// Accepts optional DBOpts to specify a DB instance.
+func (svc LoginThrottleService) RecordSuccess(email string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	result := db.Where("key = ?", accountThrottleKey(email)).Delete(&models.LoginThrottle{})
+	if result.Error != nil {
+		slog.Error("Failed to reset login throttle", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	return nil
+}
+
+// RecordEmailRequest records a request for an email with a link, like a password reset, to the given email from
+// the given IP address. Requests are limited the same way as failed logins, but counted separately from them.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTooManyEmailRequests along with how long until the lockout ends if either is locked out,
+// in which case the request isn't recorded and the email must not be sent.
+func (svc LoginThrottleService) RecordEmailRequest(email, ip string, opts *DBOpts) (time.Duration, error) {
+	db := svc.getDB(opts)
+	cfg := config.Get()
+
+	var retryAfter time.Duration
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		retryAfter, err = throttleLockout(tx, emailRequestThrottleKey(email), emailRequestIPThrottleKey(ip))
+		if err != nil || retryAfter > 0 {
+			return err
+		}
+
+		if err := recordThrottleFailure(tx, emailRequestThrottleKey(email), cfg.LoginMaxFailuresPerAccount); err != nil {
+			return err
+		}
+		return recordThrottleFailure(tx, emailRequestIPThrottleKey(ip), cfg.LoginMaxFailuresPerIP)
+	})
+	if err != nil {
+		slog.Error("Failed to record email request", slog.Any("error", err))
+		return 0, apperror.Internal(err)
+	}
+	if retryAfter > 0 {
+		return retryAfter, apperror.ErrTooManyEmailRequests
+	}
+
+	return 0, nil
+}
+
+// throttleLockout returns how long until the longest lockout of the throttles with the given keys ends, or zero if
+// none of them is locked out.
+func throttleLockout(db *gorm.DB, keys ...string) (time.Duration, error) {
+	var throttles []models.LoginThrottle
+	if err := db.Where("key IN ? AND locked_until > ?", keys, time.Now()).Find(&throttles).Error; err != nil {
+		return 0, err
+	}
+
+	var retryAfter time.Duration
+	for _, throttle := range throttles {
+		retryAfter = max(retryAfter, time.Until(*throttle.LockedUntil))
+	}
+
+	return retryAfter, nil
+}
+
+// recordThrottleFailure increments the failure counter with the given key, and locks it out if it has reached
+// maxFailures. Every failure after that doubles the lockout, up to the configured maximum.
+func recordThrottleFailure(tx *gorm.DB, key string, maxFailures int) error {
+	cfg := config.Get()
+	now := time.Now()
+
+	// Make sure that the row exists, then lock it so that concurrent failures are all counted
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.LoginThrottle{Key: key, LastFailureAt: now})
+	if result.Error != nil {
+		return result.Error
+	}
+
+	var throttle models.LoginThrottle
+	result = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&throttle)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	// Start counting again if the last failure is old enough to be forgotten
+	if now.Sub(throttle.LastFailureAt) > cfg.LoginFailureWindow {
+		throttle.Failures = 0
+	}
+	throttle.Failures++
+	throttle.LastFailureAt = now
+
+	if excess := throttle.Failures - maxFailures; excess >= 0 {
+		// Double the lockout for every failure past the limit, stopping at the maximum so that it can't overflow
+		lockout := cfg.LoginLockoutBase
+		for i := 0; i < excess && lockout < cfg.LoginLockoutMax; i++ {
+			lockout *= 2
+		}
+		lockout = min(lockout, cfg.LoginLockoutMax)
+		lockedUntil := now.Add(lockout)
+		throttle.LockedUntil = &lockedUntil
+	}
+
+	return tx.Save(&throttle).Error
+}
+
+// accountThrottleKey returns the key of the login throttle for an email.
+func accountThrottleKey(email string) string {
+	return "account:" + email
+}
+
+// ipThrottleKey returns the key of the login throttle for an IP address.
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// emailRequestThrottleKey returns the key of the throttle of requests for emails with links to an email.
+func emailRequestThrottleKey(email string) string {
+	return "email-request:" + email
+}
+
+// emailRequestIPThrottleKey returns the key of the throttle of requests for emails with links from an IP address.
+func emailRequestIPThrottleKey(ip string) string {
+	return "email-request-ip:" + ip
+}
@@ -0,0 +1,152 @@
+package service_test
+
+import (
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type LoginThrottleServiceTestSuite struct {
+	suite.Suite
+	dbService            database.Service
+	loginThrottleService service.LoginThrottleService
+}
+
+func (suite *LoginThrottleServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the login throttle service instance to use for testing
+	suite.loginThrottleService = service.LoginThrottleService{Service: service.Service{DBService: suite.dbService}}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *LoginThrottleServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+
+	slog.Debug("Setup test")
+}
+
+func (suite *LoginThrottleServiceTestSuite) TestAccountLockout() {
+	svc := suite.loginThrottleService
+	cfg := config.Get()
+	email := "john.doe@example.com"
+
+	// Fail just short of the limit, from different IP addresses
+	for i := range cfg.LoginMaxFailuresPerAccount - 1 {
+		suite.NoError(svc.RecordFailure(email, fmt.Sprintf("192.0.2.%d", i+1), nil))
+	}
+	_, errCheck := svc.Check(email, "192.0.2.100", nil)
+	suite.NoError(errCheck)
+
+	// The next failure locks the account out, from any IP address
+	suite.NoError(svc.RecordFailure(email, "192.0.2.100", nil))
+	retryAfter, errCheck := svc.Check(email, "198.51.100.1", nil)
+	suite.ErrorIs(errCheck, apperror.ErrTooManyLoginAttempts)
+	suite.InDelta(cfg.LoginLockoutBase.Seconds(), retryAfter.Seconds(), 1)
+
+	// Other accounts are not affected
+	_, errCheck = svc.Check("jane.doe@example.com", "198.51.100.1", nil)
+	suite.NoError(errCheck)
+
+	// Every further failure doubles the lockout
+	suite.NoError(svc.RecordFailure(email, "192.0.2.100", nil))
+	retryAfter, _ = svc.Check(email, "198.51.100.1", nil)
+	suite.InDelta((2 * cfg.LoginLockoutBase).Seconds(), retryAfter.Seconds(), 1)
+
+	// Logging in resets the counter
+	suite.NoError(svc.RecordSuccess(email, nil))
+	_, errCheck = svc.Check(email, "198.51.100.1", nil)
+	suite.NoError(errCheck)
+}
+
+func (suite *LoginThrottleServiceTestSuite) TestIPLockout() {
+	svc := suite.loginThrottleService
+	cfg := config.Get()
+	ip := "192.0.2.1"
+
+	// Fail once for many different accounts from the same IP address
+	for i := range cfg.LoginMaxFailuresPerIP {
+		suite.NoError(svc.RecordFailure(fmt.Sprintf("user%d@example.com", i), ip, nil))
+	}
+
+	// The IP address is locked out, for any account
+	_, errCheck := svc.Check("new.user@example.com", ip, nil)
+	suite.ErrorIs(errCheck, apperror.ErrTooManyLoginAttempts)
+
+	// Logging in to an account doesn't reset the IP address
+	suite.NoError(svc.RecordSuccess("user0@example.com", nil))
+	_, errCheck = svc.Check("user0@example.com", ip, nil)
+	suite.ErrorIs(errCheck, apperror.ErrTooManyLoginAttempts)
+
+	// Other IP addresses are not affected
+	_, errCheck = svc.Check("new.user@example.com", "192.0.2.2", nil)
+	suite.NoError(errCheck)
+}
+
+func (suite *LoginThrottleServiceTestSuite) TestFailureWindow() {
+	svc := suite.loginThrottleService
+	cfg := config.Get()
+	email := "john.doe@example.com"
+
+	for range cfg.LoginMaxFailuresPerAccount - 1 {
+		suite.NoError(svc.RecordFailure(email, "192.0.2.1", nil))
+	}
+
+	// Pretend that the failures happened long ago
+	errUpdate := suite.dbService.GetDB().Model(&models.LoginThrottle{}).
+		Where("1 = 1").
+		Update("last_failure_at", time.Now().Add(-2*cfg.LoginFailureWindow)).Error
+	suite.NoError(errUpdate)
+
+	// The next failure starts counting again instead of locking the account out
+	suite.NoError(svc.RecordFailure(email, "192.0.2.1", nil))
+	_, errCheck := svc.Check(email, "192.0.2.1", nil)
+	suite.NoError(errCheck)
+}
+
+func (suite *LoginThrottleServiceTestSuite) TestEmailRequests() {
+	svc := suite.loginThrottleService
+	cfg := config.Get()
+	email := "john.doe@example.com"
+
+	// Every request up to the limit is let through, from any IP address
+	for i := range cfg.LoginMaxFailuresPerAccount {
+		_, err := svc.RecordEmailRequest(email, fmt.Sprintf("192.0.2.%d", i+1), nil)
+		suite.NoError(err)
+	}
+	retryAfter, err := svc.RecordEmailRequest(email, "198.51.100.1", nil)
+	suite.ErrorIs(err, apperror.ErrTooManyEmailRequests)
+	suite.InDelta(cfg.LoginLockoutBase.Seconds(), retryAfter.Seconds(), 1)
+
+	// Rejected requests aren't counted, so the lockout doesn't grow while it lasts
+	retryAfter, _ = svc.RecordEmailRequest(email, "198.51.100.1", nil)
+	suite.InDelta(cfg.LoginLockoutBase.Seconds(), retryAfter.Seconds(), 1)
+
+	// Requests for emails are counted separately from failed logins
+	_, err = svc.Check(email, "198.51.100.1", nil)
+	suite.NoError(err)
+	_, err = svc.RecordEmailRequest("jane.doe@example.com", "198.51.100.1", nil)
+	suite.NoError(err)
+}
+
+func TestLoginThrottleService(t *testing.T) {
+	suite.Run(t, new(LoginThrottleServiceTestSuite))
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/mailer"
+	"notes-app/models"
+
+	"gorm.io/gorm"
+)
+
+// RequestMagicLink emails a link to log in without a password to the user with the given email, replacing any link
+// sent before.
+//
+// Nothing happens if there is no such user, so that callers can't tell which emails are registered. Users from a
+// directory don't get links either, since the directory decides whether they can log in.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc UserService) RequestMagicLink(email string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	user, err := svc.GetByEmail(email, opts)
+	if errors.Is(err, apperror.ErrUserNotFound) {
+		slog.Debug("Magic link requested for unknown email")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if user.AuthSource == models.AuthSourceLDAP {
+		slog.Debug("Magic link requested for directory user", slog.Any("userID", user.ID))
+		return nil
+	}
+
+	ttl := config.Get().MagicLinkTTL
+	token, err := issueOneTimeToken(db, user, tokenPurposeMagicLink, ttl)
+	if err != nil {
+		return err
+	}
+
+	err = sendEmail(svc.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Your login link",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"To log in to your account, open this link:\n\n%s\n\n"+
+				"The link can only be used once and expires in %s. If you didn't ask for this, you can ignore this "+
+				"email.\n",
+			user.Name, appLink("/login/magic", token), ttl,
+		),
+	})
+	if err != nil {
+		slog.Error("Failed to send magic link email", slog.Any("error", err), slog.Any("userID", user.ID))
+		return apperror.Internal(err)
+	}
+
+	return nil
+}
+
+// LoginWithMagicLink retrieves the user that the login link was sent to, using up the token.
+//
+// Since the token was sent to the user's email, using it also verifies the email.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the user, or apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the token can't be used.
+func (svc UserService) LoginWithMagicLink(token string, opts *DBOpts) (models.User, error) {
+	db := svc.getDB(opts)
+
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		oneTimeToken, err := consumeOneTimeToken(tx, token, tokenPurposeMagicLink)
+		if err != nil {
+			return err
+		}
+
+		user, err = svc.GetByID(oneTimeToken.UserID, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+		if user.Email != oneTimeToken.Email || user.AuthSource == models.AuthSourceLDAP {
+			return apperror.ErrTokenInvalid
+		}
+
+		if !user.EmailVerified {
+			result := tx.Model(&user).Update("email_verified", true)
+			if result.Error != nil {
+				slog.Error("Failed to verify email", slog.Any("error", result.Error))
+				return apperror.Internal(result.Error)
+			}
+		}
+
+		return nil
+	})
+
+	return user, err
+}
@@ -0,0 +1,191 @@
+package service
+
+import (
+	"errors"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type INoteService interface {
+	// Access resolves the access of the given user to a note, through all the ways it can be shared with them.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns AccessRead or AccessEdit, or apperror.ErrNoteNotFound if the user has no access to the note.
+	Access(userID, noteID uint, opts *DBOpts) (string, error)
+
+	// ShareWithGroup shares a note owned by the given user with all members of a group that they can see, replacing
+	// the access that the group had before. Notes of an organization can only be shared with its groups.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the share, apperror.ErrNoteNotFound, apperror.ErrGroupNotFound, or apperror.ErrValidationFailed if the
+	// access is unknown or the group isn't in the organization of the note.
+	ShareWithGroup(userID, noteID, groupID uint, access string, opts *DBOpts) (models.NoteGroupShare, error)
+
+	// UnshareWithGroup stops sharing a note owned by the given user with a group.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrNoteNotFound or apperror.ErrNoteShareNotFound.
+	UnshareWithGroup(userID, noteID, groupID uint, opts *DBOpts) error
+}
+
+type NoteService struct {
+	Service
+
+	// GroupService is used to check that users can see the groups that they share notes with.
+	GroupService IGroupService
+
+	// OrganizationService is used to check that users are members of the organizations that notes belong to.
+	OrganizationService IOrganizationService
+}
+
+// Access resolves the access of the given user to a note, through all the ways it can be shared with them.
+//
+// Shares with groups are looked up through the current members of the groups, so that users gain and lose access as
+// soon as they join or leave a group.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns AccessRead or AccessEdit, or apperror.ErrNoteNotFound if the user has no access to the note.
+func (svc NoteService) Access(userID, noteID uint, opts *DBOpts) (string, error) {
+	db := svc.getDB(opts)
+
+	var note models.Note
+	result := db.Where("id = ?", noteID).First(&note)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return AccessNone, apperror.ErrNoteNotFound
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch note", slog.Any("error", result.Error))
+		return AccessNone, apperror.Internal(result.Error)
+	}
+
+	access, err := noteAccess(db, svc.organizationService(), userID, note)
+	if err != nil {
+		return AccessNone, err
+	}
+	if access == AccessNone {
+		return AccessNone, apperror.ErrNoteNotFound
+	}
+
+	return access, nil
+}
+
+// ShareWithGroup shares a note owned by the given user with all members of a group that they can see, replacing the
+// access that the group had before.
+//
+// Notes of an organization can only be shared with its groups, so that they can't be shared outside of it this way.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the share, apperror.ErrNoteNotFound, apperror.ErrGroupNotFound, or apperror.ErrValidationFailed if the
+// access is unknown or the group isn't in the organization of the note.
+func (svc NoteService) ShareWithGroup(
+	userID, noteID, groupID uint, access string, opts *DBOpts,
+) (models.NoteGroupShare, error) {
+	db := svc.getDB(opts)
+
+	if err := checkGrantableAccess(access); err != nil {
+		return models.NoteGroupShare{}, err
+	}
+
+	share := models.NoteGroupShare{NoteID: noteID, GroupID: groupID, Access: access}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		note, err := findOwnedNote(tx, svc.organizationService(), userID, noteID)
+		if err != nil {
+			return err
+		}
+		group, err := svc.groupService().Get(userID, groupID, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+		if note.OrganizationID != nil &&
+			(group.OrganizationID == nil || *group.OrganizationID != *note.OrganizationID) {
+			return apperror.ErrValidationFailed.WithDetails(apperror.FieldError{
+				Field:   "group_id",
+				Message: "is not in the organization of the note",
+			})
+		}
+
+		result := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "note_id"}, {Name: "group_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"access", "updated_at"}),
+		}).Create(&share)
+		if result.Error != nil {
+			slog.Error("Failed to share note with group", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.NoteGroupShare{}, err
+	}
+
+	return share, nil
+}
+
+// UnshareWithGroup stops sharing a note owned by the given user with a group. Its members lose the access right away,
+// unless the note is also shared with them some other way.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrNoteNotFound or apperror.ErrNoteShareNotFound.
+func (svc NoteService) UnshareWithGroup(userID, noteID, groupID uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	if _, err := findOwnedNote(db, svc.organizationService(), userID, noteID); err != nil {
+		return err
+	}
+
+	result := db.Where("note_id = ? AND group_id = ?", noteID, groupID).Delete(&models.NoteGroupShare{})
+	if result.Error != nil {
+		slog.Error("Failed to unshare note with group", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.ErrNoteShareNotFound
+	}
+
+	return nil
+}
+
+// groupService returns the group service, defaulting to one using the same DB.
+func (svc NoteService) groupService() IGroupService {
+	if svc.GroupService != nil {
+		return svc.GroupService
+	}
+	return GroupService{Service: svc.Service, OrganizationService: svc.organizationService()}
+}
+
+// organizationService returns the organization service, defaulting to one using the same DB.
+func (svc NoteService) organizationService() IOrganizationService {
+	if svc.OrganizationService != nil {
+		return svc.OrganizationService
+	}
+	return OrganizationService{Service: svc.Service}
+}
+
+// deleteNotes deletes the notes with the IDs selected by the given query, along with their shares, share links and
+// access requests.
+func deleteNotes(tx *gorm.DB, noteIDs *gorm.DB) error {
+	for _, model := range []any{&models.NoteShare{}, &models.NoteGroupShare{}} {
+		if err := tx.Where("note_id IN (?)", noteIDs).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	linkIDs := tx.Model(&models.ShareLink{}).Select("id").Where("note_id IN (?)", noteIDs)
+	if err := tx.Where("share_link_id IN (?)", linkIDs).Delete(&models.ShareSession{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("note_id IN (?)", noteIDs).Delete(&models.ShareLink{}).Error; err != nil {
+		return err
+	}
+
+	requestIDs := tx.Model(&models.AccessRequest{}).Select("id").Where("note_id IN (?)", noteIDs)
+	if err := tx.Where("access_request_id IN (?)", requestIDs).Delete(&models.AccessRequestEvent{}).Error; err != nil {
+		return err
+	}
+	if err := tx.Where("note_id IN (?)", noteIDs).Delete(&models.AccessRequest{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("id IN (?)", noteIDs).Delete(&models.Note{}).Error
+}
@@ -0,0 +1,227 @@
+package service_test
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type NoteServiceTestSuite struct {
+	suite.Suite
+	dbService    database.Service
+	groupService service.GroupService
+	noteService  service.NoteService
+	owner        models.User
+	member       models.User
+	note         models.Note
+	group        models.UserGroup
+}
+
+func (suite *NoteServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the service instances to use for testing
+	suite.groupService = service.GroupService{Service: service.Service{DBService: suite.dbService}}
+	suite.noteService = service.NoteService{
+		Service:      service.Service{DBService: suite.dbService},
+		GroupService: suite.groupService,
+	}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *NoteServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+
+	suite.owner = models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "hashedpassword"}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&suite.owner).Error)
+	suite.member = models.User{
+		Name: "Jane Doe", Email: "jane.doe@example.com", Password: "hashedpassword", Discoverable: true,
+	}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&suite.member).Error)
+	suite.note = models.Note{OwnerID: &suite.owner.ID, Title: "Shopping list"}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&suite.note).Error)
+
+	var err error
+	suite.group, err = suite.groupService.Create(suite.owner.ID, nil, "Family", nil)
+	suite.Require().NoError(err)
+
+	slog.Debug("Setup test")
+}
+
+func (suite *NoteServiceTestSuite) TestShareWithGroup() {
+	svc := suite.noteService
+
+	// Only the owner of the note can share it, and only with groups they can see
+	_, err := svc.ShareWithGroup(suite.member.ID, suite.note.ID, suite.group.ID, service.AccessRead, nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+	others, err := suite.groupService.Create(suite.member.ID, nil, "Jane's", nil)
+	suite.Require().NoError(err)
+	_, err = svc.ShareWithGroup(suite.owner.ID, suite.note.ID, others.ID, service.AccessRead, nil)
+	suite.ErrorIs(err, apperror.ErrGroupNotFound)
+	_, err = svc.ShareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, "admin", nil)
+	suite.ErrorIs(err, apperror.ErrValidationFailed)
+
+	share, err := svc.ShareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, service.AccessRead, nil)
+	suite.NoError(err)
+	suite.Equal(service.AccessRead, share.Access)
+
+	// Sharing again replaces the access
+	share, err = svc.ShareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, service.AccessEdit, nil)
+	suite.NoError(err)
+	suite.Equal(service.AccessEdit, share.Access)
+
+	var count int64
+	suite.NoError(suite.dbService.GetDB().Model(&models.NoteGroupShare{}).Count(&count).Error)
+	suite.Equal(int64(1), count)
+}
+
+func (suite *NoteServiceTestSuite) TestGroupMembership() {
+	svc := suite.noteService
+
+	access, err := svc.Access(suite.owner.ID, suite.note.ID, nil)
+	suite.NoError(err)
+	suite.Equal(service.AccessEdit, access)
+
+	_, err = svc.ShareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, service.AccessRead, nil)
+	suite.Require().NoError(err)
+
+	// Users get access as soon as they join the group
+	_, err = svc.Access(suite.member.ID, suite.note.ID, nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+	suite.NoError(suite.groupService.AddMember(suite.owner.ID, suite.group.ID, suite.member.ID, nil))
+	access, err = svc.Access(suite.member.ID, suite.note.ID, nil)
+	suite.NoError(err)
+	suite.Equal(service.AccessRead, access)
+
+	// A direct share and a group share add up to the most permissive access
+	share := models.NoteShare{NoteID: suite.note.ID, UserID: suite.member.ID, Access: service.AccessEdit}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&share).Error)
+	access, err = svc.Access(suite.member.ID, suite.note.ID, nil)
+	suite.NoError(err)
+	suite.Equal(service.AccessEdit, access)
+	suite.Require().NoError(suite.dbService.GetDB().Delete(&share).Error)
+
+	// And lose it as soon as they leave it
+	suite.NoError(suite.groupService.RemoveMember(suite.member.ID, suite.group.ID, suite.member.ID, nil))
+	_, err = svc.Access(suite.member.ID, suite.note.ID, nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+}
+
+func (suite *NoteServiceTestSuite) TestUnshareWithGroup() {
+	svc := suite.noteService
+
+	_, err := svc.ShareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, service.AccessRead, nil)
+	suite.Require().NoError(err)
+	suite.NoError(suite.groupService.AddMember(suite.owner.ID, suite.group.ID, suite.member.ID, nil))
+
+	suite.ErrorIs(svc.UnshareWithGroup(suite.member.ID, suite.note.ID, suite.group.ID, nil), apperror.ErrNoteNotFound)
+	suite.NoError(svc.UnshareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, nil))
+	suite.ErrorIs(
+		svc.UnshareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, nil), apperror.ErrNoteShareNotFound,
+	)
+
+	// The members of the group lose access right away
+	_, err = svc.Access(suite.member.ID, suite.note.ID, nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+}
+
+func (suite *NoteServiceTestSuite) TestDeleteGroup() {
+	svc := suite.noteService
+
+	_, err := svc.ShareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, service.AccessRead, nil)
+	suite.Require().NoError(err)
+
+	// Deleting the group deletes its shares
+	suite.NoError(suite.groupService.Delete(suite.owner.ID, suite.group.ID, nil))
+	var count int64
+	suite.NoError(suite.dbService.GetDB().Model(&models.NoteGroupShare{}).Count(&count).Error)
+	suite.Zero(count)
+}
+
+func (suite *NoteServiceTestSuite) TestOrganizationIsolation() {
+	svc := suite.noteService
+	db := suite.dbService.GetDB()
+	accessRequestService := service.AccessRequestService{Service: service.Service{DBService: suite.dbService}}
+	outsider := models.User{Name: "Mallory", Email: "mallory@example.com", Password: "hashedpassword"}
+	suite.Require().NoError(db.Create(&outsider).Error)
+
+	// The owner and the member are in Acme, and the outsider is in Globex
+	acme := models.Organization{Name: "Acme"}
+	suite.Require().NoError(db.Create(&acme).Error)
+	globex := models.Organization{Name: "Globex"}
+	suite.Require().NoError(db.Create(&globex).Error)
+	suite.Require().NoError(db.Create(&[]models.OrganizationMember{
+		{OrganizationID: acme.ID, UserID: suite.owner.ID, Role: models.OrgRoleOwner},
+		{OrganizationID: acme.ID, UserID: suite.member.ID, Role: models.OrgRoleGuest},
+		{OrganizationID: globex.ID, UserID: outsider.ID, Role: models.OrgRoleOwner},
+	}).Error)
+	suite.Require().NoError(db.Model(&suite.note).Update("organization_id", acme.ID).Error)
+
+	// Sharing the note with someone outside of the organization doesn't give them access
+	suite.Require().NoError(db.Create(&[]models.NoteShare{
+		{NoteID: suite.note.ID, UserID: suite.member.ID, Access: service.AccessRead},
+		{NoteID: suite.note.ID, UserID: outsider.ID, Access: service.AccessEdit},
+	}).Error)
+	access, err := svc.Access(suite.member.ID, suite.note.ID, nil)
+	suite.NoError(err)
+	suite.Equal(service.AccessRead, access)
+	_, err = svc.Access(outsider.ID, suite.note.ID, nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+
+	// Outsiders can't ask for access either
+	_, err = accessRequestService.Create(outsider.ID, suite.note.ID, service.AccessRead, "", nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+
+	// The note can only be shared with the groups of its organization
+	_, err = svc.ShareWithGroup(suite.owner.ID, suite.note.ID, suite.group.ID, service.AccessRead, nil)
+	suite.ErrorIs(err, apperror.ErrValidationFailed)
+	globexGroup, err := suite.groupService.Create(outsider.ID, &globex.ID, "Globex", nil)
+	suite.Require().NoError(err)
+	_, err = svc.ShareWithGroup(suite.owner.ID, suite.note.ID, globexGroup.ID, service.AccessRead, nil)
+	suite.ErrorIs(err, apperror.ErrGroupNotFound)
+	acmeGroup, err := suite.groupService.Create(suite.owner.ID, &acme.ID, "Acme", nil)
+	suite.Require().NoError(err)
+	_, err = svc.ShareWithGroup(suite.owner.ID, suite.note.ID, acmeGroup.ID, service.AccessRead, nil)
+	suite.NoError(err)
+
+	// Owners lose their notes when they leave the organization
+	suite.Require().NoError(db.Where("user_id = ?", suite.owner.ID).Delete(&models.OrganizationMember{}).Error)
+	_, err = svc.Access(suite.owner.ID, suite.note.ID, nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+	suite.ErrorIs(svc.UnshareWithGroup(suite.owner.ID, suite.note.ID, acmeGroup.ID, nil), apperror.ErrNoteNotFound)
+
+	// Deleting another organization leaves the note alone, and deleting its own deletes it along with its shares
+	organizationService := service.OrganizationService{Service: service.Service{DBService: suite.dbService}}
+	suite.NoError(organizationService.Delete(outsider.ID, globex.ID, nil))
+	var count int64
+	suite.NoError(db.Model(&models.Note{}).Count(&count).Error)
+	suite.Equal(int64(1), count)
+	suite.Require().NoError(db.Create(&models.OrganizationMember{
+		OrganizationID: acme.ID, UserID: suite.owner.ID, Role: models.OrgRoleOwner,
+	}).Error)
+	suite.NoError(organizationService.Delete(suite.owner.ID, acme.ID, nil))
+	suite.NoError(db.Model(&models.Note{}).Count(&count).Error)
+	suite.Zero(count)
+	suite.NoError(db.Model(&models.NoteShare{}).Count(&count).Error)
+	suite.Zero(count)
+}
+
+func TestNoteService(t *testing.T) {
+	suite.Run(t, new(NoteServiceTestSuite))
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/models"
+	"notes-app/utils"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+// oidcRequestTimeout is how long to wait for each request to an identity provider.
+const oidcRequestTimeout = 10 * time.Second
+
+var (
+	// oidcProviders caches the discovered identity providers by issuer URL, so that discovery only happens once.
+	oidcProviders   = make(map[string]*oidc.Provider)
+	oidcProvidersMu sync.Mutex
+)
+
+// OIDCState is what has to be kept in the user's browser between starting a login with an identity provider and the
+// callback, so that the callback can only complete a login that the same browser started.
+type OIDCState struct {
+	// State is sent to the provider and must come back unchanged in the callback, to prevent CSRF.
+	State string
+	// Nonce is sent to the provider and must be in the ID token, to prevent replaying ID tokens.
+	Nonce string
+	// CodeVerifier is the PKCE secret, whose hash is sent to the provider, so that a stolen code can't be exchanged.
+	CodeVerifier string
+}
+
+type IOIDCService interface {
+	// ProviderNames returns the names of the identity providers that users can log in with, sorted.
+	ProviderNames() []string
+
+	// AuthCodeURL starts logging in with the given identity provider, with the authorization code flow and PKCE.
+	// Returns the URL of the provider to send the user to, and the state to keep in the user's browser until the
+	// callback, or apperror.ErrOIDCProviderNotFound.
+	AuthCodeURL(provider string) (string, OIDCState, error)
+
+	// Exchange completes logging in with the given identity provider, by exchanging the authorization code from the
+	// callback for an ID token and validating it.
+	//
+	// The user is found by the identity linked to them, or else by email, as long as both the provider and the user
+	// have verified it. Users that don't exist yet are created.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the user, apperror.ErrOIDCProviderNotFound, apperror.ErrOIDCLoginFailed if the provider doesn't confirm
+	// who the user is, or apperror.ErrEmailNotVerified if the user with the same email hasn't verified it.
+	Exchange(provider string, state OIDCState, returnedState, code string, opts *DBOpts) (models.User, error)
+}
+
+type OIDCService struct {
+	Service
+
+	// UserService is used to find and create the users that log in.
+	UserService IUserService
+
+	// Providers are the identity providers that users can log in with, by name. Defaults to the configured ones if nil.
+	Providers map[string]config.OIDCProvider
+}
+
+// ProviderNames returns the names of the identity providers that users can log in with, sorted.
+func (svc OIDCService) ProviderNames() []string {
+	names := make([]string, 0, len(svc.providers()))
+	for name := range svc.providers() {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+// AuthCodeURL starts logging in with the given identity provider, with the authorization code flow and PKCE.
+// Returns the URL of the provider to send the user to, and the state to keep in the user's browser until the
+// callback, or apperror.ErrOIDCProviderNotFound.
+func (svc OIDCService) AuthCodeURL(provider string) (string, OIDCState, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+
+	oauth2Config, _, err := svc.oauth2Config(ctx, provider)
+	if err != nil {
+		return "", OIDCState{}, err
+	}
+
+	var state OIDCState
+	if state.State, err = generateToken(); err == nil {
+		state.Nonce, err = generateToken()
+	}
+	if err != nil {
+		slog.Error("Failed to generate OIDC state", slog.Any("error", err))
+		return "", OIDCState{}, apperror.Internal(err)
+	}
+	state.CodeVerifier = oauth2.GenerateVerifier()
+
+	url := oauth2Config.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.CodeVerifier))
+	return url, state, nil
+}
+
+// Exchange completes logging in with the given identity provider, by exchanging the authorization code from the
+// callback for an ID token and validating it.
+//
+// The user is found by the identity linked to them, or else by email, as long as both the provider and the user
+// have verified it. Users that don't exist yet are created.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the user, apperror.ErrOIDCProviderNotFound, apperror.ErrOIDCLoginFailed if the provider doesn't confirm
+// who the user is, or apperror.ErrEmailNotVerified if the user with the same email hasn't verified it.
+func (svc OIDCService) Exchange(provider string, state OIDCState, returnedState, code string, opts *DBOpts) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), oidcRequestTimeout)
+	defer cancel()
+
+	oauth2Config, oidcProvider, err := svc.oauth2Config(ctx, provider)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// Check that the callback is for the login that this browser started
+	if state.State == "" || subtle.ConstantTimeCompare([]byte(state.State), []byte(returnedState)) != 1 {
+		return models.User{}, apperror.ErrOIDCLoginFailed.WithCause(errors.New("state mismatch"))
+	}
+
+	token, err := oauth2Config.Exchange(ctx, code, oauth2.VerifierOption(state.CodeVerifier))
+	if err != nil {
+		slog.Error("Failed to exchange OIDC authorization code", slog.Any("error", err), slog.String("provider", provider))
+		return models.User{}, apperror.ErrOIDCLoginFailed.WithCause(err)
+	}
+
+	// Check the signature, issuer, audience and expiry of the ID token, then that it was issued for this login
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return models.User{}, apperror.ErrOIDCLoginFailed.WithCause(errors.New("no id_token in token response"))
+	}
+	idToken, err := oidcProvider.Verifier(&oidc.Config{ClientID: oauth2Config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		slog.Error("Failed to verify OIDC ID token", slog.Any("error", err), slog.String("provider", provider))
+		return models.User{}, apperror.ErrOIDCLoginFailed.WithCause(err)
+	}
+	if subtle.ConstantTimeCompare([]byte(state.Nonce), []byte(idToken.Nonce)) != 1 {
+		return models.User{}, apperror.ErrOIDCLoginFailed.WithCause(errors.New("nonce mismatch"))
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return models.User{}, apperror.ErrOIDCLoginFailed.WithCause(err)
+	}
+
+	return svc.linkUser(provider, idToken.Subject, claims, opts)
+}
+
+// oidcClaims are the claims of an ID token that are used to find or create the user.
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// linkUser finds the user with the given identity, linking or creating them by email if it is the first time that
+// they log in with the provider.
+func (svc OIDCService) linkUser(provider, subject string, claims oidcClaims, opts *DBOpts) (models.User, error) {
+	db := svc.getDB(opts)
+
+	var user models.User
+	err := db.Transaction(func(tx *gorm.DB) error {
+		txOpts := &DBOpts{db: tx}
+		now := time.Now()
+		email := utils.NormalizeEmail(claims.Email)
+
+		// Users that logged in with the provider before are found by their subject, even if their email changed
+		var identity models.OIDCIdentity
+		result := tx.Where("provider = ? AND subject = ?", provider, subject).First(&identity)
+		if result.Error == nil {
+			result = tx.Model(&identity).Updates(map[string]any{"email": email, "last_login_at": now})
+			if result.Error != nil {
+				slog.Error("Failed to update OIDC identity", slog.Any("error", result.Error))
+				return apperror.Internal(result.Error)
+			}
+
+			var err error
+			user, err = svc.UserService.GetByID(identity.UserID, txOpts)
+			return err
+		} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			slog.Error("Failed to fetch OIDC identity", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		// Otherwise, the email is all there is to go on, so the provider must have checked it
+		if email == "" || !claims.EmailVerified {
+			return apperror.ErrOIDCLoginFailed.WithCause(errors.New("email not verified by provider"))
+		}
+
+		var err error
+		user, err = svc.UserService.GetByEmail(email, txOpts)
+		if errors.Is(err, apperror.ErrUserNotFound) {
+			user, err = svc.createUser(email, claims.Name, txOpts)
+		} else if err == nil && !user.EmailVerified {
+			// Someone could have registered with the email without owning it, and would still know the password
+			return apperror.ErrEmailNotVerified
+		}
+		if err != nil {
+			return err
+		}
+
+		result = tx.Create(&models.OIDCIdentity{
+			UserID:      user.ID,
+			Provider:    provider,
+			Subject:     subject,
+			Email:       email,
+			LastLoginAt: now,
+		})
+		if result.Error != nil {
+			slog.Error("Failed to create OIDC identity", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		return nil
+	})
+
+	return user, err
+}
+
+// createUser creates a user that logged in with an identity provider for the first time.
+//
+// The user gets a random password that nobody knows, and can set one by resetting it if they want to log in without
+// the provider.
+func (svc OIDCService) createUser(email, name string, opts *DBOpts) (models.User, error) {
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	user := models.User{Name: name, Email: email, EmailVerified: true}
+	if err := svc.UserService.CreateWithRandomPassword(&user, opts); err != nil {
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+// providers returns the identity providers that users can log in with, by name.
+func (svc OIDCService) providers() map[string]config.OIDCProvider {
+	if svc.Providers != nil {
+		return svc.Providers
+	}
+	return config.Get().OIDCProviders
+}
+
+// oauth2Config discovers the endpoints of the identity provider with the given name, and returns its OAuth2
+// configuration.
+func (svc OIDCService) oauth2Config(ctx context.Context, name string) (oauth2.Config, *oidc.Provider, error) {
+	providerConfig, ok := svc.providers()[name]
+	if !ok {
+		return oauth2.Config{}, nil, apperror.ErrOIDCProviderNotFound
+	}
+
+	oidcProvider, err := discoverOIDCProvider(ctx, providerConfig.IssuerURL)
+	if err != nil {
+		slog.Error("Failed to discover OIDC provider", slog.Any("error", err), slog.String("provider", name))
+		return oauth2.Config{}, nil, apperror.Internal(err)
+	}
+
+	return oauth2.Config{
+		ClientID:     providerConfig.ClientID,
+		ClientSecret: providerConfig.ClientSecret,
+		Endpoint:     oidcProvider.Endpoint(),
+		RedirectURL:  providerConfig.RedirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID}, providerConfig.Scopes...),
+	}, oidcProvider, nil
+}
+
+// discoverOIDCProvider fetches the discovery document of the identity provider with the given issuer URL, caching it
+// once it succeeds.
+func discoverOIDCProvider(ctx context.Context, issuerURL string) (*oidc.Provider, error) {
+	oidcProvidersMu.Lock()
+	defer oidcProvidersMu.Unlock()
+
+	if provider, ok := oidcProviders[issuerURL]; ok {
+		return provider, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, issuerURL)
+	if err != nil {
+		return nil, err
+	}
+	oidcProviders[issuerURL] = provider
+
+	return provider, nil
+}
@@ -0,0 +1,328 @@
+package service_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// mockIdP is a minimal OpenID Connect identity provider, that issues ID tokens for codes registered with authorize.
+type mockIdP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockIdPGrant
+}
+
+// mockIdPGrant is what the mock identity provider remembers about an authorization code.
+type mockIdPGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	idp := &mockIdP{key: key, codes: make(map[string]mockIdPGrant)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                idp.URL,
+			"authorization_endpoint":                idp.URL + "/authorize",
+			"token_endpoint":                        idp.URL + "/token",
+			"jwks_uri":                              idp.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "idp-key",
+				"alg": "RS256",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.token)
+
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+
+	return idp
+}
+
+// authorize simulates the user logging in at the authorization URL, and returns the code that the provider would
+// redirect back with. The claims are added to the ID token, along with the nonce from the URL.
+func (idp *mockIdP) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+
+	idTokenClaims := jwt.MapClaims{
+		"iss":   idp.URL,
+		"aud":   query.Get("client_id"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		idTokenClaims[name] = value
+	}
+
+	code := rand.Text()
+	idp.mu.Lock()
+	idp.codes[code] = mockIdPGrant{challenge: query.Get("code_challenge"), claims: idTokenClaims}
+	idp.mu.Unlock()
+
+	return code
+}
+
+// token exchanges an authorization code for an ID token, checking the PKCE verifier like a real provider.
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.FormValue("code")]
+	delete(idp.codes, r.FormValue("code"))
+	idp.mu.Unlock()
+
+	verifierHash := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifierHash[:]) != grant.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+	token.Header["kid"] = "idp-key"
+	idToken, _ := token.SignedString(idp.key)
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access-token",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+// newOIDCService creates an OIDC service that logs in with the mock identity provider, as the "mock" provider.
+func newOIDCService(idp *mockIdP, dbService database.Service) service.OIDCService {
+	return service.OIDCService{
+		Service:     service.Service{DBService: dbService},
+		UserService: service.UserService{Service: service.Service{DBService: dbService}, AuthService: service.AuthService{}},
+		Providers: map[string]config.OIDCProvider{
+			"mock": {
+				Name:         "mock",
+				IssuerURL:    idp.URL,
+				ClientID:     "notes",
+				ClientSecret: "secret",
+				RedirectURL:  "http://localhost:3000/api/v1/users/oidc/mock/callback",
+				Scopes:       []string{"email", "profile"},
+			},
+		},
+	}
+}
+
+func TestOIDCAuthCodeURL(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newOIDCService(idp, database.Service{})
+
+	assert.Equal(t, []string{"mock"}, svc.ProviderNames())
+
+	authURL, state, err := svc.AuthCodeURL("mock")
+	require.NoError(t, err)
+
+	// Assert that the user is sent to the provider with PKCE, and the state and nonce to check in the callback
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	query := parsed.Query()
+	assert.Equal(t, idp.URL+"/authorize", parsed.Scheme+"://"+parsed.Host+parsed.Path)
+	assert.Equal(t, "code", query.Get("response_type"))
+	assert.Equal(t, "notes", query.Get("client_id"))
+	assert.Equal(t, "http://localhost:3000/api/v1/users/oidc/mock/callback", query.Get("redirect_uri"))
+	assert.Equal(t, "openid email profile", query.Get("scope"))
+	assert.Equal(t, state.State, query.Get("state"))
+	assert.Equal(t, state.Nonce, query.Get("nonce"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+	assert.NotEmpty(t, query.Get("code_challenge"))
+	assert.NotContains(t, authURL, state.CodeVerifier)
+
+	_, _, err = svc.AuthCodeURL("unknown")
+	assert.ErrorIs(t, err, apperror.ErrOIDCProviderNotFound)
+}
+
+func TestOIDCExchangeRejectsInvalidLogins(t *testing.T) {
+	idp := newMockIdP(t)
+	svc := newOIDCService(idp, database.Service{})
+	claims := jwt.MapClaims{"sub": "user-1", "email": "john.doe@example.com", "email_verified": true}
+
+	tests := map[string]func(authURL string, state service.OIDCState) (service.OIDCState, string, string){
+		"state mismatch": func(authURL string, state service.OIDCState) (service.OIDCState, string, string) {
+			return state, "other-state", idp.authorize(t, authURL, claims)
+		},
+		"missing state cookie": func(authURL string, state service.OIDCState) (service.OIDCState, string, string) {
+			return service.OIDCState{}, "", idp.authorize(t, authURL, claims)
+		},
+		"wrong code verifier": func(authURL string, state service.OIDCState) (service.OIDCState, string, string) {
+			code := idp.authorize(t, authURL, claims)
+			state.CodeVerifier = "stolen-code-without-verifier-aaaaaaaaaaaaaaaaaaaaaa"
+			return state, state.State, code
+		},
+		"unknown code": func(authURL string, state service.OIDCState) (service.OIDCState, string, string) {
+			return state, state.State, "unknown-code"
+		},
+		"nonce mismatch": func(authURL string, state service.OIDCState) (service.OIDCState, string, string) {
+			return state, state.State, idp.authorize(t, authURL, jwt.MapClaims{"sub": "user-1", "nonce": "replayed"})
+		},
+		"wrong audience": func(authURL string, state service.OIDCState) (service.OIDCState, string, string) {
+			return state, state.State, idp.authorize(t, authURL, jwt.MapClaims{"sub": "user-1", "aud": "other-app"})
+		},
+		"wrong issuer": func(authURL string, state service.OIDCState) (service.OIDCState, string, string) {
+			return state, state.State, idp.authorize(t, authURL, jwt.MapClaims{"sub": "user-1", "iss": "https://evil.example.com"})
+		},
+		"expired id token": func(authURL string, state service.OIDCState) (service.OIDCState, string, string) {
+			expired := jwt.MapClaims{"sub": "user-1", "exp": time.Now().Add(-time.Hour).Unix()}
+			return state, state.State, idp.authorize(t, authURL, expired)
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			authURL, state, err := svc.AuthCodeURL("mock")
+			require.NoError(t, err)
+
+			state, returnedState, code := tt(authURL, state)
+			_, err = svc.Exchange("mock", state, returnedState, code, nil)
+			assert.ErrorIs(t, err, apperror.ErrOIDCLoginFailed)
+		})
+	}
+
+	_, err := svc.Exchange("unknown", service.OIDCState{}, "", "", nil)
+	assert.ErrorIs(t, err, apperror.ErrOIDCProviderNotFound)
+}
+
+type OIDCServiceTestSuite struct {
+	suite.Suite
+	dbService database.Service
+	idp       *mockIdP
+}
+
+func (suite *OIDCServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Start the identity provider to log in with
+	suite.idp = newMockIdP(suite.T())
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *OIDCServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+
+	slog.Debug("Setup test")
+}
+
+// login logs in with the mock identity provider as the user with the given claims.
+func (suite *OIDCServiceTestSuite) login(claims jwt.MapClaims) (models.User, error) {
+	svc := newOIDCService(suite.idp, suite.dbService)
+
+	authURL, state, err := svc.AuthCodeURL("mock")
+	suite.Require().NoError(err)
+
+	return svc.Exchange("mock", state, state.State, suite.idp.authorize(suite.T(), authURL, claims), nil)
+}
+
+func (suite *OIDCServiceTestSuite) TestNewUser() {
+	// The random password of new users isn't held to the password policy, which it could fail by chance
+	cfg := config.Get()
+	defaultMinLength := cfg.PasswordMinLength
+	cfg.PasswordMinLength = 1000
+	defer func() { cfg.PasswordMinLength = defaultMinLength }()
+
+	// Log in for the first time, which creates a verified user
+	user, errLogin := suite.login(jwt.MapClaims{
+		"sub":            "user-1",
+		"email":          "John.Doe@example.com",
+		"email_verified": true,
+		"name":           "John Doe",
+	})
+	suite.NoError(errLogin)
+	suite.Equal("john.doe@example.com", user.Email)
+	suite.Equal("John Doe", user.Name)
+	suite.True(user.EmailVerified)
+
+	// Log in again after the email changed with the provider, which finds the same user by subject
+	again, errLogin := suite.login(jwt.MapClaims{"sub": "user-1", "email": "john@example.com"})
+	suite.NoError(errLogin)
+	suite.Equal(user.ID, again.ID)
+
+	var identity models.OIDCIdentity
+	suite.NoError(suite.dbService.GetDB().Where("user_id = ?", user.ID).First(&identity).Error)
+	suite.Equal("mock", identity.Provider)
+	suite.Equal("user-1", identity.Subject)
+	suite.Equal("john@example.com", identity.Email)
+}
+
+func (suite *OIDCServiceTestSuite) TestLinkExistingUser() {
+	db := suite.dbService.GetDB()
+	verified := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "password", EmailVerified: true}
+	suite.NoError(db.Create(&verified).Error)
+	unverified := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "password"}
+	suite.NoError(db.Create(&unverified).Error)
+
+	// Users with a verified email are linked by it
+	user, errLogin := suite.login(jwt.MapClaims{"sub": "user-1", "email": "john.doe@example.com", "email_verified": true})
+	suite.NoError(errLogin)
+	suite.Equal(verified.ID, user.ID)
+
+	// Users that haven't verified their email are not, since someone else could have registered with it
+	_, errLogin = suite.login(jwt.MapClaims{"sub": "user-2", "email": "jane.doe@example.com", "email_verified": true})
+	suite.ErrorIs(errLogin, apperror.ErrEmailNotVerified)
+
+	// Nobody is linked by an email that the provider hasn't verified
+	_, errLogin = suite.login(jwt.MapClaims{"sub": "user-3", "email": "john.doe@example.com", "email_verified": false})
+	suite.ErrorIs(errLogin, apperror.ErrOIDCLoginFailed)
+
+	var count int64
+	db.Model(&models.OIDCIdentity{}).Count(&count)
+	suite.Equal(int64(1), count)
+}
+
+func TestOIDCService(t *testing.T) {
+	suite.Run(t, new(OIDCServiceTestSuite))
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"errors"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/models"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Purposes of one-time tokens.
+const (
+	tokenPurposeEmailVerification = "email_verification"
+	tokenPurposePasswordReset     = "password_reset"
+	tokenPurposeMFAChallenge      = "mfa_challenge"
+	tokenPurposeMagicLink         = "magic_link"
+)
+
+// issueOneTimeToken issues a new one-time token for the given purpose to the user, replacing any unused tokens that
+// were issued to the user for the same purpose, so that only the latest link works.
+//
+// Returns the plaintext token, which is only stored as a hash.
+func issueOneTimeToken(db *gorm.DB, user models.User, purpose string, ttl time.Duration) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		slog.Error("Failed to generate one-time token", slog.Any("error", err))
+		return "", apperror.Internal(err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ? AND purpose = ? AND used_at IS NULL", user.ID, purpose).
+			Delete(&models.OneTimeToken{})
+		if result.Error != nil {
+			return result.Error
+		}
+
+		return tx.Create(&models.OneTimeToken{
+			UserID:    user.ID,
+			Purpose:   purpose,
+			Email:     user.Email,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(ttl),
+		}).Error
+	})
+	if err != nil {
+		slog.Error("Failed to create one-time token", slog.Any("error", err))
+		return "", apperror.Internal(err)
+	}
+
+	return token, nil
+}
+
+// consumeOneTimeToken marks the one-time token as used, as long as it was issued for the given purpose and is still
+// valid. It should be called in a transaction along with the action that the token allows, so that both either happen
+// or not.
+//
+// Returns the used token, apperror.ErrTokenInvalid if it doesn't exist or was already used, or apperror.ErrTokenExpired.
+func consumeOneTimeToken(db *gorm.DB, token, purpose string) (models.OneTimeToken, error) {
+	// Lock the token so that concurrent requests can't both use it
+	oneTimeToken, err := findOneTimeToken(db.Clauses(clause.Locking{Strength: "UPDATE"}), token, purpose)
+	if err != nil {
+		return oneTimeToken, err
+	}
+
+	if err := db.Model(&oneTimeToken).Update("used_at", time.Now()).Error; err != nil {
+		slog.Error("Failed to use one-time token", slog.Any("error", err))
+		return oneTimeToken, apperror.Internal(err)
+	}
+
+	return oneTimeToken, nil
+}
+
+// findOneTimeToken fetches the one-time token without using it up, as long as it was issued for the given purpose and is
+// still valid.
+//
+// Returns apperror.ErrTokenInvalid if it doesn't exist or was already used, or apperror.ErrTokenExpired.
+func findOneTimeToken(db *gorm.DB, token, purpose string) (models.OneTimeToken, error) {
+	var oneTimeToken models.OneTimeToken
+	result := db.Where("token_hash = ? AND purpose = ?", hashToken(token), purpose).First(&oneTimeToken)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return oneTimeToken, apperror.ErrTokenInvalid
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch one-time token", slog.Any("error", result.Error))
+		return oneTimeToken, apperror.Internal(result.Error)
+	}
+
+	if oneTimeToken.UsedAt != nil {
+		return oneTimeToken, apperror.ErrTokenInvalid
+	}
+	if time.Now().After(oneTimeToken.ExpiresAt) {
+		return oneTimeToken, apperror.ErrTokenExpired
+	}
+
+	return oneTimeToken, nil
+}
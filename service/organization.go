@@ -0,0 +1,725 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/mailer"
+	"notes-app/models"
+	"notes-app/utils"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// OrgMembership is an organization along with the role of a user in it.
+type OrgMembership struct {
+	models.Organization
+	Role string `json:"role"`
+}
+
+// OrgMember is a member of an organization, along with the details that the other members can see.
+type OrgMember struct {
+	UserID    uint      `json:"user_id"`
+	Name      string    `json:"name"`
+	Username  *string   `json:"username"`
+	Email     string    `json:"email"`
+	AvatarURL string    `json:"avatar_url"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+type IOrganizationService interface {
+	// Create creates an organization, with the user with the given ID as its owner.
+	// Accepts optional DBOpts to specify a DB instance.
+	Create(userID uint, name string, opts *DBOpts) (OrgMembership, error)
+
+	// List lists the organizations that the user with the given ID is a member of, sorted by name.
+	// Accepts optional DBOpts to specify a DB instance.
+	List(userID uint, opts *DBOpts) ([]OrgMembership, error)
+
+	// Get retrieves an organization that the user with the given ID is a member of.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrOrganizationNotFound if there is no such organization, or the user is not a member.
+	Get(userID, orgID uint, opts *DBOpts) (OrgMembership, error)
+
+	// RequireRole checks that the user with the given ID is a member of the organization, with at least the given role.
+	// Anything that belongs to an organization must be checked with it before it is read or changed.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the user's role, apperror.ErrOrganizationNotFound if the user is not a member, or
+	// apperror.ErrInsufficientRole.
+	RequireRole(userID, orgID uint, minRole string, opts *DBOpts) (string, error)
+
+	// Rename changes the name of an organization. Only admins and owners can rename it.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the renamed organization, apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+	Rename(userID, orgID uint, name string, opts *DBOpts) (OrgMembership, error)
+
+	// Delete deletes an organization, along with its memberships, invitations, groups and notes. Only owners can
+	// delete it.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+	Delete(userID, orgID uint, opts *DBOpts) error
+
+	// ListMembers lists the members of an organization, sorted by name. Guests can't see the other members.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+	ListMembers(userID, orgID uint, opts *DBOpts) ([]OrgMember, error)
+
+	// UpdateMemberRole changes the role of a member of an organization. Admins can manage everyone but owners.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the updated membership, apperror.ErrOrganizationNotFound, apperror.ErrMemberNotFound,
+	// apperror.ErrInsufficientRole, apperror.ErrValidationFailed if the role is unknown, or apperror.ErrLastOwner if
+	// the last owner would be demoted.
+	UpdateMemberRole(userID, orgID, memberID uint, role string, opts *DBOpts) (models.OrganizationMember, error)
+
+	// RemoveMember removes a member from an organization. Members can always leave, and admins can remove everyone
+	// but owners.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrOrganizationNotFound, apperror.ErrMemberNotFound, apperror.ErrInsufficientRole, or
+	// apperror.ErrLastOwner if the last owner would be removed.
+	RemoveMember(userID, orgID, memberID uint, opts *DBOpts) error
+
+	// Invite emails an invitation to join an organization with the given role, whether or not the email is
+	// registered. Only admins and owners can invite, and not to a role above their own.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the invitation, apperror.ErrOrganizationNotFound, apperror.ErrInsufficientRole, or
+	// apperror.ErrAlreadyMember.
+	Invite(userID, orgID uint, email, role string, opts *DBOpts) (models.OrganizationInvitation, error)
+
+	// ListInvitations lists the invitations to an organization that haven't been accepted or expired yet. Only admins
+	// and owners can see them.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+	ListInvitations(userID, orgID uint, opts *DBOpts) ([]models.OrganizationInvitation, error)
+
+	// RevokeInvitation deletes an invitation to an organization, so that it can't be accepted anymore.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrOrganizationNotFound, apperror.ErrInsufficientRole or apperror.ErrInvitationNotFound.
+	RevokeInvitation(userID, orgID, invitationID uint, opts *DBOpts) error
+
+	// AcceptInvitation makes the user with the given ID a member of the organization that the token invites them to.
+	// The user must have verified the email that the invitation was sent to.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the organization, apperror.ErrTokenInvalid, apperror.ErrTokenExpired,
+	// apperror.ErrInvitationEmailMismatch or apperror.ErrEmailNotVerified.
+	AcceptInvitation(userID uint, token string, opts *DBOpts) (OrgMembership, error)
+}
+
+type OrganizationService struct {
+	Service
+
+	// Mailer is used to send invitations, which should be created from config at startup with mailer.New.
+	Mailer mailer.Mailer
+}
+
+// Create creates an organization, with the user with the given ID as its owner.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc OrganizationService) Create(userID uint, name string, opts *DBOpts) (OrgMembership, error) {
+	db := svc.getDB(opts)
+
+	membership := OrgMembership{Organization: models.Organization{Name: name}, Role: models.OrgRoleOwner}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&membership.Organization).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.OrganizationMember{
+			OrganizationID: membership.ID,
+			UserID:         userID,
+			Role:           models.OrgRoleOwner,
+		}).Error
+	})
+	if err != nil {
+		slog.Error("Failed to create organization", slog.Any("error", err))
+		return OrgMembership{}, apperror.Internal(err)
+	}
+
+	return membership, nil
+}
+
+// List lists the organizations that the user with the given ID is a member of, sorted by name.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc OrganizationService) List(userID uint, opts *DBOpts) ([]OrgMembership, error) {
+	db := svc.getDB(opts)
+
+	memberships := make([]OrgMembership, 0)
+	result := membershipsQuery(db, userID).Order("organizations.name, organizations.id").Scan(&memberships)
+	if result.Error != nil {
+		slog.Error("Failed to list organizations", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	return memberships, nil
+}
+
+// Get retrieves an organization that the user with the given ID is a member of.
+//
+// Organizations that the user is not a member of are reported as not found, so that their existence isn't given away.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrOrganizationNotFound if there is no such organization, or the user is not a member.
+func (svc OrganizationService) Get(userID, orgID uint, opts *DBOpts) (OrgMembership, error) {
+	db := svc.getDB(opts)
+
+	var membership OrgMembership
+	result := membershipsQuery(db, userID).Where("organizations.id = ?", orgID).Limit(1).Scan(&membership)
+	if result.Error != nil {
+		slog.Error("Failed to fetch organization", slog.Any("error", result.Error))
+		return OrgMembership{}, apperror.Internal(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return OrgMembership{}, apperror.ErrOrganizationNotFound
+	}
+
+	return membership, nil
+}
+
+// RequireRole checks that the user with the given ID is a member of the organization, with at least the given role.
+//
+// Anything that belongs to an organization must be checked with it before it is read or changed, so that one
+// organization can never see another's data.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the user's role, apperror.ErrOrganizationNotFound if the user is not a member, or
+// apperror.ErrInsufficientRole.
+func (svc OrganizationService) RequireRole(userID, orgID uint, minRole string, opts *DBOpts) (string, error) {
+	member, err := findMember(svc.getDB(opts), orgID, userID, false)
+	if errors.Is(err, apperror.ErrMemberNotFound) {
+		return "", apperror.ErrOrganizationNotFound
+	} else if err != nil {
+		return "", err
+	}
+
+	if orgRoleRank(member.Role) < orgRoleRank(minRole) {
+		return member.Role, apperror.ErrInsufficientRole
+	}
+
+	return member.Role, nil
+}
+
+// Rename changes the name of an organization. Only admins and owners can rename it.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the renamed organization, apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+func (svc OrganizationService) Rename(userID, orgID uint, name string, opts *DBOpts) (OrgMembership, error) {
+	db := svc.getDB(opts)
+
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, opts); err != nil {
+		return OrgMembership{}, err
+	}
+
+	result := db.Model(&models.Organization{ID: orgID}).Update("name", name)
+	if result.Error != nil {
+		slog.Error("Failed to rename organization", slog.Any("error", result.Error))
+		return OrgMembership{}, apperror.Internal(result.Error)
+	}
+
+	return svc.Get(userID, orgID, opts)
+}
+
+// Delete deletes an organization, along with its memberships, invitations, groups and notes. Only owners can delete
+// it.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+func (svc OrganizationService) Delete(userID, orgID uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleOwner, opts); err != nil {
+		return err
+	}
+
+	if err := db.Transaction(func(tx *gorm.DB) error { return deleteOrganization(tx, orgID) }); err != nil {
+		slog.Error("Failed to delete organization", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+
+	return nil
+}
+
+// ListMembers lists the members of an organization, sorted by name. Guests can't see the other members.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+func (svc OrganizationService) ListMembers(userID, orgID uint, opts *DBOpts) ([]OrgMember, error) {
+	db := svc.getDB(opts)
+
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleMember, opts); err != nil {
+		return nil, err
+	}
+
+	members := make([]OrgMember, 0)
+	result := db.Table("organization_members").
+		Select("users.id AS user_id, users.name, users.username, users.email, users.avatar_url, "+
+			"organization_members.role, organization_members.created_at AS joined_at").
+		Joins("JOIN users ON users.id = organization_members.user_id AND users.deleted_at IS NULL").
+		Where("organization_members.organization_id = ?", orgID).
+		Order("users.name, users.id").
+		Scan(&members)
+	if result.Error != nil {
+		slog.Error("Failed to list organization members", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	return members, nil
+}
+
+// UpdateMemberRole changes the role of a member of an organization.
+//
+// Admins can manage everyone but owners, and nobody can give a role above their own. Owners can step down, as long as
+// another owner is left.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the updated membership, apperror.ErrOrganizationNotFound, apperror.ErrMemberNotFound,
+// apperror.ErrInsufficientRole, apperror.ErrValidationFailed if the role is unknown, or apperror.ErrLastOwner if the
+// last owner would be demoted.
+func (svc OrganizationService) UpdateMemberRole(userID, orgID, memberID uint, role string, opts *DBOpts) (models.OrganizationMember, error) {
+	db := svc.getDB(opts)
+
+	if err := checkOrgRole(role); err != nil {
+		return models.OrganizationMember{}, err
+	}
+
+	var member models.OrganizationMember
+	err := db.Transaction(func(tx *gorm.DB) error {
+		actorRole, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+
+		member, err = findMember(tx, orgID, memberID, true)
+		if err != nil {
+			return err
+		}
+		if orgRoleRank(member.Role) > orgRoleRank(actorRole) || orgRoleRank(role) > orgRoleRank(actorRole) {
+			return apperror.ErrInsufficientRole
+		}
+		if member.Role == role {
+			return nil
+		}
+		if member.Role == models.OrgRoleOwner {
+			if err := requireAnotherOwner(tx, orgID, memberID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Model(&member).Update("role", role).Error; err != nil {
+			slog.Error("Failed to update organization member", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+
+	return member, err
+}
+
+// RemoveMember removes a member from an organization.
+//
+// Members can always leave, and admins can remove everyone but owners. The last owner can't leave, so that the
+// organization can still be managed.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrOrganizationNotFound, apperror.ErrMemberNotFound, apperror.ErrInsufficientRole, or
+// apperror.ErrLastOwner if the last owner would be removed.
+func (svc OrganizationService) RemoveMember(userID, orgID, memberID uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		minRole := models.OrgRoleAdmin
+		if memberID == userID {
+			minRole = models.OrgRoleGuest
+		}
+		actorRole, err := svc.RequireRole(userID, orgID, minRole, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+
+		member, err := findMember(tx, orgID, memberID, true)
+		if err != nil {
+			return err
+		}
+		if orgRoleRank(member.Role) > orgRoleRank(actorRole) {
+			return apperror.ErrInsufficientRole
+		}
+		if member.Role == models.OrgRoleOwner {
+			if err := requireAnotherOwner(tx, orgID, memberID); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Delete(&member).Error; err != nil {
+			slog.Error("Failed to remove organization member", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		err = tx.Where("user_id = ? AND group_id IN (?)", memberID,
+			tx.Model(&models.UserGroup{}).Select("id").Where("organization_id = ?", orgID)).
+			Delete(&models.UserGroupMember{}).Error
+		if err != nil {
+			slog.Error("Failed to remove member from organization groups", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+}
+
+// Invite emails an invitation to join an organization with the given role, whether or not the email is registered.
+//
+// Only admins and owners can invite, and not to a role above their own. Inviting the same email again replaces the
+// previous invitation, so that only the latest link works.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the invitation, apperror.ErrOrganizationNotFound, apperror.ErrInsufficientRole, or
+// apperror.ErrAlreadyMember.
+func (svc OrganizationService) Invite(userID, orgID uint, email, role string, opts *DBOpts) (models.OrganizationInvitation, error) {
+	db := svc.getDB(opts)
+	email = utils.NormalizeEmail(email)
+	ttl := config.Get().OrgInvitationTTL
+
+	if err := checkOrgRole(role); err != nil {
+		return models.OrganizationInvitation{}, err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		slog.Error("Failed to generate invitation token", slog.Any("error", err))
+		return models.OrganizationInvitation{}, apperror.Internal(err)
+	}
+
+	var organization OrgMembership
+	var inviter models.User
+	invitation := models.OrganizationInvitation{
+		OrganizationID: orgID,
+		Email:          email,
+		Role:           role,
+		TokenHash:      hashToken(token),
+		ExpiresAt:      time.Now().Add(ttl),
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		txOpts := &DBOpts{db: tx}
+
+		actorRole, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, txOpts)
+		if err != nil {
+			return err
+		}
+		if orgRoleRank(role) > orgRoleRank(actorRole) {
+			return apperror.ErrInsufficientRole
+		}
+
+		if organization, err = svc.Get(userID, orgID, txOpts); err != nil {
+			return err
+		}
+		if err := tx.Where("id = ?", userID).First(&inviter).Error; err != nil {
+			slog.Error("Failed to fetch inviter", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		var members int64
+		err = tx.Model(&models.OrganizationMember{}).
+			Joins("JOIN users ON users.id = organization_members.user_id").
+			Where("organization_members.organization_id = ? AND users.email = ?", orgID, email).
+			Count(&members).Error
+		if err != nil {
+			slog.Error("Failed to check organization members", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+		if members > 0 {
+			return apperror.ErrAlreadyMember
+		}
+
+		err = tx.Where("organization_id = ? AND email = ?", orgID, email).Delete(&models.OrganizationInvitation{}).Error
+		if err == nil {
+			err = tx.Create(&invitation).Error
+		}
+		if err != nil {
+			slog.Error("Failed to create invitation", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.OrganizationInvitation{}, err
+	}
+
+	err = sendEmail(svc.Mailer, mailer.Message{
+		To:      email,
+		Subject: fmt.Sprintf("%s invited you to join %s", inviter.Name, organization.Name),
+		Body: fmt.Sprintf(
+			"Hi,\n\n"+
+				"%s invited you to join %s as %s %s. To accept, open this link, and sign up with this email if you "+
+				"don't have an account yet:\n\n%s\n\n"+
+				"The invitation expires in %s. If you don't want to join, you can ignore this email.\n",
+			inviter.Name, organization.Name, article(role), role, appLink("/invitations/accept", token), ttl,
+		),
+	})
+	if err != nil {
+		// The invitation can be sent again, which replaces it
+		slog.Error("Failed to send invitation email", slog.Any("error", err), slog.Any("invitationID", invitation.ID))
+		return models.OrganizationInvitation{}, apperror.Internal(err)
+	}
+
+	return invitation, nil
+}
+
+// ListInvitations lists the invitations to an organization that haven't been accepted or expired yet. Only admins
+// and owners can see them.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrOrganizationNotFound or apperror.ErrInsufficientRole.
+func (svc OrganizationService) ListInvitations(userID, orgID uint, opts *DBOpts) ([]models.OrganizationInvitation, error) {
+	db := svc.getDB(opts)
+
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, opts); err != nil {
+		return nil, err
+	}
+
+	invitations := make([]models.OrganizationInvitation, 0)
+	result := db.Where("organization_id = ? AND expires_at > ?", orgID, time.Now()).Order("created_at").
+		Find(&invitations)
+	if result.Error != nil {
+		slog.Error("Failed to list invitations", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation deletes an invitation to an organization, so that it can't be accepted anymore.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrOrganizationNotFound, apperror.ErrInsufficientRole or apperror.ErrInvitationNotFound.
+func (svc OrganizationService) RevokeInvitation(userID, orgID, invitationID uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	if _, err := svc.RequireRole(userID, orgID, models.OrgRoleAdmin, opts); err != nil {
+		return err
+	}
+
+	result := db.Where("id = ? AND organization_id = ?", invitationID, orgID).Delete(&models.OrganizationInvitation{})
+	if result.Error != nil {
+		slog.Error("Failed to revoke invitation", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.ErrInvitationNotFound
+	}
+
+	return nil
+}
+
+// AcceptInvitation makes the user with the given ID a member of the organization that the token invites them to.
+//
+// The user must have verified the email that the invitation was sent to, so that a forwarded or leaked link can't be
+// used by someone else. Users that are already members keep their role.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the organization, apperror.ErrTokenInvalid, apperror.ErrTokenExpired,
+// apperror.ErrInvitationEmailMismatch or apperror.ErrEmailNotVerified.
+func (svc OrganizationService) AcceptInvitation(userID uint, token string, opts *DBOpts) (OrgMembership, error) {
+	db := svc.getDB(opts)
+
+	var membership OrgMembership
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the invitation so that concurrent requests can't both use it
+		var invitation models.OrganizationInvitation
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("token_hash = ?", hashToken(token)).
+			First(&invitation)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return apperror.ErrTokenInvalid
+		} else if result.Error != nil {
+			slog.Error("Failed to fetch invitation", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+		if time.Now().After(invitation.ExpiresAt) {
+			return apperror.ErrTokenExpired
+		}
+
+		var user models.User
+		if err := tx.Where("id = ?", userID).First(&user).Error; err != nil {
+			slog.Error("Failed to fetch user", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+		if user.Email != invitation.Email {
+			return apperror.ErrInvitationEmailMismatch
+		}
+		if !user.EmailVerified {
+			return apperror.ErrEmailNotVerified
+		}
+
+		result = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.OrganizationMember{
+			OrganizationID: invitation.OrganizationID,
+			UserID:         userID,
+			Role:           invitation.Role,
+		})
+		if result.Error == nil {
+			result = tx.Delete(&invitation)
+		}
+		if result.Error != nil {
+			slog.Error("Failed to accept invitation", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		var err error
+		membership, err = svc.Get(userID, invitation.OrganizationID, &DBOpts{db: tx})
+		return err
+	})
+
+	return membership, err
+}
+
+// membershipsQuery selects the organizations that the user is a member of, along with their role in each.
+func membershipsQuery(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Table("organizations").
+		Select("organizations.*, organization_members.role").
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userID)
+}
+
+// findMember retrieves the membership of a user in an organization, locking it if lock is set.
+//
+// Returns apperror.ErrMemberNotFound if the user is not a member.
+func findMember(db *gorm.DB, orgID, userID uint, lock bool) (models.OrganizationMember, error) {
+	if lock {
+		db = db.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	var member models.OrganizationMember
+	result := db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return member, apperror.ErrMemberNotFound
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch organization member", slog.Any("error", result.Error))
+		return member, apperror.Internal(result.Error)
+	}
+
+	return member, nil
+}
+
+// requireAnotherOwner checks that the organization has an owner other than the given user, locking the owners so that
+// two owners can't both step down at the same time.
+//
+// Returns apperror.ErrLastOwner if there is no other owner.
+func requireAnotherOwner(tx *gorm.DB, orgID, userID uint) error {
+	var owners []models.OrganizationMember
+	result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("organization_id = ? AND role = ?", orgID, models.OrgRoleOwner).
+		Find(&owners)
+	if result.Error != nil {
+		slog.Error("Failed to fetch organization owners", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	for _, owner := range owners {
+		if owner.UserID != userID {
+			return nil
+		}
+	}
+
+	return apperror.ErrLastOwner
+}
+
+// leaveOrganizations removes the user from all their organizations, when their account is deleted. Organizations that
+// they are the only member of are deleted along with them.
+//
+// Returns apperror.ErrLastOwner if they are the last owner of an organization that has other members, who would be
+// left without anyone to manage it.
+func leaveOrganizations(tx *gorm.DB, userID uint) error {
+	var owned []models.OrganizationMember
+	if err := tx.Where("user_id = ? AND role = ?", userID, models.OrgRoleOwner).Find(&owned).Error; err != nil {
+		slog.Error("Failed to fetch owned organizations", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+
+	for _, membership := range owned {
+		err := requireAnotherOwner(tx, membership.OrganizationID, userID)
+		if !errors.Is(err, apperror.ErrLastOwner) {
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		var members int64
+		if err := tx.Model(&models.OrganizationMember{}).Where("organization_id = ?", membership.OrganizationID).
+			Count(&members).Error; err != nil {
+			slog.Error("Failed to count organization members", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+		if members > 1 {
+			return apperror.ErrLastOwner.WithCause(fmt.Errorf("last owner of organization %d", membership.OrganizationID))
+		}
+
+		if err := deleteOrganization(tx, membership.OrganizationID); err != nil {
+			slog.Error("Failed to delete organization", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+	}
+
+	if err := tx.Where("user_id = ?", userID).Delete(&models.OrganizationMember{}).Error; err != nil {
+		slog.Error("Failed to leave organizations", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+
+	return nil
+}
+
+// deleteOrganization deletes an organization, along with its memberships, invitations, groups and notes.
+func deleteOrganization(tx *gorm.DB, orgID uint) error {
+	if err := deleteGroups(tx, tx.Model(&models.UserGroup{}).Select("id").Where("organization_id = ?", orgID)); err != nil {
+		return err
+	}
+
+	if err := deleteNotes(tx, tx.Model(&models.Note{}).Select("id").Where("organization_id = ?", orgID)); err != nil {
+		return err
+	}
+
+	for _, model := range []any{&models.OrganizationInvitation{}, &models.OrganizationMember{}} {
+		if err := tx.Where("organization_id = ?", orgID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	return tx.Delete(&models.Organization{ID: orgID}).Error
+}
+
+// orgRoleRank ranks the roles of organization members, so that more privileged roles rank higher. Unknown roles rank
+// lowest.
+func orgRoleRank(role string) int {
+	index := slices.Index(models.OrgRoles, role)
+	if index < 0 {
+		return 0
+	}
+	return len(models.OrgRoles) - index
+}
+
+// checkOrgRole checks that a role is one of the roles of organization members.
+//
+// Returns apperror.ErrValidationFailed if it isn't.
+func checkOrgRole(role string) error {
+	if !slices.Contains(models.OrgRoles, role) {
+		return apperror.ErrValidationFailed.WithDetails(apperror.FieldError{
+			Field:   "role",
+			Message: fmt.Sprintf("must be one of: %s", strings.Join(models.OrgRoles, " ")),
+		})
+	}
+	return nil
+}
+
+// article returns the indefinite article to use before a role.
+func article(role string) string {
+	if role == models.OrgRoleOwner || role == models.OrgRoleAdmin {
+		return "an"
+	}
+	return "a"
+}
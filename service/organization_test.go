@@ -0,0 +1,259 @@
+package service_test
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type OrganizationServiceTestSuite struct {
+	suite.Suite
+	dbService           database.Service
+	userService         service.UserService
+	organizationService service.OrganizationService
+	mailer              *recordingMailer
+}
+
+func (suite *OrganizationServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the service instances to use for testing
+	suite.mailer = &recordingMailer{}
+	suite.userService = service.UserService{
+		Service:     service.Service{DBService: suite.dbService},
+		AuthService: service.AuthService{},
+		Mailer:      suite.mailer,
+		SessionService: service.SessionService{
+			Service:     service.Service{DBService: suite.dbService},
+			AuthService: service.AuthService{},
+		},
+	}
+	suite.organizationService = service.OrganizationService{
+		Service: service.Service{DBService: suite.dbService},
+		Mailer:  suite.mailer,
+	}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *OrganizationServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+	suite.mailer.messages = nil
+
+	slog.Debug("Setup test")
+}
+
+// createUser creates a user with the given name and email, which is already verified.
+func (suite *OrganizationServiceTestSuite) createUser(name, email string) models.User {
+	user := models.User{Name: name, Email: email, Password: "correct horse battery stapler"}
+	suite.Require().NoError(suite.userService.Create(&user, nil))
+	suite.Require().NoError(suite.dbService.GetDB().Model(&user).Update("email_verified", true).Error)
+
+	return user
+}
+
+// join invites the user to the organization with the given role, and accepts the invitation as them.
+func (suite *OrganizationServiceTestSuite) join(inviterID, orgID uint, user models.User, role string) {
+	_, err := suite.organizationService.Invite(inviterID, orgID, user.Email, role, nil)
+	suite.Require().NoError(err)
+
+	_, err = suite.organizationService.AcceptInvitation(user.ID, suite.mailer.lastToken(), nil)
+	suite.Require().NoError(err)
+}
+
+func (suite *OrganizationServiceTestSuite) TestTenantIsolation() {
+	svc := suite.organizationService
+	john := suite.createUser("John Doe", "john.doe@example.com")
+	jane := suite.createUser("Jane Doe", "jane.doe@example.com")
+
+	acme, err := svc.Create(john.ID, "Acme", nil)
+	suite.NoError(err)
+	suite.Equal(models.OrgRoleOwner, acme.Role)
+	globex, err := svc.Create(jane.ID, "Globex", nil)
+	suite.NoError(err)
+
+	// Each user only sees their own organization, and the other one doesn't exist as far as they can tell
+	organizations, err := svc.List(john.ID, nil)
+	suite.NoError(err)
+	suite.Len(organizations, 1)
+	suite.Equal("Acme", organizations[0].Name)
+
+	_, err = svc.Get(john.ID, globex.ID, nil)
+	suite.ErrorIs(err, apperror.ErrOrganizationNotFound)
+	_, err = svc.Rename(john.ID, globex.ID, "Mine now", nil)
+	suite.ErrorIs(err, apperror.ErrOrganizationNotFound)
+	_, err = svc.ListMembers(john.ID, globex.ID, nil)
+	suite.ErrorIs(err, apperror.ErrOrganizationNotFound)
+	_, err = svc.Invite(john.ID, globex.ID, "mallory@example.com", models.OrgRoleOwner, nil)
+	suite.ErrorIs(err, apperror.ErrOrganizationNotFound)
+	suite.ErrorIs(svc.RemoveMember(john.ID, globex.ID, jane.ID, nil), apperror.ErrOrganizationNotFound)
+	suite.ErrorIs(svc.Delete(john.ID, globex.ID, nil), apperror.ErrOrganizationNotFound)
+
+	// Revoking an invitation of another organization doesn't work through one's own either
+	invitation, err := svc.Invite(jane.ID, globex.ID, "new@example.com", models.OrgRoleMember, nil)
+	suite.NoError(err)
+	suite.ErrorIs(svc.RevokeInvitation(john.ID, acme.ID, invitation.ID, nil), apperror.ErrInvitationNotFound)
+}
+
+func (suite *OrganizationServiceTestSuite) TestInvitation() {
+	svc := suite.organizationService
+	owner := suite.createUser("John Doe", "john.doe@example.com")
+	organization, err := svc.Create(owner.ID, "Acme", nil)
+	suite.NoError(err)
+
+	// People can be invited before they register
+	invitation, err := svc.Invite(owner.ID, organization.ID, " Jane.Doe@Example.com ", models.OrgRoleMember, nil)
+	suite.NoError(err)
+	suite.Equal("jane.doe@example.com", invitation.Email)
+	suite.Len(suite.mailer.messages, 1)
+	suite.Equal("jane.doe@example.com", suite.mailer.messages[0].To)
+	token := suite.mailer.lastToken()
+
+	invitations, err := svc.ListInvitations(owner.ID, organization.ID, nil)
+	suite.NoError(err)
+	suite.Len(invitations, 1)
+
+	// The invitation can only be accepted by whoever verified the email it was sent to
+	mallory := suite.createUser("Mallory", "mallory@example.com")
+	_, err = svc.AcceptInvitation(mallory.ID, token, nil)
+	suite.ErrorIs(err, apperror.ErrInvitationEmailMismatch)
+
+	jane := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "correct horse battery stapler"}
+	suite.NoError(suite.userService.Create(&jane, nil))
+	_, err = svc.AcceptInvitation(jane.ID, token, nil)
+	suite.ErrorIs(err, apperror.ErrEmailNotVerified)
+
+	suite.NoError(suite.dbService.GetDB().Model(&jane).Update("email_verified", true).Error)
+	joined, err := svc.AcceptInvitation(jane.ID, token, nil)
+	suite.NoError(err)
+	suite.Equal(organization.ID, joined.ID)
+	suite.Equal(models.OrgRoleMember, joined.Role)
+
+	// The invitation is used up, and members can't be invited again
+	_, err = svc.AcceptInvitation(jane.ID, token, nil)
+	suite.ErrorIs(err, apperror.ErrTokenInvalid)
+	_, err = svc.Invite(owner.ID, organization.ID, jane.Email, models.OrgRoleAdmin, nil)
+	suite.ErrorIs(err, apperror.ErrAlreadyMember)
+
+	// Members can't invite, and inviting again replaces the invitation
+	_, err = svc.Invite(jane.ID, organization.ID, mallory.Email, models.OrgRoleGuest, nil)
+	suite.ErrorIs(err, apperror.ErrInsufficientRole)
+
+	_, err = svc.Invite(owner.ID, organization.ID, mallory.Email, models.OrgRoleGuest, nil)
+	suite.NoError(err)
+	first := suite.mailer.lastToken()
+	revoked, err := svc.Invite(owner.ID, organization.ID, mallory.Email, models.OrgRoleGuest, nil)
+	suite.NoError(err)
+	_, err = svc.AcceptInvitation(mallory.ID, first, nil)
+	suite.ErrorIs(err, apperror.ErrTokenInvalid)
+
+	suite.NoError(svc.RevokeInvitation(owner.ID, organization.ID, revoked.ID, nil))
+	_, err = svc.AcceptInvitation(mallory.ID, suite.mailer.lastToken(), nil)
+	suite.ErrorIs(err, apperror.ErrTokenInvalid)
+}
+
+func (suite *OrganizationServiceTestSuite) TestRoles() {
+	svc := suite.organizationService
+	owner := suite.createUser("John Doe", "john.doe@example.com")
+	admin := suite.createUser("Jane Doe", "jane.doe@example.com")
+	member := suite.createUser("Jim Doe", "jim.doe@example.com")
+	guest := suite.createUser("Joe Guest", "joe@example.com")
+
+	organization, err := svc.Create(owner.ID, "Acme", nil)
+	suite.NoError(err)
+	suite.join(owner.ID, organization.ID, admin, models.OrgRoleAdmin)
+	suite.join(admin.ID, organization.ID, member, models.OrgRoleMember)
+	suite.join(admin.ID, organization.ID, guest, models.OrgRoleGuest)
+
+	// Admins can't invite or promote anyone above themselves, or manage owners
+	_, err = svc.Invite(admin.ID, organization.ID, "new@example.com", models.OrgRoleOwner, nil)
+	suite.ErrorIs(err, apperror.ErrInsufficientRole)
+	_, err = svc.UpdateMemberRole(admin.ID, organization.ID, member.ID, models.OrgRoleOwner, nil)
+	suite.ErrorIs(err, apperror.ErrInsufficientRole)
+	_, err = svc.UpdateMemberRole(admin.ID, organization.ID, owner.ID, models.OrgRoleMember, nil)
+	suite.ErrorIs(err, apperror.ErrInsufficientRole)
+	suite.ErrorIs(svc.RemoveMember(admin.ID, organization.ID, owner.ID, nil), apperror.ErrInsufficientRole)
+	suite.ErrorIs(svc.Delete(admin.ID, organization.ID, nil), apperror.ErrInsufficientRole)
+
+	updated, err := svc.UpdateMemberRole(admin.ID, organization.ID, member.ID, models.OrgRoleAdmin, nil)
+	suite.NoError(err)
+	suite.Equal(models.OrgRoleAdmin, updated.Role)
+	_, err = svc.UpdateMemberRole(admin.ID, organization.ID, owner.ID+1000, models.OrgRoleMember, nil)
+	suite.ErrorIs(err, apperror.ErrMemberNotFound)
+
+	// Guests can't see the other members, but can leave
+	_, err = svc.ListMembers(guest.ID, organization.ID, nil)
+	suite.ErrorIs(err, apperror.ErrInsufficientRole)
+	suite.NoError(svc.RemoveMember(guest.ID, organization.ID, guest.ID, nil))
+	_, err = svc.Get(guest.ID, organization.ID, nil)
+	suite.ErrorIs(err, apperror.ErrOrganizationNotFound)
+
+	members, err := svc.ListMembers(member.ID, organization.ID, nil)
+	suite.NoError(err)
+	suite.Len(members, 3)
+
+	// The last owner can't step down or leave until there is another owner
+	_, err = svc.UpdateMemberRole(owner.ID, organization.ID, owner.ID, models.OrgRoleAdmin, nil)
+	suite.ErrorIs(err, apperror.ErrLastOwner)
+	suite.ErrorIs(svc.RemoveMember(owner.ID, organization.ID, owner.ID, nil), apperror.ErrLastOwner)
+
+	_, err = svc.UpdateMemberRole(owner.ID, organization.ID, admin.ID, models.OrgRoleOwner, nil)
+	suite.NoError(err)
+	suite.NoError(svc.RemoveMember(owner.ID, organization.ID, owner.ID, nil))
+
+	// Only owners can delete the organization
+	suite.NoError(svc.Delete(admin.ID, organization.ID, nil))
+	_, err = svc.Get(admin.ID, organization.ID, nil)
+	suite.ErrorIs(err, apperror.ErrOrganizationNotFound)
+}
+
+func (suite *OrganizationServiceTestSuite) TestAccountDeletion() {
+	svc := suite.organizationService
+	owner := suite.createUser("John Doe", "john.doe@example.com")
+	member := suite.createUser("Jane Doe", "jane.doe@example.com")
+
+	solo, err := svc.Create(owner.ID, "Solo", nil)
+	suite.NoError(err)
+	shared, err := svc.Create(owner.ID, "Shared", nil)
+	suite.NoError(err)
+	suite.join(owner.ID, shared.ID, member, models.OrgRoleMember)
+
+	// Members of the same organization can find each other, even if they aren't discoverable
+	found, err := suite.userService.Search(member.ID, "john", 0, nil)
+	suite.NoError(err)
+	suite.Len(found, 1)
+
+	// The last owner of an organization with other members has to hand it over before deleting their account
+	password := "correct horse battery stapler"
+	suite.ErrorIs(suite.userService.Delete(owner.ID, password, nil), apperror.ErrLastOwner)
+
+	_, err = svc.UpdateMemberRole(owner.ID, shared.ID, member.ID, models.OrgRoleOwner, nil)
+	suite.NoError(err)
+	suite.NoError(suite.userService.Delete(owner.ID, password, nil))
+
+	// Organizations that nobody else was in are deleted with the account
+	_, err = svc.Get(owner.ID, solo.ID, nil)
+	suite.ErrorIs(err, apperror.ErrOrganizationNotFound)
+	members, err := svc.ListMembers(member.ID, shared.ID, nil)
+	suite.NoError(err)
+	suite.Len(members, 1)
+}
+
+func TestOrganizationService(t *testing.T) {
+	suite.Run(t, new(OrganizationServiceTestSuite))
+}
@@ -0,0 +1,303 @@
+package service
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/nbutton23/zxcvbn-go"
+)
+
+// MaxPasswordLength is the maximum length of a password in bytes.
+//
+// It isn't there for security, since longer passwords would be fine, but to cap how much work a single request can make
+// the server do by hashing, breach checking and scoring a huge password. Bcrypt only uses the first 72 bytes of a
+// password, so passwords are capped at that instead while it is the configured algorithm, see maxPasswordBytes.
+const MaxPasswordLength = 1024
+
+// bcryptMaxPasswordLength is the maximum length of a password in bytes that bcrypt can hash.
+const bcryptMaxPasswordLength = 72
+
+// maxScoredPasswordLength is the number of characters of a password that are scored for strength, since scoring gets
+// expensive for long inputs and anything longer is strong enough anyway.
+const maxScoredPasswordLength = 100
+
+// minUserInputLength is the minimum length of a user input (name, email) for it to be disallowed in passwords, to
+// avoid rejecting passwords just because they contain a short name like "Al".
+const minUserInputLength = 3
+
+// bundledBreachedPasswords is a small list of commonly breached password hashes that is always checked.
+//
+//go:embed breached_passwords.txt
+var bundledBreachedPasswords string
+
+// BreachedPasswords is a list of SHA-1 hashes of passwords that are known to have been compromised.
+//
+// Hashes are grouped by their first 5 hex characters, the same k-anonymity prefix format that the Have I Been Pwned
+// range API uses, so that a downloaded corpus can be used as is without calling any external service.
+type BreachedPasswords struct {
+	// ranges maps hash prefixes to the set of hash suffixes that were loaded into memory.
+	ranges map[string]map[string]struct{}
+
+	// dir is a directory containing one file per hash prefix, which is read on demand.
+	dir string
+}
+
+// LoadBreachedPasswords loads a list of breached password hashes, always including the bundled list.
+//
+// The path may be empty, a file with one "HASH[:COUNT]" line per password, or a directory with one file per hash
+// prefix (named "ABCDE" or "ABCDE.txt") containing "SUFFIX[:COUNT]" lines, as produced by the HIBP downloader.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	list := &BreachedPasswords{ranges: map[string]map[string]struct{}{}}
+
+	// Load the bundled list
+	if err := list.loadHashes(strings.NewReader(bundledBreachedPasswords)); err != nil {
+		return nil, err
+	}
+
+	if path == "" {
+		return list, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Directories are read on demand, since a full corpus doesn't fit in memory
+	if info.IsDir() {
+		list.dir = path
+		return list, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if err := list.loadHashes(file); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Contains checks whether the given plaintext password is in the list.
+func (list *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	if _, ok := list.ranges[prefix][suffix]; ok {
+		return true, nil
+	}
+
+	if list.dir == "" {
+		return false, nil
+	}
+
+	return list.rangeFileContains(prefix, suffix)
+}
+
+// loadHashes reads full "HASH[:COUNT]" lines into memory.
+func (list *BreachedPasswords) loadHashes(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		hash, ok := parseHashLine(scanner.Text())
+		if !ok {
+			continue
+		}
+
+		if len(hash) != sha1.Size*2 {
+			return fmt.Errorf("invalid breached password hash %q", hash)
+		}
+
+		prefix, suffix := hash[:5], hash[5:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = map[string]struct{}{}
+		}
+		list.ranges[prefix][suffix] = struct{}{}
+	}
+
+	return scanner.Err()
+}
+
+// rangeFileContains checks whether the range file for the given prefix contains the given suffix.
+func (list *BreachedPasswords) rangeFileContains(prefix, suffix string) (bool, error) {
+	var file *os.File
+	var err error
+	for _, name := range []string{prefix, prefix + ".txt"} {
+		if file, err = os.Open(filepath.Join(list.dir, name)); err == nil {
+			break
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		// A missing range file means that no breached password has this prefix
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line, ok := parseHashLine(scanner.Text()); ok && line == suffix {
+			return true, nil
+		}
+	}
+
+	return false, scanner.Err()
+}
+
+// parseHashLine extracts the uppercase hash from a "HASH[:COUNT]" line, skipping blank lines and comments.
+func parseHashLine(line string) (string, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", false
+	}
+
+	hash, _, _ := strings.Cut(line, ":")
+	return strings.ToUpper(hash), true
+}
+
+// bundledBreachedPasswordList returns the bundled list of breached passwords on its own, shared by all AuthService
+// instances that aren't given a list.
+var bundledBreachedPasswordList = sync.OnceValues(func() (*BreachedPasswords, error) {
+	return LoadBreachedPasswords("")
+})
+
+// breachedPasswords returns the list of breached passwords of the service, falling back to the bundled list.
+//
+// The configured list is loaded at startup rather than here, so that a missing or broken corpus stops the server from
+// starting instead of failing requests.
+func (svc AuthService) breachedPasswords() (*BreachedPasswords, error) {
+	if svc.BreachedPasswords != nil {
+		return svc.BreachedPasswords, nil
+	}
+
+	return bundledBreachedPasswordList()
+}
+
+// maxPasswordBytes returns the maximum length of a new password in bytes for the configured hash algorithm.
+func maxPasswordBytes() int {
+	if config.Get().PasswordHashAlgorithm == HashAlgorithmBcrypt {
+		return bcryptMaxPasswordLength
+	}
+	return MaxPasswordLength
+}
+
+// checkPasswordMaxLength checks that a new password isn't longer than the configured hash algorithm allows.
+//
+// Returns apperror.ErrWeakPassword if it is.
+func checkPasswordMaxLength(password string) error {
+	if maxBytes := maxPasswordBytes(); len(password) > maxBytes {
+		return apperror.ErrWeakPassword.WithDetails(apperror.FieldError{
+			Field:   "password",
+			Message: fmt.Sprintf("must be at most %d bytes long", maxBytes),
+		})
+	}
+	return nil
+}
+
+// ValidatePassword checks the given password against the password policy.
+//
+// The password must be long enough but not longer than MaxPasswordLength, must not contain any of the user inputs
+// (like the user's name or email), must not be in the list of breached passwords, and must be strong enough.
+//
+// Returns apperror.ErrWeakPassword or apperror.ErrBreachedPassword if the password is not allowed.
+func (svc AuthService) ValidatePassword(password string, userInputs ...string) error {
+	cfg := config.Get()
+
+	// Check the length of the password, before doing any expensive checks
+	if utf8.RuneCountInString(password) < cfg.PasswordMinLength {
+		return apperror.ErrWeakPassword.WithDetails(apperror.FieldError{
+			Field:   "password",
+			Message: fmt.Sprintf("must be at least %d characters long", cfg.PasswordMinLength),
+		})
+	}
+	if err := checkPasswordMaxLength(password); err != nil {
+		return err
+	}
+
+	// Check that the password doesn't contain the user's details
+	inputs := passwordUserInputs(userInputs)
+	lowerPassword := strings.ToLower(password)
+	for _, input := range inputs {
+		if strings.Contains(lowerPassword, input) {
+			return apperror.ErrWeakPassword.WithDetails(apperror.FieldError{
+				Field:   "password",
+				Message: "must not contain your name or email",
+			})
+		}
+	}
+
+	// Check that the password hasn't been breached
+	list, err := svc.breachedPasswords()
+	if err != nil {
+		slog.Error("Failed to load breached passwords", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+	breached, err := list.Contains(password)
+	if err != nil {
+		slog.Error("Failed to check for breached password", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+	if breached {
+		return apperror.ErrBreachedPassword.WithDetails(apperror.FieldError{
+			Field:   "password",
+			Message: "has appeared in a data breach, please choose a different one",
+		})
+	}
+
+	// Check the strength of the password
+	scored := password
+	if utf8.RuneCountInString(scored) > maxScoredPasswordLength {
+		scored = string([]rune(scored)[:maxScoredPasswordLength])
+	}
+	if strength := zxcvbn.PasswordStrength(scored, inputs); strength.Score < cfg.PasswordMinScore {
+		return apperror.ErrWeakPassword.WithDetails(apperror.FieldError{
+			Field:   "password",
+			Message: "is too easy to guess, try a longer password or a passphrase",
+		})
+	}
+
+	return nil
+}
+
+// passwordUserInputs converts the user's details into lowercase inputs that are disallowed in passwords.
+//
+// Emails are split so that the local part alone is also disallowed.
+func passwordUserInputs(userInputs []string) []string {
+	inputs := make([]string, 0, len(userInputs)*2)
+	for _, input := range userInputs {
+		input = strings.ToLower(strings.TrimSpace(input))
+
+		candidates := []string{input}
+		if local, _, ok := strings.Cut(input, "@"); ok {
+			candidates = append(candidates, local)
+		}
+		candidates = append(candidates, strings.Fields(input)...)
+
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= minUserInputLength {
+				inputs = append(inputs, candidate)
+			}
+		}
+	}
+
+	return inputs
+}
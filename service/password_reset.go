@@ -0,0 +1,115 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/mailer"
+	"notes-app/models"
+
+	"gorm.io/gorm"
+)
+
+// RequestPasswordReset emails a link to reset the password to the user with the given email, replacing any link sent
+// before.
+//
+// Nothing happens if there is no such user, so that callers can't tell which emails are registered. Users from a
+// directory don't get links either, since their password is managed by the directory.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc UserService) RequestPasswordReset(email string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	user, err := svc.GetByEmail(email, opts)
+	if errors.Is(err, apperror.ErrUserNotFound) {
+		slog.Debug("Password reset requested for unknown email")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if user.AuthSource == models.AuthSourceLDAP {
+		slog.Debug("Password reset requested for directory user", slog.Any("userID", user.ID))
+		return nil
+	}
+
+	ttl := config.Get().PasswordResetTTL
+	token, err := issueOneTimeToken(db, user, tokenPurposePasswordReset, ttl)
+	if err != nil {
+		return err
+	}
+
+	err = sendEmail(svc.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"Someone asked to reset the password of your account. To choose a new password, open this link:\n\n%s\n\n"+
+				"The link expires in %s. If you didn't ask for this, you can ignore this email and your password "+
+				"won't change.\n",
+			user.Name, appLink("/reset-password", token), ttl,
+		),
+	})
+	if err != nil {
+		slog.Error("Failed to send password reset email", slog.Any("error", err), slog.Any("userID", user.ID))
+		return apperror.Internal(err)
+	}
+
+	return nil
+}
+
+// ResetPassword sets a new password for the user that the reset token was sent to, and revokes all their sessions.
+//
+// The token can only be used once, and the new password must satisfy the password policy. Since the token was sent to
+// the user's email, using it also verifies the email.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the token can't be used, apperror.ErrWeakPassword
+// or apperror.ErrBreachedPassword if the password is not allowed, or apperror.ErrManagedByDirectory if the user moved
+// to a directory after the token was sent.
+func (svc UserService) ResetPassword(token, password string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	if svc.SessionService == nil {
+		panic("UserService.SessionService must be set to reset passwords ^._.^")
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		oneTimeToken, err := consumeOneTimeToken(tx, token, tokenPurposePasswordReset)
+		if err != nil {
+			return err
+		}
+
+		user, err := svc.GetByID(oneTimeToken.UserID, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+		if user.Email != oneTimeToken.Email {
+			return apperror.ErrTokenInvalid
+		}
+		if user.AuthSource == models.AuthSourceLDAP {
+			return apperror.ErrManagedByDirectory
+		}
+
+		// Check the password against the password policy before hashing it. Returning an error rolls back the
+		// transaction, so the token can be used again with a better password.
+		if err := svc.AuthService.ValidatePassword(password, user.Name, user.Email); err != nil {
+			return err
+		}
+
+		hashedPassword, err := svc.AuthService.HashPassword(password)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Model(&user).Updates(map[string]any{"password": hashedPassword, "email_verified": true})
+		if result.Error != nil {
+			slog.Error("Failed to reset password", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		// Log out everywhere, in case someone else knew the old password
+		return svc.SessionService.RevokeAll(user.ID, &DBOpts{db: tx})
+	})
+}
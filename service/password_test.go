@@ -0,0 +1,121 @@
+package service_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/service"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// sha1Hex returns the uppercase SHA-1 hash of the password, as used in breached password lists.
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestValidatePassword(t *testing.T) {
+	svc := service.AuthService{}
+
+	testCases := map[string]struct {
+		password   string
+		userInputs []string
+		err        error
+	}{
+		"strong":               {password: "violet-otter-harbour-93", userInputs: []string{"John Doe", "john.doe@example.com"}},
+		"too short":            {password: "x7#Qp", err: apperror.ErrWeakPassword},
+		"contains name":        {password: "JohnDoe-is-the-best", userInputs: []string{"John Doe"}, err: apperror.ErrWeakPassword},
+		"contains email local": {password: "john.doe-2024!", userInputs: []string{"john.doe@example.com"}, err: apperror.ErrWeakPassword},
+		"too easy to guess":    {password: "aaaaaaaaaaaa", err: apperror.ErrWeakPassword},
+		"breached":             {password: "correcthorsebatterystaple", err: apperror.ErrBreachedPassword},
+		"long passphrase":      {password: strings.Repeat("violet otter harbour ", 10)},
+		"too long":             {password: strings.Repeat("violet otter harbour ", 50), err: apperror.ErrWeakPassword},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := svc.ValidatePassword(tc.password, tc.userInputs...)
+			if tc.err == nil {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestPasswordMaxLength(t *testing.T) {
+	svc := service.AuthService{}
+	cfg := config.Get()
+	defaultAlgorithm := cfg.PasswordHashAlgorithm
+	defer func() { cfg.PasswordHashAlgorithm = defaultAlgorithm }()
+
+	// Passwords too long to have been set are rejected without hashing them
+	hashedPassword, err := svc.HashPassword("violet-otter-harbour-93")
+	assert.NoError(t, err)
+	err = svc.ComparePasswords(hashedPassword, strings.Repeat("a", service.MaxPasswordLength+1))
+	assert.ErrorIs(t, err, apperror.ErrInvalidCredentials)
+
+	// Bcrypt can only hash 72 bytes, so longer passwords are rejected while it is configured
+	cfg.PasswordHashAlgorithm = service.HashAlgorithmBcrypt
+	password := strings.Repeat("violet otter harbour ", 4)
+	assert.ErrorIs(t, svc.ValidatePassword(password), apperror.ErrWeakPassword)
+	_, err = svc.HashPassword(password)
+	assert.ErrorIs(t, err, apperror.ErrWeakPassword)
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	dir := t.TempDir()
+
+	// A full list in the HASH:COUNT format
+	fullList := filepath.Join(dir, "breached.txt")
+	err := os.WriteFile(fullList, []byte("# comment\n"+sha1Hex("violet-otter-harbour-93")+":42\n"), 0o600)
+	assert.NoError(t, err)
+
+	// A directory of range files in the SUFFIX:COUNT format
+	rangeDir := filepath.Join(dir, "ranges")
+	assert.NoError(t, os.Mkdir(rangeDir, 0o700))
+	hash := sha1Hex("amber-walrus-lantern-17")
+	err = os.WriteFile(filepath.Join(rangeDir, hash[:5]+".txt"), []byte("0000000000000000000000000000000000A:1\n"+hash[5:]+":3\n"), 0o600)
+	assert.NoError(t, err)
+
+	testCases := map[string]struct {
+		path     string
+		password string
+		breached bool
+	}{
+		"bundled":                {path: "", password: "password123", breached: true},
+		"not bundled":            {path: "", password: "violet-otter-harbour-93", breached: false},
+		"full list":              {path: fullList, password: "violet-otter-harbour-93", breached: true},
+		"full list with bundled": {path: fullList, password: "qwertyuiop", breached: true},
+		"range file":             {path: rangeDir, password: "amber-walrus-lantern-17", breached: true},
+		"missing range file":     {path: rangeDir, password: "violet-otter-harbour-93", breached: false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			list, err := service.LoadBreachedPasswords(tc.path)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			breached, err := list.Contains(tc.password)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.breached, breached)
+
+			// The list should be usable by the password policy as well
+			err = service.AuthService{BreachedPasswords: list}.ValidatePassword(tc.password)
+			if tc.breached {
+				assert.ErrorIs(t, err, apperror.ErrBreachedPassword)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,545 @@
+package service
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/mailer"
+	"notes-app/models"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// dataExportBuildTimeout is how long an export can be pending before it is assumed to have been lost, e.g. because the
+// server restarted while building it.
+const dataExportBuildTimeout = 15 * time.Minute
+
+type IPrivacyService interface {
+	// RequestExport starts exporting all the data kept about the user with the given ID.
+	// If an export is already being built, it is returned instead of starting another one.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the pending export, and whether it was just created, in which case it has to be built with BuildExport.
+	RequestExport(userID uint, opts *DBOpts) (models.DataExport, bool, error)
+
+	// BuildExport builds the archive of the export with the given ID, and emails the user once it is ready to download.
+	// Accepts optional DBOpts to specify a DB instance.
+	BuildExport(id string, opts *DBOpts) error
+
+	// GetExport retrieves the export with the given ID, without its archive.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrDataExportNotFound if the user has no such export, or it has expired.
+	GetExport(userID uint, id string, opts *DBOpts) (models.DataExport, error)
+
+	// DownloadExport retrieves the export with the given ID, along with its archive.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrDataExportNotFound if the user has no such export, or it has expired, or
+	// apperror.ErrDataExportNotReady if it is still being built or failed.
+	DownloadExport(userID uint, id string, opts *DBOpts) (models.DataExport, error)
+
+	// EraseExpiredData deletes expired exports, and erases the data of accounts that were deleted longer than the grace
+	// period ago for good.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the number of accounts erased.
+	EraseExpiredData(opts *DBOpts) (int, error)
+}
+
+type PrivacyService struct {
+	Service
+
+	// Mailer is used to tell users that their export is ready, which should be created from config at startup with
+	// mailer.New.
+	Mailer mailer.Mailer
+
+	// OrganizationService is used to leave the notes of organizations that users have left out of their exports.
+	OrganizationService IOrganizationService
+}
+
+// RequestExport starts exporting all the data kept about the user with the given ID.
+//
+// If an export is already being built, it is returned instead of starting another one.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the pending export, and whether it was just created, in which case it has to be built with BuildExport.
+func (svc PrivacyService) RequestExport(userID uint, opts *DBOpts) (models.DataExport, bool, error) {
+	db := svc.getDB(opts)
+
+	var export models.DataExport
+	result := db.Omit("archive").
+		Where("user_id = ? AND status = ? AND created_at > ?", userID, models.DataExportPending,
+			time.Now().Add(-dataExportBuildTimeout)).
+		Limit(1).
+		Find(&export)
+	if result.Error != nil {
+		slog.Error("Failed to fetch pending data export", slog.Any("error", result.Error))
+		return models.DataExport{}, false, apperror.Internal(result.Error)
+	}
+	if result.RowsAffected > 0 {
+		return export, false, nil
+	}
+
+	export = models.DataExport{ID: uuid.NewString(), UserID: userID, Status: models.DataExportPending}
+	if err := db.Create(&export).Error; err != nil {
+		slog.Error("Failed to create data export", slog.Any("error", err))
+		return models.DataExport{}, false, apperror.Internal(err)
+	}
+
+	return export, true, nil
+}
+
+// BuildExport builds the archive of the export with the given ID, and emails the user once it is ready to download.
+//
+// The export is marked as failed if the archive can't be built, so that the user can ask for another one.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc PrivacyService) BuildExport(id string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	var export models.DataExport
+	if err := db.Omit("archive").Where("id = ?", id).First(&export).Error; err != nil {
+		slog.Error("Failed to fetch data export", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+
+	var user models.User
+	archive, err := func() ([]byte, error) {
+		if err := db.Where("id = ?", export.UserID).First(&user).Error; err != nil {
+			return nil, err
+		}
+		return buildDataExportArchive(db, svc.organizationService(), user)
+	}()
+	if err != nil {
+		slog.Error("Failed to build data export", slog.Any("error", err), slog.String("exportID", id))
+		if err := db.Model(&export).Update("status", models.DataExportFailed).Error; err != nil {
+			slog.Error("Failed to mark data export as failed", slog.Any("error", err))
+		}
+		return apperror.Internal(err)
+	}
+
+	ttl := config.Get().DataExportTTL
+	now := time.Now()
+	result := db.Model(&export).Updates(map[string]any{
+		"status":       models.DataExportReady,
+		"archive":      archive,
+		"completed_at": now,
+		"expires_at":   now.Add(ttl),
+	})
+	if result.Error != nil {
+		slog.Error("Failed to save data export", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	// The export can be downloaded either way, so only log failures to tell the user
+	err = sendEmail(svc.Mailer, mailer.Message{
+		To:      user.Email,
+		Subject: "Your data export is ready",
+		Body: fmt.Sprintf(
+			"Hi %s,\n\n"+
+				"The copy of your data that you asked for is ready. You can download it from your account settings:"+
+				"\n\n%s/settings/privacy\n\n"+
+				"It will be available for %s. If you didn't ask for this, please change your password.\n",
+			user.Name, strings.TrimSuffix(config.Get().AppURL, "/"), ttl,
+		),
+	})
+	if err != nil {
+		slog.Error("Failed to send data export email", slog.Any("error", err), slog.Any("userID", user.ID))
+	}
+
+	return nil
+}
+
+// GetExport retrieves the export with the given ID, without its archive.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrDataExportNotFound if the user has no such export, or it has expired.
+func (svc PrivacyService) GetExport(userID uint, id string, opts *DBOpts) (models.DataExport, error) {
+	return svc.findExport(svc.getDB(opts).Omit("archive"), userID, id)
+}
+
+// DownloadExport retrieves the export with the given ID, along with its archive.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrDataExportNotFound if the user has no such export, or it has expired, or
+// apperror.ErrDataExportNotReady if it is still being built or failed.
+func (svc PrivacyService) DownloadExport(userID uint, id string, opts *DBOpts) (models.DataExport, error) {
+	export, err := svc.findExport(svc.getDB(opts), userID, id)
+	if err != nil {
+		return models.DataExport{}, err
+	}
+	if export.Status != models.DataExportReady {
+		return models.DataExport{}, apperror.ErrDataExportNotReady
+	}
+
+	return export, nil
+}
+
+// findExport retrieves the export with the given ID, as long as it belongs to the user and hasn't expired.
+func (svc PrivacyService) findExport(db *gorm.DB, userID uint, id string) (models.DataExport, error) {
+	// Export IDs are UUIDs, and anything else would fail to compare with the column
+	if _, err := uuid.Parse(id); err != nil {
+		return models.DataExport{}, apperror.ErrDataExportNotFound
+	}
+
+	var export models.DataExport
+	result := db.Where("id = ? AND user_id = ? AND (expires_at IS NULL OR expires_at > ?)", id, userID, time.Now()).
+		First(&export)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return models.DataExport{}, apperror.ErrDataExportNotFound
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch data export", slog.Any("error", result.Error))
+		return models.DataExport{}, apperror.Internal(result.Error)
+	}
+
+	return export, nil
+}
+
+// EraseExpiredData deletes expired exports, and erases the data of accounts that were deleted longer than the grace
+// period ago for good.
+//
+// Deleted accounts are only soft deleted at first, so that they can be restored if they were deleted by mistake. Once
+// the grace period is over, the account and everything linked to it is hard deleted, each account in its own
+// transaction so that one failure doesn't hold up the rest. Notes that others can still see are kept without an owner.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the number of accounts erased.
+func (svc PrivacyService) EraseExpiredData(opts *DBOpts) (int, error) {
+	db := svc.getDB(opts)
+	cfg := config.Get()
+	now := time.Now()
+
+	// Exports that never completed have no expiry, so they go once they would have expired anyway
+	result := db.Where("expires_at < ? OR (expires_at IS NULL AND created_at < ?)", now, now.Add(-cfg.DataExportTTL)).
+		Delete(&models.DataExport{})
+	if result.Error != nil {
+		slog.Error("Failed to delete expired data exports", slog.Any("error", result.Error))
+		return 0, apperror.Internal(result.Error)
+	}
+
+	var users []models.User
+	result = db.Unscoped().
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", now.Add(-cfg.AccountErasureGracePeriod)).
+		Find(&users)
+	if result.Error != nil {
+		slog.Error("Failed to fetch deleted users", slog.Any("error", result.Error))
+		return 0, apperror.Internal(result.Error)
+	}
+
+	erased := 0
+	for _, user := range users {
+		if err := db.Transaction(func(tx *gorm.DB) error { return eraseUser(tx, user) }); err != nil {
+			slog.Error("Failed to erase user", slog.Any("error", err), slog.Any("userID", user.ID))
+			continue
+		}
+		erased++
+	}
+
+	return erased, nil
+}
+
+// RunErasureJob calls EraseExpiredData right away and then at the given interval, forever.
+func (svc PrivacyService) RunErasureJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Failures are logged by EraseExpiredData, and retried on the next run
+		if erased, err := svc.EraseExpiredData(nil); err == nil && erased > 0 {
+			slog.Info("Erased deleted users", slog.Int("count", erased))
+		}
+
+		<-ticker.C
+	}
+}
+
+// organizationService returns the organization service, defaulting to one using the same DB.
+func (svc PrivacyService) organizationService() IOrganizationService {
+	if svc.OrganizationService != nil {
+		return svc.OrganizationService
+	}
+	return OrganizationService{Service: svc.Service}
+}
+
+// eraseUser hard deletes the user and everything linked to them.
+//
+// Their notes that others can still see are kept without an owner, rather than taken away from the people that they
+// were shared with: notes of an organization, and notes shared with other users or with groups that outlive the user.
+// Only the notes that nobody else can see are deleted.
+func eraseUser(tx *gorm.DB, user models.User) error {
+	sessionIDs := tx.Model(&models.Session{}).Select("id").Where("user_id = ?", user.ID)
+	if err := tx.Where("session_id IN (?)", sessionIDs).Delete(&models.RefreshToken{}).Error; err != nil {
+		return err
+	}
+
+	for _, model := range []any{
+		&models.Session{},
+		&models.PersonalAccessToken{},
+		&models.OneTimeToken{},
+		&models.TOTPCredential{},
+		&models.RecoveryCode{},
+		&models.OIDCIdentity{},
+		&models.DataExport{},
+		&models.OrganizationMember{},
+	} {
+		if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Where("email = ?", user.Email).Delete(&models.OrganizationInvitation{}).Error; err != nil {
+		return err
+	}
+
+	if err := leaveGroups(tx, user.ID); err != nil {
+		return err
+	}
+
+	if err := deleteShareLinks(tx, user.ID); err != nil {
+		return err
+	}
+
+	if err := deleteAccessRequests(tx, user.ID); err != nil {
+		return err
+	}
+
+	// Notes of an organization are kept for its admins to manage, and shared notes for the people they are shared with
+	sharedNoteIDs := tx.Model(&models.NoteShare{}).Select("note_id").Where("user_id <> ?", user.ID)
+	groupSharedNoteIDs := tx.Model(&models.NoteGroupShare{}).Select("note_id")
+	err := tx.Model(&models.Note{}).
+		Where("owner_id = ? AND (organization_id IS NOT NULL OR id IN (?) OR id IN (?))",
+			user.ID, sharedNoteIDs, groupSharedNoteIDs).
+		Update("owner_id", nil).Error
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.NoteShare{}).Error; err != nil {
+		return err
+	}
+	if err := deleteNotes(tx, tx.Model(&models.Note{}).Select("id").Where("owner_id = ?", user.ID)); err != nil {
+		return err
+	}
+
+	throttleKeys := []string{accountThrottleKey(user.Email), emailRequestThrottleKey(user.Email)}
+	if err := tx.Where("key IN ?", throttleKeys).Delete(&models.LoginThrottle{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Unscoped().Delete(&user).Error
+}
+
+// dataExportReadme explains the files in a data export.
+const dataExportReadme = `This archive contains all the data kept about your account.
+
+profile.json            Your profile
+notes.json              Your notes, except the ones of organizations that you have left
+note_shares.json        The notes shared with you, and who you shared your notes with
+note_group_shares.json  The groups you shared your notes with
+sessions.json           The devices you have logged in from, including ones that have since logged out
+access_tokens.json      Your personal access tokens, without the tokens themselves
+identities.json         The identity providers linked to your account
+two_factor.json         Whether two-factor authentication is enabled, without the secret or recovery codes
+organizations.json      The organizations you are a member of, and your role in each
+groups.json             The groups you created, and the groups you are a member of
+share_links.json        The share links you created, without the links themselves or their passwords
+access_requests.json    The requests for access to notes that you sent, and the ones sent to you
+`
+
+// dataExportIdentity is an identity provider linked to the user, as included in their data export.
+type dataExportIdentity struct {
+	Provider    string    `json:"provider"`
+	Subject     string    `json:"subject"`
+	Email       string    `json:"email"`
+	LinkedAt    time.Time `json:"linked_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// dataExportTwoFactor is the two-factor authentication of the user, as included in their data export.
+type dataExportTwoFactor struct {
+	Enabled                bool       `json:"enabled"`
+	EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesRemaining int64      `json:"recovery_codes_remaining"`
+}
+
+// dataExportOrganization is an organization that the user is a member of, as included in their data export.
+type dataExportOrganization struct {
+	ID       uint      `json:"id"`
+	Name     string    `json:"name"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// dataExportGroup is a group that the user created or is a member of, as included in their data export.
+type dataExportGroup struct {
+	ID             uint       `json:"id"`
+	Name           string     `json:"name"`
+	OrganizationID *uint      `json:"organization_id"`
+	Owner          bool       `json:"owner"`
+	AddedAt        *time.Time `json:"added_at"`
+}
+
+// buildDataExportArchive collects all the data kept about the user into a zip archive of JSON files.
+//
+// Secrets like password hashes, token hashes and the TOTP secret are left out, since they are of no use to the user and
+// would only be a liability if the archive leaked. So are the notes of organizations that the user has left, which
+// belong to the organization rather than to them.
+func buildDataExportArchive(db *gorm.DB, orgs IOrganizationService, user models.User) ([]byte, error) {
+	var sessions []models.Session
+	if err := db.Where("user_id = ?", user.ID).Order("created_at").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+
+	var accessTokens []models.PersonalAccessToken
+	if err := db.Where("user_id = ?", user.ID).Order("created_at").Find(&accessTokens).Error; err != nil {
+		return nil, err
+	}
+
+	var oidcIdentities []models.OIDCIdentity
+	if err := db.Where("user_id = ?", user.ID).Order("created_at").Find(&oidcIdentities).Error; err != nil {
+		return nil, err
+	}
+	identities := make([]dataExportIdentity, 0, len(oidcIdentities))
+	for _, identity := range oidcIdentities {
+		identities = append(identities, dataExportIdentity{
+			Provider:    identity.Provider,
+			Subject:     identity.Subject,
+			Email:       identity.Email,
+			LinkedAt:    identity.CreatedAt,
+			LastLoginAt: identity.LastLoginAt,
+		})
+	}
+
+	var twoFactor dataExportTwoFactor
+	var credential models.TOTPCredential
+	result := db.Where("user_id = ? AND confirmed_at IS NOT NULL", user.ID).Limit(1).Find(&credential)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected > 0 {
+		twoFactor.Enabled = true
+		twoFactor.EnabledAt = credential.ConfirmedAt
+		err := db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).
+			Count(&twoFactor.RecoveryCodesRemaining).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	organizations := make([]dataExportOrganization, 0)
+	result = db.Table("organization_members").
+		Select("organizations.id, organizations.name, organization_members.role, "+
+			"organization_members.created_at AS joined_at").
+		Joins("JOIN organizations ON organizations.id = organization_members.organization_id").
+		Where("organization_members.user_id = ?", user.ID).
+		Order("organization_members.created_at").
+		Scan(&organizations)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	groups := make([]dataExportGroup, 0)
+	result = db.Table("user_groups").
+		Select("user_groups.id, user_groups.name, user_groups.organization_id, "+
+			"user_groups.owner_id IS NOT NULL AND user_groups.owner_id = ? AS owner, "+
+			"user_group_members.created_at AS added_at", user.ID).
+		Joins("LEFT JOIN user_group_members ON user_group_members.group_id = user_groups.id "+
+			"AND user_group_members.user_id = ?", user.ID).
+		Where("user_groups.owner_id = ? OR user_group_members.user_id IS NOT NULL", user.ID).
+		Order("user_groups.created_at").
+		Scan(&groups)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var owned []models.Note
+	if err := db.Where("owner_id = ?", user.ID).Order("created_at").Find(&owned).Error; err != nil {
+		return nil, err
+	}
+
+	notes := make([]models.Note, 0, len(owned))
+	noteIDs := make([]uint, 0, len(owned))
+	for _, note := range owned {
+		_, err := checkNoteOrganization(db, orgs, user.ID, note, models.OrgRoleGuest)
+		if errors.Is(err, apperror.ErrNoteNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		notes = append(notes, note)
+		noteIDs = append(noteIDs, note.ID)
+	}
+
+	var noteShares []models.NoteShare
+	result = db.Where("user_id = ? OR note_id IN (?)", user.ID, noteIDs).Order("created_at").Find(&noteShares)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var noteGroupShares []models.NoteGroupShare
+	if err := db.Where("note_id IN (?)", noteIDs).Order("created_at").Find(&noteGroupShares).Error; err != nil {
+		return nil, err
+	}
+
+	var shareLinks []models.ShareLink
+	if err := db.Where("user_id = ?", user.ID).Order("created_at").Find(&shareLinks).Error; err != nil {
+		return nil, err
+	}
+
+	var accessRequests []models.AccessRequest
+	result = db.Where("requester_id = ? OR owner_id = ?", user.ID, user.ID).Order("created_at").Find(&accessRequests)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+
+	readme, err := archive.Create("README.txt")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := readme.Write([]byte(dataExportReadme)); err != nil {
+		return nil, err
+	}
+
+	for _, file := range []struct {
+		name string
+		data any
+	}{
+		{"profile.json", user},
+		{"notes.json", notes},
+		{"note_shares.json", noteShares},
+		{"note_group_shares.json", noteGroupShares},
+		{"sessions.json", sessions},
+		{"access_tokens.json", accessTokens},
+		{"identities.json", identities},
+		{"two_factor.json", twoFactor},
+		{"organizations.json", organizations},
+		{"groups.json", groups},
+		{"share_links.json", shareLinks},
+		{"access_requests.json", accessRequests},
+	} {
+		writer, err := archive.Create(file.name)
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(writer)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
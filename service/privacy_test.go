@@ -0,0 +1,225 @@
+package service_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type PrivacyServiceTestSuite struct {
+	suite.Suite
+	dbService      database.Service
+	userService    service.UserService
+	privacyService service.PrivacyService
+	mailer         *recordingMailer
+}
+
+func (suite *PrivacyServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the service instances to use for testing
+	suite.mailer = &recordingMailer{}
+	suite.userService = service.UserService{
+		Service:     service.Service{DBService: suite.dbService},
+		AuthService: service.AuthService{},
+		Mailer:      suite.mailer,
+		SessionService: service.SessionService{
+			Service:     service.Service{DBService: suite.dbService},
+			AuthService: service.AuthService{},
+		},
+	}
+	suite.privacyService = service.PrivacyService{
+		Service: service.Service{DBService: suite.dbService},
+		Mailer:  suite.mailer,
+	}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *PrivacyServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+	suite.mailer.messages = nil
+
+	slog.Debug("Setup test")
+}
+
+// createUser creates a user with the given email, and a session for them.
+func (suite *PrivacyServiceTestSuite) createUser(email string) models.User {
+	user := models.User{Name: "John Doe", Email: email, Password: "correct horse battery stapler"}
+	suite.Require().NoError(suite.userService.Create(&user, nil))
+
+	_, err := suite.userService.SessionService.Create(user.ID, service.ClientInfo{IPAddress: "192.0.2.1"}, nil)
+	suite.Require().NoError(err)
+
+	return user
+}
+
+func (suite *PrivacyServiceTestSuite) TestExport() {
+	svc := suite.privacyService
+	user := suite.createUser("john.doe@example.com")
+
+	// Start an export, asking again while it is pending returns the same one
+	export, created, err := svc.RequestExport(user.ID, nil)
+	suite.NoError(err)
+	suite.True(created)
+	suite.Equal(models.DataExportPending, export.Status)
+
+	again, created, err := svc.RequestExport(user.ID, nil)
+	suite.NoError(err)
+	suite.False(created)
+	suite.Equal(export.ID, again.ID)
+
+	// It can't be downloaded until it is built
+	_, err = svc.DownloadExport(user.ID, export.ID, nil)
+	suite.ErrorIs(err, apperror.ErrDataExportNotReady)
+
+	suite.NoError(svc.BuildExport(export.ID, nil))
+	suite.Len(suite.mailer.messages, 1)
+	suite.Equal(user.Email, suite.mailer.messages[0].To)
+
+	status, err := svc.GetExport(user.ID, export.ID, nil)
+	suite.NoError(err)
+	suite.Equal(models.DataExportReady, status.Status)
+	suite.NotNil(status.ExpiresAt)
+	suite.Empty(status.Archive)
+
+	// Assert that the archive has the user's data, without their password hash
+	download, err := svc.DownloadExport(user.ID, export.ID, nil)
+	suite.NoError(err)
+	archive, err := zip.NewReader(bytes.NewReader(download.Archive), int64(len(download.Archive)))
+	suite.Require().NoError(err)
+
+	files := make(map[string][]byte)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		suite.Require().NoError(err)
+		files[file.Name], err = io.ReadAll(reader)
+		suite.Require().NoError(err)
+		suite.NoError(reader.Close())
+	}
+	suite.Contains(files, "README.txt")
+
+	var profile map[string]any
+	suite.NoError(json.Unmarshal(files["profile.json"], &profile))
+	suite.Equal(user.Email, profile["email"])
+	suite.NotContains(profile, "password")
+
+	var sessions []map[string]any
+	suite.NoError(json.Unmarshal(files["sessions.json"], &sessions))
+	suite.Len(sessions, 1)
+	suite.Equal("192.0.2.1", sessions[0]["ip_address"])
+
+	// Other users can't see the export
+	other := suite.createUser("jane.doe@example.com")
+	_, err = svc.GetExport(other.ID, export.ID, nil)
+	suite.ErrorIs(err, apperror.ErrDataExportNotFound)
+	_, err = svc.DownloadExport(other.ID, export.ID, nil)
+	suite.ErrorIs(err, apperror.ErrDataExportNotFound)
+}
+
+func (suite *PrivacyServiceTestSuite) TestEraseExpiredData() {
+	svc := suite.privacyService
+	db := suite.dbService.GetDB()
+	password := "correct horse battery stapler"
+
+	// One account deleted long ago, one deleted just now, and one still in use
+	erased := suite.createUser("erased@example.com")
+	suite.NoError(suite.userService.Delete(erased.ID, password, nil))
+	deletedAt := time.Now().Add(-config.Get().AccountErasureGracePeriod - time.Hour)
+	suite.NoError(db.Unscoped().Model(&erased).Update("deleted_at", deletedAt).Error)
+
+	recent := suite.createUser("recent@example.com")
+	suite.NoError(suite.userService.Delete(recent.ID, password, nil))
+
+	active := suite.createUser("active@example.com")
+	export, _, err := svc.RequestExport(active.ID, nil)
+	suite.NoError(err)
+	suite.NoError(svc.BuildExport(export.ID, nil))
+
+	count, err := svc.EraseExpiredData(nil)
+	suite.NoError(err)
+	suite.Equal(1, count)
+
+	// Assert that only the account past the grace period is gone for good, along with its sessions
+	var users []models.User
+	suite.NoError(db.Unscoped().Order("id").Find(&users).Error)
+	suite.Len(users, 2)
+	suite.Equal(recent.ID, users[0].ID)
+	suite.Equal(active.ID, users[1].ID)
+
+	var sessionCount int64
+	suite.NoError(db.Model(&models.Session{}).Where("user_id = ?", erased.ID).Count(&sessionCount).Error)
+	suite.Zero(sessionCount)
+
+	// Exports are only deleted once they expire
+	_, err = svc.GetExport(active.ID, export.ID, nil)
+	suite.NoError(err)
+	suite.NoError(db.Model(&models.DataExport{}).Where("id = ?", export.ID).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	_, err = svc.EraseExpiredData(nil)
+	suite.NoError(err)
+
+	var exportCount int64
+	suite.NoError(db.Model(&models.DataExport{}).Count(&exportCount).Error)
+	suite.Zero(exportCount)
+}
+
+func (suite *PrivacyServiceTestSuite) TestEraseSharedNotes() {
+	svc := suite.privacyService
+	db := suite.dbService.GetDB()
+	noteService := service.NoteService{Service: service.Service{DBService: suite.dbService}}
+
+	erased := suite.createUser("erased@example.com")
+	other := suite.createUser("jane.doe@example.com")
+	notes := []models.Note{
+		{OwnerID: &erased.ID, Title: "Shopping list"},
+		{OwnerID: &erased.ID, Title: "Diary"},
+	}
+	suite.Require().NoError(db.Create(&notes).Error)
+	share := models.NoteShare{NoteID: notes[0].ID, UserID: other.ID, Access: service.AccessRead}
+	suite.Require().NoError(db.Create(&share).Error)
+
+	suite.NoError(suite.userService.Delete(erased.ID, "correct horse battery stapler", nil))
+	deletedAt := time.Now().Add(-config.Get().AccountErasureGracePeriod - time.Hour)
+	suite.NoError(db.Unscoped().Model(&erased).Update("deleted_at", deletedAt).Error)
+
+	count, err := svc.EraseExpiredData(nil)
+	suite.NoError(err)
+	suite.Equal(1, count)
+
+	// Assert that the shared note is kept without an owner, and the other one is gone
+	var left []models.Note
+	suite.NoError(db.Find(&left).Error)
+	suite.Require().Len(left, 1)
+	suite.Equal(notes[0].ID, left[0].ID)
+	suite.Nil(left[0].OwnerID)
+
+	// The note is still shared with the other user
+	access, err := noteService.Access(other.ID, notes[0].ID, nil)
+	suite.NoError(err)
+	suite.Equal(service.AccessRead, access)
+}
+
+func TestPrivacyService(t *testing.T) {
+	suite.Run(t, new(PrivacyServiceTestSuite))
+}
@@ -0,0 +1,289 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/models"
+	"notes-app/utils"
+
+	"gorm.io/gorm"
+)
+
+// What happens to the notes of an account when it is deleted, see config.Config.AccountDeletionNotes.
+const (
+	// DeletedNotesDelete deletes the notes right away, along with their shares.
+	DeletedNotesDelete = "delete"
+	// DeletedNotesTransfer hands the notes of organizations over to them right away, for their admins to manage. Other
+	// notes are kept until the account is erased.
+	DeletedNotesTransfer = "transfer"
+	// DeletedNotesKeep keeps the notes until the account is erased.
+	DeletedNotesKeep = "keep"
+)
+
+// ProfileUpdate holds the changes to the profile of a user, where nil fields are left unchanged.
+type ProfileUpdate struct {
+	Name  *string
+	Email *string
+	// AvatarURL can be set to an empty string to remove the avatar.
+	AvatarURL *string
+	// Username can be set to an empty string to remove the username.
+	Username *string
+	// Discoverable is whether other users can find the user by searching.
+	Discoverable *bool
+}
+
+// UpdateProfile applies the given changes to the profile of the user with the given ID.
+//
+// Changing the email marks it as unverified, and sends a link to verify it to the new address. Users from a directory
+// can't change their email, since it is how the directory finds them.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the updated user, apperror.ErrUserExists if another user has the new email,
+// apperror.ErrUsernameUnavailable if the new username is reserved or taken, apperror.ErrValidationFailed if it is
+// malformed, or apperror.ErrManagedByDirectory.
+func (svc UserService) UpdateProfile(userID uint, update ProfileUpdate, opts *DBOpts) (models.User, error) {
+	db := svc.getDB(opts)
+
+	var user models.User
+	emailChanged := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		user, err = svc.GetByID(userID, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+
+		updates := make(map[string]any)
+		if update.Name != nil {
+			updates["name"] = *update.Name
+		}
+		if update.AvatarURL != nil {
+			updates["avatar_url"] = *update.AvatarURL
+		}
+		if update.Discoverable != nil {
+			updates["discoverable"] = *update.Discoverable
+		}
+		if update.Username != nil {
+			if username := utils.NormalizeUsername(*update.Username); username == "" {
+				updates["username"] = nil
+			} else if user.Username == nil || username != *user.Username {
+				if err := checkUsername(tx, username, user.ID); err != nil {
+					return err
+				}
+				updates["username"] = username
+			}
+		}
+		if update.Email != nil {
+			if email := utils.NormalizeEmail(*update.Email); email != user.Email {
+				if user.AuthSource == models.AuthSourceLDAP {
+					return apperror.ErrManagedByDirectory
+				}
+				updates["email"] = email
+				updates["email_verified"] = false
+				emailChanged = true
+			}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+
+		result := tx.Model(&user).Updates(updates)
+		if result.Error != nil {
+			slog.Error("Failed to update user", slog.Any("error", result.Error))
+
+			if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+				return apperror.ErrUserExists.WithCause(result.Error)
+			}
+			return apperror.Internal(result.Error)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.User{}, err
+	}
+
+	// The email is already changed, so the user can ask for the link again if sending it fails
+	if emailChanged {
+		if err := svc.SendVerificationEmail(user, opts); err != nil {
+			slog.Error("Failed to send verification email for new email", slog.Any("error", err),
+				slog.Any("userID", user.ID))
+		}
+	}
+
+	return user, nil
+}
+
+// ChangePassword sets a new password for the user with the given ID, after checking their current password, and
+// revokes all their other sessions.
+//
+// The new password must satisfy the password policy. Users from a directory have to change their password there.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrIncorrectPassword, apperror.ErrWeakPassword or apperror.ErrBreachedPassword if the new password
+// is not allowed, or apperror.ErrManagedByDirectory.
+func (svc UserService) ChangePassword(userID uint, sessionID, currentPassword, newPassword string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	if svc.SessionService == nil {
+		panic("UserService.SessionService must be set to change passwords ^._.^")
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		user, err := svc.GetByID(userID, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+		if user.AuthSource == models.AuthSourceLDAP {
+			return apperror.ErrManagedByDirectory
+		}
+
+		if err := svc.checkCurrentPassword(user, currentPassword); err != nil {
+			return err
+		}
+
+		// Check the password against the password policy before hashing it
+		if err := svc.AuthService.ValidatePassword(newPassword, user.Name, user.Email); err != nil {
+			return err
+		}
+
+		hashedPassword, err := svc.AuthService.HashPassword(newPassword)
+		if err != nil {
+			return err
+		}
+
+		result := tx.Model(&user).Update("password", hashedPassword)
+		if result.Error != nil {
+			slog.Error("Failed to change password", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		// Log out everywhere else, in case someone else knew the old password
+		return svc.SessionService.RevokeOthers(user.ID, sessionID, &DBOpts{db: tx})
+	})
+}
+
+// Delete deletes the account of the user with the given ID, after checking their current password.
+//
+// All their sessions are revoked, and their access tokens, two-factor authentication, linked identities, outstanding
+// links and data exports are deleted along with the account. The account itself is soft deleted, and erased for good
+// by PrivacyService.EraseExpiredData after the grace period.
+//
+// The user leaves all their organizations, and the ones that they are the only member of are deleted. The last owner
+// of an organization with other members has to hand it over first.
+//
+// Their notes are handled as configured with config.Config.AccountDeletionNotes. Unless they are kept, the notes shared
+// with the user are unshared too.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrIncorrectPassword if the password is incorrect, or apperror.ErrLastOwner.
+func (svc UserService) Delete(userID uint, password string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	if svc.SessionService == nil {
+		panic("UserService.SessionService must be set to delete users ^._.^")
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		user, err := svc.GetByID(userID, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+
+		if err := svc.checkCurrentPassword(user, password); err != nil {
+			return err
+		}
+
+		if err := leaveOrganizations(tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := leaveGroups(tx, user.ID); err != nil {
+			slog.Error("Failed to leave groups", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		// Links shared by the user stop working along with their account
+		if err := deleteShareLinks(tx, user.ID); err != nil {
+			slog.Error("Failed to delete share links", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		if err := handleDeletedNotes(tx, user.ID, config.Get().AccountDeletionNotes); err != nil {
+			slog.Error("Failed to handle notes of deleted user", slog.Any("error", err), slog.Any("userID", user.ID))
+			return apperror.Internal(err)
+		}
+
+		if err := svc.SessionService.RevokeAll(user.ID, &DBOpts{db: tx}); err != nil {
+			return err
+		}
+
+		for _, model := range []any{
+			&models.PersonalAccessToken{},
+			&models.TOTPCredential{},
+			&models.RecoveryCode{},
+			&models.OIDCIdentity{},
+			&models.OneTimeToken{},
+			&models.DataExport{},
+		} {
+			if err := tx.Where("user_id = ?", user.ID).Delete(model).Error; err != nil {
+				slog.Error("Failed to delete user data", slog.Any("error", err), slog.Any("userID", user.ID))
+				return apperror.Internal(err)
+			}
+		}
+
+		if err := tx.Delete(&user).Error; err != nil {
+			slog.Error("Failed to delete user", slog.Any("error", err), slog.Any("userID", user.ID))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+}
+
+// checkCurrentPassword checks the password that the user entered to confirm a sensitive action, against the directory
+// for users from one.
+//
+// Returns apperror.ErrIncorrectPassword if it doesn't match.
+func (svc UserService) checkCurrentPassword(user models.User, password string) error {
+	var err error
+	if user.AuthSource == models.AuthSourceLDAP && svc.AuthService.DirectoryEnabled() {
+		_, err = svc.AuthService.AuthenticateDirectory(user.Email, password)
+	} else {
+		err = svc.AuthService.ComparePasswords(user.Password, password)
+	}
+
+	if errors.Is(err, apperror.ErrInvalidCredentials) {
+		return apperror.ErrIncorrectPassword.WithCause(err)
+	}
+	return err
+}
+
+// handleDeletedNotes applies the given policy to the notes of a user whose account is being deleted, one of the
+// DeletedNotes constants.
+//
+// It runs after the user has left their organizations, so that the notes of the organizations deleted along with them
+// are already gone.
+func handleDeletedNotes(tx *gorm.DB, userID uint, policy string) error {
+	switch policy {
+	case DeletedNotesKeep:
+		return nil
+	case DeletedNotesTransfer:
+		err := tx.Model(&models.Note{}).Where("owner_id = ? AND organization_id IS NOT NULL", userID).
+			Update("owner_id", nil).Error
+		if err != nil {
+			return err
+		}
+	case DeletedNotesDelete:
+		if err := deleteNotes(tx, tx.Model(&models.Note{}).Select("id").Where("owner_id = ?", userID)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown policy for the notes of deleted accounts: %s", policy)
+	}
+
+	return tx.Where("user_id = ?", userID).Delete(&models.NoteShare{}).Error
+}
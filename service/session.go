@@ -0,0 +1,356 @@
+package service
+
+import (
+	"errors"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/models"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Tokens holds the tokens issued for a session.
+type Tokens struct {
+	// SessionID is the ID of the session, which is also the JWT ID of the access token.
+	SessionID string
+
+	// AccessToken is a short-lived JWT for authenticating requests.
+	AccessToken string
+	// AccessTokenExpiry is the time at which the access token expires.
+	AccessTokenExpiry time.Time
+
+	// RefreshToken is a single-use opaque token for getting a new access token.
+	RefreshToken string
+	// RefreshTokenExpiry is the time at which the refresh token expires.
+	RefreshTokenExpiry time.Time
+}
+
+// ClientInfo holds details about the client of a session, to help users recognise their sessions.
+type ClientInfo struct {
+	// IPAddress is the IP address that the client connected from.
+	IPAddress string
+	// UserAgent is the User-Agent header sent by the client.
+	UserAgent string
+}
+
+type ISessionService interface {
+	// Create creates a new session for the given user, and issues an access token and a refresh token for it.
+	// Accepts optional DBOpts to specify a DB instance.
+	Create(userID uint, client ClientInfo, opts *DBOpts) (Tokens, error)
+
+	// Refresh exchanges a refresh token for a new access token and a new refresh token for the same session.
+	// The refresh token can only be used once. Reusing it revokes the whole session, since that means it was stolen.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTokenInvalid, apperror.ErrTokenExpired or apperror.ErrTokenReused if the token can't be used.
+	Refresh(refreshToken string, client ClientInfo, opts *DBOpts) (Tokens, error)
+
+	// ListActive retrieves all sessions of the given user that have not been revoked or expired, most recently seen
+	// first.
+	// Accepts optional DBOpts to specify a DB instance.
+	ListActive(userID uint, opts *DBOpts) ([]models.Session, error)
+
+	// GetActive retrieves a session by its ID, as long as it has not been revoked or expired.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the session or apperror.ErrSessionRevoked if the session is not active.
+	GetActive(id string, opts *DBOpts) (models.Session, error)
+
+	// Touch records that the session was just used by the given client.
+	// Accepts optional DBOpts to specify a DB instance.
+	Touch(id string, client ClientInfo, opts *DBOpts) error
+
+	// Revoke revokes the session with the given ID, so that its tokens stop working immediately.
+	// Accepts optional DBOpts to specify a DB instance.
+	Revoke(id string, opts *DBOpts) error
+
+	// RevokeForUser revokes the session with the given ID, as long as it belongs to the given user.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrSessionNotFound if the user has no active session with the given ID.
+	RevokeForUser(userID uint, id string, opts *DBOpts) error
+
+	// RevokeAll revokes all sessions of the given user.
+	// Accepts optional DBOpts to specify a DB instance.
+	RevokeAll(userID uint, opts *DBOpts) error
+
+	// RevokeOthers revokes all sessions of the given user except the one with the given ID, which may be empty to
+	// revoke them all.
+	// Accepts optional DBOpts to specify a DB instance.
+	RevokeOthers(userID uint, keepID string, opts *DBOpts) error
+}
+
+type SessionService struct {
+	Service
+	AuthService IAuthService
+}
+
+// Create creates a new session for the given user, and issues an access token and a refresh token for it.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc SessionService) Create(userID uint, client ClientInfo, opts *DBOpts) (Tokens, error) {
+	db := svc.getDB(opts)
+
+	var tokens Tokens
+	err := db.Transaction(func(tx *gorm.DB) error {
+		session := models.Session{
+			ID:         uuid.NewString(),
+			UserID:     userID,
+			LastSeenAt: time.Now(),
+			IPAddress:  client.IPAddress,
+			UserAgent:  client.UserAgent,
+			ExpiresAt:  time.Now().Add(config.Get().RefreshTokenTTL),
+		}
+
+		if err := tx.Create(&session).Error; err != nil {
+			slog.Error("Failed to create session", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		var err error
+		tokens, err = svc.issueTokens(session, &DBOpts{db: tx})
+		return err
+	})
+
+	return tokens, err
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token for the same session.
+//
+// The refresh token can only be used once. Reusing it revokes the whole session, since that means it was stolen.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTokenInvalid, apperror.ErrTokenExpired or apperror.ErrTokenReused if the token can't be used.
+func (svc SessionService) Refresh(refreshToken string, client ClientInfo, opts *DBOpts) (Tokens, error) {
+	db := svc.getDB(opts)
+
+	var tokens Tokens
+	var reused bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Lock the refresh token so that concurrent refreshes can't both use it
+		var token models.RefreshToken
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", hashToken(refreshToken)).
+			First(&token)
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return apperror.ErrTokenInvalid
+		} else if result.Error != nil {
+			slog.Error("Failed to fetch refresh token", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		session, err := svc.GetActive(token.SessionID, &DBOpts{db: tx})
+		if err != nil {
+			return err
+		}
+
+		// A used token being presented again means that it was stolen, so kill the whole session
+		if token.UsedAt != nil {
+			slog.Warn("Refresh token reused, revoking session", slog.String("sessionID", session.ID))
+			reused = true
+			return svc.Revoke(session.ID, &DBOpts{db: tx})
+		}
+
+		if time.Now().After(token.ExpiresAt) {
+			return apperror.ErrTokenExpired
+		}
+
+		// Mark the token as used, and issue new ones
+		if err := tx.Model(&token).Update("used_at", time.Now()).Error; err != nil {
+			slog.Error("Failed to use refresh token", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		// Refreshing counts as using the session
+		if err := svc.Touch(session.ID, client, &DBOpts{db: tx}); err != nil {
+			return err
+		}
+
+		tokens, err = svc.issueTokens(session, &DBOpts{db: tx})
+		return err
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	// The revocation has to be committed, so the error is only returned after the transaction
+	if reused {
+		return Tokens{}, apperror.ErrTokenReused
+	}
+
+	return tokens, nil
+}
+
+// GetActive retrieves a session by its ID, as long as it has not been revoked or expired.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the session or apperror.ErrSessionRevoked if the session is not active.
+func (svc SessionService) GetActive(id string, opts *DBOpts) (models.Session, error) {
+	db := svc.getDB(opts)
+
+	var session models.Session
+	result := db.Where("id = ? AND revoked_at IS NULL AND expires_at > ?", id, time.Now()).First(&session)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return session, apperror.ErrSessionRevoked
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch session", slog.Any("error", result.Error))
+		return session, apperror.Internal(result.Error)
+	}
+
+	return session, nil
+}
+
+// ListActive retrieves all sessions of the given user that have not been revoked or expired, most recently seen first.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc SessionService) ListActive(userID uint, opts *DBOpts) ([]models.Session, error) {
+	db := svc.getDB(opts)
+
+	var sessions []models.Session
+	result := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions)
+	if result.Error != nil {
+		slog.Error("Failed to fetch sessions", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	return sessions, nil
+}
+
+// Touch records that the session was just used by the given client.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc SessionService) Touch(id string, client ClientInfo, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	result := db.Model(&models.Session{}).Where("id = ?", id).Updates(map[string]any{
+		"last_seen_at": time.Now(),
+		"ip_address":   client.IPAddress,
+		"user_agent":   client.UserAgent,
+	})
+	if result.Error != nil {
+		slog.Error("Failed to update session", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	return nil
+}
+
+// Revoke revokes the session with the given ID, so that its tokens stop working immediately.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc SessionService) Revoke(id string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	result := db.Model(&models.Session{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", time.Now())
+	if result.Error != nil {
+		slog.Error("Failed to revoke session", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	return nil
+}
+
+// RevokeForUser revokes the session with the given ID, as long as it belongs to the given user.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrSessionNotFound if the user has no active session with the given ID.
+func (svc SessionService) RevokeForUser(userID uint, id string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	result := db.Model(&models.Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		slog.Error("Failed to revoke session", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return apperror.ErrSessionNotFound
+	}
+
+	return nil
+}
+
+// RevokeAll revokes all sessions of the given user.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc SessionService) RevokeAll(userID uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	result := db.Model(&models.Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		slog.Error("Failed to revoke sessions", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	return nil
+}
+
+// RevokeOthers revokes all sessions of the given user except the one with the given ID, which may be empty to revoke
+// them all.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc SessionService) RevokeOthers(userID uint, keepID string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	query := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if keepID != "" {
+		query = query.Where("id <> ?", keepID)
+	}
+
+	result := query.Update("revoked_at", time.Now())
+	if result.Error != nil {
+		slog.Error("Failed to revoke sessions", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+
+	return nil
+}
+
+// issueTokens issues a new access token and refresh token for the session, and extends the session to the expiry of
+// the refresh token.
+func (svc SessionService) issueTokens(session models.Session, opts *DBOpts) (Tokens, error) {
+	db := svc.getDB(opts)
+
+	refreshToken, err := generateToken()
+	if err != nil {
+		slog.Error("Failed to generate refresh token", slog.Any("error", err))
+		return Tokens{}, apperror.Internal(err)
+	}
+
+	refreshTokenExpiry := time.Now().Add(config.Get().RefreshTokenTTL)
+	result := db.Create(&models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: hashToken(refreshToken),
+		ExpiresAt: refreshTokenExpiry,
+	})
+	if result.Error != nil {
+		slog.Error("Failed to create refresh token", slog.Any("error", result.Error))
+		return Tokens{}, apperror.Internal(result.Error)
+	}
+
+	result = db.Model(&session).Update("expires_at", refreshTokenExpiry)
+	if result.Error != nil {
+		slog.Error("Failed to extend session", slog.Any("error", result.Error))
+		return Tokens{}, apperror.Internal(result.Error)
+	}
+
+	accessToken, accessTokenExpiry, err := svc.AuthService.GenerateJWT(session.UserID, session.ID)
+	if err != nil {
+		return Tokens{}, err
+	}
+
+	return Tokens{
+		SessionID:          session.ID,
+		AccessToken:        accessToken,
+		AccessTokenExpiry:  accessTokenExpiry,
+		RefreshToken:       refreshToken,
+		RefreshTokenExpiry: refreshTokenExpiry,
+	}, nil
+}
@@ -0,0 +1,141 @@
+package service_test
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type SessionServiceTestSuite struct {
+	suite.Suite
+	dbService      database.Service
+	sessionService service.SessionService
+	user           models.User
+}
+
+func (suite *SessionServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the session service instance to use for testing
+	suite.sessionService = service.SessionService{
+		Service:     service.Service{DBService: suite.dbService},
+		AuthService: service.AuthService{},
+	}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *SessionServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+
+	// Create a user to own the sessions
+	suite.user = models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "password"}
+	errCreate := suite.dbService.GetDB().Create(&suite.user).Error
+	suite.NoError(errCreate)
+
+	slog.Debug("Setup test")
+}
+
+func (suite *SessionServiceTestSuite) TestCreate() {
+	svc := suite.sessionService
+
+	// Create a session using the service
+	tokens, errCreate := svc.Create(suite.user.ID, service.ClientInfo{IPAddress: "127.0.0.1", UserAgent: "Go-http-client/1.1"}, nil)
+	suite.NoError(errCreate)
+	suite.NotEmpty(tokens.AccessToken)
+	suite.NotEmpty(tokens.RefreshToken)
+
+	// Assert that the access token is tied to the session
+	claims, errParse := service.AuthService{}.ParseJWT(tokens.AccessToken)
+	suite.NoError(errParse)
+	suite.Equal(tokens.SessionID, claims.ID)
+
+	// Assert that the session is active
+	session, errGet := svc.GetActive(tokens.SessionID, nil)
+	suite.NoError(errGet)
+	suite.Equal(suite.user.ID, session.UserID)
+
+	// Assert that only the hash of the refresh token is stored
+	var count int64
+	suite.dbService.GetDB().Model(&models.RefreshToken{}).Where("token_hash = ?", tokens.RefreshToken).Count(&count)
+	suite.Zero(count)
+}
+
+func (suite *SessionServiceTestSuite) TestRefresh() {
+	svc := suite.sessionService
+
+	tokens, errCreate := svc.Create(suite.user.ID, service.ClientInfo{IPAddress: "127.0.0.1", UserAgent: "Go-http-client/1.1"}, nil)
+	suite.NoError(errCreate)
+
+	// Refresh the session, which should rotate the refresh token
+	refreshedTokens, errRefresh := svc.Refresh(tokens.RefreshToken, service.ClientInfo{}, nil)
+	suite.NoError(errRefresh)
+	suite.Equal(tokens.SessionID, refreshedTokens.SessionID)
+	suite.NotEqual(tokens.RefreshToken, refreshedTokens.RefreshToken)
+
+	// Refresh with an unknown token
+	_, errRefresh = svc.Refresh("nosuchtoken", service.ClientInfo{}, nil)
+	suite.ErrorIs(errRefresh, apperror.ErrTokenInvalid)
+
+	// Reuse the old refresh token, which should revoke the whole session
+	_, errRefresh = svc.Refresh(tokens.RefreshToken, service.ClientInfo{}, nil)
+	suite.ErrorIs(errRefresh, apperror.ErrTokenReused)
+
+	_, errGet := svc.GetActive(tokens.SessionID, nil)
+	suite.ErrorIs(errGet, apperror.ErrSessionRevoked)
+
+	// The latest refresh token should not work anymore either
+	_, errRefresh = svc.Refresh(refreshedTokens.RefreshToken, service.ClientInfo{}, nil)
+	suite.ErrorIs(errRefresh, apperror.ErrSessionRevoked)
+}
+
+func (suite *SessionServiceTestSuite) TestRevoke() {
+	svc := suite.sessionService
+
+	firstTokens, errCreate := svc.Create(suite.user.ID, service.ClientInfo{IPAddress: "127.0.0.1", UserAgent: "Go-http-client/1.1"}, nil)
+	suite.NoError(errCreate)
+	secondTokens, errCreate := svc.Create(suite.user.ID, service.ClientInfo{IPAddress: "127.0.0.1", UserAgent: "Go-http-client/1.1"}, nil)
+	suite.NoError(errCreate)
+
+	// Revoke the first session only
+	suite.NoError(svc.Revoke(firstTokens.SessionID, nil))
+	_, errGet := svc.GetActive(firstTokens.SessionID, nil)
+	suite.ErrorIs(errGet, apperror.ErrSessionRevoked)
+	_, errGet = svc.GetActive(secondTokens.SessionID, nil)
+	suite.NoError(errGet)
+
+	// Revoking a session of another user should fail
+	errRevoke := svc.RevokeForUser(suite.user.ID+1, secondTokens.SessionID, nil)
+	suite.ErrorIs(errRevoke, apperror.ErrSessionNotFound)
+
+	// Only the second session should be listed now
+	sessions, errList := svc.ListActive(suite.user.ID, nil)
+	suite.NoError(errList)
+	suite.Len(sessions, 1)
+	suite.Equal(secondTokens.SessionID, sessions[0].ID)
+	suite.Equal("127.0.0.1", sessions[0].IPAddress)
+
+	// Revoke all sessions of the user
+	suite.NoError(svc.RevokeAll(suite.user.ID, nil))
+	_, errGet = svc.GetActive(secondTokens.SessionID, nil)
+	suite.ErrorIs(errGet, apperror.ErrSessionRevoked)
+}
+
+func TestSessionService(t *testing.T) {
+	suite.Run(t, new(SessionServiceTestSuite))
+}
@@ -0,0 +1,423 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/models"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+// ShareSessionPrefix is the prefix of the tokens of anonymous sessions opened with share links, which tells them apart
+// from JWTs and personal access tokens.
+const ShareSessionPrefix = "nss_"
+
+// AuthMethodShareLink is the auth method of requests authenticated with an anonymous session opened with a share link.
+const AuthMethodShareLink = "share_link"
+
+// ShareLinkOptions are the options of a new share link.
+type ShareLinkOptions struct {
+	// Access is the access that the link grants to the note, AccessRead or AccessEdit.
+	Access string
+	// Password is the password that has to be entered to open the link, or empty for none.
+	Password string
+	// ExpiresAt is when the link stops working, or nil for never.
+	ExpiresAt *time.Time
+	// MaxUses is how many times the link can be opened, or nil for no limit.
+	MaxUses *int
+}
+
+type IShareLinkService interface {
+	// Create creates a share link granting access to the note with the given ID, on behalf of the given user, who has
+	// to own the note.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the link record and its URL, which is not stored and can't be retrieved again,
+	// apperror.ErrNoteNotFound if the user doesn't own the note, or apperror.ErrValidationFailed if the options are
+	// invalid.
+	Create(userID, noteID uint, options ShareLinkOptions, opts *DBOpts) (models.ShareLink, string, error)
+
+	// List retrieves all share links created by the given user that have not been revoked, newest first.
+	// Accepts optional DBOpts to specify a DB instance.
+	List(userID uint, opts *DBOpts) ([]models.ShareLink, error)
+
+	// Revoke revokes the share link with the given ID, as long as it was created by the given user, ending the
+	// sessions opened with it.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrShareLinkNotFound if the user has no such link.
+	Revoke(userID, id uint, opts *DBOpts) error
+
+	// Open opens the share link with the given token, checking its password if it has one, and starts an anonymous
+	// session that only gives access to its note. Wrong passwords are counted per link and per IP address of the
+	// client, and either is locked out for a while after too many of them.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the link, the token of the session and when it expires, apperror.ErrShareLinkNotFound,
+	// apperror.ErrShareLinkExpired if the link has expired or has been used up, apperror.ErrSharePasswordInvalid, or
+	// apperror.ErrTooManyShareAttempts if the link or the IP address is locked out.
+	Open(token, password, ip string, opts *DBOpts) (models.ShareLink, string, time.Time, error)
+
+	// Authenticate retrieves the share link of the anonymous session with the given token.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTokenInvalid if the session doesn't exist or its link was revoked, or
+	// apperror.ErrTokenExpired if the session or its link has expired.
+	Authenticate(token string, opts *DBOpts) (models.ShareLink, error)
+
+	// GenMiddleware generates a Fiber middleware that authenticates requests with anonymous sessions opened with share
+	// links.
+	//
+	// The middleware looks for the token of the session in an Authorization: Bearer header. If it is valid, it sets
+	// the share link and the auth method in the context for further use, see ShareLinkFromCtx.
+	GenMiddleware() fiber.Handler
+}
+
+type ShareLinkService struct {
+	Service
+
+	// AuthService is used to hash and check the passwords of share links.
+	AuthService IAuthService
+
+	// OrganizationService is used to check that users are members of the organizations that notes belong to.
+	OrganizationService IOrganizationService
+}
+
+// Create creates a share link granting access to the note with the given ID, on behalf of the given user, who has to
+// own the note.
+//
+// Passwords are hashed the same way as the passwords of users, but aren't held to the password policy, since they are
+// only a second secret next to the link itself.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the link record and its URL, which is not stored and can't be retrieved again, apperror.ErrNoteNotFound if
+// the user doesn't own the note, or apperror.ErrValidationFailed if the options are invalid.
+func (svc ShareLinkService) Create(
+	userID, noteID uint, options ShareLinkOptions, opts *DBOpts,
+) (models.ShareLink, string, error) {
+	db := svc.getDB(opts)
+
+	// Check the options
+	var details []apperror.FieldError
+	if !slices.Contains(grantableAccess, options.Access) {
+		details = append(details, apperror.FieldError{
+			Field:   "access",
+			Message: fmt.Sprintf("must be one of: %s", strings.Join(grantableAccess, " ")),
+		})
+	}
+	if options.ExpiresAt != nil && !options.ExpiresAt.After(time.Now()) {
+		details = append(details, apperror.FieldError{Field: "expires_at", Message: "must be in the future"})
+	}
+	if options.MaxUses != nil && *options.MaxUses < 1 {
+		details = append(details, apperror.FieldError{Field: "max_uses", Message: "must be at least 1"})
+	}
+	if len(details) > 0 {
+		return models.ShareLink{}, "", apperror.ErrValidationFailed.WithDetails(details...)
+	}
+
+	// Only the owner of a note can share it, and other users' notes aren't revealed to exist
+	if _, err := findOwnedNote(db, svc.organizationService(), userID, noteID); err != nil {
+		return models.ShareLink{}, "", err
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		slog.Error("Failed to generate share link token", slog.Any("error", err))
+		return models.ShareLink{}, "", apperror.Internal(err)
+	}
+
+	link := models.ShareLink{
+		UserID:    userID,
+		NoteID:    noteID,
+		Access:    options.Access,
+		TokenHash: hashToken(token),
+		ExpiresAt: options.ExpiresAt,
+		MaxUses:   options.MaxUses,
+	}
+	if options.Password != "" {
+		link.PasswordHash, err = svc.AuthService.HashPassword(options.Password)
+		if err != nil {
+			slog.Error("Failed to hash share link password", slog.Any("error", err))
+			return models.ShareLink{}, "", apperror.Internal(err)
+		}
+		link.PasswordProtected = true
+	}
+
+	if err := db.Create(&link).Error; err != nil {
+		slog.Error("Failed to create share link", slog.Any("error", err))
+		return models.ShareLink{}, "", apperror.Internal(err)
+	}
+
+	return link, appLink("/share", token), nil
+}
+
+// List retrieves all share links created by the given user that have not been revoked, newest first.
+//
+// Links that have expired or have been used up are included, so that users can see what happened to them.
+//
+// Accepts optional DBOpts to specify a DB instance.
+func (svc ShareLinkService) List(userID uint, opts *DBOpts) ([]models.ShareLink, error) {
+	db := svc.getDB(opts)
+
+	links := make([]models.ShareLink, 0)
+	result := db.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&links)
+	if result.Error != nil {
+		slog.Error("Failed to fetch share links", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	return links, nil
+}
+
+// Revoke revokes the share link with the given ID, as long as it was created by the given user, ending the sessions
+// opened with it.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrShareLinkNotFound if the user has no such link.
+func (svc ShareLinkService) Revoke(userID, id uint, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.ShareLink{}).
+			Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+			Update("revoked_at", time.Now())
+		if result.Error != nil {
+			slog.Error("Failed to revoke share link", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return apperror.ErrShareLinkNotFound
+		}
+
+		if err := tx.Where("share_link_id = ?", id).Delete(&models.ShareSession{}).Error; err != nil {
+			slog.Error("Failed to delete share sessions", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+}
+
+// Open opens the share link with the given token, checking its password if it has one, and starts an anonymous
+// session that only gives access to its note.
+//
+// Each successful open counts as a use of the link, and is counted atomically so that concurrent opens can't go over
+// the limit. The session expires after the configured TTL, or when the link does if that is sooner.
+//
+// Wrong passwords are throttled the same way as failed logins, with the same limits per link as per account, and per
+// IP address. The lockouts are checked before the password is hashed, so that locked out guesses don't cost a hash.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the link, the token of the session and when it expires, apperror.ErrShareLinkNotFound,
+// apperror.ErrShareLinkExpired if the link has expired or has been used up, apperror.ErrSharePasswordInvalid, or
+// apperror.ErrTooManyShareAttempts if the link or the IP address is locked out.
+func (svc ShareLinkService) Open(
+	token, password, ip string, opts *DBOpts,
+) (models.ShareLink, string, time.Time, error) {
+	db := svc.getDB(opts)
+
+	var link models.ShareLink
+	result := db.Where("token_hash = ? AND revoked_at IS NULL", hashToken(token)).First(&link)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return models.ShareLink{}, "", time.Time{}, apperror.ErrShareLinkNotFound
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch share link", slog.Any("error", result.Error))
+		return models.ShareLink{}, "", time.Time{}, apperror.Internal(result.Error)
+	}
+
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return models.ShareLink{}, "", time.Time{}, apperror.ErrShareLinkExpired
+	}
+
+	// Check the password before counting the use, so that wrong guesses don't use up the link
+	if link.PasswordProtected {
+		if password == "" {
+			return models.ShareLink{}, "", time.Time{}, apperror.ErrSharePasswordInvalid
+		}
+
+		// Don't check the password at all while the link or the client is locked out
+		linkKey, ipKey := shareLinkThrottleKey(link.ID), shareIPThrottleKey(ip)
+		retryAfter, err := throttleLockout(db, linkKey, ipKey)
+		if err != nil {
+			slog.Error("Failed to check share link throttles", slog.Any("error", err))
+			return models.ShareLink{}, "", time.Time{}, apperror.Internal(err)
+		}
+		if retryAfter > 0 {
+			return models.ShareLink{}, "", time.Time{}, apperror.ErrTooManyShareAttempts
+		}
+
+		err = svc.AuthService.ComparePasswords(link.PasswordHash, password)
+		if errors.Is(err, apperror.ErrInvalidCredentials) {
+			if err := recordShareFailure(db, linkKey, ipKey); err != nil {
+				slog.Error("Failed to record wrong share link password", slog.Any("error", err))
+				return models.ShareLink{}, "", time.Time{}, apperror.Internal(err)
+			}
+			return models.ShareLink{}, "", time.Time{}, apperror.ErrSharePasswordInvalid
+		} else if err != nil {
+			return models.ShareLink{}, "", time.Time{}, err
+		}
+	}
+
+	secret, err := generateToken()
+	if err != nil {
+		slog.Error("Failed to generate share session token", slog.Any("error", err))
+		return models.ShareLink{}, "", time.Time{}, apperror.Internal(err)
+	}
+	sessionToken := ShareSessionPrefix + secret
+
+	expiresAt := time.Now().Add(config.Get().ShareSessionTTL)
+	if link.ExpiresAt != nil && link.ExpiresAt.Before(expiresAt) {
+		expiresAt = *link.ExpiresAt
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.ShareLink{}).
+			Where("id = ? AND revoked_at IS NULL AND (max_uses IS NULL OR uses < max_uses)", link.ID).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			slog.Error("Failed to count share link use", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return apperror.ErrShareLinkExpired
+		}
+
+		session := models.ShareSession{ShareLinkID: link.ID, TokenHash: hashToken(sessionToken), ExpiresAt: expiresAt}
+		if err := tx.Create(&session).Error; err != nil {
+			slog.Error("Failed to create share session", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return models.ShareLink{}, "", time.Time{}, err
+	}
+	link.Uses++
+
+	return link, sessionToken, expiresAt, nil
+}
+
+// Authenticate retrieves the share link of the anonymous session with the given token.
+//
+// The link is checked on every request, so that revoking it ends its sessions right away.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTokenInvalid if the session doesn't exist or its link was revoked, or apperror.ErrTokenExpired
+// if the session or its link has expired.
+func (svc ShareLinkService) Authenticate(token string, opts *DBOpts) (models.ShareLink, error) {
+	db := svc.getDB(opts)
+
+	var session models.ShareSession
+	result := db.Where("token_hash = ?", hashToken(token)).First(&session)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return models.ShareLink{}, apperror.ErrTokenInvalid
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch share session", slog.Any("error", result.Error))
+		return models.ShareLink{}, apperror.Internal(result.Error)
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return models.ShareLink{}, apperror.ErrTokenExpired
+	}
+
+	var link models.ShareLink
+	result = db.Where("id = ? AND revoked_at IS NULL", session.ShareLinkID).First(&link)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return models.ShareLink{}, apperror.ErrTokenInvalid
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch share link", slog.Any("error", result.Error))
+		return models.ShareLink{}, apperror.Internal(result.Error)
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return models.ShareLink{}, apperror.ErrTokenExpired
+	}
+
+	return link, nil
+}
+
+// GenMiddleware generates a Fiber middleware that authenticates requests with anonymous sessions opened with share
+// links.
+//
+// The middleware looks for the token of the session in an Authorization: Bearer header. It isn't accepted in a cookie,
+// so that it can't be used for CSRF, and routes that need a user never accept it, since it isn't a JWT. If it is
+// valid, the middleware sets the share link and the auth method in the context for further use, see ShareLinkFromCtx.
+func (svc ShareLinkService) GenMiddleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token, _ := tokenFromRequest(c, []string{"header"})
+		if !strings.HasPrefix(token, ShareSessionPrefix) {
+			return apperror.ErrUnauthorized
+		}
+
+		link, err := svc.Authenticate(token, nil)
+		if err != nil {
+			return err
+		}
+
+		// Set the share link and auth method in the context for further use
+		c.Locals("shareLink", link)
+		c.Locals("authMethod", AuthMethodShareLink)
+
+		return c.Next()
+	}
+}
+
+// organizationService returns the organization service, defaulting to one using the same DB.
+func (svc ShareLinkService) organizationService() IOrganizationService {
+	if svc.OrganizationService != nil {
+		return svc.OrganizationService
+	}
+	return OrganizationService{Service: svc.Service}
+}
+
+// ShareLinkFromCtx returns the share link of the anonymous session that authenticated the request, as set in the
+// context by the share link middleware.
+//
+// Returns apperror.ErrUnauthorized if the request wasn't authenticated with a share link.
+func ShareLinkFromCtx(c *fiber.Ctx) (models.ShareLink, error) {
+	link, ok := c.Locals("shareLink").(models.ShareLink)
+	if !ok {
+		return models.ShareLink{}, apperror.ErrUnauthorized
+	}
+
+	return link, nil
+}
+
+// recordShareFailure records a wrong password for a share link, counting it against both the link and the IP address
+// of the client.
+func recordShareFailure(db *gorm.DB, linkKey, ipKey string) error {
+	cfg := config.Get()
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := recordThrottleFailure(tx, linkKey, cfg.LoginMaxFailuresPerAccount); err != nil {
+			return err
+		}
+		return recordThrottleFailure(tx, ipKey, cfg.LoginMaxFailuresPerIP)
+	})
+}
+
+// shareLinkThrottleKey returns the key of the throttle of wrong passwords for a share link.
+func shareLinkThrottleKey(linkID uint) string {
+	return fmt.Sprintf("share-link:%d", linkID)
+}
+
+// shareIPThrottleKey returns the key of the throttle of wrong share link passwords from an IP address.
+//
+// It is kept apart from the login throttle of the IP address, so that visitors guessing a share link password can't
+// lock the users behind the same address out of their accounts.
+func shareIPThrottleKey(ip string) string {
+	return "share-ip:" + ip
+}
+
+// deleteShareLinks deletes the share links created by the user, along with their sessions, when their account is
+// deleted.
+func deleteShareLinks(tx *gorm.DB, userID uint) error {
+	linkIDs := tx.Model(&models.ShareLink{}).Select("id").Where("user_id = ?", userID)
+	if err := tx.Where("share_link_id IN (?)", linkIDs).Delete(&models.ShareSession{}).Error; err != nil {
+		return err
+	}
+
+	return tx.Where("user_id = ?", userID).Delete(&models.ShareLink{}).Error
+}
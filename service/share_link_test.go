@@ -0,0 +1,209 @@
+package service_test
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ShareLinkServiceTestSuite struct {
+	suite.Suite
+	dbService        database.Service
+	shareLinkService service.ShareLinkService
+	user             models.User
+	note             models.Note
+}
+
+func (suite *ShareLinkServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the service instances to use for testing
+	suite.shareLinkService = service.ShareLinkService{
+		Service:     service.Service{DBService: suite.dbService},
+		AuthService: service.AuthService{},
+	}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *ShareLinkServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+
+	suite.user = models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "hashedpassword"}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&suite.user).Error)
+	suite.note = models.Note{OwnerID: &suite.user.ID, Title: "Shopping list"}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&suite.note).Error)
+
+	slog.Debug("Setup test")
+}
+
+// create creates a share link with the given options, and returns it along with its token.
+func (suite *ShareLinkServiceTestSuite) create(options service.ShareLinkOptions) (models.ShareLink, string) {
+	link, linkURL, err := suite.shareLinkService.Create(suite.user.ID, suite.note.ID, options, nil)
+	suite.Require().NoError(err)
+
+	parsed, err := url.Parse(linkURL)
+	suite.Require().NoError(err)
+	return link, parsed.Query().Get("token")
+}
+
+func (suite *ShareLinkServiceTestSuite) TestCreate() {
+	svc := suite.shareLinkService
+
+	link, token := suite.create(service.ShareLinkOptions{Access: service.AccessRead, Password: "open sesame"})
+	suite.NotEmpty(token)
+	suite.NotContains(link.TokenHash, token)
+	suite.True(link.PasswordProtected)
+	suite.NotEqual("open sesame", link.PasswordHash)
+
+	// Unknown access, past expiry and non-positive use limits are rejected
+	past := time.Now().Add(-time.Minute)
+	zero := 0
+	_, _, err := svc.Create(suite.user.ID, suite.note.ID, service.ShareLinkOptions{Access: "admin", ExpiresAt: &past, MaxUses: &zero}, nil)
+	suite.ErrorIs(err, apperror.ErrValidationFailed)
+	var appErr *apperror.Error
+	suite.Require().ErrorAs(err, &appErr)
+	suite.Len(appErr.Details, 3)
+
+	// Only the owner of a note can share it
+	other := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "hashedpassword"}
+	suite.Require().NoError(suite.dbService.GetDB().Create(&other).Error)
+	_, _, err = svc.Create(other.ID, suite.note.ID, service.ShareLinkOptions{Access: service.AccessRead}, nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+	_, _, err = svc.Create(suite.user.ID, suite.note.ID+1, service.ShareLinkOptions{Access: service.AccessRead}, nil)
+	suite.ErrorIs(err, apperror.ErrNoteNotFound)
+
+	links, err := svc.List(suite.user.ID, nil)
+	suite.NoError(err)
+	suite.Len(links, 1)
+}
+
+func (suite *ShareLinkServiceTestSuite) TestOpen() {
+	svc := suite.shareLinkService
+	_, token := suite.create(service.ShareLinkOptions{Access: service.AccessEdit, Password: "open sesame"})
+
+	// Wrong passwords don't use up the link
+	_, _, _, err := svc.Open(token, "", "203.0.113.1", nil)
+	suite.ErrorIs(err, apperror.ErrSharePasswordInvalid)
+	_, _, _, err = svc.Open(token, "guess", "203.0.113.1", nil)
+	suite.ErrorIs(err, apperror.ErrSharePasswordInvalid)
+	_, _, _, err = svc.Open("nosuchtoken", "open sesame", "203.0.113.1", nil)
+	suite.ErrorIs(err, apperror.ErrShareLinkNotFound)
+
+	link, sessionToken, expiresAt, err := svc.Open(token, "open sesame", "203.0.113.1", nil)
+	suite.NoError(err)
+	suite.Equal(1, link.Uses)
+	suite.WithinDuration(time.Now().Add(config.Get().ShareSessionTTL), expiresAt, time.Minute)
+
+	// The session only leads to the link's note
+	authenticated, err := svc.Authenticate(sessionToken, nil)
+	suite.NoError(err)
+	suite.Equal(suite.note.ID, authenticated.NoteID)
+	suite.Equal(service.AccessEdit, authenticated.Access)
+}
+
+func (suite *ShareLinkServiceTestSuite) TestThrottle() {
+	svc := suite.shareLinkService
+	cfg := config.Get()
+	_, token := suite.create(service.ShareLinkOptions{Access: service.AccessRead, Password: "open sesame"})
+	_, otherToken := suite.create(service.ShareLinkOptions{Access: service.AccessRead, Password: "open sesame"})
+
+	// Guessing the password of a link locks it out, even for the right password and from other IP addresses
+	for i := range cfg.LoginMaxFailuresPerAccount {
+		_, _, _, err := svc.Open(token, "guess", fmt.Sprintf("198.51.100.%d", i), nil)
+		suite.ErrorIs(err, apperror.ErrSharePasswordInvalid)
+	}
+	_, _, _, err := svc.Open(token, "open sesame", "203.0.113.1", nil)
+	suite.ErrorIs(err, apperror.ErrTooManyShareAttempts)
+
+	// Other links are not affected
+	_, _, _, err = svc.Open(otherToken, "open sesame", "203.0.113.1", nil)
+	suite.NoError(err)
+
+	// An IP address that has guessed too often is locked out, for any link
+	lockedUntil := time.Now().Add(time.Minute)
+	suite.Require().NoError(suite.dbService.GetDB().Create(&models.LoginThrottle{
+		Key:           "share-ip:192.0.2.1",
+		Failures:      cfg.LoginMaxFailuresPerIP,
+		LastFailureAt: time.Now(),
+		LockedUntil:   &lockedUntil,
+	}).Error)
+	_, _, _, err = svc.Open(otherToken, "open sesame", "192.0.2.1", nil)
+	suite.ErrorIs(err, apperror.ErrTooManyShareAttempts)
+
+	// Wrong share link passwords don't count against logins from the IP address
+	var count int64
+	suite.NoError(suite.dbService.GetDB().Model(&models.LoginThrottle{}).Where("key LIKE ?", "ip:%").Count(&count).Error)
+	suite.Zero(count)
+}
+
+func (suite *ShareLinkServiceTestSuite) TestLimits() {
+	svc := suite.shareLinkService
+
+	// Links can only be opened as many times as they allow
+	maxUses := 2
+	_, token := suite.create(service.ShareLinkOptions{Access: service.AccessRead, MaxUses: &maxUses})
+	for range maxUses {
+		_, _, _, err := svc.Open(token, "", "203.0.113.1", nil)
+		suite.NoError(err)
+	}
+	_, _, _, err := svc.Open(token, "", "203.0.113.1", nil)
+	suite.ErrorIs(err, apperror.ErrShareLinkExpired)
+
+	// Sessions don't outlive their link, and stop working once it expires
+	expiresAt := time.Now().Add(time.Second)
+	link, token := suite.create(service.ShareLinkOptions{Access: service.AccessRead, ExpiresAt: &expiresAt})
+	_, sessionToken, sessionExpiresAt, err := svc.Open(token, "", "203.0.113.1", nil)
+	suite.NoError(err)
+	suite.WithinDuration(*link.ExpiresAt, sessionExpiresAt, time.Millisecond)
+
+	suite.NoError(suite.dbService.GetDB().Model(&link).Update("expires_at", time.Now().Add(-time.Second)).Error)
+	_, _, _, err = svc.Open(token, "", "203.0.113.1", nil)
+	suite.ErrorIs(err, apperror.ErrShareLinkExpired)
+	_, err = svc.Authenticate(sessionToken, nil)
+	suite.ErrorIs(err, apperror.ErrTokenExpired)
+}
+
+func (suite *ShareLinkServiceTestSuite) TestRevoke() {
+	svc := suite.shareLinkService
+	link, token := suite.create(service.ShareLinkOptions{Access: service.AccessRead})
+	_, sessionToken, _, err := svc.Open(token, "", "203.0.113.1", nil)
+	suite.NoError(err)
+
+	// Only the user who created the link can revoke it
+	suite.ErrorIs(svc.Revoke(suite.user.ID+1, link.ID, nil), apperror.ErrShareLinkNotFound)
+	suite.NoError(svc.Revoke(suite.user.ID, link.ID, nil))
+	suite.ErrorIs(svc.Revoke(suite.user.ID, link.ID, nil), apperror.ErrShareLinkNotFound)
+
+	// Revoking ends the sessions opened with the link right away, and the link can't be opened again
+	_, err = svc.Authenticate(sessionToken, nil)
+	suite.ErrorIs(err, apperror.ErrTokenInvalid)
+	_, _, _, err = svc.Open(token, "", "203.0.113.1", nil)
+	suite.ErrorIs(err, apperror.ErrShareLinkNotFound)
+
+	links, err := svc.List(suite.user.ID, nil)
+	suite.NoError(err)
+	suite.Empty(links)
+}
+
+func TestShareLinkService(t *testing.T) {
+	suite.Run(t, new(ShareLinkServiceTestSuite))
+}
@@ -0,0 +1,29 @@
+package service
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// tokenLength is the number of random bytes in opaque tokens like refresh tokens.
+const tokenLength = 32
+
+// generateToken generates a random, URL-safe opaque token.
+func generateToken() (string, error) {
+	token := make([]byte, tokenLength)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// hashToken returns the SHA-256 hash of an opaque token, which is what gets stored in the database.
+//
+// A fast hash is fine here, unlike for passwords, since the tokens are random and long enough to not be guessable.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
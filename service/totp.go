@@ -0,0 +1,450 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/models"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TOTP parameters, as per RFC 6238. These are the defaults of every authenticator app, so they are not configurable.
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of time steps before and after the current one that are also accepted, to allow for
+	// clock drift and slow typing.
+	totpSkew = 1
+	// totpSecretLength is the length of TOTP secrets in bytes, as recommended by RFC 4226.
+	totpSecretLength = 20
+)
+
+// Recovery code parameters.
+const (
+	recoveryCodeCount = 10
+	// recoveryCodeLength is the number of base32 characters in a recovery code, excluding the separator.
+	recoveryCodeLength = 10
+)
+
+// totpEncoding is the encoding of TOTP secrets expected by authenticator apps.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type ITOTPService interface {
+	// IsEnabled checks if the given user has confirmed two-factor authentication.
+	// Accepts optional DBOpts to specify a DB instance.
+	IsEnabled(userID uint, opts *DBOpts) (bool, error)
+
+	// Enroll generates a new TOTP secret for the given user, replacing any unconfirmed one. Two-factor authentication
+	// is only enabled once the secret is confirmed with Confirm.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the base32 secret and an otpauth:// URI to show as a QR code, or apperror.ErrTOTPAlreadyEnabled.
+	Enroll(user models.User, opts *DBOpts) (string, string, error)
+
+	// Confirm enables two-factor authentication for the given user, as long as the code matches their enrolled secret.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the plaintext recovery codes, which are not stored and can't be retrieved again,
+	// apperror.ErrTOTPNotEnabled if the user has not enrolled, apperror.ErrTOTPAlreadyEnabled, or
+	// apperror.ErrInvalidOTP.
+	Confirm(userID uint, code string, opts *DBOpts) ([]string, error)
+
+	// Disable disables two-factor authentication for the given user and deletes their recovery codes, as long as the
+	// code is a valid TOTP code or recovery code.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTOTPNotEnabled or apperror.ErrInvalidOTP.
+	Disable(userID uint, code string, opts *DBOpts) error
+
+	// Verify checks a TOTP code or recovery code of the given user. Each code can only be used once.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTOTPNotEnabled or apperror.ErrInvalidOTP.
+	Verify(userID uint, code string, opts *DBOpts) error
+
+	// CreateChallenge issues a short-lived token that proves that the user entered their password, to be exchanged for
+	// a session along with a TOTP code or recovery code with VerifyChallenge.
+	// Accepts optional DBOpts to specify a DB instance.
+	CreateChallenge(user models.User, opts *DBOpts) (string, error)
+
+	// ChallengeEmail returns the email of the user that the challenge token from CreateChallenge was issued to,
+	// without using it up, so that logins can be throttled before the code is checked.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the challenge token is not valid.
+	ChallengeEmail(challengeToken string, opts *DBOpts) (string, error)
+
+	// VerifyChallenge checks the challenge token from CreateChallenge and the user's TOTP code or recovery code.
+	//
+	// The challenge token is used up even if the code is wrong, so that each guess of the code needs the password
+	// again.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the ID of the user, apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the challenge token is not
+	// valid, or apperror.ErrInvalidOTP.
+	VerifyChallenge(challengeToken, code string, opts *DBOpts) (uint, error)
+}
+
+type TOTPService struct {
+	Service
+}
+
+// IsEnabled checks if the given user has confirmed two-factor authentication.
+// Accepts optional DBOpts to specify a DB instance.
+func (svc TOTPService) IsEnabled(userID uint, opts *DBOpts) (bool, error) {
+	db := svc.getDB(opts)
+
+	var count int64
+	result := db.Model(&models.TOTPCredential{}).
+		Where("user_id = ? AND confirmed_at IS NOT NULL", userID).
+		Count(&count)
+	if result.Error != nil {
+		slog.Error("Failed to check TOTP credential", slog.Any("error", result.Error))
+		return false, apperror.Internal(result.Error)
+	}
+
+	return count > 0, nil
+}
+
+// Enroll generates a new TOTP secret for the given user, replacing any unconfirmed one. Two-factor authentication
+// is only enabled once the secret is confirmed with Confirm.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the base32 secret and an otpauth:// URI to show as a QR code, or apperror.ErrTOTPAlreadyEnabled.
+func (svc TOTPService) Enroll(user models.User, opts *DBOpts) (string, string, error) {
+	db := svc.getDB(opts)
+
+	secret := make([]byte, totpSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		slog.Error("Failed to generate TOTP secret", slog.Any("error", err))
+		return "", "", apperror.Internal(err)
+	}
+	encoded := totpEncoding.EncodeToString(secret)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		credential, err := findTOTPCredential(tx, user.ID)
+		if errors.Is(err, apperror.ErrTOTPNotEnabled) {
+			credential = models.TOTPCredential{UserID: user.ID}
+		} else if err != nil {
+			return err
+		} else if credential.ConfirmedAt != nil {
+			return apperror.ErrTOTPAlreadyEnabled
+		}
+
+		// Start over with the new secret, whether or not the user enrolled before
+		credential.Secret = encoded
+		credential.LastUsedStep = 0
+		if err := tx.Save(&credential).Error; err != nil {
+			slog.Error("Failed to save TOTP credential", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return encoded, totpURI(encoded, user.Email), nil
+}
+
+// Confirm enables two-factor authentication for the given user, as long as the code matches their enrolled secret.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the plaintext recovery codes, which are not stored and can't be retrieved again,
+// apperror.ErrTOTPNotEnabled if the user has not enrolled, apperror.ErrTOTPAlreadyEnabled, or
+// apperror.ErrInvalidOTP.
+func (svc TOTPService) Confirm(userID uint, code string, opts *DBOpts) ([]string, error) {
+	db := svc.getDB(opts)
+
+	var recoveryCodes []string
+	err := db.Transaction(func(tx *gorm.DB) error {
+		credential, err := findTOTPCredential(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID)
+		if err != nil {
+			return err
+		}
+		if credential.ConfirmedAt != nil {
+			return apperror.ErrTOTPAlreadyEnabled
+		}
+
+		step, ok := checkTOTPCode(credential, code, time.Now())
+		if !ok {
+			return apperror.ErrInvalidOTP
+		}
+
+		result := tx.Model(&credential).Updates(map[string]any{"confirmed_at": time.Now(), "last_used_step": step})
+		if result.Error != nil {
+			slog.Error("Failed to confirm TOTP credential", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		recoveryCodes, err = replaceRecoveryCodes(tx, userID)
+		return err
+	})
+
+	return recoveryCodes, err
+}
+
+// Disable disables two-factor authentication for the given user and deletes their recovery codes, as long as the
+// code is a valid TOTP code or recovery code.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTOTPNotEnabled or apperror.ErrInvalidOTP.
+func (svc TOTPService) Disable(userID uint, code string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := verifyCode(tx, userID, code); err != nil {
+			return err
+		}
+
+		err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+		if err == nil {
+			err = tx.Where("user_id = ?", userID).Delete(&models.TOTPCredential{}).Error
+		}
+		if err != nil {
+			slog.Error("Failed to disable TOTP", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+
+		return nil
+	})
+}
+
+// Verify checks a TOTP code or recovery code of the given user. Each code can only be used once.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTOTPNotEnabled or apperror.ErrInvalidOTP.
+func (svc TOTPService) Verify(userID uint, code string, opts *DBOpts) error {
+	db := svc.getDB(opts)
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		return verifyCode(tx, userID, code)
+	})
+}
+
+// CreateChallenge issues a short-lived token that proves that the user entered their password, to be exchanged for
+// a session along with a TOTP code or recovery code with VerifyChallenge.
+// Accepts optional DBOpts to specify a DB instance.
+func (svc TOTPService) CreateChallenge(user models.User, opts *DBOpts) (string, error) {
+	return issueOneTimeToken(svc.getDB(opts), user, tokenPurposeMFAChallenge, config.Get().MFAChallengeTTL)
+}
+
+// ChallengeEmail returns the email of the user that the challenge token from CreateChallenge was issued to, without
+// using it up, so that logins can be throttled before the code is checked.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the challenge token is not valid.
+func (svc TOTPService) ChallengeEmail(challengeToken string, opts *DBOpts) (string, error) {
+	challenge, err := findOneTimeToken(svc.getDB(opts), challengeToken, tokenPurposeMFAChallenge)
+	if err != nil {
+		return "", err
+	}
+
+	return challenge.Email, nil
+}
+
+// VerifyChallenge checks the challenge token from CreateChallenge and the user's TOTP code or recovery code.
+//
+// The challenge token is used up even if the code is wrong, so that each guess of the code needs the password
+// again.
+// Accepts optional DBOpts to specify a DB instance.
+// Returns the ID of the user, apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the challenge token is not
+// valid, or apperror.ErrInvalidOTP.
+func (svc TOTPService) VerifyChallenge(challengeToken, code string, opts *DBOpts) (uint, error) {
+	db := svc.getDB(opts)
+
+	// Use up the challenge in its own transaction, so that it stays used even if the code is wrong
+	var challenge models.OneTimeToken
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		challenge, err = consumeOneTimeToken(tx, challengeToken, tokenPurposeMFAChallenge)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := svc.Verify(challenge.UserID, code, &DBOpts{db: db}); err != nil {
+		return 0, err
+	}
+
+	return challenge.UserID, nil
+}
+
+// TOTPCode generates the TOTP code for the given base32 secret at the given time.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return totpCode(key, t.Unix()/totpPeriod), nil
+}
+
+// totpCode generates the code for the given key and time step, as per RFC 4226.
+func totpCode(key []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation, take 4 bytes at the offset given by the last nibble
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for range totpDigits {
+		modulo *= 10
+	}
+
+	return fmt.Sprintf("%0*d", totpDigits, value%modulo)
+}
+
+// decodeTOTPSecret decodes a base32 TOTP secret, ignoring case and spaces, since users sometimes type them in.
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return totpEncoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// totpURI builds the otpauth:// URI of a TOTP secret, as understood by authenticator apps.
+//
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func totpURI(secret, email string) string {
+	issuer := config.Get().TOTPIssuer
+
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+
+	uri := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + email,
+		RawQuery: query.Encode(),
+	}
+	return uri.String()
+}
+
+// checkTOTPCode checks the code against the credential's secret at the given time, allowing for some clock drift.
+// Codes from a time step at or before the last used one are rejected, so that a code can't be replayed.
+//
+// Returns the time step that the code matched.
+func checkTOTPCode(credential models.TOTPCredential, code string, now time.Time) (int64, bool) {
+	key, err := decodeTOTPSecret(credential.Secret)
+	if err != nil {
+		slog.Error("Failed to decode TOTP secret", slog.Any("error", err))
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= credential.LastUsedStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+// findTOTPCredential retrieves the TOTP credential of the given user, confirmed or not.
+//
+// Returns apperror.ErrTOTPNotEnabled if the user has not enrolled.
+func findTOTPCredential(db *gorm.DB, userID uint) (models.TOTPCredential, error) {
+	var credential models.TOTPCredential
+	result := db.Where("user_id = ?", userID).First(&credential)
+	if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+		return credential, apperror.ErrTOTPNotEnabled
+	} else if result.Error != nil {
+		slog.Error("Failed to fetch TOTP credential", slog.Any("error", result.Error))
+		return credential, apperror.Internal(result.Error)
+	}
+
+	return credential, nil
+}
+
+// verifyCode checks a TOTP code or recovery code of the given user with confirmed two-factor authentication, and
+// marks it as used. It should be called in a transaction, so that the credential stays locked until the code is used.
+func verifyCode(tx *gorm.DB, userID uint, code string) error {
+	credential, err := findTOTPCredential(tx.Clauses(clause.Locking{Strength: "UPDATE"}), userID)
+	if err != nil {
+		return err
+	}
+	if credential.ConfirmedAt == nil {
+		return apperror.ErrTOTPNotEnabled
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) == totpDigits {
+		step, ok := checkTOTPCode(credential, code, time.Now())
+		if !ok {
+			return apperror.ErrInvalidOTP
+		}
+		if err := tx.Model(&credential).Update("last_used_step", step).Error; err != nil {
+			slog.Error("Failed to use TOTP code", slog.Any("error", err))
+			return apperror.Internal(err)
+		}
+		return nil
+	}
+
+	// Anything else can only be a recovery code
+	result := tx.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		slog.Error("Failed to use recovery code", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return apperror.ErrInvalidOTP
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes generates new recovery codes for the given user, deleting any old ones.
+//
+// Returns the plaintext codes, which are only stored as hashes.
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		slog.Error("Failed to delete recovery codes", slog.Any("error", err))
+		return nil, apperror.Internal(err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	records := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		// Base32 characters are easy to read out and type in, and 10 of them give 50 bits of randomness
+		raw := make([]byte, recoveryCodeLength*5/8)
+		if _, err := rand.Read(raw); err != nil {
+			slog.Error("Failed to generate recovery code", slog.Any("error", err))
+			return nil, apperror.Internal(err)
+		}
+		code := totpEncoding.EncodeToString(raw)
+
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+		records[i] = models.RecoveryCode{UserID: userID, CodeHash: hashToken(code)}
+	}
+
+	if err := tx.Create(&records).Error; err != nil {
+		slog.Error("Failed to create recovery codes", slog.Any("error", err))
+		return nil, apperror.Internal(err)
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode removes the separator and ignores case, so that recovery codes can be typed in loosely.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToUpper(strings.ReplaceAll(code, "-", ""))
+}
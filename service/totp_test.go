@@ -0,0 +1,238 @@
+package service_test
+
+import (
+	"log/slog"
+	"net/url"
+	"notes-app/apperror"
+	"notes-app/config"
+	"notes-app/database"
+	"notes-app/models"
+	"notes-app/service"
+	"notes-app/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// Test vectors from RFC 6238, appendix B, for SHA-1, truncated to 6 digits. The secret is the ASCII string
+	// "12345678901234567890".
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		code, err := service.TOTPCode(secret, time.Unix(tt.unix, 0))
+		assert.NoError(t, err)
+		assert.Equal(t, tt.code, code, "time %d", tt.unix)
+	}
+
+	// Secrets are accepted in lower case and with spaces, as users sometimes type them in
+	code, err := service.TOTPCode(strings.ToLower("GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ"), time.Unix(59, 0))
+	assert.NoError(t, err)
+	assert.Equal(t, "287082", code)
+
+	_, err = service.TOTPCode("not base32!", time.Now())
+	assert.Error(t, err)
+}
+
+type TOTPServiceTestSuite struct {
+	suite.Suite
+	dbService   database.Service
+	totpService service.TOTPService
+	user        models.User
+}
+
+func (suite *TOTPServiceTestSuite) SetupSuite() {
+	// Setup logger at debug level for easy visibility during tests
+	utils.SetDefaultLogger(slog.LevelDebug)
+
+	cfg := config.Get()
+
+	// Connect to the database
+	suite.dbService = database.Service{}
+	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
+
+	// Create the TOTP service instance to use for testing
+	suite.totpService = service.TOTPService{Service: service.Service{DBService: suite.dbService}}
+
+	slog.Debug("Setup suite")
+}
+
+func (suite *TOTPServiceTestSuite) SetupTest() {
+	// Clear all tables before each test
+	suite.dbService.ClearAllTables()
+
+	// Create a user to enroll
+	suite.user = models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "password"}
+	errCreate := suite.dbService.GetDB().Create(&suite.user).Error
+	suite.NoError(errCreate)
+
+	slog.Debug("Setup test")
+}
+
+// enroll enrolls the test user and confirms the secret, returning the secret and the recovery codes.
+func (suite *TOTPServiceTestSuite) enroll() (string, []string) {
+	svc := suite.totpService
+
+	secret, _, errEnroll := svc.Enroll(suite.user, nil)
+	suite.Require().NoError(errEnroll)
+
+	code, errCode := service.TOTPCode(secret, time.Now())
+	suite.Require().NoError(errCode)
+	recoveryCodes, errConfirm := svc.Confirm(suite.user.ID, code, nil)
+	suite.Require().NoError(errConfirm)
+
+	return secret, recoveryCodes
+}
+
+func (suite *TOTPServiceTestSuite) TestEnroll() {
+	svc := suite.totpService
+
+	secret, uri, errEnroll := svc.Enroll(suite.user, nil)
+	suite.NoError(errEnroll)
+
+	// Assert that the URI can be read by authenticator apps
+	parsed, errParse := url.Parse(uri)
+	suite.NoError(errParse)
+	suite.Equal("otpauth", parsed.Scheme)
+	suite.Equal("totp", parsed.Host)
+	suite.Equal("/Notes:john.doe@example.com", parsed.Path)
+	suite.Equal(secret, parsed.Query().Get("secret"))
+	suite.Equal("Notes", parsed.Query().Get("issuer"))
+
+	// Two-factor authentication is not enabled until the secret is confirmed
+	enabled, errEnabled := svc.IsEnabled(suite.user.ID, nil)
+	suite.NoError(errEnabled)
+	suite.False(enabled)
+
+	// A wrong code doesn't confirm the secret
+	_, errConfirm := svc.Confirm(suite.user.ID, "000000", nil)
+	suite.ErrorIs(errConfirm, apperror.ErrInvalidOTP)
+
+	// Enrolling again replaces the secret
+	newSecret, _, errEnroll := svc.Enroll(suite.user, nil)
+	suite.NoError(errEnroll)
+	suite.NotEqual(secret, newSecret)
+
+	code, _ := service.TOTPCode(newSecret, time.Now())
+	recoveryCodes, errConfirm := svc.Confirm(suite.user.ID, code, nil)
+	suite.NoError(errConfirm)
+	suite.Len(recoveryCodes, 10)
+
+	enabled, errEnabled = svc.IsEnabled(suite.user.ID, nil)
+	suite.NoError(errEnabled)
+	suite.True(enabled)
+
+	// Assert that only the hashes of the recovery codes are stored
+	var count int64
+	suite.dbService.GetDB().Model(&models.RecoveryCode{}).Where("code_hash = ?", recoveryCodes[0]).Count(&count)
+	suite.Zero(count)
+
+	// Enrolling again after confirming is not allowed, the user has to disable two-factor authentication first
+	_, _, errEnroll = svc.Enroll(suite.user, nil)
+	suite.ErrorIs(errEnroll, apperror.ErrTOTPAlreadyEnabled)
+	_, errConfirm = svc.Confirm(suite.user.ID, code, nil)
+	suite.ErrorIs(errConfirm, apperror.ErrTOTPAlreadyEnabled)
+
+	// Confirming without enrolling is not possible
+	_, errConfirm = svc.Confirm(suite.user.ID+1, "000000", nil)
+	suite.ErrorIs(errConfirm, apperror.ErrTOTPNotEnabled)
+}
+
+func (suite *TOTPServiceTestSuite) TestVerify() {
+	svc := suite.totpService
+	secret, recoveryCodes := suite.enroll()
+
+	// The code used to confirm can't be used again
+	code, _ := service.TOTPCode(secret, time.Now())
+	suite.ErrorIs(svc.Verify(suite.user.ID, code, nil), apperror.ErrInvalidOTP)
+
+	// The code of the next time step works once, to allow for clock drift
+	code, _ = service.TOTPCode(secret, time.Now().Add(30*time.Second))
+	suite.NoError(svc.Verify(suite.user.ID, code, nil))
+	suite.ErrorIs(svc.Verify(suite.user.ID, code, nil), apperror.ErrInvalidOTP)
+
+	// Codes from too far away are rejected
+	code, _ = service.TOTPCode(secret, time.Now().Add(5*time.Minute))
+	suite.ErrorIs(svc.Verify(suite.user.ID, code, nil), apperror.ErrInvalidOTP)
+
+	// Recovery codes work once, and can be typed in loosely
+	suite.NoError(svc.Verify(suite.user.ID, strings.ToLower(strings.ReplaceAll(recoveryCodes[0], "-", "")), nil))
+	suite.ErrorIs(svc.Verify(suite.user.ID, recoveryCodes[0], nil), apperror.ErrInvalidOTP)
+	suite.NoError(svc.Verify(suite.user.ID, recoveryCodes[1], nil))
+
+	// Users without two-factor authentication can't verify codes
+	suite.ErrorIs(svc.Verify(suite.user.ID+1, "000000", nil), apperror.ErrTOTPNotEnabled)
+}
+
+func (suite *TOTPServiceTestSuite) TestDisable() {
+	svc := suite.totpService
+	_, recoveryCodes := suite.enroll()
+
+	// A wrong code doesn't disable two-factor authentication
+	suite.ErrorIs(svc.Disable(suite.user.ID, "000000", nil), apperror.ErrInvalidOTP)
+
+	suite.NoError(svc.Disable(suite.user.ID, recoveryCodes[0], nil))
+
+	enabled, errEnabled := svc.IsEnabled(suite.user.ID, nil)
+	suite.NoError(errEnabled)
+	suite.False(enabled)
+
+	// Assert that the recovery codes are deleted
+	var count int64
+	suite.dbService.GetDB().Model(&models.RecoveryCode{}).Where("user_id = ?", suite.user.ID).Count(&count)
+	suite.Zero(count)
+
+	suite.ErrorIs(svc.Disable(suite.user.ID, recoveryCodes[1], nil), apperror.ErrTOTPNotEnabled)
+}
+
+func (suite *TOTPServiceTestSuite) TestChallenge() {
+	svc := suite.totpService
+	secret, _ := suite.enroll()
+
+	// A challenge can be exchanged along with a code for the user's ID
+	challenge, errChallenge := svc.CreateChallenge(suite.user, nil)
+	suite.NoError(errChallenge)
+
+	// The email can be looked up without using up the challenge
+	email, errEmail := svc.ChallengeEmail(challenge, nil)
+	suite.NoError(errEmail)
+	suite.Equal(suite.user.Email, email)
+
+	code, _ := service.TOTPCode(secret, time.Now().Add(30*time.Second))
+	userID, errVerify := svc.VerifyChallenge(challenge, code, nil)
+	suite.NoError(errVerify)
+	suite.Equal(suite.user.ID, userID)
+
+	// The challenge can only be used once
+	_, errVerify = svc.VerifyChallenge(challenge, code, nil)
+	suite.ErrorIs(errVerify, apperror.ErrTokenInvalid)
+	_, errEmail = svc.ChallengeEmail(challenge, nil)
+	suite.ErrorIs(errEmail, apperror.ErrTokenInvalid)
+
+	// A wrong code uses up the challenge too
+	challenge, errChallenge = svc.CreateChallenge(suite.user, nil)
+	suite.NoError(errChallenge)
+	_, errVerify = svc.VerifyChallenge(challenge, "000000", nil)
+	suite.ErrorIs(errVerify, apperror.ErrInvalidOTP)
+	code, _ = service.TOTPCode(secret, time.Now().Add(-30*time.Second))
+	_, errVerify = svc.VerifyChallenge(challenge, code, nil)
+	suite.ErrorIs(errVerify, apperror.ErrTokenInvalid)
+}
+
+func TestTOTPService(t *testing.T) {
+	suite.Run(t, new(TOTPServiceTestSuite))
+}
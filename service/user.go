@@ -1,38 +1,190 @@
 package service
 
 import (
+	"errors"
 	"log/slog"
+	"notes-app/apperror"
+	"notes-app/mailer"
 	"notes-app/models"
+	"notes-app/utils"
+	"strings"
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
 )
 
 type IUserService interface {
 	// Create creates a new user record in the database.
-	// The user's password is hashed before saving.
+	// The user's email is normalized and the password is checked against the password policy and hashed before saving.
 	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrUserExists if a user with the same email already exists, or apperror.ErrUsernameUnavailable
+	// if the username is reserved or taken.
 	Create(user *models.User, opts *DBOpts) error
 
-	// GetByEmail retrieves a user by their email from the database.
+	// CreateWithRandomPassword creates a new user record in the database, with a random password that nobody knows, for
+	// users that log in some other way, like with an identity provider.
+	// The user's email is normalized and the password is generated and hashed before saving. The password policy isn't
+	// checked, since it is meant for passwords chosen by people.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrUserExists if a user with the same email already exists, or apperror.ErrUsernameUnavailable
+	// if the username is reserved or taken.
+	CreateWithRandomPassword(user *models.User, opts *DBOpts) error
+
+	// GetByEmail retrieves a user by their email from the database, ignoring case and surrounding whitespace.
 	// Accepts optional DBOpts to specify a DB instance.
-	// Returns the user or an error if the user is not found.
+	// Returns the user or apperror.ErrUserNotFound if the user is not found.
 	GetByEmail(email string, opts *DBOpts) (models.User, error)
 
 	// GetByID retrieves a user by their ID from the database.
 	// Accepts optional DBOpts to specify a DB instance.
-	// Returns the user or an error if the user is not found.
+	// Returns the user or apperror.ErrUserNotFound if the user is not found.
 	GetByID(id uint, opts *DBOpts) (models.User, error)
+
+	// Authenticate retrieves a user by their email and checks that the given password matches.
+	// If the stored hash uses an outdated algorithm or parameters, it is transparently upgraded.
+	//
+	// If a directory is configured, the password is checked against it instead, creating or updating the user from the
+	// directory. Local admins are still checked locally, so that they can log in when the directory is down.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the user, or apperror.ErrInvalidCredentials if the user is not found or the password is incorrect, so that
+	// callers can't tell which emails are registered, or apperror.ErrDirectoryUnavailable.
+	Authenticate(email, password string, opts *DBOpts) (models.User, error)
+
+	// SendVerificationEmail sends the user a link to verify their email, replacing any link sent before.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrEmailAlreadyVerified if the user's email is already verified.
+	SendVerificationEmail(user models.User, opts *DBOpts) error
+
+	// VerifyEmail marks the email of the user that the verification token was sent to as verified.
+	// The token can only be used once, and only while the user still has the email it was sent to.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the verified user, or apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the token can't be used.
+	VerifyEmail(token string, opts *DBOpts) (models.User, error)
+
+	// GenVerifiedEmailMiddleware generates a Fiber middleware that only lets users with a verified email through, for
+	// actions that could be abused with throwaway accounts, like sharing notes.
+	// It must be used after the auth middleware.
+	GenVerifiedEmailMiddleware() fiber.Handler
+
+	// RequestPasswordReset emails a link to reset the password to the user with the given email, replacing any link
+	// sent before. Nothing happens if there is no such user, so that callers can't tell which emails are registered.
+	// Users from a directory don't get links either, since their password is managed by the directory.
+	// Accepts optional DBOpts to specify a DB instance.
+	RequestPasswordReset(email string, opts *DBOpts) error
+
+	// ResetPassword sets a new password for the user that the reset token was sent to, and revokes all their sessions.
+	// The token can only be used once, and the new password must satisfy the password policy.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the token can't be used,
+	// apperror.ErrWeakPassword or apperror.ErrBreachedPassword if the password is not allowed, or
+	// apperror.ErrManagedByDirectory if the user moved to a directory after the token was sent.
+	ResetPassword(token, password string, opts *DBOpts) error
+
+	// RequestMagicLink emails a link to log in without a password to the user with the given email, replacing any link
+	// sent before. Nothing happens if there is no such user, so that callers can't tell which emails are registered.
+	// Accepts optional DBOpts to specify a DB instance.
+	RequestMagicLink(email string, opts *DBOpts) error
+
+	// LoginWithMagicLink retrieves the user that the login link was sent to, using up the token.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the user, or apperror.ErrTokenInvalid or apperror.ErrTokenExpired if the token can't be used.
+	LoginWithMagicLink(token string, opts *DBOpts) (models.User, error)
+
+	// UpdateProfile applies the given changes to the profile of the user with the given ID.
+	// Changing the email marks it as unverified, and sends a link to verify it to the new address.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns the updated user, apperror.ErrUserExists if another user has the new email,
+	// apperror.ErrUsernameUnavailable if the new username is reserved or taken, or apperror.ErrManagedByDirectory if a
+	// user from a directory changes their email.
+	UpdateProfile(userID uint, update ProfileUpdate, opts *DBOpts) (models.User, error)
+
+	// ChangePassword sets a new password for the user with the given ID, after checking their current password, and
+	// revokes all their sessions except the one with the given ID.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrIncorrectPassword, apperror.ErrWeakPassword or apperror.ErrBreachedPassword if the new
+	// password is not allowed, or apperror.ErrManagedByDirectory if the user is from a directory.
+	ChangePassword(userID uint, sessionID, currentPassword, newPassword string, opts *DBOpts) error
+
+	// Delete deletes the account of the user with the given ID, after checking their current password, revoking all
+	// their sessions and access tokens. Their notes are handled as configured with config.Config.AccountDeletionNotes.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns apperror.ErrIncorrectPassword if the password is incorrect, or apperror.ErrLastOwner if they are the last
+	// owner of an organization with other members.
+	Delete(userID uint, password string, opts *DBOpts) error
+
+	// Search finds users whose username, name or email starts with the given query, to pick who to share a note with.
+	// Only users that allow being discovered, or that are in an organization with the user searching, are found, and
+	// never the user searching.
+	// Accepts optional DBOpts to specify a DB instance.
+	// Returns at most limit users, sorted by username and name.
+	Search(userID uint, query string, limit int, opts *DBOpts) ([]models.User, error)
 }
 
 type UserService struct {
 	Service
 	AuthService IAuthService
+
+	// Mailer is used to send emails to users, which should be created from config at startup with mailer.New.
+	Mailer mailer.Mailer
+
+	// SessionService is used to revoke the sessions of users when their password is reset or changed, or their account
+	// is deleted.
+	SessionService ISessionService
 }
 
 // Create creates a new user record in the database.
-// The user's password is hashed before saving.
+// The user's email is normalized and the password is checked against the password policy and hashed before saving.
 //
 // Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrUserExists if a user with the same email already exists, or apperror.ErrUsernameUnavailable if
+// the username is reserved or taken.
 func (svc UserService) Create(user *models.User, opts *DBOpts) error {
-	db := svc.getDB(opts)
+	return svc.create(svc.getDB(opts), user, true)
+}
+
+// CreateWithRandomPassword creates a new user record in the database, with a random password that nobody knows, for
+// users that log in some other way, like with an identity provider.
+// The user's email is normalized and the password is generated and hashed before saving.
+//
+// The password policy isn't checked, since it is meant for passwords chosen by people. A random password could be
+// rejected for containing a short piece of the user's name or email by chance, which would fail the login for no
+// reason.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns apperror.ErrUserExists if a user with the same email already exists, or apperror.ErrUsernameUnavailable if
+// the username is reserved or taken.
+func (svc UserService) CreateWithRandomPassword(user *models.User, opts *DBOpts) error {
+	password, err := generateToken()
+	if err != nil {
+		slog.Error("Failed to generate password", slog.Any("error", err))
+		return apperror.Internal(err)
+	}
+	user.Password = password
+
+	return svc.create(svc.getDB(opts), user, false)
+}
+
+// create normalizes and saves a new user, hashing their password, and checking it against the password policy first
+// if checkPolicy is set.
+func (svc UserService) create(db *gorm.DB, user *models.User, checkPolicy bool) error {
+	// Normalize the email so that the unique index catches duplicates differing by case
+	user.Email = utils.NormalizeEmail(user.Email)
+
+	if user.Username != nil {
+		username := utils.NormalizeUsername(*user.Username)
+		if err := checkUsername(db, username, 0); err != nil {
+			return err
+		}
+		user.Username = &username
+	}
+
+	// Check the password against the password policy before hashing it
+	if checkPolicy {
+		if err := svc.AuthService.ValidatePassword(user.Password, user.Name, user.Email); err != nil {
+			return err
+		}
+	}
 
 	var err error
 	user.Password, err = svc.AuthService.HashPassword(user.Password)
@@ -43,15 +195,20 @@ func (svc UserService) Create(user *models.User, opts *DBOpts) error {
 	result := db.Create(user)
 	if result.Error != nil {
 		slog.Error("Failed to create user", slog.Any("error", result.Error))
+
+		if errors.Is(result.Error, gorm.ErrDuplicatedKey) {
+			return apperror.ErrUserExists.WithCause(result.Error)
+		}
+		return apperror.Internal(result.Error)
 	}
 
-	return result.Error
+	return nil
 }
 
 // GetByID retrieves a user by their ID from the database.
 // Accepts optional DBOpts to specify a DB instance.
 //
-// Returns the user or an error if the user is not found.
+// Returns the user or apperror.ErrUserNotFound if the user is not found.
 func (svc UserService) GetByID(id uint, opts *DBOpts) (models.User, error) {
 	db := svc.getDB(opts)
 
@@ -59,23 +216,179 @@ func (svc UserService) GetByID(id uint, opts *DBOpts) (models.User, error) {
 	result := db.Where("id = ?", id).First(&user)
 	if result.Error != nil {
 		slog.Error("Failed to fetch user", slog.Any("error", result.Error))
+		return user, userLookupError(result.Error)
 	}
 
-	return user, result.Error
+	return user, nil
 }
 
-// GetByEmail retrieves a user by their email from the database.
+// GetByEmail retrieves a user by their email from the database, ignoring case and surrounding whitespace.
 // Accepts optional DBOpts to specify a DB instance.
 //
-// Returns the user or an error if the user is not found.
+// Returns the user or apperror.ErrUserNotFound if the user is not found.
 func (svc UserService) GetByEmail(email string, opts *DBOpts) (models.User, error) {
 	db := svc.getDB(opts)
 
 	var user models.User
-	result := db.Where("email = ?", email).First(&user)
+	result := db.Where("email = ?", utils.NormalizeEmail(email)).First(&user)
 	if result.Error != nil {
 		slog.Error("Failed to fetch user", slog.Any("error", result.Error))
+		return user, userLookupError(result.Error)
+	}
+
+	return user, nil
+}
+
+// userLookupError converts an error from fetching a user into an application error.
+func userLookupError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return apperror.ErrUserNotFound.WithCause(err)
+	}
+	return apperror.Internal(err)
+}
+
+// Authenticate retrieves a user by their email and checks that the given password matches.
+// If the stored hash uses an outdated algorithm or parameters, it is transparently upgraded.
+//
+// If a directory is configured, the password is checked against it instead, creating or updating the user from the
+// directory. Local admins are still checked locally, so that they can log in when the directory is down.
+// Accepts optional DBOpts to specify a DB instance.
+//
+// Returns the user, or apperror.ErrInvalidCredentials if the user is not found or the password is incorrect, so that
+// callers can't tell which emails are registered, or apperror.ErrDirectoryUnavailable.
+func (svc UserService) Authenticate(email, password string, opts *DBOpts) (models.User, error) {
+	user, err := svc.GetByEmail(email, opts)
+	if err != nil && !errors.Is(err, apperror.ErrUserNotFound) {
+		return models.User{}, err
+	}
+
+	if svc.AuthService.DirectoryEnabled() {
+		// Local admins are the way in when the directory is down or misconfigured
+		isBreakGlass := err == nil && user.AuthSource == models.AuthSourceLocal && user.Role == models.RoleAdmin
+		if !isBreakGlass {
+			return svc.authenticateDirectory(email, password, opts)
+		}
+	} else if err != nil {
+		// Hash the password anyway, so that unknown emails take as long to reject as incorrect passwords
+		_ = svc.AuthService.ComparePasswords(svc.dummyPasswordHash(), password)
+		return models.User{}, apperror.ErrInvalidCredentials.WithCause(err)
+	}
+
+	// Compare the hashed password with the plaintext password
+	if err := svc.AuthService.ComparePasswords(user.Password, password); err != nil {
+		return models.User{}, err
+	}
+
+	// Upgrade the hash while the plaintext password is available
+	if svc.AuthService.NeedsRehash(user.Password) {
+		svc.rehashPassword(&user, password, opts)
+	}
+
+	return user, nil
+}
+
+// authenticateDirectory checks the password against the directory, then creates the user if they are logging in for the
+// first time, or updates them to match the directory otherwise.
+//
+// Users that registered locally with the same email are taken over by the directory, since it is the authority on who
+// owns the email.
+func (svc UserService) authenticateDirectory(email, password string, opts *DBOpts) (models.User, error) {
+	db := svc.getDB(opts)
+
+	directoryUser, err := svc.AuthService.AuthenticateDirectory(email, password)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	var user models.User
+	err = db.Transaction(func(tx *gorm.DB) error {
+		txOpts := &DBOpts{db: tx}
+
+		var err error
+		user, err = svc.GetByEmail(directoryUser.Email, txOpts)
+		if errors.Is(err, apperror.ErrUserNotFound) {
+			return svc.createDirectoryUser(&user, directoryUser, txOpts)
+		} else if err != nil {
+			return err
+		}
+
+		updates := map[string]any{
+			"role":           directoryUser.Role,
+			"auth_source":    models.AuthSourceLDAP,
+			"email_verified": true,
+		}
+		if directoryUser.Name != "" {
+			updates["name"] = directoryUser.Name
+		}
+
+		result := tx.Model(&user).Updates(updates)
+		if result.Error != nil {
+			slog.Error("Failed to update directory user", slog.Any("error", result.Error))
+			return apperror.Internal(result.Error)
+		}
+
+		return nil
+	})
+
+	return user, err
+}
+
+// createDirectoryUser creates a user that logged in with the directory for the first time.
+//
+// The user gets a random password that nobody knows, since their password is always checked against the directory.
+func (svc UserService) createDirectoryUser(user *models.User, directoryUser DirectoryUser, opts *DBOpts) error {
+	name := directoryUser.Name
+	if name == "" {
+		name, _, _ = strings.Cut(directoryUser.Email, "@")
+	}
+
+	*user = models.User{
+		Name:          name,
+		Email:         directoryUser.Email,
+		EmailVerified: true,
+		Role:          directoryUser.Role,
+		AuthSource:    models.AuthSourceLDAP,
+	}
+	return svc.CreateWithRandomPassword(user, opts)
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash returns the hash of a random password, hashed with the configured algorithm and parameters, to
+// compare passwords against when there is no user to compare them with.
+func (svc UserService) dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		password, err := generateToken()
+		if err == nil {
+			dummyHash, err = svc.AuthService.HashPassword(password)
+		}
+		if err != nil {
+			slog.Error("Failed to generate dummy password hash", slog.Any("error", err))
+		}
+	})
+
+	return dummyHash
+}
+
+// rehashPassword hashes the password with the configured algorithm and parameters, and saves it for the user.
+//
+// Failures are only logged, since the old hash still works and the upgrade will be retried on the next login.
+func (svc UserService) rehashPassword(user *models.User, password string, opts *DBOpts) {
+	db := svc.getDB(opts)
+
+	hashedPassword, err := svc.AuthService.HashPassword(password)
+	if err != nil {
+		return
+	}
+
+	result := db.Model(user).Update("password", hashedPassword)
+	if result.Error != nil {
+		slog.Error("Failed to rehash password", slog.Any("error", result.Error), slog.Any("userID", user.ID))
+		return
 	}
 
-	return user, result.Error
+	slog.Debug("Rehashed password", slog.Any("userID", user.ID))
 }
@@ -1,21 +1,55 @@
 package service_test
 
 import (
+	"fmt"
+	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/crypto/bcrypt"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"notes-app/apperror"
 	"notes-app/config"
 	"notes-app/database"
+	"notes-app/mailer"
 	"notes-app/models"
 	"notes-app/service"
 	"notes-app/utils"
+	"regexp"
 	"testing"
 )
 
+// recordingMailer is a mailer that keeps the messages it is asked to send.
+type recordingMailer struct {
+	messages []mailer.Message
+}
+
+func (m *recordingMailer) Send(msg mailer.Message) error {
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// lastToken extracts the token from the link in the last message.
+func (m *recordingMailer) lastToken() string {
+	if len(m.messages) == 0 {
+		return ""
+	}
+
+	match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(m.messages[len(m.messages)-1].Body)
+	if match == nil {
+		return ""
+	}
+
+	token, _ := url.QueryUnescape(match[1])
+	return token
+}
+
 type UserServiceTestSuite struct {
 	suite.Suite
 	dbService   database.Service
 	userService service.UserService
+	mailer      *recordingMailer
 }
 
 func (suite *UserServiceTestSuite) SetupSuite() {
@@ -29,7 +63,16 @@ func (suite *UserServiceTestSuite) SetupSuite() {
 	suite.dbService.Connect(cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.TestDBName, cfg.DBSSLMode)
 
 	// Create the user service instance to use for testing
-	suite.userService = service.UserService{Service: service.Service{DBService: suite.dbService}}
+	suite.mailer = &recordingMailer{}
+	suite.userService = service.UserService{
+		Service:     service.Service{DBService: suite.dbService},
+		AuthService: service.AuthService{},
+		Mailer:      suite.mailer,
+		SessionService: service.SessionService{
+			Service:     service.Service{DBService: suite.dbService},
+			AuthService: service.AuthService{},
+		},
+	}
 
 	slog.Debug("Setup suite")
 }
@@ -37,6 +80,7 @@ func (suite *UserServiceTestSuite) SetupSuite() {
 func (suite *UserServiceTestSuite) SetupTest() {
 	// Clear all tables before each test
 	suite.dbService.ClearAllTables()
+	suite.mailer.messages = nil
 	slog.Debug("Setup test")
 }
 
@@ -45,7 +89,7 @@ func (suite *UserServiceTestSuite) SetupTest() {
 func (suite *UserServiceTestSuite) TestCreate() {
 	svc := suite.userService
 
-	password := "password"
+	password := "correct horse battery stapler"
 	user := models.User{
 		Name:     "John Doe",
 		Email:    "john.doe@example.com",
@@ -71,7 +115,7 @@ func (suite *UserServiceTestSuite) TestCreate() {
 	suite.Equal(user.Password, userFromDB.Password)
 
 	// Compare the hashed password
-	errCompare := bcrypt.CompareHashAndPassword([]byte(userFromDB.Password), []byte(password))
+	errCompare := service.AuthService{}.ComparePasswords(userFromDB.Password, password)
 	suite.NoError(errCompare)
 }
 
@@ -125,6 +169,465 @@ func (suite *UserServiceTestSuite) TestGetByEmail() {
 	suite.Equal(user.Password, userFromDB.Password)
 }
 
+func (suite *UserServiceTestSuite) TestAuthenticate() {
+	password := "correct horse battery stapler"
+
+	// Create the user with an outdated bcrypt hash
+	bcryptHash, errHash := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	suite.NoError(errHash)
+	user := models.User{
+		Name:     "John Doe",
+		Email:    "john.doe@example.com",
+		Password: string(bcryptHash),
+	}
+	errCreate := suite.dbService.GetDB().Create(&user).Error
+	suite.NoError(errCreate)
+
+	// Authenticate with an incorrect password
+	svc := suite.userService
+	_, errAuth := svc.Authenticate("john.doe@example.com", "wrongpassword", nil)
+	suite.ErrorIs(errAuth, apperror.ErrInvalidCredentials)
+
+	// Authenticate with an unknown email, which can't be told apart from an incorrect password
+	_, errAuth = svc.Authenticate("jane.doe@example.com", password, nil)
+	suite.ErrorIs(errAuth, apperror.ErrInvalidCredentials)
+
+	// Authenticate with the correct password
+	authenticatedUser, errAuth := svc.Authenticate("John.Doe@example.com", password, nil)
+	suite.NoError(errAuth)
+	suite.Equal(user.ID, authenticatedUser.ID)
+
+	// Assert that the hash was upgraded to argon2id, and still matches the password
+	var userFromDB models.User
+	errSearch := suite.dbService.GetDB().Where("id = ?", user.ID).First(&userFromDB).Error
+	suite.NoError(errSearch)
+	suite.NotEqual(string(bcryptHash), userFromDB.Password)
+	suite.False(service.AuthService{}.NeedsRehash(userFromDB.Password))
+	suite.NoError(service.AuthService{}.ComparePasswords(userFromDB.Password, password))
+}
+
+func (suite *UserServiceTestSuite) TestVerifyEmail() {
+	svc := suite.userService
+
+	user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "correct horse battery stapler"}
+	suite.NoError(svc.Create(&user, nil))
+	suite.False(user.EmailVerified)
+
+	// Send the verification email twice, only the latest link should work
+	suite.NoError(svc.SendVerificationEmail(user, nil))
+	oldToken := suite.mailer.lastToken()
+	suite.NoError(svc.SendVerificationEmail(user, nil))
+	token := suite.mailer.lastToken()
+	suite.NotEmpty(token)
+	suite.NotEqual(oldToken, token)
+	suite.Equal("john.doe@example.com", suite.mailer.messages[1].To)
+
+	_, errVerify := svc.VerifyEmail(oldToken, nil)
+	suite.ErrorIs(errVerify, apperror.ErrTokenInvalid)
+
+	// Verify the email
+	verified, errVerify := svc.VerifyEmail(token, nil)
+	suite.NoError(errVerify)
+	suite.Equal(user.ID, verified.ID)
+
+	fetched, errGet := svc.GetByID(user.ID, nil)
+	suite.NoError(errGet)
+	suite.True(fetched.EmailVerified)
+
+	// The token can only be used once, and verified users can't ask for another one
+	_, errVerify = svc.VerifyEmail(token, nil)
+	suite.ErrorIs(errVerify, apperror.ErrTokenInvalid)
+	suite.ErrorIs(svc.SendVerificationEmail(fetched, nil), apperror.ErrEmailAlreadyVerified)
+}
+
+func (suite *UserServiceTestSuite) TestGenVerifiedEmailMiddleware() {
+	svc := suite.userService
+
+	user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "correct horse battery stapler"}
+	suite.NoError(svc.Create(&user, nil))
+
+	app := fiber.New(fiber.Config{ErrorHandler: func(c *fiber.Ctx, err error) error {
+		return c.Status(apperror.From(err).Status()).SendString(string(apperror.From(err).Code))
+	}})
+	app.Post("/share", func(c *fiber.Ctx) error {
+		c.Locals("userID", fmt.Sprintf("%d", user.ID))
+		return c.Next()
+	}, svc.GenVerifiedEmailMiddleware(), func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	// Unverified users are not let through
+	response, err := app.Test(httptest.NewRequest(http.MethodPost, "/share", nil))
+	suite.NoError(err)
+	suite.Equal(http.StatusForbidden, response.StatusCode)
+
+	// Verified users are
+	suite.NoError(svc.SendVerificationEmail(user, nil))
+	_, errVerify := svc.VerifyEmail(suite.mailer.lastToken(), nil)
+	suite.NoError(errVerify)
+
+	response, err = app.Test(httptest.NewRequest(http.MethodPost, "/share", nil))
+	suite.NoError(err)
+	suite.Equal(http.StatusOK, response.StatusCode)
+}
+
+func (suite *UserServiceTestSuite) TestResetPassword() {
+	svc := suite.userService
+
+	user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "correct horse battery stapler"}
+	suite.NoError(svc.Create(&user, nil))
+
+	tokens, errSession := svc.SessionService.Create(user.ID, service.ClientInfo{}, nil)
+	suite.NoError(errSession)
+
+	// Nothing is sent for unknown emails, but it isn't an error either
+	suite.NoError(svc.RequestPasswordReset("jane.doe@example.com", nil))
+	suite.Empty(suite.mailer.messages)
+
+	// Request a reset for the user, with the email in a different case
+	suite.NoError(svc.RequestPasswordReset(" John.Doe@example.com", nil))
+	suite.Len(suite.mailer.messages, 1)
+	token := suite.mailer.lastToken()
+	suite.NotEmpty(token)
+
+	// A weak password is rejected, without using up the token
+	suite.ErrorIs(svc.ResetPassword(token, "password", nil), apperror.ErrWeakPassword)
+
+	// Reset the password
+	newPassword := "purple monkey dishwasher"
+	suite.NoError(svc.ResetPassword(token, newPassword, nil))
+
+	// Assert that only the new password works, and that the email is now verified
+	_, errAuthenticate := svc.Authenticate(user.Email, "correct horse battery stapler", nil)
+	suite.ErrorIs(errAuthenticate, apperror.ErrInvalidCredentials)
+	authenticated, errAuthenticate := svc.Authenticate(user.Email, newPassword, nil)
+	suite.NoError(errAuthenticate)
+	suite.True(authenticated.EmailVerified)
+
+	// Assert that existing sessions were revoked
+	_, errSession = svc.SessionService.GetActive(tokens.SessionID, nil)
+	suite.ErrorIs(errSession, apperror.ErrSessionRevoked)
+
+	// The token can only be used once
+	suite.ErrorIs(svc.ResetPassword(token, "another secure password", nil), apperror.ErrTokenInvalid)
+
+	// Tokens for other purposes can't be used
+	suite.NoError(svc.SendVerificationEmail(models.User{Model: user.Model, Name: user.Name, Email: user.Email}, nil))
+	suite.ErrorIs(svc.ResetPassword(suite.mailer.lastToken(), "another secure password", nil), apperror.ErrTokenInvalid)
+
+	// Users from a directory can't use links sent before the directory took over, and don't get new ones
+	suite.NoError(svc.RequestPasswordReset(user.Email, nil))
+	token = suite.mailer.lastToken()
+	suite.NoError(suite.dbService.GetDB().Model(&user).Update("auth_source", models.AuthSourceLDAP).Error)
+	suite.ErrorIs(svc.ResetPassword(token, "another secure password", nil), apperror.ErrManagedByDirectory)
+	messageCount := len(suite.mailer.messages)
+	suite.NoError(svc.RequestPasswordReset(user.Email, nil))
+	suite.Len(suite.mailer.messages, messageCount)
+}
+
+func (suite *UserServiceTestSuite) TestMagicLink() {
+	svc := suite.userService
+
+	user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "correct horse battery stapler"}
+	suite.NoError(svc.Create(&user, nil))
+
+	// Nothing is sent for unknown emails, but it isn't an error either
+	suite.NoError(svc.RequestMagicLink("jane.doe@example.com", nil))
+	suite.Empty(suite.mailer.messages)
+
+	// Request a link for the user, with the email in a different case
+	suite.NoError(svc.RequestMagicLink(" John.Doe@example.com", nil))
+	suite.Len(suite.mailer.messages, 1)
+	token := suite.mailer.lastToken()
+	suite.NotEmpty(token)
+
+	// Log in with the link, which also verifies the email
+	loggedIn, errLogin := svc.LoginWithMagicLink(token, nil)
+	suite.NoError(errLogin)
+	suite.Equal(user.ID, loggedIn.ID)
+	suite.True(loggedIn.EmailVerified)
+
+	// The token can only be used once
+	_, errLogin = svc.LoginWithMagicLink(token, nil)
+	suite.ErrorIs(errLogin, apperror.ErrTokenInvalid)
+
+	// Requesting a new link invalidates the previous one
+	suite.NoError(svc.RequestMagicLink(user.Email, nil))
+	oldToken := suite.mailer.lastToken()
+	suite.NoError(svc.RequestMagicLink(user.Email, nil))
+	_, errLogin = svc.LoginWithMagicLink(oldToken, nil)
+	suite.ErrorIs(errLogin, apperror.ErrTokenInvalid)
+
+	// Tokens for other purposes can't be used
+	suite.NoError(svc.RequestPasswordReset(user.Email, nil))
+	_, errLogin = svc.LoginWithMagicLink(suite.mailer.lastToken(), nil)
+	suite.ErrorIs(errLogin, apperror.ErrTokenInvalid)
+
+	// Users from a directory don't get links
+	directoryUser := models.User{
+		Name:       "Jane Doe",
+		Email:      "jane.doe@example.com",
+		Password:   "correct horse battery stapler",
+		AuthSource: models.AuthSourceLDAP,
+	}
+	suite.NoError(svc.Create(&directoryUser, nil))
+	messageCount := len(suite.mailer.messages)
+	suite.NoError(svc.RequestMagicLink(directoryUser.Email, nil))
+	suite.Len(suite.mailer.messages, messageCount)
+}
+
+func (suite *UserServiceTestSuite) TestUpdateProfile() {
+	svc := suite.userService
+
+	user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "correct horse battery stapler"}
+	suite.NoError(svc.Create(&user, nil))
+	other := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "correct horse battery stapler"}
+	suite.NoError(svc.Create(&other, nil))
+	suite.NoError(suite.dbService.GetDB().Model(&user).Update("email_verified", true).Error)
+
+	// Change the name and avatar, leaving the email alone
+	name, avatarURL := "Johnny", "https://example.com/avatar.png"
+	updated, errUpdate := svc.UpdateProfile(user.ID, service.ProfileUpdate{Name: &name, AvatarURL: &avatarURL}, nil)
+	suite.NoError(errUpdate)
+	suite.Equal(name, updated.Name)
+	suite.Equal(avatarURL, updated.AvatarURL)
+	suite.True(updated.EmailVerified)
+	suite.Empty(suite.mailer.messages)
+
+	// Another user's email can't be taken
+	takenEmail := "Jane.Doe@example.com"
+	_, errUpdate = svc.UpdateProfile(user.ID, service.ProfileUpdate{Email: &takenEmail}, nil)
+	suite.ErrorIs(errUpdate, apperror.ErrUserExists)
+
+	// A new email has to be verified again
+	newEmail := "johnny@example.com"
+	updated, errUpdate = svc.UpdateProfile(user.ID, service.ProfileUpdate{Email: &newEmail}, nil)
+	suite.NoError(errUpdate)
+	suite.Equal(newEmail, updated.Email)
+	suite.False(updated.EmailVerified)
+	suite.Len(suite.mailer.messages, 1)
+	suite.Equal(newEmail, suite.mailer.messages[0].To)
+
+	verified, errVerify := svc.VerifyEmail(suite.mailer.lastToken(), nil)
+	suite.NoError(errVerify)
+	suite.True(verified.EmailVerified)
+}
+
+func (suite *UserServiceTestSuite) TestChangePassword() {
+	svc := suite.userService
+	password := "correct horse battery stapler"
+
+	user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: password}
+	suite.NoError(svc.Create(&user, nil))
+
+	current, errSession := svc.SessionService.Create(user.ID, service.ClientInfo{}, nil)
+	suite.NoError(errSession)
+	other, errSession := svc.SessionService.Create(user.ID, service.ClientInfo{}, nil)
+	suite.NoError(errSession)
+
+	// The current password has to be correct, and the new one has to satisfy the password policy
+	newPassword := "purple monkey dishwasher"
+	suite.ErrorIs(svc.ChangePassword(user.ID, current.SessionID, "wrongpassword", newPassword, nil),
+		apperror.ErrIncorrectPassword)
+	suite.ErrorIs(svc.ChangePassword(user.ID, current.SessionID, password, "password", nil), apperror.ErrWeakPassword)
+
+	suite.NoError(svc.ChangePassword(user.ID, current.SessionID, password, newPassword, nil))
+
+	// Assert that only the new password works
+	_, errAuthenticate := svc.Authenticate(user.Email, password, nil)
+	suite.ErrorIs(errAuthenticate, apperror.ErrInvalidCredentials)
+	_, errAuthenticate = svc.Authenticate(user.Email, newPassword, nil)
+	suite.NoError(errAuthenticate)
+
+	// Assert that only the current session is still active
+	_, errSession = svc.SessionService.GetActive(current.SessionID, nil)
+	suite.NoError(errSession)
+	_, errSession = svc.SessionService.GetActive(other.SessionID, nil)
+	suite.ErrorIs(errSession, apperror.ErrSessionRevoked)
+}
+
+func (suite *UserServiceTestSuite) TestDelete() {
+	svc := suite.userService
+	password := "correct horse battery stapler"
+
+	user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: password}
+	suite.NoError(svc.Create(&user, nil))
+
+	tokens, errSession := svc.SessionService.Create(user.ID, service.ClientInfo{}, nil)
+	suite.NoError(errSession)
+
+	// The password has to be correct
+	suite.ErrorIs(svc.Delete(user.ID, "wrongpassword", nil), apperror.ErrIncorrectPassword)
+	_, errGet := svc.GetByID(user.ID, nil)
+	suite.NoError(errGet)
+
+	suite.NoError(svc.Delete(user.ID, password, nil))
+
+	// Assert that the user is gone, and logged out
+	_, errGet = svc.GetByID(user.ID, nil)
+	suite.ErrorIs(errGet, apperror.ErrUserNotFound)
+	_, errAuthenticate := svc.Authenticate(user.Email, password, nil)
+	suite.ErrorIs(errAuthenticate, apperror.ErrInvalidCredentials)
+	_, errSession = svc.SessionService.GetActive(tokens.SessionID, nil)
+	suite.ErrorIs(errSession, apperror.ErrSessionRevoked)
+}
+
+func (suite *UserServiceTestSuite) TestDeleteNotes() {
+	svc := suite.userService
+	db := suite.dbService.GetDB()
+	password := "correct horse battery stapler"
+
+	cfg := config.Get()
+	defaultPolicy := cfg.AccountDeletionNotes
+	defer func() { cfg.AccountDeletionNotes = defaultPolicy }()
+
+	testCases := map[string]struct {
+		policy string
+		// notes are the titles of the notes left after the deletion
+		notes []string
+		// orgNoteOwned is whether the note of the organization still has its owner
+		orgNoteOwned bool
+		shares       int64
+	}{
+		"delete":   {policy: service.DeletedNotesDelete, notes: []string{"Shopping list"}, shares: 0},
+		"transfer": {policy: service.DeletedNotesTransfer, notes: []string{"Diary", "Roadmap", "Shopping list"}, shares: 1},
+		"keep":     {policy: service.DeletedNotesKeep, notes: []string{"Diary", "Roadmap", "Shopping list"}, orgNoteOwned: true, shares: 2},
+	}
+
+	for name, tc := range testCases {
+		suite.Run(name, func() {
+			suite.SetupTest()
+			cfg.AccountDeletionNotes = tc.policy
+
+			user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: password}
+			suite.Require().NoError(svc.Create(&user, nil))
+			other := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: password}
+			suite.Require().NoError(svc.Create(&other, nil))
+
+			// Both users own an organization, so that it outlives the user
+			organization := models.Organization{Name: "Acme"}
+			suite.Require().NoError(db.Create(&organization).Error)
+			suite.Require().NoError(db.Create(&[]models.OrganizationMember{
+				{OrganizationID: organization.ID, UserID: user.ID, Role: models.OrgRoleOwner},
+				{OrganizationID: organization.ID, UserID: other.ID, Role: models.OrgRoleOwner},
+			}).Error)
+
+			// The user shares a note of the organization, and a note of the other user is shared with them
+			notes := []models.Note{
+				{OwnerID: &user.ID, Title: "Diary"},
+				{OwnerID: &user.ID, OrganizationID: &organization.ID, Title: "Roadmap"},
+				{OwnerID: &other.ID, Title: "Shopping list"},
+			}
+			suite.Require().NoError(db.Create(&notes).Error)
+			suite.Require().NoError(db.Create(&[]models.NoteShare{
+				{NoteID: notes[1].ID, UserID: other.ID, Access: service.AccessRead},
+				{NoteID: notes[2].ID, UserID: user.ID, Access: service.AccessRead},
+			}).Error)
+
+			suite.NoError(svc.Delete(user.ID, password, nil))
+
+			var left []models.Note
+			suite.NoError(db.Order("id").Find(&left).Error)
+			titles := make([]string, 0, len(left))
+			for _, note := range left {
+				titles = append(titles, note.Title)
+				if note.OrganizationID != nil {
+					suite.Equal(tc.orgNoteOwned, note.OwnerID != nil)
+				}
+			}
+			suite.Equal(tc.notes, titles)
+
+			var shares int64
+			suite.NoError(db.Model(&models.NoteShare{}).Count(&shares).Error)
+			suite.Equal(tc.shares, shares)
+		})
+	}
+}
+
+func (suite *UserServiceTestSuite) TestUsername() {
+	svc := suite.userService
+
+	username := " @John_Doe "
+	user := models.User{Name: "John Doe", Email: "john.doe@example.com", Password: "correct horse battery stapler",
+		Username: &username}
+	suite.NoError(svc.Create(&user, nil))
+	suite.Equal("john_doe", *user.Username)
+
+	// Usernames are unique regardless of case, and reserved ones can't be taken
+	for _, taken := range []string{"JOHN_DOE", "admin"} {
+		other := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "correct horse battery stapler",
+			Username: &taken}
+		suite.ErrorIs(svc.Create(&other, nil), apperror.ErrUsernameUnavailable)
+	}
+
+	other := models.User{Name: "Jane Doe", Email: "jane.doe@example.com", Password: "correct horse battery stapler"}
+	suite.NoError(svc.Create(&other, nil))
+	suite.Nil(other.Username)
+
+	taken, malformed, free := "john_doe", "jd", "jane"
+	_, errUpdate := svc.UpdateProfile(other.ID, service.ProfileUpdate{Username: &taken}, nil)
+	suite.ErrorIs(errUpdate, apperror.ErrUsernameUnavailable)
+	_, errUpdate = svc.UpdateProfile(other.ID, service.ProfileUpdate{Username: &malformed}, nil)
+	suite.ErrorIs(errUpdate, apperror.ErrValidationFailed)
+	updated, errUpdate := svc.UpdateProfile(other.ID, service.ProfileUpdate{Username: &free}, nil)
+	suite.NoError(errUpdate)
+	suite.Equal(free, *updated.Username)
+
+	// Keeping the same username isn't a conflict with oneself, and an empty one removes it
+	_, errUpdate = svc.UpdateProfile(other.ID, service.ProfileUpdate{Username: &free}, nil)
+	suite.NoError(errUpdate)
+	empty := ""
+	updated, errUpdate = svc.UpdateProfile(other.ID, service.ProfileUpdate{Username: &empty}, nil)
+	suite.NoError(errUpdate)
+	suite.Nil(updated.Username)
+
+	// A deleted user keeps their username until they are erased
+	suite.NoError(svc.Delete(user.ID, "correct horse battery stapler", nil))
+	_, errUpdate = svc.UpdateProfile(other.ID, service.ProfileUpdate{Username: &taken}, nil)
+	suite.ErrorIs(errUpdate, apperror.ErrUsernameUnavailable)
+}
+
+func (suite *UserServiceTestSuite) TestSearch() {
+	svc := suite.userService
+	discoverable := true
+
+	newUser := func(name, email, username string, discoverable bool) models.User {
+		user := models.User{Name: name, Email: email, Password: "correct horse battery stapler", Username: &username}
+		suite.NoError(svc.Create(&user, nil))
+		suite.NoError(suite.dbService.GetDB().Model(&user).Update("discoverable", discoverable).Error)
+		return user
+	}
+	searcher := newUser("John Doe", "john.doe@example.com", "john_doe", discoverable)
+	jane := newUser("Jane Doe", "jane.doe@example.com", "jane", discoverable)
+	newUser("Janet Hidden", "janet@example.com", "janet", !discoverable)
+	jay := newUser("Jay Percy", "jay@example.com", "p_u", discoverable)
+
+	names := func(query string, limit int) []string {
+		users, err := svc.Search(searcher.ID, query, limit, nil)
+		suite.NoError(err)
+
+		names := make([]string, 0, len(users))
+		for _, user := range users {
+			names = append(names, user.Name)
+		}
+		return names
+	}
+
+	// Users are found by the start of their username, any word of their name, or their email, but only if they are
+	// discoverable, and never the user searching
+	suite.Equal([]string{jane.Name, jay.Name}, names("@JA", 0))
+	suite.Equal([]string{jay.Name}, names("p_", 0))
+	suite.Equal([]string{jane.Name}, names("doe", 0))
+	suite.Equal([]string{jane.Name}, names("jane.doe@", 0))
+	suite.Empty(names("hidden", 0))
+
+	// Wildcards in the query match only themselves
+	suite.Empty(names("ja_", 0))
+	suite.Empty(names("%", 0))
+
+	suite.Equal([]string{jane.Name}, names("ja", 1))
+}
+
 func TestUserService(t *testing.T) {
 	suite.Run(t, new(UserServiceTestSuite))
 }
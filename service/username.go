@@ -0,0 +1,104 @@
+package service
+
+import (
+	"log/slog"
+	"notes-app/apperror"
+	"notes-app/models"
+	"notes-app/utils"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// reservedUsernames can't be taken by users, since they could be mistaken for the app itself or clash with routes.
+var reservedUsernames = []string{
+	"about", "abuse", "account", "admin", "administrator", "api", "app", "auth", "billing", "contact", "help",
+	"info", "login", "logout", "me", "moderator", "new", "noreply", "no_reply", "notes", "null", "official",
+	"postmaster", "privacy", "register", "root", "search", "security", "settings", "signin", "signup", "staff",
+	"support", "system", "team", "terms", "undefined", "user", "users", "webmaster",
+}
+
+// Search limits.
+const (
+	DefaultSearchLimit = 10
+	MaxSearchLimit     = 25
+)
+
+// checkUsername checks that a normalized username has the right format, isn't reserved and isn't taken by another user
+// than the one with the given ID, which is 0 for new users.
+//
+// Returns apperror.ErrValidationFailed or apperror.ErrUsernameUnavailable.
+func checkUsername(db *gorm.DB, username string, userID uint) error {
+	if !utils.ValidUsername(username) {
+		return apperror.ErrValidationFailed.WithDetails(apperror.FieldError{
+			Field:   "username",
+			Message: utils.UsernameFormatMessage,
+		})
+	}
+
+	if slices.Contains(reservedUsernames, username) {
+		return apperror.ErrUsernameUnavailable
+	}
+
+	// Deleted users keep their username until they are erased, so that nobody can pose as them in the meantime
+	var count int64
+	result := db.Unscoped().Model(&models.User{}).Where("username = ? AND id <> ?", username, userID).Count(&count)
+	if result.Error != nil {
+		slog.Error("Failed to check username", slog.Any("error", result.Error))
+		return apperror.Internal(result.Error)
+	}
+	if count > 0 {
+		return apperror.ErrUsernameUnavailable
+	}
+
+	return nil
+}
+
+// Search finds users whose username, name or email starts with the given query, to pick who to share a note with.
+//
+// Only users that allow being discovered, or that are in an organization with the user searching, are found, and never
+// the user searching.
+//
+// Accepts optional DBOpts to specify a DB instance.
+// Returns at most limit users, sorted by username and name.
+func (svc UserService) Search(userID uint, query string, limit int, opts *DBOpts) ([]models.User, error) {
+	db := svc.getDB(opts)
+
+	if limit <= 0 || limit > MaxSearchLimit {
+		limit = DefaultSearchLimit
+	}
+
+	// Match the start of any word of the name, so that searching for a last name works too
+	query = utils.NormalizeUsername(query)
+	prefix := escapeLike(query) + "%"
+	wordPrefix := "% " + prefix
+
+	users := make([]models.User, 0)
+	result := findableBy(db, userID).
+		Where("username LIKE ? OR LOWER(name) LIKE ? OR LOWER(name) LIKE ? OR email LIKE ?",
+			prefix, prefix, wordPrefix, prefix).
+		Order("username IS NULL, username, name").
+		Limit(limit).
+		Find(&users)
+	if result.Error != nil {
+		slog.Error("Failed to search users", slog.Any("error", result.Error))
+		return nil, apperror.Internal(result.Error)
+	}
+
+	return users, nil
+}
+
+// findableBy selects the users that the user with the given ID can find: the ones that allow being discovered, and the
+// ones in an organization with them, since members of the same organization can always find each other.
+func findableBy(db *gorm.DB, userID uint) *gorm.DB {
+	sharedOrgs := db.Model(&models.OrganizationMember{}).Select("organization_id").Where("user_id = ?", userID)
+	orgMembers := db.Model(&models.OrganizationMember{}).Select("user_id").Where("organization_id IN (?)", sharedOrgs)
+
+	return db.Model(&models.User{}).Where("users.id <> ? AND (users.discoverable OR users.id IN (?))", userID, orgMembers)
+}
+
+// escapeLike escapes the wildcards in a string, so that it only matches itself in a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -1,5 +1,7 @@
 package utils
 
+import "notes-app/apperror"
+
 // ApiResponse is a struct that defines the schema for all API responses.
 type ApiResponse struct {
 	// Success is a boolean indicating whether the API call was successful or not.
@@ -9,3 +11,17 @@ type ApiResponse struct {
 	//message should contain an error message.
 	Message string `json:"message"`
 }
+
+// ErrorResponse is a struct that defines the schema for all unsuccessful API responses.
+type ErrorResponse struct {
+	ApiResponse
+
+	// Code is a stable, machine-readable identifier for the error that clients can branch on.
+	Code apperror.Code `json:"code"`
+
+	// Details contains field-level errors, if any.
+	Details []apperror.FieldError `json:"details,omitempty"`
+
+	// RequestID is the unique ID of the request, to help with correlating errors with logs.
+	RequestID string `json:"request_id,omitempty"`
+}
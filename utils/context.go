@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"notes-app/apperror"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// GetUserID returns the ID of the authenticated user, as set in the context by the auth middleware.
+//
+// Returns apperror.ErrUnauthorized if the request is not authenticated.
+func GetUserID(ctx *fiber.Ctx) (uint, error) {
+	userID, ok := ctx.Locals("userID").(string)
+	if !ok {
+		return 0, apperror.ErrUnauthorized
+	}
+
+	id, err := strconv.ParseUint(userID, 10, 0)
+	if err != nil {
+		return 0, apperror.ErrUnauthorized.WithCause(err)
+	}
+
+	return uint(id), nil
+}
+
+// GetSessionID returns the ID of the current session, as set in the context by the auth middleware.
+//
+// Returns apperror.ErrUnauthorized if the request is not authenticated with a session.
+func GetSessionID(ctx *fiber.Ctx) (string, error) {
+	sessionID, ok := ctx.Locals("sessionID").(string)
+	if !ok || sessionID == "" {
+		return "", apperror.ErrUnauthorized
+	}
+
+	return sessionID, nil
+}
+
+// GetAuthMethod returns how the current request was authenticated ("bearer" or "cookie"), as set in the context by the
+// auth middleware, or an empty string if the request is not authenticated.
+func GetAuthMethod(ctx *fiber.Ctx) string {
+	method, _ := ctx.Locals("authMethod").(string)
+	return method
+}
@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"notes-app/apperror"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+// Normalizer is implemented by request schemas that need to clean up their fields, e.g. trimming whitespace, before
+// they are validated.
+type Normalizer interface {
+	Normalize()
+}
+
+// validate is the shared validator instance, which caches struct metadata between calls.
+var validate = newValidator()
+
+// newValidator creates a validator that reports fields by their JSON names, so that clients can map errors back to
+// the fields they sent.
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			return ""
+		case "":
+			return field.Name
+		}
+		return name
+	})
+
+	_ = v.RegisterValidation("username", func(fl validator.FieldLevel) bool {
+		return ValidUsername(fl.Field().String())
+	})
+
+	return v
+}
+
+// usernamePattern is the format of usernames, which are stored normalized to lowercase.
+var usernamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{2,29}$`)
+
+// UsernameFormatMessage describes the format of usernames, for validation errors.
+const UsernameFormatMessage = "must be 3 to 30 lowercase letters, digits or underscores, starting with a letter"
+
+// NormalizeUsername trims surrounding whitespace and a leading @ from a username and converts it to lowercase, so that
+// usernames differing by case are the same.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+}
+
+// ValidUsername checks whether a normalized username has the right format: 3 to 30 lowercase letters, digits or
+// underscores, starting with a letter.
+func ValidUsername(username string) bool {
+	return usernamePattern.MatchString(username)
+}
+
+// NormalizeEmail trims surrounding whitespace from an email address and converts it to lowercase.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Validate validates a struct using the rules in its `validate` tags.
+//
+// Returns apperror.ErrValidationFailed with a detail for each invalid field, or nil if the struct is valid.
+func Validate(s any) error {
+	err := validate.Struct(s)
+	if err == nil {
+		return nil
+	}
+
+	var validationErrors validator.ValidationErrors
+	if !errors.As(err, &validationErrors) {
+		// This only happens if something other than a struct is passed in
+		return apperror.Internal(err)
+	}
+
+	details := make([]apperror.FieldError, 0, len(validationErrors))
+	for _, fieldError := range validationErrors {
+		details = append(details, apperror.FieldError{Field: fieldError.Field(), Message: validationMessage(fieldError)})
+	}
+
+	return apperror.ErrValidationFailed.WithCause(err).WithDetails(details...)
+}
+
+// ParseBody parses the request body into the given struct, normalizes it if it implements Normalizer, and validates
+// it.
+//
+// Returns apperror.ErrInvalidRequestBody if the body cannot be parsed, or apperror.ErrValidationFailed if it is
+// invalid.
+func ParseBody(ctx *fiber.Ctx, out any) error {
+	if err := ctx.BodyParser(out); err != nil {
+		return apperror.ErrInvalidRequestBody.WithCause(err)
+	}
+
+	if normalizer, ok := out.(Normalizer); ok {
+		normalizer.Normalize()
+	}
+
+	return Validate(out)
+}
+
+// ParseQuery parses the query parameters into the given struct, normalizes it if it implements Normalizer, and
+// validates it, same as ParseBody.
+//
+// Returns apperror.ErrInvalidQuery if the query parameters cannot be parsed, or apperror.ErrValidationFailed if they
+// are invalid.
+func ParseQuery(ctx *fiber.Ctx, out any) error {
+	if err := ctx.QueryParser(out); err != nil {
+		return apperror.ErrInvalidQuery.WithCause(err)
+	}
+
+	if normalizer, ok := out.(Normalizer); ok {
+		normalizer.Normalize()
+	}
+
+	return Validate(out)
+}
+
+// validationMessage returns a human-readable message for a failed validation rule.
+func validationMessage(fieldError validator.FieldError) string {
+	// Length rules are about characters for strings, and about the value itself for everything else
+	isString := fieldError.Kind() == reflect.String
+
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		if isString {
+			return fmt.Sprintf("must be at least %s characters long", fieldError.Param())
+		}
+		return fmt.Sprintf("must be at least %s", fieldError.Param())
+	case "max":
+		if isString {
+			return fmt.Sprintf("must be at most %s characters long", fieldError.Param())
+		}
+		return fmt.Sprintf("must be at most %s", fieldError.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of: %s", fieldError.Param())
+	case "username", "len=0|username":
+		return UsernameFormatMessage
+	}
+
+	return "is invalid"
+}
@@ -0,0 +1,53 @@
+package utils
+
+import "log/slog"
+
+// WorkerPool runs tasks in the background on a fixed number of goroutines, so that a burst of requests can't start an
+// unbounded number of them.
+type WorkerPool struct {
+	tasks chan workerTask
+}
+
+type workerTask struct {
+	name string
+	run  func()
+}
+
+// NewWorkerPool starts a pool with the given number of workers, which queues up to queueSize tasks while they are
+// all busy.
+func NewWorkerPool(workers, queueSize int) *WorkerPool {
+	pool := &WorkerPool{tasks: make(chan workerTask, queueSize)}
+	for range workers {
+		go pool.work()
+	}
+	return pool
+}
+
+// Submit queues the task to be run by the next free worker. The name is used to log the task if it panics.
+//
+// Returns false without queueing the task if the queue is full, rather than blocking the caller.
+func (p *WorkerPool) Submit(name string, task func()) bool {
+	select {
+	case p.tasks <- workerTask{name: name, run: task}:
+		return true
+	default:
+		return false
+	}
+}
+
+// work runs queued tasks for as long as the app runs.
+func (p *WorkerPool) work() {
+	for task := range p.tasks {
+		runTask(task)
+	}
+}
+
+// runTask runs the task, recovering from a panic so that it doesn't take down the worker or the app.
+func runTask(task workerTask) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("Background task panicked", slog.String("task", task.name), slog.Any("panic", r))
+		}
+	}()
+	task.run()
+}